@@ -0,0 +1,571 @@
+/**
+ * anthropic.go
+ *
+ * Anthropic Messages API（POST /v1/messages）の受け付け
+ * Anthropic SDK を使うエージェントからも、Bifrost 経由の任意のモデルでツール呼び出しを使えるようにする。
+ * リクエストを ChatCompletionRequest に変換し、Chat Completions と同じエミュレートの処理（ツール定義の埋め込み・抽出・確認）に載せて、
+ * 結果を Messages API の形式（content ブロック・stop_reason）で返す。
+ *   - system → system メッセージ、tools[].input_schema → tools[].function.parameters
+ *   - tool_choice: auto → "auto"、any → "required"、tool → 関数名の指定、none → "none"（disable_parallel_tool_use は parallel_tool_calls: false）
+ *   - assistant の tool_use ブロック → tool_calls、user の tool_result ブロック → role: "tool" のメッセージ
+ *   - 抽出したツール呼び出しは tool_use ブロックとして返し、stop_reason を "tool_use" にする
+ * ストリーミング（stream: true）では、Messages API のイベント（message_start / content_block_* / message_delta / message_stop）を送る。
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- 型定義 (Messages API) ---
+
+// AnthropicMessagesRequest は Messages API のリクエスト
+type AnthropicMessagesRequest struct {
+	Model         string                  `json:"model"`
+	MaxTokens     *int                    `json:"max_tokens,omitempty"`
+	System        any                     `json:"system,omitempty"` // string or []AnthropicContentBlock
+	Messages      []AnthropicMessage      `json:"messages"`
+	Tools         []AnthropicTool         `json:"tools,omitempty"`
+	ToolChoice    *AnthropicToolChoice    `json:"tool_choice,omitempty"`
+	Stream        bool                    `json:"stream,omitempty"`
+	Temperature   *float32                `json:"temperature,omitempty"`
+	TopP          *float32                `json:"top_p,omitempty"`
+	StopSequences []string                `json:"stop_sequences,omitempty"`
+	Metadata      map[string]any          `json:"metadata,omitempty"` // prompt_profile / locale のみ受け付ける（user_id などはバックエンドに送らない）
+	Thinking      *AnthropicThinkingParam `json:"thinking,omitempty"`
+}
+
+// AnthropicThinkingParam は拡張思考の指定（エミュレートでは参照しない）
+type AnthropicThinkingParam struct {
+	Type         string `json:"type"` // "enabled", "disabled"
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+// AnthropicMessage は会話の1メッセージ
+type AnthropicMessage struct {
+	Role    string `json:"role"`    // "user", "assistant"
+	Content any    `json:"content"` // string or []AnthropicContentBlock
+}
+
+// AnthropicContentBlock はメッセージの content ブロック
+type AnthropicContentBlock struct {
+	Type      string                `json:"type"` // "text", "image", "tool_use", "tool_result", "thinking"
+	Text      string                `json:"text,omitempty"`
+	ID        string                `json:"id,omitempty"`          // tool_use
+	Name      string                `json:"name,omitempty"`        // tool_use
+	Input     any                   `json:"input,omitempty"`       // tool_use の引数（オブジェクト）
+	ToolUseID string                `json:"tool_use_id,omitempty"` // tool_result
+	Content   any                   `json:"content,omitempty"`     // tool_result の結果（string or []AnthropicContentBlock）
+	IsError   bool                  `json:"is_error,omitempty"`    // tool_result
+	Source    *AnthropicImageSource `json:"source,omitempty"`      // image
+	Thinking  string                `json:"thinking,omitempty"`    // thinking
+	Signature *string               `json:"signature,omitempty"`   // thinking
+}
+
+// AnthropicImageSource は画像ブロックの画像
+type AnthropicImageSource struct {
+	Type      string `json:"type"`                 // "base64", "url"
+	MediaType string `json:"media_type,omitempty"` // "image/png" など（base64 の場合）
+	Data      string `json:"data,omitempty"`       // base64 の場合
+	URL       string `json:"url,omitempty"`        // url の場合
+}
+
+// AnthropicTool はツール定義
+type AnthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+// AnthropicToolChoice はツール選択の指定
+type AnthropicToolChoice struct {
+	Type                   string `json:"type"`           // "auto", "any", "tool", "none"
+	Name                   string `json:"name,omitempty"` // type="tool" の場合
+	DisableParallelToolUse *bool  `json:"disable_parallel_tool_use,omitempty"`
+}
+
+// AnthropicMessagesResponse は Messages API のレスポンス
+type AnthropicMessagesResponse struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"` // "message"
+	Role         string                  `json:"role"` // "assistant"
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   *string                 `json:"stop_reason"` // "end_turn", "tool_use", "max_tokens", "stop_sequence", "refusal"（ストリーミングの開始時は null）
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+}
+
+// AnthropicUsage はトークン使用量
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// anthropicDefaultMaxTokens は max_tokens を省略したリクエストに使う値（Messages API では必須のため、省略時はバックエンドの既定に任せない）
+const anthropicDefaultMaxTokens = 4096
+
+// --- リクエストの変換 ---
+
+// toChatCompletionRequest は Messages API のリクエストを ChatCompletionRequest に変換する
+func (r *AnthropicMessagesRequest) toChatCompletionRequest() (*ChatCompletionRequest, error) {
+	req := &ChatCompletionRequest{
+		Model:       r.Model,
+		Stream:      r.Stream,
+		Temperature: r.Temperature,
+		TopP:        r.TopP,
+		MaxTokens:   r.MaxTokens,
+	}
+	if req.MaxTokens == nil {
+		maxTokens := anthropicDefaultMaxTokens
+		req.MaxTokens = &maxTokens
+	}
+	if len(r.StopSequences) > 0 {
+		req.Stop = r.StopSequences
+	}
+	if r.Stream {
+		// message_delta で usage を返すため、バックエンドにも usage を求める
+		req.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+	// プロンプトのプロファイル・ロケールの指定は metadata から受け付ける（user_id などはバックエンドに送らない）
+	for _, key := range []string{"prompt_profile", "locale"} {
+		if value, ok := r.Metadata[key]; ok {
+			if req.Metadata == nil {
+				req.Metadata = map[string]any{}
+			}
+			req.Metadata[key] = value
+		}
+	}
+
+	if system := anthropicText(r.System); system != "" {
+		req.Messages = append(req.Messages, Message{Role: "system", Content: system})
+	}
+	for i, msg := range r.Messages {
+		blocks, err := anthropicBlocks(msg.Content)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d].content: %v", i, err)
+		}
+		switch msg.Role {
+		case "user":
+			req.Messages = append(req.Messages, anthropicUserMessages(blocks)...)
+		case "assistant":
+			req.Messages = append(req.Messages, anthropicAssistantMessage(blocks))
+		default:
+			return nil, fmt.Errorf("messages[%d].role: unsupported role %q", i, msg.Role)
+		}
+	}
+
+	for _, tool := range r.Tools {
+		params := tool.InputSchema
+		if params == nil {
+			params = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		req.Tools = append(req.Tools, Tool{Type: "function", Function: FunctionDef{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  params,
+		}})
+	}
+	if tc := r.ToolChoice; tc != nil {
+		switch tc.Type {
+		case "auto", "":
+			req.ToolChoice = "auto"
+		case "any":
+			req.ToolChoice = "required"
+		case "none":
+			req.ToolChoice = "none"
+		case "tool":
+			req.ToolChoice = map[string]any{"type": "function", "function": map[string]any{"name": tc.Name}}
+		default:
+			return nil, fmt.Errorf("tool_choice.type: unsupported value %q", tc.Type)
+		}
+		if tc.DisableParallelToolUse != nil && *tc.DisableParallelToolUse && len(req.Tools) > 0 {
+			single := false
+			req.ParallelToolCalls = &single
+		}
+	}
+	return req, nil
+}
+
+// anthropicBlocks は content（文字列または content ブロックの配列）をブロックの配列にする
+func anthropicBlocks(content any) ([]AnthropicContentBlock, error) {
+	switch v := content.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []AnthropicContentBlock{{Type: "text", Text: v}}, nil
+	}
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []AnthropicContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil, fmt.Errorf("must be a string or an array of content blocks")
+	}
+	return blocks, nil
+}
+
+// anthropicText は文字列または content ブロックの配列から text ブロックのテキストを連結する
+func anthropicText(content any) string {
+	blocks, err := anthropicBlocks(content)
+	if err != nil {
+		return ""
+	}
+	var parts []string
+	for _, block := range blocks {
+		if block.Type == "text" && block.Text != "" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// anthropicUserMessages は user メッセージのブロックを変換する
+// tool_result ブロックは直前の tool_calls に続くよう role: "tool" のメッセージとして先に並べ、残りのテキスト・画像を user メッセージにする
+func anthropicUserMessages(blocks []AnthropicContentBlock) []Message {
+	var messages []Message
+	var parts []ContentPart
+	hasImage := false
+	for _, block := range blocks {
+		switch block.Type {
+		case "tool_result":
+			result := anthropicText(block.Content)
+			if block.IsError {
+				result = "Error: " + result
+			}
+			messages = append(messages, Message{Role: "tool", ToolCallID: block.ToolUseID, Content: result})
+		case "text":
+			parts = append(parts, ContentPart{Type: "text", Text: block.Text})
+		case "image":
+			if block.Source == nil {
+				continue
+			}
+			url := block.Source.URL
+			if block.Source.Type == "base64" {
+				url = "data:" + block.Source.MediaType + ";base64," + block.Source.Data
+			}
+			parts = append(parts, ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: url}})
+			hasImage = true
+		}
+	}
+	switch {
+	case len(parts) == 0:
+	case hasImage:
+		messages = append(messages, Message{Role: "user", Content: parts})
+	default:
+		texts := make([]string, len(parts))
+		for i, part := range parts {
+			texts[i] = part.Text
+		}
+		messages = append(messages, Message{Role: "user", Content: strings.Join(texts, "\n\n")})
+	}
+	return messages
+}
+
+// anthropicAssistantMessage は assistant メッセージのブロックを変換する（tool_use → tool_calls。thinking は履歴に含めない）
+func anthropicAssistantMessage(blocks []AnthropicContentBlock) Message {
+	msg := Message{Role: "assistant"}
+	var texts []string
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			texts = append(texts, block.Text)
+		case "tool_use":
+			input := block.Input
+			if input == nil {
+				input = map[string]any{}
+			}
+			arguments, _ := json.Marshal(input)
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: ToolCallFunction{Name: block.Name, Arguments: string(arguments)},
+			})
+		}
+	}
+	if len(texts) > 0 {
+		msg.Content = strings.Join(texts, "\n\n")
+	}
+	return msg
+}
+
+// --- レスポンスの変換 ---
+
+// anthropicStopReason は finish_reason を stop_reason に変換する（コンテンツフィルターで止まった場合は "refusal"）
+func anthropicStopReason(finish string) string {
+	switch finish {
+	case "tool_calls":
+		return "tool_use"
+	case "length":
+		return "max_tokens"
+	case "content_filter":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+// anthropicToolUseBlock はツール呼び出しを tool_use ブロックにする（引数の JSON はオブジェクトとして返す）
+func anthropicToolUseBlock(tc ToolCall) AnthropicContentBlock {
+	var input any = map[string]any{}
+	if strings.TrimSpace(tc.Function.Arguments) != "" {
+		if err := json.Unmarshal([]byte(tc.Function.Arguments), &input); err != nil {
+			input = map[string]any{}
+		}
+	}
+	return AnthropicContentBlock{Type: "tool_use", ID: tc.ID, Name: tc.Function.Name, Input: input}
+}
+
+// buildAnthropicResponse はエミュレートの結果から Messages API のレスポンスを作る
+func buildAnthropicResponse(model string, result *emulationResult) AnthropicMessagesResponse {
+	content := []AnthropicContentBlock{}
+	if result.reasoning != "" {
+		content = append(content, AnthropicContentBlock{Type: "thinking", Thinking: result.reasoning, Signature: stringPtr("")})
+	}
+	if result.content != "" {
+		content = append(content, AnthropicContentBlock{Type: "text", Text: result.content})
+	}
+	for _, tc := range result.toolCalls {
+		content = append(content, anthropicToolUseBlock(tc))
+	}
+	usage := result.usage()
+	return AnthropicMessagesResponse{
+		ID:         generateAnthropicMessageID(),
+		Type:       "message",
+		Role:       "assistant",
+		Model:      model,
+		Content:    content,
+		StopReason: stringPtr(anthropicStopReason(result.finishReason)),
+		Usage:      AnthropicUsage{InputTokens: usage.PromptTokens, OutputTokens: usage.CompletionTokens},
+	}
+}
+
+// generateAnthropicMessageID は Messages API の形式のメッセージIDを生成する
+func generateAnthropicMessageID() string {
+	return "msg_" + strings.TrimPrefix(generateResponseID(), "chatcmpl-")
+}
+
+// anthropicErrorType はステータスコードに対応する Messages API のエラーの type
+func anthropicErrorType(status int) string {
+	switch status {
+	case 400:
+		return "invalid_request_error"
+	case 401:
+		return "authentication_error"
+	case 403:
+		return "permission_error"
+	case 404:
+		return "not_found_error"
+	case 413:
+		return "request_too_large"
+	case 429:
+		return "rate_limit_error"
+	case 503, 529:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
+
+// anthropicErrorBody は Messages API の形式のエラーの本文
+func anthropicErrorBody(status int, message string) gin.H {
+	return gin.H{"type": "error", "error": gin.H{"type": anthropicErrorType(status), "message": message}}
+}
+
+// writeAnthropicError はエミュレートの処理のエラーを Messages API の形式で返す
+func writeAnthropicError(c *gin.Context, apiErr *emulationError) {
+	c.JSON(apiErr.status, anthropicErrorBody(apiErr.status, apiErr.detail().Message))
+}
+
+// --- ストリーミング ---
+
+// anthropicStreamWriter はクライアントへ Messages API のイベントをSSEで書き出す
+// content ブロックは種類（thinking / text / tool_use）が変わるたびに閉じて、次の index で開く
+type anthropicStreamWriter struct {
+	c         *gin.Context
+	id        string
+	model     string
+	index     int    // 次に開く content ブロックの index
+	openBlock string // 開いている content ブロックの種類（"" は開いていない）
+}
+
+// SSEヘッダーを送出し、ストリーミング用ライターを作成
+func newAnthropicStreamWriter(c *gin.Context, model string) *anthropicStreamWriter {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // リバースプロキシでのバッファリングを無効化
+	c.Status(http.StatusOK)
+	return &anthropicStreamWriter{c: c, id: generateAnthropicMessageID(), model: model}
+}
+
+// SSEの event: 行と data: 行を1組書き出してフラッシュ
+func (w *anthropicStreamWriter) writeEvent(event string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w.c.Writer, "event: %s\ndata: %s\n\n", event, b); err != nil {
+		return err
+	}
+	w.c.Writer.Flush()
+	return nil
+}
+
+// startBlock は kind の content ブロックを開く（同じ種類のブロックが開いている場合はそのまま続ける）
+// 開始時のブロックは空の text / thinking も省略せずに送るため、AnthropicContentBlock ではなく gin.H で渡す
+func (w *anthropicStreamWriter) startBlock(kind string, block gin.H) error {
+	if w.openBlock == kind && kind != "tool_use" {
+		return nil
+	}
+	if err := w.stopBlock(); err != nil {
+		return err
+	}
+	w.openBlock = kind
+	return w.writeEvent("content_block_start", gin.H{"type": "content_block_start", "index": w.index, "content_block": block})
+}
+
+// stopBlock は開いている content ブロックを閉じる
+func (w *anthropicStreamWriter) stopBlock() error {
+	if w.openBlock == "" {
+		return nil
+	}
+	w.openBlock = ""
+	w.index++
+	return w.writeEvent("content_block_stop", gin.H{"type": "content_block_stop", "index": w.index - 1})
+}
+
+// delta は開いている content ブロックの差分を書き出す
+func (w *anthropicStreamWriter) delta(delta gin.H) error {
+	return w.writeEvent("content_block_delta", gin.H{"type": "content_block_delta", "index": w.index, "delta": delta})
+}
+
+// message_start を書き出す
+func (w *anthropicStreamWriter) writeRole() error {
+	return w.writeEvent("message_start", gin.H{"type": "message_start", "message": AnthropicMessagesResponse{
+		ID:      w.id,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   w.model,
+		Content: []AnthropicContentBlock{},
+	}})
+}
+
+// テキストの差分を text ブロックの text_delta として書き出す
+func (w *anthropicStreamWriter) writeContent(text string) error {
+	if text == "" {
+		return nil
+	}
+	if err := w.startBlock("text", gin.H{"type": "text", "text": ""}); err != nil {
+		return err
+	}
+	return w.delta(gin.H{"type": "text_delta", "text": text})
+}
+
+// 推論テキストの差分を thinking ブロックの thinking_delta として書き出す
+func (w *anthropicStreamWriter) writeReasoning(text string) error {
+	if text == "" {
+		return nil
+	}
+	if err := w.startBlock("thinking", gin.H{"type": "thinking", "thinking": "", "signature": ""}); err != nil {
+		return err
+	}
+	return w.delta(gin.H{"type": "thinking_delta", "thinking": text})
+}
+
+// ツール呼び出しを tool_use ブロックとして書き出す（引数の JSON は input_json_delta で断片ごとに送る）
+func (w *anthropicStreamWriter) writeToolCalls(toolCalls []ToolCall) error {
+	for _, tc := range toolCalls {
+		block := gin.H{"type": "tool_use", "id": tc.ID, "name": tc.Function.Name, "input": gin.H{}}
+		if err := w.startBlock("tool_use", block); err != nil {
+			return err
+		}
+		for _, fragment := range splitArguments(tc.Function.Arguments, streamArgumentsChunkSize) {
+			if err := w.delta(gin.H{"type": "input_json_delta", "partial_json": fragment}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 開いているブロックを閉じ、stop_reason と usage を message_delta として書き出す
+func (w *anthropicStreamWriter) writeFinish(reason string, usage *Usage) error {
+	if err := w.stopBlock(); err != nil {
+		return err
+	}
+	outputTokens := 0
+	if usage != nil {
+		outputTokens = usage.CompletionTokens
+	}
+	return w.writeEvent("message_delta", gin.H{
+		"type":  "message_delta",
+		"delta": gin.H{"stop_reason": anthropicStopReason(reason), "stop_sequence": nil},
+		"usage": gin.H{"output_tokens": outputTokens},
+	})
+}
+
+// エラーイベントを書き出す（ストリーム開始後にエラーが発生した場合）
+func (w *anthropicStreamWriter) writeError(status int, body map[string]any) error {
+	apiErr := &emulationError{status: status, body: body}
+	return w.writeEvent("error", anthropicErrorBody(status, apiErr.detail().Message))
+}
+
+// message_stop を書き出す
+func (w *anthropicStreamWriter) writeDone() error {
+	return w.writeEvent("message_stop", gin.H{"type": "message_stop"})
+}
+
+// --- Ginハンドラー ---
+
+// Messages API: リクエストを変換してエミュレートし、Messages API の形式で返す
+func handleAnthropicMessages(c *gin.Context) {
+	var areq AnthropicMessagesRequest
+	if err := c.ShouldBindJSON(&areq); err != nil {
+		c.JSON(400, anthropicErrorBody(400, fmt.Sprintf("Invalid JSON: %v", err)))
+		return
+	}
+	req, err := areq.toChatCompletionRequest()
+	if err != nil {
+		c.JSON(400, anthropicErrorBody(400, err.Error()))
+		return
+	}
+	logDebug("Request Received (Messages API)", map[string]any{
+		"Model":         req.Model,
+		"Tool Count":    len(req.Tools),
+		"Message Count": len(req.Messages),
+		"Has Stream":    req.Stream,
+	})
+
+	e, apiErr := prepareEmulation(c, req, false)
+	if apiErr != nil {
+		writeAnthropicError(c, apiErr)
+		return
+	}
+	if req.Stream {
+		handleChatCompletionsEmulateStream(c, e, streamFrontEnd{
+			newWriter: func() emulationStreamWriter { return newAnthropicStreamWriter(c, req.Model) },
+			fail: func(status int, body any) {
+				writeAnthropicError(c, &emulationError{status: status, body: body})
+			},
+		})
+		return
+	}
+	result, apiErr := runEmulation(e, c.Writer.Header())
+	if apiErr != nil {
+		writeAnthropicError(c, apiErr)
+		return
+	}
+	resp := buildAnthropicResponse(req.Model, result)
+	logDebug("Response Generated (Messages API)", map[string]any{
+		"Stop Reason":      *resp.StopReason,
+		"Tool Calls Count": len(result.toolCalls),
+	})
+	c.JSON(200, resp)
+}
@@ -0,0 +1,226 @@
+/**
+ * choices.go
+ *
+ * 複数の選択肢（n > 1）
+ * バックエンドが返したすべての選択肢について、ツール呼び出しの抽出・確認（必要なら聞き直し）を選択肢ごとに行い、
+ * それぞれに finish_reason を付けて返す（runEmulation）。
+ * n を無視して選択肢を1つしか返さないバックエンドのために、N_FANOUT（モデルごとには n_fanout）で TCGW が転送を分けられる。
+ *   - off: n をそのままバックエンドに送る（デフォルト）
+ *   - fallback: n をそのまま送り、返った選択肢が n に満たない場合は、足りない数だけ n なしのリクエストを並行して送る
+ *   - always: はじめから n なしのリクエストを n 個並行して送る
+ * 分けて転送する場合は、同時に送るリクエストを N_FANOUT_CONCURRENCY 個までに抑え、クライアントが切断した時点で残りを止める。
+ * 分けて転送した応答は1つのレスポンスにまとめる（choices の index を振り直し、usage は合計する）。
+ * 選択肢が n に満たない場合（n を無視された・一部のリクエストが失敗した）は、足りない数を X-TCGW-Choices-Missing ヘッダーで返す。
+ * ストリーミングで n > 1 の場合は、すべての選択肢をまとめて受け取ってから選択肢ごとにチャンクを送る。
+ */
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/t-kawata/tcgw/config"
+)
+
+// n > 1 の場合の転送の分け方
+const (
+	nFanoutOff      = "off"      // n をそのままバックエンドに送る
+	nFanoutFallback = "fallback" // 返った選択肢が足りない場合のみ、足りない数だけ分けて送る
+	nFanoutAlways   = "always"   // はじめから n 回に分けて送る
+)
+
+// maxChoices は n の上限（OpenAI と同じ）
+const maxChoices = 128
+
+// headerChoicesMissing は返した選択肢が n に満たない場合の、足りない選択肢の数
+const headerChoicesMissing = "X-TCGW-Choices-Missing"
+
+// validNFanout は n_fanout として有効な値か
+func validNFanout(mode string) bool {
+	return mode == nFanoutOff || mode == nFanoutFallback || mode == nFanoutAlways
+}
+
+// resolveNFanout はモデル設定の n_fanout（未指定の場合は N_FANOUT）を返す
+func resolveNFanout(modelConfig config.ModelConfig) string {
+	if modelConfig.NFanout != "" {
+		return modelConfig.NFanout
+	}
+	return nFanout
+}
+
+// requestedChoices はリクエストの n（未指定の場合は 1）
+func requestedChoices(req *ChatCompletionRequest) int {
+	if req.N == nil || *req.N < 1 {
+		return 1
+	}
+	return *req.N
+}
+
+// fanoutResult は分けて転送した1つのリクエストの結果
+type fanoutResult struct {
+	resp map[string]any
+	err  error
+}
+
+// forwardChoices は n 個の選択肢を得るようにバックエンドへ転送する（mode は N_FANOUT の値）
+// 分けて転送した場合、一部のリクエストのエラーは選択肢を減らして続ける（すべて失敗した場合のみエラー。足りない数は呼び出し側がヘッダーで示す）
+func forwardChoices(ctx context.Context, req *ChatCompletionRequest, n int, mode string) (map[string]any, error) {
+	timeout := time.Duration(requestTimeout) * time.Millisecond
+	if n <= 1 || mode == nFanoutOff {
+		return forwardToBifrostWithContext(ctx, req, timeout)
+	}
+
+	single := *req
+	single.N = nil
+	if mode == nFanoutAlways {
+		results := forwardParallel(ctx, &single, n)
+		var base map[string]any
+		var extras []map[string]any
+		for _, r := range results {
+			if r.err != nil {
+				logDebug("N Fanout: Request Failed", r.resp)
+				continue
+			}
+			if base == nil {
+				base = r.resp
+			} else {
+				extras = append(extras, r.resp)
+			}
+		}
+		if base == nil {
+			return results[0].resp, results[0].err
+		}
+		return mergeChoiceResponses(base, extras), nil
+	}
+
+	backendResp, err := forwardToBifrostWithContext(ctx, req, timeout)
+	if err != nil {
+		return backendResp, err
+	}
+	choices, _ := backendResp["choices"].([]any)
+	missing := n - len(choices)
+	if missing <= 0 {
+		return backendResp, nil
+	}
+	logDebug("N Fanout: Backend Returned Fewer Choices", map[string]any{"Requested": n, "Returned": len(choices), "Missing": missing})
+	var extras []map[string]any
+	for _, r := range forwardParallel(ctx, &single, missing) {
+		if r.err != nil {
+			logDebug("N Fanout: Request Failed", r.resp)
+			continue
+		}
+		extras = append(extras, r.resp)
+	}
+	return mergeChoiceResponses(backendResp, extras), nil
+}
+
+// forwardParallel は同じリクエストを count 個、N_FANOUT_CONCURRENCY 個ずつ並行して転送する（結果は送った順）
+// ctx が終了した場合（クライアントの切断）は、送信中のリクエストを中断し、まだ送っていないリクエストはエラーとする
+func forwardParallel(ctx context.Context, req *ChatCompletionRequest, count int) []fanoutResult {
+	timeout := time.Duration(requestTimeout) * time.Millisecond
+	results := make([]fanoutResult, count)
+	sem := make(chan struct{}, nFanoutConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			body, err := bifrostTransportError(ctx.Err())
+			for j := i; j < count; j++ {
+				results[j] = fanoutResult{resp: body, err: err}
+			}
+			wg.Wait()
+			return results
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			resp, err := forwardToBifrostWithContext(ctx, req, timeout)
+			results[i] = fanoutResult{resp: resp, err: err}
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// mergeChoiceResponses は base の choices の後ろに extras の choices を加え、index を振り直す
+// usage は数値の項目を合計する（分けて転送した分のトークンもすべて数える）
+func mergeChoiceResponses(base map[string]any, extras []map[string]any) map[string]any {
+	extractBackendChoices(base) // choices を index 順に並べる
+	choices, _ := base["choices"].([]any)
+	merged := append([]any(nil), choices...)
+	usage, _ := base["usage"].(map[string]any)
+	for _, extra := range extras {
+		extractBackendChoices(extra)
+		extraChoices, _ := extra["choices"].([]any)
+		merged = append(merged, extraChoices...)
+		extraUsage, ok := extra["usage"].(map[string]any)
+		if !ok {
+			continue
+		}
+		if usage == nil {
+			usage = map[string]any{}
+		}
+		for key, value := range extraUsage {
+			if v, ok := value.(float64); ok {
+				current, _ := usage[key].(float64)
+				usage[key] = current + v
+			}
+		}
+	}
+	for i, item := range merged {
+		if choice, ok := item.(map[string]any); ok {
+			choice["index"] = i
+		}
+	}
+	base["choices"] = merged
+	if usage != nil {
+		base["usage"] = usage
+	}
+	return base
+}
+
+// handleChatCompletionsEmulateChoicesStream はストリーミングで n > 1 の場合の Chat Completions の応答
+// すべての選択肢をストリーミングなしで受け取ってエミュレートし、選択肢ごとに role・本文・ツール呼び出し・finish_reason のチャンクを送る
+func handleChatCompletionsEmulateChoicesStream(c *gin.Context, e *emulation, legacy bool) {
+	includeUsage := e.req.StreamOptions != nil && e.req.StreamOptions.IncludeUsage
+	e.req.Stream = false
+	e.req.StreamOptions = nil
+
+	result, apiErr := runEmulation(e, c.Writer.Header())
+	if apiErr != nil {
+		c.JSON(apiErr.status, apiErr.body)
+		return
+	}
+
+	w := newOpenAIStreamWriter(c, e.req.Model)
+	w.legacy = legacy
+	for i, ec := range result.choices {
+		w.index = i
+		if err := w.writeRole(); err != nil {
+			return // クライアント切断
+		}
+		if err := w.writeReasoning(ec.reasoning); err != nil {
+			return
+		}
+		if err := w.writeContent(ec.content); err != nil {
+			return
+		}
+		if err := w.writeToolCalls(ec.toolCalls); err != nil {
+			return
+		}
+		// usage はすべての選択肢の後に1度だけ送る
+		if err := w.writeFinish(ec.finishReason, nil); err != nil {
+			return
+		}
+	}
+	if includeUsage {
+		if err := w.writeUsage(result.usage()); err != nil {
+			return
+		}
+	}
+	w.writeDone()
+}
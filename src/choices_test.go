@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeBifrost はテスト用のバックエンド（/v1/chat/completions）
+// honorN が false の場合は n を無視して選択肢を1つだけ返し、fail が true を返したリクエスト（受け付けた順、0 から）は 500 にする
+type fakeBifrost struct {
+	honorN bool
+	fail   func(seq int) bool
+
+	mu       sync.Mutex
+	requests []*int // 受け付けた各リクエストの n
+}
+
+func (f *fakeBifrost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	f.mu.Lock()
+	seq := len(f.requests)
+	f.requests = append(f.requests, req.N)
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if f.fail != nil && f.fail(seq) {
+		w.WriteHeader(500)
+		fmt.Fprint(w, `{"error": {"message": "boom", "type": "server_error"}}`)
+		return
+	}
+	count := 1
+	if f.honorN && req.N != nil {
+		count = *req.N
+	}
+	choices := make([]any, count)
+	for i := range choices {
+		choices[i] = map[string]any{
+			"index":         i,
+			"message":       map[string]any{"role": "assistant", "content": fmt.Sprintf("answer %d-%d", seq, i)},
+			"finish_reason": "stop",
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":      "chatcmpl-fake",
+		"object":  "chat.completion",
+		"model":   req.Model,
+		"choices": choices,
+		"usage":   map[string]any{"prompt_tokens": 10, "completion_tokens": 2, "total_tokens": 12},
+	})
+}
+
+// sentN は受け付けたリクエストの n（未指定は 0）を順不同で比べられるよう数える
+func (f *fakeBifrost) sentN() map[int]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := map[int]int{}
+	for _, n := range f.requests {
+		v := 0
+		if n != nil {
+			v = *n
+		}
+		counts[v]++
+	}
+	return counts
+}
+
+// useFakeBifrost は f をバックエンドとして起動し、転送に使う設定をテスト用の値にする（テストの終了時に元に戻す）
+func useFakeBifrost(t *testing.T, f *fakeBifrost) {
+	t.Helper()
+	server := httptest.NewServer(f)
+	saved := []any{bifrostURL, requestTimeout, nFanout, nFanoutConcurrency, toolNamePolicy, toolValidationPolicy, singleToolCallPolicy, responseFormatMode}
+	t.Cleanup(func() {
+		server.Close()
+		bifrostURL, requestTimeout = saved[0].(string), saved[1].(int64)
+		nFanout, nFanoutConcurrency = saved[2].(string), saved[3].(int)
+		toolNamePolicy, toolValidationPolicy = saved[4].(string), saved[5].(string)
+		singleToolCallPolicy, responseFormatMode = saved[6].(string), saved[7].(string)
+	})
+	bifrostURL = server.URL
+	requestTimeout = 5000
+	nFanout = nFanoutOff
+	nFanoutConcurrency = 2
+	toolNamePolicy = toolNameCorrect
+	toolValidationPolicy = "pass"
+	singleToolCallPolicy = "keep_first"
+	responseFormatMode = responseFormatPassthrough
+}
+
+func TestMergeChoiceResponses(t *testing.T) {
+	base := map[string]any{
+		"id": "base",
+		"choices": []any{
+			map[string]any{"index": float64(1), "message": map[string]any{"content": "b1"}},
+			map[string]any{"index": float64(0), "message": map[string]any{"content": "b0"}},
+		},
+		"usage": map[string]any{"prompt_tokens": float64(10), "completion_tokens": float64(4), "total_tokens": float64(14)},
+	}
+	extras := []map[string]any{
+		{"choices": []any{map[string]any{"index": float64(0), "message": map[string]any{"content": "e0"}}}},
+		{
+			"choices": []any{map[string]any{"index": float64(0), "message": map[string]any{"content": "e1"}}},
+			"usage":   map[string]any{"prompt_tokens": float64(10), "completion_tokens": float64(3), "total_tokens": float64(13), "note": "ignored"},
+		},
+	}
+
+	merged := mergeChoiceResponses(base, extras)
+	if merged["id"] != "base" {
+		t.Errorf("id = %v, want the base response", merged["id"])
+	}
+	var contents []string
+	for i, item := range merged["choices"].([]any) {
+		choice := item.(map[string]any)
+		if choice["index"] != i {
+			t.Errorf("choice %d index = %v", i, choice["index"])
+		}
+		contents = append(contents, choice["message"].(map[string]any)["content"].(string))
+	}
+	if want := []string{"b0", "b1", "e0", "e1"}; !reflect.DeepEqual(contents, want) {
+		t.Errorf("choices = %v, want %v", contents, want)
+	}
+	wantUsage := map[string]any{"prompt_tokens": float64(20), "completion_tokens": float64(7), "total_tokens": float64(27)}
+	if !reflect.DeepEqual(merged["usage"], wantUsage) {
+		t.Errorf("usage = %v, want %v", merged["usage"], wantUsage)
+	}
+}
+
+func TestMergeChoiceResponsesWithoutBaseUsage(t *testing.T) {
+	base := map[string]any{"choices": []any{map[string]any{"index": float64(0)}}}
+	extra := map[string]any{"choices": []any{map[string]any{"index": float64(0)}}, "usage": map[string]any{"total_tokens": float64(5)}}
+	merged := mergeChoiceResponses(base, []map[string]any{extra})
+	if got := merged["usage"].(map[string]any)["total_tokens"]; got != float64(5) {
+		t.Errorf("total_tokens = %v, want 5", got)
+	}
+	if merged := mergeChoiceResponses(map[string]any{"choices": []any{}}, nil); merged["usage"] != nil {
+		t.Errorf("usage = %v, want none when no response has usage", merged["usage"])
+	}
+}
+
+func TestForwardChoices(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		n           int
+		honorN      bool
+		fail        func(seq int) bool
+		wantChoices int
+		wantSentN   map[int]int // 送ったリクエストの n（0 は指定なし）ごとの数
+		wantErr     bool
+	}{
+		{name: "off sends n once", mode: nFanoutOff, n: 3, honorN: true, wantChoices: 3, wantSentN: map[int]int{3: 1}},
+		{name: "off keeps an ignored n", mode: nFanoutOff, n: 3, wantChoices: 1, wantSentN: map[int]int{3: 1}},
+		{name: "fallback is not needed when n is honored", mode: nFanoutFallback, n: 3, honorN: true, wantChoices: 3, wantSentN: map[int]int{3: 1}},
+		{name: "fallback fills the missing choices", mode: nFanoutFallback, n: 3, wantChoices: 3, wantSentN: map[int]int{3: 1, 0: 2}},
+		{name: "fallback keeps going when a filler fails", mode: nFanoutFallback, n: 3, fail: func(seq int) bool { return seq == 1 }, wantChoices: 2, wantSentN: map[int]int{3: 1, 0: 2}},
+		{name: "fallback fails when the first request fails", mode: nFanoutFallback, n: 3, fail: func(seq int) bool { return seq == 0 }, wantErr: true, wantSentN: map[int]int{3: 1}},
+		{name: "always splits from the start", mode: nFanoutAlways, n: 4, honorN: true, wantChoices: 4, wantSentN: map[int]int{0: 4}},
+		{name: "always drops failed requests", mode: nFanoutAlways, n: 4, fail: func(seq int) bool { return seq%2 == 0 }, wantChoices: 2, wantSentN: map[int]int{0: 4}},
+		{name: "always fails when every request fails", mode: nFanoutAlways, n: 2, fail: func(int) bool { return true }, wantErr: true, wantSentN: map[int]int{0: 2}},
+		{name: "n of 1 is never split", mode: nFanoutAlways, n: 1, wantChoices: 1, wantSentN: map[int]int{1: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeBifrost{honorN: tt.honorN, fail: tt.fail}
+			useFakeBifrost(t, f)
+			n := tt.n
+			req := &ChatCompletionRequest{Model: "m", Messages: []Message{{Role: "user", Content: "hi"}}, N: &n}
+
+			resp, err := forwardChoices(context.Background(), req, tt.n, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("forwardChoices error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := f.sentN(); !reflect.DeepEqual(got, tt.wantSentN) {
+				t.Errorf("requests by n = %v, want %v", got, tt.wantSentN)
+			}
+			if tt.wantErr {
+				if err.Error() != "500" || resp["error"] == nil {
+					t.Errorf("error = %v with body %v, want the backend 500 error", err, resp)
+				}
+				return
+			}
+			choices, _ := resp["choices"].([]any)
+			if len(choices) != tt.wantChoices {
+				t.Fatalf("got %d choices, want %d", len(choices), tt.wantChoices)
+			}
+			for i, item := range choices {
+				if index := item.(map[string]any)["index"]; index != i && index != float64(i) {
+					t.Errorf("choice %d index = %v", i, index)
+				}
+			}
+			// usage は成功したすべてのリクエストの合計
+			succeeded := 0
+			for seq := range f.requests {
+				if tt.fail == nil || !tt.fail(seq) {
+					succeeded++
+				}
+			}
+			if got, want := resp["usage"].(map[string]any)["total_tokens"], float64(12*succeeded); got != want {
+				t.Errorf("total_tokens = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestChoicesMissingHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name        string
+		mode        string
+		honorN      bool
+		fail        func(seq int) bool
+		wantMissing string
+		wantChoices int
+	}{
+		{name: "n honored", mode: nFanoutOff, honorN: true, wantChoices: 3},
+		{name: "n ignored without fanout", mode: nFanoutOff, wantMissing: "2", wantChoices: 1},
+		{name: "n ignored with fallback", mode: nFanoutFallback, wantChoices: 3},
+		{name: "failed fanout request", mode: nFanoutAlways, fail: func(seq int) bool { return seq == 0 }, wantMissing: "1", wantChoices: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeBifrost{honorN: tt.honorN, fail: tt.fail}
+			useFakeBifrost(t, f)
+			nFanout = tt.mode
+
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			body := `{"model": "m", "n": 3, "messages": [{"role": "user", "content": "hi"}]}`
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			handleChatCompletionsEmulate(c)
+
+			if rec.Code != 200 {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get(headerChoicesMissing); got != tt.wantMissing {
+				t.Errorf("%s = %q, want %q", headerChoicesMissing, got, tt.wantMissing)
+			}
+			var resp ChatCompletionResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Choices) != tt.wantChoices {
+				t.Errorf("got %d choices, want %d", len(resp.Choices), tt.wantChoices)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+/**
+ * coercion.go
+ *
+ * 抽出したツール呼び出しの引数の型をツール定義のスキーマに合わせる
+ * XML形式などパラメータ値がテキストで書かれる形式では、パーサーは値の見た目から型を推定する（inferType など）。
+ * ツール定義の parameters でプロパティの型が宣言されている場合は、パーサーが保持した書かれたままの値（RawArguments）から
+ * schema パッケージで型を決め直す。JSON 形式の呼び出しでも、文字列で書かれた数値・真偽値などをスキーマの型に合わせる。
+ * 型が宣言されていないプロパティは、パーサーの推定した値をそのまま使う。
+ */
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/t-kawata/tcgw/schema"
+)
+
+// coerceToolCallArguments は各呼び出しの引数を、同名のツール定義の parameters に合わせて変換する
+// ツール定義にない名前の呼び出し・引数が JSON オブジェクトでない呼び出しはそのまま返す
+func coerceToolCallArguments(calls []ToolCall, tools []Tool) []ToolCall {
+	if len(calls) == 0 || len(tools) == 0 {
+		return calls
+	}
+	defs := make(map[string]map[string]any, len(tools))
+	for _, tool := range tools {
+		defs[tool.Function.Name] = tool.Function.Parameters
+	}
+	for i, call := range calls {
+		params, ok := defs[call.Function.Name]
+		if !ok || len(params) == 0 {
+			continue
+		}
+		if arguments, changed := coerceArguments(params, call.Function.Arguments, call.RawArguments); changed {
+			logDebug("Tool Call Arguments Coerced", map[string]any{
+				"Function": call.Function.Name,
+				"Before":   call.Function.Arguments,
+				"After":    arguments,
+			})
+			calls[i].Function.Arguments = arguments
+		}
+	}
+	return calls
+}
+
+// coerceArguments は1件の呼び出しの引数（JSON文字列）を変換し、変わった場合は changed=true とともに返す
+func coerceArguments(params map[string]any, arguments string, raw map[string]string) (string, bool) {
+	args, ok := decodeArguments(arguments).(map[string]any)
+	if !ok {
+		return arguments, false
+	}
+
+	props, _ := params["properties"].(map[string]any)
+	for name, value := range args {
+		prop, ok := props[name].(map[string]any)
+		if !ok {
+			continue // 型が宣言されていないパラメータはパーサーの推定のまま
+		}
+		if text, ok := raw[name]; ok {
+			if coerced, ok := schema.CoerceText(params, prop, text); ok {
+				args[name] = coerced
+			}
+			continue
+		}
+		args[name] = schema.CoerceValue(params, prop, value)
+	}
+
+	coerced, err := json.Marshal(args)
+	if err != nil {
+		return arguments, false
+	}
+	// キーの順序・空白の違いだけで変更扱いにしないよう、元の引数も同じ方法で整形して比べる
+	original, _ := json.Marshal(decodeArguments(arguments))
+	if string(coerced) == string(original) {
+		return arguments, false
+	}
+	return string(coerced), true
+}
+
+// decodeArguments は引数の JSON 文字列をデコードする（数値の表記を変えないため json.Number）
+func decodeArguments(arguments string) any {
+	dec := json.NewDecoder(strings.NewReader(arguments))
+	dec.UseNumber()
+	var value any
+	_ = dec.Decode(&value)
+	return value
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ModelRule はモデル名のパターンごとの設定（MODEL_CONFIG_FILE の "models" 配列の1要素）
+// 値を指定しなかった項目は、後続のルールまたはデフォルト値が使われる
+type ModelRule struct {
+	Pattern                  string   `json:"pattern"`                                // モデル名のglobパターン（* は任意の文字列、? は任意の1文字）
+	KeepContentWithToolCalls *bool    `json:"keep_content_with_tool_calls,omitempty"` // ツール呼び出し時に前後の文章を content に残すか
+	Parsers                  []string `json:"parsers,omitempty"`                      // ツール呼び出しを抽出するパーサー名（試す順。"default" は残りの標準の順に展開）
+	ToolPrompt               string   `json:"tool_prompt,omitempty"`                  // ツール定義プロンプトの形式（"tcgw", "hermes", "llama3.1", "mistral", "harmony"）
+	PromptProfile            string   `json:"prompt_profile,omitempty"`               // システムプロンプトのプロファイル名（PROMPT_PROFILE_DIR のテンプレート）
+	AssistantPrefill         *bool    `json:"assistant_prefill,omitempty"`            // バックエンドが末尾の assistant メッセージの続きを生成できるか（プレフィル）
+	SingleToolCallPolicy     string   `json:"single_tool_call_policy,omitempty"`      // parallel_tool_calls: false で複数の呼び出しがあった場合の扱い（"keep_first", "retry"）
+	ValidationPolicy         string   `json:"validation_policy,omitempty"`            // 引数がスキーマに合わない呼び出しの扱い（"pass", "drop", "repair"）
+	ToolNamePolicy           string   `json:"tool_name_policy,omitempty"`             // 宣言されていないツール名の呼び出しの扱い（"correct", "strict", "off"）
+	RepairAttempts           *int     `json:"repair_attempts,omitempty"`              // 壊れた・検証に失敗したツール呼び出しを直すよう聞き直す回数（0 は無効）
+	RepairTimeoutMs          *int     `json:"repair_timeout_ms,omitempty"`            // 聞き直しに使える時間の上限（ミリ秒。0 は REQUEST_TIMEOUT のみ）
+	ResponseFormatMode       string   `json:"response_format_mode,omitempty"`         // response_format の扱い（"passthrough", "emulate"）
+	NFanout                  string   `json:"n_fanout,omitempty"`                     // n > 1 の場合に TCGW が n 回に分けて転送するか（"off", "fallback", "always"）
+	ToolCalling              string   `json:"tool_calling,omitempty"`                 // ツール呼び出しの扱い（"emulated", "native"。"native" は ROUTING_MODE=auto の場合のみ有効）
+
+	pattern *regexp.Regexp // Pattern をコンパイルしたもの（LoadModelSettings で設定する）
+}
+
+// ModelSettings はモデル設定ファイルの内容
+type ModelSettings struct {
+	Models  []ModelRule       `json:"models"`
+	Aliases map[string]string `json:"aliases,omitempty"` // TCGWでのモデルの別名 → Bifrost のモデル名（ルールは Bifrost のモデル名で照合する）
+}
+
+// ModelConfig は特定のモデルに適用される設定（マッチしたルールとデフォルト値を統合したもの）
+type ModelConfig struct {
+	KeepContentWithToolCalls bool     // false の場合はツール呼び出し時の content を null にする（従来の動作。残すかはモデルごとのオプトイン）
+	Parsers                  []string // nil の場合は標準の抽出順（すべてのパーサー）
+	ToolPrompt               string   // 空の場合は TCGW標準（<function_calls> XML形式）
+	PromptProfile            string   // 空の場合は tool_prompt の形式のプロンプト（TCGW標準では default プロファイル）
+	AssistantPrefill         bool     // tool_choice で聞き直す際にツール呼び出しの開始部分をプレフィルとして渡すか
+	SingleToolCallPolicy     string   // parallel_tool_calls: false で複数の呼び出しがあった場合の扱い（空の場合は SINGLE_TOOL_CALL_POLICY）
+	ValidationPolicy         string   // 引数がスキーマに合わない呼び出しの扱い（空の場合は TOOL_VALIDATION_POLICY）
+	ToolNamePolicy           string   // 宣言されていないツール名の呼び出しの扱い（空の場合は TOOL_NAME_POLICY）
+	RepairAttempts           *int     // 壊れた・検証に失敗したツール呼び出しを聞き直す回数（nil の場合は TOOL_REPAIR_ATTEMPTS）
+	RepairTimeoutMs          *int     // 聞き直しに使える時間の上限（nil の場合は TOOL_REPAIR_TIMEOUT_MS）
+	ResponseFormatMode       string   // response_format の扱い（空の場合は RESPONSE_FORMAT_MODE）
+	NFanout                  string   // n > 1 の場合に n 回に分けて転送するか（空の場合は N_FANOUT）
+	ToolCalling              string   // ツール呼び出しの扱い（空の場合は "emulated"）
+}
+
+// DefaultModelConfig はどのルールにもマッチしない場合の設定
+func DefaultModelConfig() ModelConfig {
+	return ModelConfig{
+		KeepContentWithToolCalls: false,
+	}
+}
+
+// LoadModelSettings はJSON形式のモデル設定ファイルを読み込む
+func LoadModelSettings(path string) (*ModelSettings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var settings ModelSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	for i, rule := range settings.Models {
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("models[%d]: pattern is required", i)
+		}
+		// リクエストごとにコンパイルしないよう、読み込み時に一度だけコンパイルする
+		re, err := compileModelPattern(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("models[%d]: invalid pattern %q: %v", i, rule.Pattern, err)
+		}
+		settings.Models[i].pattern = re
+	}
+	for alias, target := range settings.Aliases {
+		if alias == "" || target == "" {
+			return nil, fmt.Errorf("aliases: alias and target model must not be empty (%q: %q)", alias, target)
+		}
+		if _, ok := settings.Aliases[target]; ok {
+			return nil, fmt.Errorf("aliases[%q]: target %q must not be another alias", alias, target)
+		}
+	}
+	return &settings, nil
+}
+
+// ResolveAlias はモデル名が別名であれば Bifrost のモデル名を返す（別名でなければ ok は false）
+func (s *ModelSettings) ResolveAlias(model string) (string, bool) {
+	if s == nil {
+		return model, false
+	}
+	target, ok := s.Aliases[model]
+	if !ok {
+		return model, false
+	}
+	return target, true
+}
+
+// Resolve はモデル名に適用される設定を返す
+// 項目ごとに、ファイル内で先に書かれたルールのうちその項目を指定しているものが優先される
+func (s *ModelSettings) Resolve(model string) ModelConfig {
+	cfg := DefaultModelConfig()
+	if s == nil {
+		return cfg
+	}
+	// 後ろのルールから順に上書きしていくことで、先に書かれたルールを優先する
+	for i := len(s.Models) - 1; i >= 0; i-- {
+		rule := s.Models[i]
+		if !rule.matches(model) {
+			continue
+		}
+		if rule.KeepContentWithToolCalls != nil {
+			cfg.KeepContentWithToolCalls = *rule.KeepContentWithToolCalls
+		}
+		if rule.Parsers != nil {
+			cfg.Parsers = rule.Parsers
+		}
+		if rule.ToolPrompt != "" {
+			cfg.ToolPrompt = rule.ToolPrompt
+		}
+		if rule.PromptProfile != "" {
+			cfg.PromptProfile = rule.PromptProfile
+		}
+		if rule.AssistantPrefill != nil {
+			cfg.AssistantPrefill = *rule.AssistantPrefill
+		}
+		if rule.SingleToolCallPolicy != "" {
+			cfg.SingleToolCallPolicy = rule.SingleToolCallPolicy
+		}
+		if rule.ValidationPolicy != "" {
+			cfg.ValidationPolicy = rule.ValidationPolicy
+		}
+		if rule.ToolNamePolicy != "" {
+			cfg.ToolNamePolicy = rule.ToolNamePolicy
+		}
+		if rule.RepairAttempts != nil {
+			cfg.RepairAttempts = rule.RepairAttempts
+		}
+		if rule.RepairTimeoutMs != nil {
+			cfg.RepairTimeoutMs = rule.RepairTimeoutMs
+		}
+		if rule.ResponseFormatMode != "" {
+			cfg.ResponseFormatMode = rule.ResponseFormatMode
+		}
+		if rule.NFanout != "" {
+			cfg.NFanout = rule.NFanout
+		}
+		if rule.ToolCalling != "" {
+			cfg.ToolCalling = rule.ToolCalling
+		}
+	}
+	return cfg
+}
+
+// matches はモデル名がルールのパターンにマッチするかを判定する
+// LoadModelSettings を通さずに作ったルール（コンパイル済みのパターンがない）は、その場でコンパイルして判定する
+func (r ModelRule) matches(model string) bool {
+	if r.pattern != nil {
+		return r.pattern.MatchString(model)
+	}
+	return MatchModelPattern(r.Pattern, model)
+}
+
+// MatchModelPattern はモデル名がglobパターンにマッチするかを判定する（大文字小文字は区別しない）
+// path.Match と異なり、* は "/" を含む任意の文字列にマッチする（例: "openrouter/qwen/*", "*deepseek-r1*"）
+func MatchModelPattern(pattern, model string) bool {
+	re, err := compileModelPattern(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(model)
+}
+
+// compileModelPattern はglobパターンを、モデル名全体に大文字小文字を区別せずマッチする正規表現にコンパイルする
+func compileModelPattern(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("(?i)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
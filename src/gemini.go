@@ -0,0 +1,701 @@
+/**
+ * gemini.go
+ *
+ * Google Gemini API（POST /v1beta/models/{model}:generateContent / :streamGenerateContent）の受け付け
+ * Gemini SDK の REST の形式を使うクライアントからも、Bifrost 経由の任意のモデルでツール呼び出しを使えるようにする。
+ * リクエストを ChatCompletionRequest に変換し、Chat Completions と同じエミュレートの処理に載せて、結果を candidates の形式で返す。
+ *   - systemInstruction → system メッセージ、contents の role: "model" → assistant
+ *   - tools[].functionDeclarations → tools（parameters の "STRING" などの大文字の type は JSON Schema の小文字にする）
+ *   - model の functionCall パート → tool_calls、user の functionResponse パート → role: "tool" のメッセージ
+ *     （id のない呼び出しには ID を振り、functionResponse とは name で対応づける）
+ *   - toolConfig.functionCallingConfig: AUTO → "auto"、ANY → "required"（allowedFunctionNames が1つなら関数名の指定）、NONE → "none"
+ *     （allowedFunctionNames に宣言されていない関数名がある場合は 400 INVALID_ARGUMENT）
+ *   - generationConfig.responseMimeType: "application/json"（responseSchema）→ response_format
+ *   - 抽出したツール呼び出しは functionCall パート（args はオブジェクト）として返す
+ * ストリーミング（:streamGenerateContent）では、差分ごとの GenerateContentResponse を ?alt=sse の場合は SSE で、それ以外は JSON 配列で送る。
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- 型定義 (Gemini API) ---
+
+// GeminiGenerateContentRequest は generateContent のリクエスト
+type GeminiGenerateContentRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []GeminiTool            `json:"tools,omitempty"`
+	ToolConfig        *GeminiToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// GeminiContent は会話の1ターン（role: "user" / "model"）
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts,omitempty"`
+}
+
+// GeminiPart は content のパート（text / inlineData / fileData / functionCall / functionResponse のいずれか）
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"` // 推論（思考）のテキスト
+	InlineData       *GeminiBlob             `json:"inlineData,omitempty"`
+	FileData         *GeminiFileData         `json:"fileData,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+// GeminiBlob は base64 のデータ（画像など）
+type GeminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// GeminiFileData は URI で参照するファイル
+type GeminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+// GeminiFunctionCall はモデルの関数呼び出し
+type GeminiFunctionCall struct {
+	ID   string         `json:"id,omitempty"`
+	Name string         `json:"name"`
+	Args map[string]any `json:"args"`
+}
+
+// GeminiFunctionResponse は関数の実行結果
+type GeminiFunctionResponse struct {
+	ID       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+// GeminiTool はツール（functionDeclarations のみ対応。googleSearch / codeExecution などは受け付けない）
+type GeminiTool struct {
+	FunctionDeclarations  []GeminiFunctionDeclaration `json:"functionDeclarations,omitempty"`
+	GoogleSearch          any                         `json:"googleSearch,omitempty"`
+	GoogleSearchRetrieval any                         `json:"googleSearchRetrieval,omitempty"`
+	CodeExecution         any                         `json:"codeExecution,omitempty"`
+	URLContext            any                         `json:"urlContext,omitempty"`
+}
+
+// GeminiFunctionDeclaration は関数の定義
+type GeminiFunctionDeclaration struct {
+	Name                 string         `json:"name"`
+	Description          string         `json:"description,omitempty"`
+	Parameters           map[string]any `json:"parameters,omitempty"`           // OpenAPI のサブセット（type は "STRING" などの大文字）
+	ParametersJSONSchema map[string]any `json:"parametersJsonSchema,omitempty"` // JSON Schema（parameters より優先）
+}
+
+// GeminiToolConfig はツールの使い方の指定
+type GeminiToolConfig struct {
+	FunctionCallingConfig *GeminiFunctionCallingConfig `json:"functionCallingConfig,omitempty"`
+}
+
+// GeminiFunctionCallingConfig は関数呼び出しのモード
+type GeminiFunctionCallingConfig struct {
+	Mode                 string   `json:"mode,omitempty"` // "AUTO", "ANY", "NONE", "VALIDATED"
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
+// GeminiGenerationConfig は生成の設定
+type GeminiGenerationConfig struct {
+	Temperature        *float32       `json:"temperature,omitempty"`
+	TopP               *float32       `json:"topP,omitempty"`
+	MaxOutputTokens    *int           `json:"maxOutputTokens,omitempty"`
+	StopSequences      []string       `json:"stopSequences,omitempty"`
+	Seed               *int64         `json:"seed,omitempty"`
+	PresencePenalty    *float32       `json:"presencePenalty,omitempty"`
+	FrequencyPenalty   *float32       `json:"frequencyPenalty,omitempty"`
+	ResponseMimeType   string         `json:"responseMimeType,omitempty"`   // "application/json" で JSON の応答
+	ResponseSchema     map[string]any `json:"responseSchema,omitempty"`     // OpenAPI のサブセット
+	ResponseJSONSchema map[string]any `json:"responseJsonSchema,omitempty"` // JSON Schema（responseSchema より優先）
+}
+
+// GeminiGenerateContentResponse は generateContent のレスポンス（ストリーミングでは差分ごとのチャンク）
+type GeminiGenerateContentResponse struct {
+	Candidates    []GeminiCandidate    `json:"candidates"`
+	UsageMetadata *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string               `json:"modelVersion,omitempty"`
+	ResponseID    string               `json:"responseId,omitempty"`
+}
+
+// GeminiCandidate は応答の候補
+type GeminiCandidate struct {
+	Content      GeminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"` // "STOP", "MAX_TOKENS", "SAFETY"
+	Index        int           `json:"index"`
+}
+
+// GeminiUsageMetadata はトークン使用量
+type GeminiUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+// --- リクエストの変換 ---
+
+// toChatCompletionRequest は generateContent のリクエストを ChatCompletionRequest に変換する（model と stream は URL から渡す）
+func (r *GeminiGenerateContentRequest) toChatCompletionRequest(model string, stream bool) (*ChatCompletionRequest, error) {
+	req := &ChatCompletionRequest{Model: model, Stream: stream}
+	if g := r.GenerationConfig; g != nil {
+		req.Temperature = g.Temperature
+		req.TopP = g.TopP
+		req.MaxTokens = g.MaxOutputTokens
+		req.Seed = g.Seed
+		req.PresencePenalty = g.PresencePenalty
+		req.FrequencyPenalty = g.FrequencyPenalty
+		if len(g.StopSequences) > 0 {
+			req.Stop = g.StopSequences
+		}
+		if format := g.responseFormat(); format != nil {
+			req.ResponseFormat = format
+		}
+	}
+	if stream {
+		// 最後のチャンクで usageMetadata を返すため、バックエンドにも usage を求める
+		req.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+
+	if r.SystemInstruction != nil {
+		if system := geminiText(r.SystemInstruction.Parts); system != "" {
+			req.Messages = append(req.Messages, Message{Role: "system", Content: system})
+		}
+	}
+	// id のない functionCall に振ったIDを、同じ name の functionResponse に順に対応づける
+	pending := map[string][]string{}
+	callCount := 0
+	for i, content := range r.Contents {
+		switch content.Role {
+		case "model":
+			msg := Message{Role: "assistant"}
+			var texts []string
+			for _, part := range content.Parts {
+				switch {
+				case part.FunctionCall != nil:
+					id := part.FunctionCall.ID
+					if id == "" {
+						callCount++
+						id = fmt.Sprintf("call_%s_%d", part.FunctionCall.Name, callCount)
+					}
+					pending[part.FunctionCall.Name] = append(pending[part.FunctionCall.Name], id)
+					args := part.FunctionCall.Args
+					if args == nil {
+						args = map[string]any{}
+					}
+					arguments, _ := json.Marshal(args)
+					msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: id, Type: "function", Function: ToolCallFunction{Name: part.FunctionCall.Name, Arguments: string(arguments)}})
+				case part.Thought:
+					// 推論（思考）は履歴に含めない
+				case part.Text != "":
+					texts = append(texts, part.Text)
+				}
+			}
+			if len(texts) > 0 {
+				msg.Content = strings.Join(texts, "\n\n")
+			}
+			req.Messages = append(req.Messages, msg)
+		case "user", "function", "":
+			req.Messages = append(req.Messages, geminiUserMessages(content.Parts, pending)...)
+		default:
+			return nil, fmt.Errorf("contents[%d].role: unsupported role %q", i, content.Role)
+		}
+	}
+
+	for i, tool := range r.Tools {
+		if tool.GoogleSearch != nil || tool.GoogleSearchRetrieval != nil || tool.CodeExecution != nil || tool.URLContext != nil {
+			return nil, fmt.Errorf("tools[%d]: only functionDeclarations are supported", i)
+		}
+		for _, decl := range tool.FunctionDeclarations {
+			params := decl.ParametersJSONSchema
+			if params == nil {
+				params = geminiSchema(decl.Parameters)
+			}
+			if params == nil {
+				params = map[string]any{"type": "object", "properties": map[string]any{}}
+			}
+			req.Tools = append(req.Tools, Tool{Type: "function", Function: FunctionDef{
+				Name:        decl.Name,
+				Description: decl.Description,
+				Parameters:  params,
+			}})
+		}
+	}
+	if r.ToolConfig != nil && r.ToolConfig.FunctionCallingConfig != nil {
+		fc := r.ToolConfig.FunctionCallingConfig
+		switch strings.ToUpper(fc.Mode) {
+		case "", "AUTO", "MODE_UNSPECIFIED":
+			req.ToolChoice = "auto"
+		case "ANY", "VALIDATED":
+			// 宣言されていない関数名が指定された場合は、ツールなしで呼び出しを求めることになるためエラーにする
+			if unknown := geminiUndeclaredFunctions(req.Tools, fc.AllowedFunctionNames); len(unknown) > 0 {
+				return nil, fmt.Errorf("toolConfig.functionCallingConfig.allowedFunctionNames: function(s) not declared in functionDeclarations: %s", strings.Join(unknown, ", "))
+			}
+			if len(fc.AllowedFunctionNames) == 1 {
+				req.ToolChoice = map[string]any{"type": "function", "function": map[string]any{"name": fc.AllowedFunctionNames[0]}}
+			} else {
+				req.ToolChoice = "required"
+				req.Tools = geminiAllowedTools(req.Tools, fc.AllowedFunctionNames)
+			}
+		case "NONE":
+			req.ToolChoice = "none"
+		default:
+			return nil, fmt.Errorf("toolConfig.functionCallingConfig.mode: unsupported value %q", fc.Mode)
+		}
+	}
+	return req, nil
+}
+
+// responseFormat は responseMimeType: "application/json" を response_format にする（スキーマがあれば json_schema）
+func (g *GeminiGenerationConfig) responseFormat() map[string]any {
+	if g.ResponseMimeType != "application/json" {
+		return nil
+	}
+	s := g.ResponseJSONSchema
+	if s == nil {
+		s = geminiSchema(g.ResponseSchema)
+	}
+	if s == nil {
+		return map[string]any{"type": "json_object"}
+	}
+	return map[string]any{"type": "json_schema", "json_schema": map[string]any{"name": "response", "schema": s}}
+}
+
+// geminiUndeclaredFunctions は allowedFunctionNames のうち functionDeclarations で宣言されていない関数名を返す
+func geminiUndeclaredFunctions(tools []Tool, allowed []string) []string {
+	declared := make(map[string]bool, len(tools))
+	for _, tool := range tools {
+		declared[tool.Function.Name] = true
+	}
+	var unknown []string
+	for _, name := range allowed {
+		if !declared[name] {
+			unknown = append(unknown, strconv.Quote(name))
+			declared[name] = true // 同じ名前は1度だけ挙げる
+		}
+	}
+	return unknown
+}
+
+// geminiAllowedTools は allowedFunctionNames に含まれるツールだけを残す（指定がなければそのまま）
+func geminiAllowedTools(tools []Tool, allowed []string) []Tool {
+	if len(allowed) == 0 {
+		return tools
+	}
+	var filtered []Tool
+	for _, tool := range tools {
+		for _, name := range allowed {
+			if tool.Function.Name == name {
+				filtered = append(filtered, tool)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// geminiUserMessages は user の content のパートを変換する
+// functionResponse は直前の tool_calls に続くよう role: "tool" のメッセージとして先に並べ、残りのテキスト・画像を user メッセージにする
+func geminiUserMessages(parts []GeminiPart, pending map[string][]string) []Message {
+	var messages []Message
+	var contentParts []ContentPart
+	hasImage := false
+	for _, part := range parts {
+		switch {
+		case part.FunctionResponse != nil:
+			fr := part.FunctionResponse
+			id := fr.ID
+			if ids := pending[fr.Name]; len(ids) > 0 {
+				if id == "" {
+					id = ids[0]
+				}
+				pending[fr.Name] = ids[1:]
+			}
+			messages = append(messages, Message{Role: "tool", ToolCallID: id, Name: fr.Name, Content: geminiFunctionResult(fr.Response)})
+		case part.InlineData != nil:
+			url := "data:" + part.InlineData.MimeType + ";base64," + part.InlineData.Data
+			contentParts = append(contentParts, ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: url}})
+			hasImage = true
+		case part.FileData != nil:
+			contentParts = append(contentParts, ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: part.FileData.FileURI}})
+			hasImage = true
+		case part.Text != "":
+			contentParts = append(contentParts, ContentPart{Type: "text", Text: part.Text})
+		}
+	}
+	switch {
+	case len(contentParts) == 0:
+	case hasImage:
+		messages = append(messages, Message{Role: "user", Content: contentParts})
+	default:
+		texts := make([]string, len(contentParts))
+		for i, part := range contentParts {
+			texts[i] = part.Text
+		}
+		messages = append(messages, Message{Role: "user", Content: strings.Join(texts, "\n\n")})
+	}
+	return messages
+}
+
+// geminiFunctionResult は functionResponse の response をツールの実行結果のテキストにする
+// {"output": ...} / {"result": ...} の形であれば中身を、それ以外は response 全体を JSON にする
+func geminiFunctionResult(response map[string]any) string {
+	if len(response) == 1 {
+		for _, key := range []string{"output", "result", "content"} {
+			if value, ok := response[key]; ok {
+				if s, ok := value.(string); ok {
+					return s
+				}
+				b, _ := json.Marshal(value)
+				return string(b)
+			}
+		}
+	}
+	if response == nil {
+		return ""
+	}
+	b, _ := json.Marshal(response)
+	return string(b)
+}
+
+// geminiText は text パートのテキストを連結する（推論のパートは含めない）
+func geminiText(parts []GeminiPart) string {
+	var texts []string
+	for _, part := range parts {
+		if part.Text != "" && !part.Thought {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// geminiSchema は Gemini の OpenAPI 形式のスキーマを JSON Schema にする（"STRING" などの大文字の type を小文字にした複製）
+func geminiSchema(s map[string]any) map[string]any {
+	if s == nil {
+		return nil
+	}
+	out := make(map[string]any, len(s))
+	for key, value := range s {
+		switch v := value.(type) {
+		case string:
+			if key == "type" {
+				v = strings.ToLower(v)
+			}
+			out[key] = v
+		case map[string]any:
+			if key == "properties" {
+				props := make(map[string]any, len(v))
+				for name, prop := range v {
+					if m, ok := prop.(map[string]any); ok {
+						props[name] = geminiSchema(m)
+					} else {
+						props[name] = prop
+					}
+				}
+				out[key] = props
+			} else {
+				out[key] = geminiSchema(v)
+			}
+		case []any:
+			items := make([]any, len(v))
+			for i, item := range v {
+				if m, ok := item.(map[string]any); ok {
+					items[i] = geminiSchema(m)
+				} else {
+					items[i] = item
+				}
+			}
+			out[key] = items
+		default:
+			out[key] = value
+		}
+	}
+	return out
+}
+
+// --- レスポンスの変換 ---
+
+// geminiFinishReason は finish_reason を finishReason に変換する（ツール呼び出しで止まった場合も "STOP"、コンテンツフィルターは "SAFETY"）
+func geminiFinishReason(finish string) string {
+	switch finish {
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+// geminiFunctionCallPart はツール呼び出しを functionCall パートにする（引数の JSON はオブジェクトとして返す）
+func geminiFunctionCallPart(tc ToolCall) GeminiPart {
+	args := map[string]any{}
+	if strings.TrimSpace(tc.Function.Arguments) != "" {
+		if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil || args == nil {
+			args = map[string]any{}
+		}
+	}
+	return GeminiPart{FunctionCall: &GeminiFunctionCall{ID: tc.ID, Name: tc.Function.Name, Args: args}}
+}
+
+// geminiUsage は usage を usageMetadata にする
+func geminiUsage(usage *Usage) *GeminiUsageMetadata {
+	if usage == nil {
+		return nil
+	}
+	return &GeminiUsageMetadata{
+		PromptTokenCount:     usage.PromptTokens,
+		CandidatesTokenCount: usage.CompletionTokens,
+		TotalTokenCount:      usage.PromptTokens + usage.CompletionTokens,
+	}
+}
+
+// buildGeminiResponse はエミュレートの結果から generateContent のレスポンスを作る
+func buildGeminiResponse(model string, result *emulationResult) GeminiGenerateContentResponse {
+	var parts []GeminiPart
+	if result.reasoning != "" {
+		parts = append(parts, GeminiPart{Text: result.reasoning, Thought: true})
+	}
+	if result.content != "" {
+		parts = append(parts, GeminiPart{Text: result.content})
+	}
+	for _, tc := range result.toolCalls {
+		parts = append(parts, geminiFunctionCallPart(tc))
+	}
+	usage := result.usage()
+	return GeminiGenerateContentResponse{
+		Candidates: []GeminiCandidate{{
+			Content:      GeminiContent{Role: "model", Parts: parts},
+			FinishReason: geminiFinishReason(result.finishReason),
+		}},
+		UsageMetadata: geminiUsage(&usage),
+		ModelVersion:  model,
+		ResponseID:    strings.TrimPrefix(generateResponseID(), "chatcmpl-"),
+	}
+}
+
+// geminiErrorStatus はステータスコードに対応する Gemini API のエラーの status
+func geminiErrorStatus(status int) string {
+	switch status {
+	case 400:
+		return "INVALID_ARGUMENT"
+	case 401:
+		return "UNAUTHENTICATED"
+	case 403:
+		return "PERMISSION_DENIED"
+	case 404:
+		return "NOT_FOUND"
+	case 429:
+		return "RESOURCE_EXHAUSTED"
+	case 503:
+		return "UNAVAILABLE"
+	case 504:
+		return "DEADLINE_EXCEEDED"
+	default:
+		return "INTERNAL"
+	}
+}
+
+// geminiErrorBody は Gemini API の形式のエラーの本文
+func geminiErrorBody(status int, message string) gin.H {
+	return gin.H{"error": gin.H{"code": status, "message": message, "status": geminiErrorStatus(status)}}
+}
+
+// writeGeminiError はエミュレートの処理のエラーを Gemini API の形式で返す
+func writeGeminiError(c *gin.Context, apiErr *emulationError) {
+	c.JSON(apiErr.status, geminiErrorBody(apiErr.status, apiErr.detail().Message))
+}
+
+// --- ストリーミング ---
+
+// geminiStreamWriter はクライアントへ差分ごとの GenerateContentResponse を書き出す
+// sse（?alt=sse）の場合は data: 行で、それ以外は1つの JSON 配列の要素として送る
+type geminiStreamWriter struct {
+	c       *gin.Context
+	model   string
+	id      string
+	sse     bool
+	written int // 書き出したチャンクの数（JSON 配列の区切りに使う）
+}
+
+// ストリーミングのヘッダーを送出し、ストリーミング用ライターを作成
+func newGeminiStreamWriter(c *gin.Context, model string, sse bool) *geminiStreamWriter {
+	if sse {
+		c.Header("Content-Type", "text/event-stream")
+	} else {
+		c.Header("Content-Type", "application/json")
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // リバースプロキシでのバッファリングを無効化
+	c.Status(http.StatusOK)
+	return &geminiStreamWriter{c: c, model: model, id: strings.TrimPrefix(generateResponseID(), "chatcmpl-"), sse: sse}
+}
+
+// writeChunk はチャンクを1つ書き出してフラッシュ
+func (w *geminiStreamWriter) writeChunk(data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	switch {
+	case w.sse:
+		_, err = fmt.Fprintf(w.c.Writer, "data: %s\n\n", b)
+	case w.written == 0:
+		_, err = fmt.Fprintf(w.c.Writer, "[%s", b)
+	default:
+		_, err = fmt.Fprintf(w.c.Writer, ",\r\n%s", b)
+	}
+	if err != nil {
+		return err
+	}
+	w.written++
+	w.c.Writer.Flush()
+	return nil
+}
+
+// writeParts はパートの差分を1つのチャンクとして書き出す
+func (w *geminiStreamWriter) writeParts(parts []GeminiPart, finishReason string, usage *Usage) error {
+	return w.writeChunk(GeminiGenerateContentResponse{
+		Candidates:    []GeminiCandidate{{Content: GeminiContent{Role: "model", Parts: parts}, FinishReason: finishReason}},
+		UsageMetadata: geminiUsage(usage),
+		ModelVersion:  w.model,
+		ResponseID:    w.id,
+	})
+}
+
+// Gemini API のストリームには role だけのチャンクがないため何もしない
+func (w *geminiStreamWriter) writeRole() error {
+	return nil
+}
+
+// テキストの差分を text パートとして書き出す
+func (w *geminiStreamWriter) writeContent(text string) error {
+	if text == "" {
+		return nil
+	}
+	return w.writeParts([]GeminiPart{{Text: text}}, "", nil)
+}
+
+// 推論テキストの差分を thought: true の text パートとして書き出す
+func (w *geminiStreamWriter) writeReasoning(text string) error {
+	if text == "" {
+		return nil
+	}
+	return w.writeParts([]GeminiPart{{Text: text, Thought: true}}, "", nil)
+}
+
+// ツール呼び出しを functionCall パートとして書き出す（Gemini API では引数を分割せず1つのパートで送る）
+func (w *geminiStreamWriter) writeToolCalls(toolCalls []ToolCall) error {
+	parts := make([]GeminiPart, len(toolCalls))
+	for i, tc := range toolCalls {
+		parts[i] = geminiFunctionCallPart(tc)
+	}
+	return w.writeParts(parts, "", nil)
+}
+
+// finishReason と usageMetadata を最後のチャンクとして書き出す
+func (w *geminiStreamWriter) writeFinish(reason string, usage *Usage) error {
+	return w.writeParts(nil, geminiFinishReason(reason), usage)
+}
+
+// エラーを書き出す（ストリーム開始後にエラーが発生した場合）
+func (w *geminiStreamWriter) writeError(status int, body map[string]any) error {
+	apiErr := &emulationError{status: status, body: body}
+	return w.writeChunk(geminiErrorBody(status, apiErr.detail().Message))
+}
+
+// JSON 配列の場合は配列を閉じる（SSE には終端のイベントがない）
+func (w *geminiStreamWriter) writeDone() error {
+	if w.sse {
+		return nil
+	}
+	closing := "]"
+	if w.written == 0 {
+		closing = "[]"
+	}
+	if _, err := fmt.Fprint(w.c.Writer, closing); err != nil {
+		return err
+	}
+	w.c.Writer.Flush()
+	return nil
+}
+
+// --- Ginハンドラー ---
+
+// Gemini API: /v1beta/models/{model}:{method} の method で generateContent と streamGenerateContent を振り分ける
+// model に "/" を含む場合（"openai/gpt-4o-mini" など）もあるため、パスの末尾の ":" で分ける
+func handleGeminiModelAction(c *gin.Context) {
+	action := strings.TrimPrefix(c.Param("action"), "/")
+	sep := strings.LastIndex(action, ":")
+	if sep <= 0 {
+		c.JSON(404, geminiErrorBody(404, fmt.Sprintf("Method not found: %s", action)))
+		return
+	}
+	model, method := action[:sep], action[sep+1:]
+	switch method {
+	case "generateContent":
+		handleGeminiGenerateContent(c, model, false)
+	case "streamGenerateContent":
+		handleGeminiGenerateContent(c, model, true)
+	default:
+		c.JSON(404, geminiErrorBody(404, fmt.Sprintf("Method not supported: %s", method)))
+	}
+}
+
+// Gemini API: リクエストを変換してエミュレートし、candidates の形式で返す
+func handleGeminiGenerateContent(c *gin.Context, model string, stream bool) {
+	var greq GeminiGenerateContentRequest
+	if err := c.ShouldBindJSON(&greq); err != nil {
+		c.JSON(400, geminiErrorBody(400, fmt.Sprintf("Invalid JSON: %v", err)))
+		return
+	}
+	req, err := greq.toChatCompletionRequest(model, stream)
+	if err != nil {
+		c.JSON(400, geminiErrorBody(400, err.Error()))
+		return
+	}
+	logDebug("Request Received (Gemini API)", map[string]any{
+		"Model":         req.Model,
+		"Tool Count":    len(req.Tools),
+		"Message Count": len(req.Messages),
+		"Has Stream":    req.Stream,
+	})
+
+	e, apiErr := prepareEmulation(c, req, false)
+	if apiErr != nil {
+		writeGeminiError(c, apiErr)
+		return
+	}
+	if stream {
+		sse := c.Query("alt") == "sse"
+		handleChatCompletionsEmulateStream(c, e, streamFrontEnd{
+			newWriter: func() emulationStreamWriter { return newGeminiStreamWriter(c, model, sse) },
+			fail: func(status int, body any) {
+				writeGeminiError(c, &emulationError{status: status, body: body})
+			},
+		})
+		return
+	}
+	result, apiErr := runEmulation(e, c.Writer.Header())
+	if apiErr != nil {
+		writeGeminiError(c, apiErr)
+		return
+	}
+	resp := buildGeminiResponse(model, result)
+	logDebug("Response Generated (Gemini API)", map[string]any{
+		"Finish Reason":    resp.Candidates[0].FinishReason,
+		"Tool Calls Count": len(result.toolCalls),
+	})
+	c.JSON(200, resp)
+}
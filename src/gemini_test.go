@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGeminiFunctionCallingConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		toolConfig string
+		wantChoice any
+		wantTools  []string
+		wantErr    string // エラーメッセージに含まれる文字列（空の場合はエラーなし）
+	}{
+		{"auto", `{"mode": "AUTO"}`, "auto", []string{"get_weather", "get_time"}, ""},
+		{"none", `{"mode": "NONE"}`, "none", []string{"get_weather", "get_time"}, ""},
+		{"any", `{"mode": "ANY"}`, "required", []string{"get_weather", "get_time"}, ""},
+		{
+			"any with one name",
+			`{"mode": "ANY", "allowedFunctionNames": ["get_time"]}`,
+			map[string]any{"type": "function", "function": map[string]any{"name": "get_time"}},
+			[]string{"get_weather", "get_time"},
+			"",
+		},
+		{"validated with several names", `{"mode": "VALIDATED", "allowedFunctionNames": ["get_time", "get_weather"]}`, "required", []string{"get_weather", "get_time"}, ""},
+		{"undeclared single name", `{"mode": "ANY", "allowedFunctionNames": ["get_wether"]}`, nil, nil, `"get_wether"`},
+		{"undeclared among several", `{"mode": "ANY", "allowedFunctionNames": ["get_time", "nope", "nope"]}`, nil, nil, `functionDeclarations: "nope"`},
+		{"unknown mode", `{"mode": "SOMETIMES"}`, nil, nil, "unsupported value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{
+				"contents": [{"role": "user", "parts": [{"text": "hi"}]}],
+				"tools": [{"functionDeclarations": [{"name": "get_weather"}, {"name": "get_time"}]}],
+				"toolConfig": {"functionCallingConfig": ` + tt.toolConfig + `}
+			}`
+			var greq GeminiGenerateContentRequest
+			if err := json.Unmarshal([]byte(body), &greq); err != nil {
+				t.Fatal(err)
+			}
+			req, err := greq.toChatCompletionRequest("m", false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(req.ToolChoice, tt.wantChoice) {
+				t.Errorf("tool_choice = %#v, want %#v", req.ToolChoice, tt.wantChoice)
+			}
+			var names []string
+			for _, tool := range req.Tools {
+				names = append(names, tool.Function.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantTools) {
+				t.Errorf("tools = %v, want %v", names, tt.wantTools)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+/**
+ * history.go
+ *
+ * ツール呼び出し履歴のテキスト化
+ * ツール非対応のバックエンドは、tool_calls を持つ assistant メッセージや role: "tool" のメッセージを拒否するか、黙って無視する。
+ * そのため、転送前に assistant のツール呼び出しをシステムプロンプトで教えたものと同じ <function_calls> 形式に、
+ * ツールの実行結果を <function_results> 形式の user メッセージに書き換え、複数ターンのエージェントループを成立させる。
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// translateToolHistory はメッセージ履歴中のツール呼び出しと実行結果をテキストに書き換えたメッセージ列を返す
+// 書き方はツール定義プロンプトと同じレンダラーに従う（TCGW標準では <function_calls> / <function_results>）
+// 連続する tool メッセージは1つの user メッセージにまとめる（ツール名は tool_call_id から直前の呼び出しを引いて求める）
+// 直後がテキストのみの user メッセージの場合は、その先頭に実行結果を入れる
+func translateToolHistory(messages []Message, renderer toolPromptRenderer) []Message {
+	callNames := make(map[string]string) // tool_call_id → ツール名
+	translated := make([]Message, 0, len(messages))
+	var results []toolResult
+	callCount, resultCount := 0, 0
+
+	// 溜めた実行結果を1つの user メッセージとして追加
+	flushResults := func() {
+		if len(results) == 0 {
+			return
+		}
+		translated = append(translated, Message{Role: "user", Content: renderer.ToolResults(results)})
+		results = nil
+	}
+
+	for _, msg := range messages {
+		switch {
+		case msg.Role == "tool":
+			name := callNames[msg.ToolCallID]
+			if name == "" {
+				name = msg.Name // 対応する呼び出しが履歴にない場合はメッセージの name を使う
+			}
+			if name == "" {
+				name = "unknown"
+			}
+			results = append(results, toolResult{Name: name, CallID: msg.ToolCallID, Content: extractStringContent(msg.Content)})
+			resultCount++
+		case msg.Role == "assistant" && len(msg.ToolCalls) > 0:
+			flushResults()
+			for _, call := range msg.ToolCalls {
+				callNames[call.ID] = call.Function.Name
+			}
+			text := renderer.ToolCalls(msg.ToolCalls)
+			if content := strings.TrimSpace(extractStringContent(msg.Content)); content != "" {
+				text = content + "\n\n" + text
+			}
+			translated = append(translated, Message{Role: "assistant", Content: text})
+			callCount += len(msg.ToolCalls)
+		case msg.Role == "user" && len(results) > 0:
+			// user/assistant の交互を要求するバックエンドのため、直後の user メッセージ（テキストのみ）には実行結果を前置して1つにまとめる
+			if text, ok := msg.Content.(string); ok {
+				msg.Content = renderer.ToolResults(results) + "\n\n" + text
+				results = nil
+			} else {
+				flushResults()
+			}
+			translated = append(translated, msg)
+		default:
+			flushResults()
+			translated = append(translated, msg)
+		}
+	}
+	flushResults()
+
+	if callCount > 0 || resultCount > 0 {
+		logDebug("Tool History Translated", map[string]any{
+			"Tool Calls":     callCount,
+			"Tool Results":   resultCount,
+			"Messages Count": len(translated),
+		})
+	}
+	return translated
+}
+
+// renderFunctionCalls はツール呼び出しを TCGW標準のシステムプロンプトで指示した <function_calls> 形式に変換する
+func renderFunctionCalls(calls []ToolCall) string {
+	var sb strings.Builder
+	sb.WriteString("<function_calls>\n")
+	for _, call := range calls {
+		sb.WriteString(fmt.Sprintf("  <invoke name=\"%s\">\n", escapeXML(call.Function.Name)))
+		keys, values := decodeOrderedArguments(call.Function.Arguments)
+		for _, key := range keys {
+			sb.WriteString(fmt.Sprintf("    <parameter name=\"%s\">%s</parameter>\n", escapeXML(key), renderParameterValue(values[key])))
+		}
+		sb.WriteString("  </invoke>\n")
+	}
+	sb.WriteString("</function_calls>")
+	return sb.String()
+}
+
+// renderFunctionResults は <result> ブロックを <function_results> で囲む
+func renderFunctionResults(results []string) string {
+	return "<function_results>\n" + strings.Join(results, "\n") + "\n</function_results>"
+}
+
+// renderFunctionResult はツールの実行結果を <function_results> 内の1件分の <result> ブロックに変換する
+func renderFunctionResult(name, output string) string {
+	return fmt.Sprintf("<result>\n<tool_name>%s</tool_name>\n<stdout>\n%s\n</stdout>\n</result>", escapeXML(name), strings.TrimSpace(output))
+}
+
+// decodeOrderedArguments は arguments（JSON文字列）をキーの出現順を保ったまま展開する
+// オブジェクトとして解釈できない場合は、生の文字列を "arguments" パラメータとして扱う
+func decodeOrderedArguments(arguments string) ([]string, map[string]json.RawMessage) {
+	values := make(map[string]json.RawMessage)
+	trimmed := strings.TrimSpace(arguments)
+	if trimmed == "" || trimmed == "{}" || trimmed == "null" {
+		return nil, values
+	}
+
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return fallbackArguments(arguments)
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return fallbackArguments(arguments)
+		}
+		key, _ := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fallbackArguments(arguments)
+		}
+		if _, exists := values[key]; !exists {
+			keys = append(keys, key)
+		}
+		values[key] = raw
+	}
+	return keys, values
+}
+
+// 解釈できない arguments を1つの文字列パラメータとして返す
+func fallbackArguments(arguments string) ([]string, map[string]json.RawMessage) {
+	raw, _ := json.Marshal(arguments)
+	return []string{"arguments"}, map[string]json.RawMessage{"arguments": raw}
+}
+
+// renderParameterValue はパラメータの値を <parameter> の中身に変換する
+// 文字列はそのまま、数値・真偽値・オブジェクト・配列は1行のJSONとして書く（いずれも < > & のみエスケープする）
+func renderParameterValue(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return escapeXMLText(s)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return escapeXMLText(string(raw))
+	}
+	return escapeXMLText(compact.String())
+}
+
+// escapeXMLText は要素の中身として書くテキストの & < > のみをエスケープする
+// 引用符はエスケープしないため、JSONの値をそのまま読める形で埋め込める（抽出時は unescapeXML で元に戻る）
+func escapeXMLText(s string) string {
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	s = strings.ReplaceAll(s, "<", "&lt;")
+	s = strings.ReplaceAll(s, ">", "&gt;")
+	return s
+}
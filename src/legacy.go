@@ -0,0 +1,106 @@
+/**
+ * legacy.go
+ *
+ * 旧形式の functions / function_call（tools / tool_choice 以前の Chat Completions API）への対応
+ * 旧形式のリクエストは、ツール呼び出しの処理の前に tools / tool_choice の形に書き換えて同じ処理に載せる。
+ *   - functions → tools、function_call（"none" / "auto" / {"name": ...}）→ tool_choice
+ *   - 旧形式では1回の応答で呼び出せる関数は1つのため、parallel_tool_calls: false として扱う
+ *   - 履歴の assistant メッセージの function_call は tool_calls に、role: "function" のメッセージは role: "tool" に書き換える
+ * 旧形式のリクエストには、message.function_call と finish_reason: "function_call" の旧形式で応答する。
+ */
+package main
+
+import "fmt"
+
+// legacyFinishReason は旧形式の関数呼び出し時の finish_reason
+const legacyFinishReason = "function_call"
+
+// translateLegacyFunctions は旧形式の functions / function_call を tools / tool_choice に書き換える
+// functions を使ったリクエスト（tools を指定していないもの）の場合は true を返し、応答も旧形式にする
+func translateLegacyFunctions(req *ChatCompletionRequest) bool {
+	req.Messages = translateLegacyHistory(req.Messages)
+
+	legacy := len(req.Functions) > 0 && len(req.Tools) == 0
+	for _, fn := range req.Functions {
+		req.Tools = append(req.Tools, Tool{Type: "function", Function: fn})
+	}
+	if req.FunctionCall != nil && req.ToolChoice == nil {
+		switch fc := req.FunctionCall.(type) {
+		case string:
+			req.ToolChoice = fc // "none" / "auto"
+		case map[string]any:
+			req.ToolChoice = map[string]any{"type": "function", "function": map[string]any{"name": fc["name"]}}
+		default:
+			req.ToolChoice = fc // parseToolChoice で不正な値として扱う
+		}
+	}
+	if legacy && req.ParallelToolCalls == nil {
+		single := false
+		req.ParallelToolCalls = &single
+	}
+	if len(req.Functions) > 0 || req.FunctionCall != nil {
+		logDebug("Legacy Functions Translated", map[string]any{
+			"Functions":       len(req.Functions),
+			"Function Call":   req.FunctionCall,
+			"Legacy Response": legacy,
+		})
+	}
+	req.Functions = nil
+	req.FunctionCall = nil
+	return legacy
+}
+
+// translateLegacyHistory は履歴中の旧形式の関数呼び出しと実行結果を、tool_calls / role: "tool" の形に書き換える
+// 旧形式の呼び出しには ID がないため、関数名ごとに直前の呼び出しへ実行結果を対応づける
+func translateLegacyHistory(messages []Message) []Message {
+	lastCallID := make(map[string]string) // 関数名 → 直前の呼び出しの ID
+	count := 0
+	for i, msg := range messages {
+		switch {
+		case msg.Role == "assistant" && msg.FunctionCall != nil:
+			count++
+			id := fmt.Sprintf("call_legacy_%d", count)
+			lastCallID[msg.FunctionCall.Name] = id
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: id, Type: "function", Function: *msg.FunctionCall})
+			msg.FunctionCall = nil
+		case msg.Role == "function":
+			msg.Role = "tool"
+			msg.ToolCallID = lastCallID[msg.Name]
+		default:
+			continue
+		}
+		messages[i] = msg
+	}
+	return messages
+}
+
+// legacyFunctionCallResponse は patchOpenAIResponse で作ったレスポンスを旧形式にする
+// 最初のツール呼び出しを message.function_call に移し、finish_reason を "function_call" にする
+func legacyFunctionCallResponse(resp map[string]any) map[string]any {
+	choices, _ := resp["choices"].([]any)
+	for _, c := range choices {
+		choice, _ := c.(map[string]any)
+		message, _ := choice["message"].(map[string]any)
+		if message == nil {
+			continue
+		}
+		if calls, ok := message["tool_calls"].([]ToolCall); ok && len(calls) > 0 {
+			message["function_call"] = calls[0].Function
+			choice["finish_reason"] = legacyFinishReason
+		}
+		delete(message, "tool_calls")
+	}
+	return resp
+}
+
+// legacyFunctionCallMessage は buildOpenAIResponse で作ったレスポンスを旧形式にする
+func legacyFunctionCallMessage(resp *ChatCompletionResponse) {
+	for i := range resp.Choices {
+		msg := &resp.Choices[i].Message
+		if len(msg.ToolCalls) > 0 {
+			msg.FunctionCall = &msg.ToolCalls[0].Function
+			resp.Choices[i].FinishReason = legacyFinishReason
+		}
+		msg.ToolCalls = nil
+	}
+}
@@ -24,19 +24,22 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/t-kawata/tcgw/config"
+	"github.com/t-kawata/tcgw/parser"
 )
 
 // --- 定数定義 ---
 const (
+	// TOOL_SYSTEM_PROMPT は組み込みの default プロファイルのテンプレート（text/template。promptprofile.go）
 	TOOL_SYSTEM_PROMPT = `You are a function-calling AI agent. You are STRICTLY PROHIBITED from generating any natural language text EXCEPT when providing the final answer after all tools have been executed.
 
 Your ONLY valid outputs are:
@@ -48,7 +51,7 @@ MANDATORY: Every response must be either a tool call OR a final conversational a
 You have access to the following tools:
 
 <tools>
-{{TOOLS_XML}}
+{{.ToolsXML}}
 </tools>
 
 CRITICAL INSTRUCTIONS:
@@ -68,6 +71,7 @@ CRITICAL INSTRUCTIONS:
    - FORBIDDEN: Any text explaining your next action
 5. After receiving tool results, if you need to use another tool, call it immediately without explanation.
    - DO NOT write "次に〜" or "Now I will〜" - just call the tool
+   - Tool results are given to you in a user message wrapped in <function_results> blocks
 6. When no more tools are needed, you MUST provide a final conversational response in Japanese. Empty responses are FORBIDDEN.
 7. Your final conversational response MUST be in Japanese (日本語).
 8. CRITICAL: You MUST provide a final response when no more tools are needed. Empty responses are FORBIDDEN.
@@ -111,63 +115,27 @@ You: <function_calls><invoke name="sendEmail"><parameter name="subject">Flight C
 You: 明日の東京の天気は晴れ（気温25度）です。フライトFL12345の予約が完了し、確認メールを送信しました。
 
 Always use the exact tool names and parameter names as specified.`
-
-	FUNCTION_CALLS_PATTERN = `<function_calls>([\s\S]*?)</function_calls>`
-	INVOKE_PATTERN         = `<invoke\s+name="([^"]+)">([\s\S]*?)</invoke>`
-	PARAMETER_PATTERN      = `<parameter\s+name="([^"]+)">([\s\S]*?)</parameter>`
-	JSON_PATTERN           = `\{[^{}]*"tool_calls"[^{}]*\}`
-	MARKDOWN_JSON_PATTERN  = `(?s)` + "`" + `(?:json)?\s*([^` + "`" + `]+)` + "`" + `(?:` + "`" + `|$)`
-)
-
-// --- 正規表現 (グローバルコンパイル) ---
-// パフォーマンス向上のため、正規表現を起動時に一度だけコンパイルします
-var (
-	reFunctionCalls = regexp.MustCompile(FUNCTION_CALLS_PATTERN)
-	reInvoke        = regexp.MustCompile(INVOKE_PATTERN)
-	reParameter     = regexp.MustCompile(PARAMETER_PATTERN)
-	reJSON          = regexp.MustCompile(JSON_PATTERN)
-	reMarkdownJSON  = regexp.MustCompile(MARKDOWN_JSON_PATTERN)
-)
-
-// ========================================
-// 正規表現の事前コンパイル（パフォーマンス最適化）
-// すべての正規表現を起動時に一度だけコンパイル
-// ========================================
-
-var (
-	// GPT-OSS
-	regexGPTOSS = regexp.MustCompile(`<\|channel\|>(commentary|analysis)\s+to=(?:functions\.)?([a-zA-Z0-9_]+)(?:\s+<\|constrain\|>[a-zA-Z0-9_-]+)?(?:\s+<\|message\|>)?(.*?)(?:<\|call\|>|$)`)
-
-	// Hermes 2 Pro - 複雑な開始パターン
-	regexHermes2ProOpen = regexp.MustCompile(`(?:(<|\[)?)?` +
-		`(<tool_call>|<functioncall>|<function>|<tool>|<tools>|<response>|<json>|<xml>|<JSON>)?` +
-		`\s*` +
-		`(?:<name>([^<]+)</name>)?` +
-		`(?:<function>([^<(]+))?` +
-		`(?:<function>([^<]+))?`)
-
-	// Functionary v3.2
-	regexFunctionaryV32 = regexp.MustCompile(`>>>(\w+)`)
-
-	// Functionary v3.1 Llama 3.1
-	regexFunctionaryV31Llama31 = regexp.MustCompile(`<function=([^>]+)>`)
-
-	// Llama 3.x
-	regexLlama3X = regexp.MustCompile(`\{"type":\s*"function",\s*"name":\s*"([^"]+)",\s*"parameters":\s*`)
-
-	// DeepSeek V3.1
-	regexDeepSeekV31Function = regexp.MustCompile(`<｜tool▁call▁begin｜>([^<｜]*)<｜tool▁sep｜>`)
-
-	// DeepSeek R1
-	regexDeepSeekR1Function = regexp.MustCompile(`<｜tool▁call▁begin｜>([^<｜]*)<｜function▁tool▁sep｜>|<｜tool▁call▁begin｜><｜function▁tool▁sep｜>`)
 )
 
 // --- グローバル変数 (設定) ---
 var bifrostURL string
-var emulatePort string // エミュレートモード用ポート
+var emulatePort string     // エミュレートモード用ポート
+var passthroughPort string // パススルー（ネイティブ Tool Calling）用ポート（空の場合は待ち受けない）
+var routingMode string     // エミュレートのポートでのリクエストの振り分け方（"emulate" / "auto"）
 var debugMode bool
 var requestTimeout int64
 var bifrostApiKey string
+var modelSettings *config.ModelSettings // モデル名ごとの設定（MODEL_CONFIG_FILE 未指定時は nil でデフォルト設定）
+var streamHoldLimit int                 // ストリーミング時にツール呼び出し候補として保留するテキストの上限バイト数（0は無制限）
+var toolChoiceRetries int               // tool_choice（"required" / 関数名の指定）・parallel_tool_calls: false に従わない出力だった場合に聞き直す回数
+var singleToolCallPolicy string         // parallel_tool_calls: false で複数の呼び出しがあった場合の扱い（"keep_first" / "retry"）
+var toolValidationPolicy string         // 引数がツール定義のスキーマに合わない呼び出しの扱い（"pass" / "drop" / "repair"）
+var toolNamePolicy string               // 宣言されていないツール名の呼び出しの扱い（"correct" / "strict" / "off"）
+var toolRepairAttempts int              // 壊れた・検証に失敗したツール呼び出しを直すよう聞き直す回数（0 は修復しない）
+var toolRepairTimeoutMs int             // 修復の聞き直しに使える時間の上限（ミリ秒。0 は REQUEST_TIMEOUT のみ）
+var responseFormatMode string           // response_format の扱い（"passthrough" / "emulate"）
+var nFanout string                      // n > 1 の場合に TCGW が n 回に分けて転送するか（"off" / "fallback" / "always"）
+var nFanoutConcurrency int              // 分けて転送する場合に同時に送るリクエストの上限
 
 // --- 型定義 (リクエスト) ---
 
@@ -179,6 +147,8 @@ type Message struct {
 	ToolCallID string     `json:"tool_call_id,omitempty"` // toolメッセージで必須
 	Name       string     `json:"name,omitempty"`         // tool/functionメッセージで使用
 	Refusal    *string    `json:"refusal,omitempty"`      // assistantが拒否した場合（レスポンスのみ）
+	// FunctionCall は旧形式（functions）の assistantメッセージでの関数呼び出し（転送前に tool_calls へ書き換える）
+	FunctionCall *ToolCallFunction `json:"function_call,omitempty"`
 }
 
 // ContentPart はマルチモーダルコンテンツの一部（テキスト/画像など）
@@ -247,6 +217,10 @@ type ChatCompletionRequest struct {
 	ToolChoice        any    `json:"tool_choice,omitempty"`         // "none", "auto", "required", or ToolChoiceObject
 	ParallelToolCalls *bool  `json:"parallel_tool_calls,omitempty"` // デフォルトtrue
 
+	// ツール関連（旧形式。受け付けた後 tools / tool_choice に書き換える）
+	Functions    []FunctionDef `json:"functions,omitempty"`
+	FunctionCall any           `json:"function_call,omitempty"` // "none", "auto", or {"name": "..."}
+
 	// サンプリングパラメータ
 	Temperature      *float32 `json:"temperature,omitempty"`       // 0.0 ~ 2.0, デフォルト1.0
 	TopP             *float32 `json:"top_p,omitempty"`             // 0.0 ~ 1.0, デフォルト1.0
@@ -299,26 +273,22 @@ type PredictionParams struct {
 
 // --- 型定義 (レスポンス) ---
 
-// ToolCallFunction はツール呼び出しの関数情報
-type ToolCallFunction struct {
-	Name      string `json:"name"`
-	Arguments string `json:"arguments"` // JSON文字列
-}
+// ToolCallFunction はツール呼び出しの関数情報（パーサーパッケージと共通の型）
+type ToolCallFunction = parser.ToolCallFunction
 
-// ToolCall はツール呼び出し情報
-type ToolCall struct {
-	ID       string           `json:"id"`
-	Type     string           `json:"type"` // "function"
-	Function ToolCallFunction `json:"function"`
-}
+// ToolCall はツール呼び出し情報（パーサーパッケージと共通の型）
+type ToolCall = parser.ToolCall
 
 // ResponseMessage はレスポンスメッセージ
 type ResponseMessage struct {
-	Role      string     `json:"role"` // "assistant"
-	Content   *string    `json:"content,omitempty"`
-	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
-	Refusal   *string    `json:"refusal,omitempty"` // モデルが拒否した場合
-	Audio     *Audio     `json:"audio,omitempty"`   // 音声出力
+	Role             string     `json:"role"` // "assistant"
+	Content          *string    `json:"content,omitempty"`
+	ReasoningContent *string    `json:"reasoning_content,omitempty"` // 推論（思考）テキスト
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+	Refusal          *string    `json:"refusal,omitempty"` // モデルが拒否した場合
+	Audio            *Audio     `json:"audio,omitempty"`   // 音声出力
+	// FunctionCall は旧形式（functions）のリクエストへの応答での関数呼び出し
+	FunctionCall *ToolCallFunction `json:"function_call,omitempty"`
 }
 
 // Audio は音声レスポンス情報
@@ -393,6 +363,50 @@ type ChatCompletionResponse struct {
 	ServiceTier       string   `json:"service_tier,omitempty"` // "scale", "default"
 }
 
+// --- 型定義 (ストリーミングレスポンス) ---
+
+// ChatCompletionChunk はストリーミング時の1チャンク（SSEの data: 行1つ分）
+type ChatCompletionChunk struct {
+	ID                string        `json:"id"`
+	Object            string        `json:"object"`  // "chat.completion.chunk"
+	Created           int64         `json:"created"` // Unix timestamp
+	Model             string        `json:"model"`
+	SystemFingerprint string        `json:"system_fingerprint,omitempty"`
+	Choices           []ChunkChoice `json:"choices"`         // include_usage の最終チャンクでは空配列
+	Usage             *Usage        `json:"usage,omitempty"` // stream_options.include_usage=true の最終チャンクのみ
+}
+
+// ChunkChoice はチャンク内の選択肢
+type ChunkChoice struct {
+	Index        int        `json:"index"`
+	Delta        ChunkDelta `json:"delta"`
+	FinishReason *string    `json:"finish_reason"` // 最終チャンク以外は null
+}
+
+// ChunkDelta はチャンクで送る差分
+type ChunkDelta struct {
+	Role             string          `json:"role,omitempty"`              // 最初のチャンクのみ "assistant"
+	Content          *string         `json:"content,omitempty"`           // テキストの差分
+	ReasoningContent *string         `json:"reasoning_content,omitempty"` // 推論テキストの差分
+	ToolCalls        []ToolCallDelta `json:"tool_calls,omitempty"`        // ツール呼び出しの差分
+	// FunctionCall は旧形式（functions）の関数呼び出しの差分
+	FunctionCall *ToolCallFunctionDelta `json:"function_call,omitempty"`
+}
+
+// ToolCallDelta はツール呼び出しの差分（index で同一呼び出しを識別）
+type ToolCallDelta struct {
+	Index    int                   `json:"index"`
+	ID       string                `json:"id,omitempty"`   // 各呼び出しの最初の差分のみ
+	Type     string                `json:"type,omitempty"` // 各呼び出しの最初の差分のみ "function"
+	Function ToolCallFunctionDelta `json:"function"`
+}
+
+// ToolCallFunctionDelta はツール呼び出しの関数情報の差分
+type ToolCallFunctionDelta struct {
+	Name      string `json:"name,omitempty"` // 各呼び出しの最初の差分のみ
+	Arguments string `json:"arguments"`      // JSON文字列の断片（連結すると完全な引数になる）
+}
+
 // ErrorResponse はエラーレスポンス
 type ErrorResponse struct {
 	Error ErrorDetail `json:"error"`
@@ -436,6 +450,33 @@ func initConfig() {
 	}
 	emulatePort = ":" + emulatePortStr
 
+	// パススルーポート設定（"0" の場合はパススルーのポートで待ち受けない）
+	passthroughPortStr := os.Getenv("PASSTHROUGH_PORT")
+	if passthroughPortStr == "" {
+		passthroughPortStr = "3001"
+	}
+	port, err = strconv.Atoi(passthroughPortStr)
+	if err != nil || port < 0 || port > 65535 {
+		fmt.Fprintf(os.Stderr, "❌ PASSTHROUGH_PORT must be a number between 1 and 65535 (0 to disable)\n")
+		os.Exit(1)
+	}
+	if port != 0 {
+		passthroughPort = ":" + passthroughPortStr
+		if passthroughPort == emulatePort {
+			fmt.Fprintf(os.Stderr, "❌ PASSTHROUGH_PORT must be different from EMULATE_PORT\n")
+			os.Exit(1)
+		}
+	}
+
+	routingMode = os.Getenv("ROUTING_MODE")
+	if routingMode == "" {
+		routingMode = routingEmulate
+	}
+	if !validRoutingMode(routingMode) {
+		fmt.Fprintf(os.Stderr, "❌ ROUTING_MODE must be \"%s\" or \"%s\"\n", routingEmulate, routingAuto)
+		os.Exit(1)
+	}
+
 	timeoutStr := os.Getenv("REQUEST_TIMEOUT")
 	if timeoutStr == "" {
 		timeoutStr = "120000"
@@ -447,8 +488,205 @@ func initConfig() {
 	}
 	requestTimeout = timeout
 
+	holdLimitStr := os.Getenv("STREAM_HOLD_LIMIT")
+	if holdLimitStr == "" {
+		holdLimitStr = "65536"
+	}
+	holdLimit, err := strconv.Atoi(holdLimitStr)
+	if err != nil || holdLimit < 0 {
+		fmt.Fprintf(os.Stderr, "❌ STREAM_HOLD_LIMIT must be a non-negative number of bytes\n")
+		os.Exit(1)
+	}
+	streamHoldLimit = holdLimit
+
+	retriesStr := os.Getenv("TOOL_CHOICE_RETRIES")
+	if retriesStr == "" {
+		retriesStr = "1"
+	}
+	retries, err := strconv.Atoi(retriesStr)
+	if err != nil || retries < 0 || retries > 5 {
+		fmt.Fprintf(os.Stderr, "❌ TOOL_CHOICE_RETRIES must be between 0 and 5\n")
+		os.Exit(1)
+	}
+	toolChoiceRetries = retries
+
+	singleToolCallPolicy = os.Getenv("SINGLE_TOOL_CALL_POLICY")
+	if singleToolCallPolicy == "" {
+		singleToolCallPolicy = singleToolCallKeepFirst
+	}
+	if !validSingleToolCallPolicy(singleToolCallPolicy) {
+		fmt.Fprintf(os.Stderr, "❌ SINGLE_TOOL_CALL_POLICY must be \"%s\" or \"%s\"\n", singleToolCallKeepFirst, singleToolCallRetry)
+		os.Exit(1)
+	}
+
+	toolValidationPolicy = os.Getenv("TOOL_VALIDATION_POLICY")
+	if toolValidationPolicy == "" {
+		toolValidationPolicy = validationPass
+	}
+	if !validValidationPolicy(toolValidationPolicy) {
+		fmt.Fprintf(os.Stderr, "❌ TOOL_VALIDATION_POLICY must be \"%s\", \"%s\" or \"%s\"\n", validationPass, validationDrop, validationRepair)
+		os.Exit(1)
+	}
+
+	toolNamePolicy = os.Getenv("TOOL_NAME_POLICY")
+	if toolNamePolicy == "" {
+		toolNamePolicy = toolNameCorrect
+	}
+	if !validToolNamePolicy(toolNamePolicy) {
+		fmt.Fprintf(os.Stderr, "❌ TOOL_NAME_POLICY must be \"%s\", \"%s\" or \"%s\"\n", toolNameCorrect, toolNameStrict, toolNameOff)
+		os.Exit(1)
+	}
+
+	repairAttemptsStr := os.Getenv("TOOL_REPAIR_ATTEMPTS")
+	if repairAttemptsStr == "" {
+		repairAttemptsStr = "0"
+	}
+	repairAttempts, err := strconv.Atoi(repairAttemptsStr)
+	if err != nil || repairAttempts < 0 || repairAttempts > 5 {
+		fmt.Fprintf(os.Stderr, "❌ TOOL_REPAIR_ATTEMPTS must be between 0 and 5\n")
+		os.Exit(1)
+	}
+	toolRepairAttempts = repairAttempts
+
+	repairTimeoutStr := os.Getenv("TOOL_REPAIR_TIMEOUT_MS")
+	if repairTimeoutStr == "" {
+		repairTimeoutStr = "60000"
+	}
+	repairTimeout, err := strconv.Atoi(repairTimeoutStr)
+	if err != nil || repairTimeout < 0 || repairTimeout > 600000 {
+		fmt.Fprintf(os.Stderr, "❌ TOOL_REPAIR_TIMEOUT_MS must be between 0 and 600000 milliseconds\n")
+		os.Exit(1)
+	}
+	toolRepairTimeoutMs = repairTimeout
+
+	responseFormatMode = os.Getenv("RESPONSE_FORMAT_MODE")
+	if responseFormatMode == "" {
+		responseFormatMode = responseFormatPassthrough
+	}
+	if !validResponseFormatMode(responseFormatMode) {
+		fmt.Fprintf(os.Stderr, "❌ RESPONSE_FORMAT_MODE must be \"%s\" or \"%s\"\n", responseFormatPassthrough, responseFormatEmulate)
+		os.Exit(1)
+	}
+
+	nFanout = os.Getenv("N_FANOUT")
+	if nFanout == "" {
+		nFanout = nFanoutOff
+	}
+	if !validNFanout(nFanout) {
+		fmt.Fprintf(os.Stderr, "❌ N_FANOUT must be \"%s\", \"%s\" or \"%s\"\n", nFanoutOff, nFanoutFallback, nFanoutAlways)
+		os.Exit(1)
+	}
+
+	fanoutConcurrencyStr := os.Getenv("N_FANOUT_CONCURRENCY")
+	if fanoutConcurrencyStr == "" {
+		fanoutConcurrencyStr = "4"
+	}
+	fanoutConcurrency, err := strconv.Atoi(fanoutConcurrencyStr)
+	if err != nil || fanoutConcurrency < 1 || fanoutConcurrency > maxChoices {
+		fmt.Fprintf(os.Stderr, "❌ N_FANOUT_CONCURRENCY must be between 1 and %d\n", maxChoices)
+		os.Exit(1)
+	}
+	nFanoutConcurrency = fanoutConcurrency
+
+	storeLimitStr := os.Getenv("RESPONSES_STORE_LIMIT")
+	if storeLimitStr == "" {
+		storeLimitStr = "1000"
+	}
+	storeLimit, err := strconv.Atoi(storeLimitStr)
+	if err != nil || storeLimit < 0 {
+		fmt.Fprintf(os.Stderr, "❌ RESPONSES_STORE_LIMIT must be a non-negative number of responses\n")
+		os.Exit(1)
+	}
+	responsesStore = newResponseStore(storeLimit)
+
+	// システムプロンプトのプロファイル（*.tmpl）を読み込むディレクトリ
+	if dir := os.Getenv("PROMPT_PROFILE_DIR"); dir != "" {
+		if err := loadPromptProfiles(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to load PROMPT_PROFILE_DIR (%s): %v\n", dir, err)
+			os.Exit(1)
+		}
+	}
+
+	if path := os.Getenv("MODEL_CONFIG_FILE"); path != "" {
+		settings, err := config.LoadModelSettings(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to load MODEL_CONFIG_FILE (%s): %v\n", path, err)
+			os.Exit(1)
+		}
+		modelSettings = settings
+	}
+
+	// ツール呼び出しパーサーの並び順と無効化（カンマ区切りのパーサー名）
+	parserOrder := splitCommaList(os.Getenv("TOOL_PARSER_ORDER"))
+	parserDisabled := splitCommaList(os.Getenv("TOOL_PARSER_DISABLE"))
+	if err := parser.Default.Configure(parserOrder, parserDisabled); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Invalid TOOL_PARSER_ORDER / TOOL_PARSER_DISABLE: %v\n", err)
+		os.Exit(1)
+	}
+	if modelSettings != nil {
+		for i, rule := range modelSettings.Models {
+			if _, err := parser.Default.Chain(rule.Parsers); err != nil {
+				fmt.Fprintf(os.Stderr, "❌ Invalid MODEL_CONFIG_FILE: models[%d] (%s): %v\n", i, rule.Pattern, err)
+				os.Exit(1)
+			}
+			if rule.PromptProfile != "" {
+				if _, ok := promptProfiles[rule.PromptProfile]; !ok {
+					fmt.Fprintf(os.Stderr, "❌ Invalid MODEL_CONFIG_FILE: models[%d] (%s): unknown prompt_profile %q (available: %s)\n",
+						i, rule.Pattern, rule.PromptProfile, strings.Join(promptProfileNames(), ", "))
+					os.Exit(1)
+				}
+			}
+			if rule.SingleToolCallPolicy != "" && !validSingleToolCallPolicy(rule.SingleToolCallPolicy) {
+				fmt.Fprintf(os.Stderr, "❌ Invalid MODEL_CONFIG_FILE: models[%d] (%s): single_tool_call_policy must be \"%s\" or \"%s\"\n",
+					i, rule.Pattern, singleToolCallKeepFirst, singleToolCallRetry)
+				os.Exit(1)
+			}
+			if rule.ValidationPolicy != "" && !validValidationPolicy(rule.ValidationPolicy) {
+				fmt.Fprintf(os.Stderr, "❌ Invalid MODEL_CONFIG_FILE: models[%d] (%s): validation_policy must be \"%s\", \"%s\" or \"%s\"\n",
+					i, rule.Pattern, validationPass, validationDrop, validationRepair)
+				os.Exit(1)
+			}
+			if rule.ToolNamePolicy != "" && !validToolNamePolicy(rule.ToolNamePolicy) {
+				fmt.Fprintf(os.Stderr, "❌ Invalid MODEL_CONFIG_FILE: models[%d] (%s): tool_name_policy must be \"%s\", \"%s\" or \"%s\"\n",
+					i, rule.Pattern, toolNameCorrect, toolNameStrict, toolNameOff)
+				os.Exit(1)
+			}
+			if rule.RepairAttempts != nil && (*rule.RepairAttempts < 0 || *rule.RepairAttempts > 5) {
+				fmt.Fprintf(os.Stderr, "❌ Invalid MODEL_CONFIG_FILE: models[%d] (%s): repair_attempts must be between 0 and 5\n", i, rule.Pattern)
+				os.Exit(1)
+			}
+			if rule.RepairTimeoutMs != nil && (*rule.RepairTimeoutMs < 0 || *rule.RepairTimeoutMs > 600000) {
+				fmt.Fprintf(os.Stderr, "❌ Invalid MODEL_CONFIG_FILE: models[%d] (%s): repair_timeout_ms must be between 0 and 600000\n", i, rule.Pattern)
+				os.Exit(1)
+			}
+			if rule.ResponseFormatMode != "" && !validResponseFormatMode(rule.ResponseFormatMode) {
+				fmt.Fprintf(os.Stderr, "❌ Invalid MODEL_CONFIG_FILE: models[%d] (%s): response_format_mode must be \"%s\" or \"%s\"\n",
+					i, rule.Pattern, responseFormatPassthrough, responseFormatEmulate)
+				os.Exit(1)
+			}
+			if rule.ToolCalling != "" && !validToolCalling(rule.ToolCalling) {
+				fmt.Fprintf(os.Stderr, "❌ Invalid MODEL_CONFIG_FILE: models[%d] (%s): tool_calling must be \"%s\" or \"%s\"\n",
+					i, rule.Pattern, toolCallingEmulated, toolCallingNative)
+				os.Exit(1)
+			}
+			if rule.NFanout != "" && !validNFanout(rule.NFanout) {
+				fmt.Fprintf(os.Stderr, "❌ Invalid MODEL_CONFIG_FILE: models[%d] (%s): n_fanout must be \"%s\", \"%s\" or \"%s\"\n",
+					i, rule.Pattern, nFanoutOff, nFanoutFallback, nFanoutAlways)
+				os.Exit(1)
+			}
+			if rule.ToolPrompt != "" {
+				if _, ok := toolPromptRenderers[rule.ToolPrompt]; !ok {
+					fmt.Fprintf(os.Stderr, "❌ Invalid MODEL_CONFIG_FILE: models[%d] (%s): unknown tool_prompt %q (available: %s)\n",
+						i, rule.Pattern, rule.ToolPrompt, strings.Join(toolPromptRendererNames(), ", "))
+					os.Exit(1)
+				}
+			}
+		}
+	}
+
 	debugStr := os.Getenv("DEBUG_MODE")
 	debugMode = strings.ToLower(debugStr) == "true"
+	parser.DebugLog = logDebug
 	bifrostApiKey = os.Getenv("BIFROST_API_KEY")
 
 	fmt.Println("🌉 TCGW Proxy Server")
@@ -457,14 +695,30 @@ func initConfig() {
 			strings.TrimPrefix(emulatePort, ":"),
 			bifrostURL,
 			requestTimeout)
+		fmt.Printf(" Prompt Profiles: %s\n", strings.Join(promptProfileNames(), ", "))
 	}
 
 	fmt.Println("[TCGW] Server Starting")
-	fmt.Printf(" Tool Calling Emulation: 0.0.0.0%s\n", emulatePort)
+	fmt.Printf(" Tool Calling Emulation: 0.0.0.0%s (Routing: %s)\n", emulatePort, routingMode)
+	if passthroughPort != "" {
+		fmt.Printf(" Native Passthrough: 0.0.0.0%s\n", passthroughPort)
+	}
 	fmt.Printf(" BIFROST: %s\n", bifrostURL)
 }
 
 // --- ヘルパー関数 ---
+
+// カンマ区切りの設定値を、前後の空白を除いた空でない要素のリストにする
+func splitCommaList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func logDebug(section string, data map[string]any) {
 	if !debugMode {
 		return
@@ -474,17 +728,6 @@ func logDebug(section string, data map[string]any) {
 		fmt.Printf("  %s: %v\n", k, v)
 	}
 }
-func generateToolCallID() string {
-	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
-	b := make([]byte, 8)
-	for i := range b {
-		// crypto/randを使用してより安全なランダム生成
-		var randomByte [1]byte
-		_, _ = cryptorand.Read(randomByte[:])
-		b[i] = charset[int(randomByte[0])%len(charset)]
-	}
-	return "call_" + string(b)
-}
 
 func generateResponseID() string {
 	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
@@ -504,29 +747,6 @@ func escapeXML(s string) string {
 	s = strings.ReplaceAll(s, "'", "&apos;")
 	return s
 }
-func unescapeXML(s string) string {
-	s = strings.ReplaceAll(s, "&apos;", "'")
-	s = strings.ReplaceAll(s, "&quot;", "\"")
-	s = strings.ReplaceAll(s, "&gt;", ">")
-	s = strings.ReplaceAll(s, "&lt;", "<")
-	s = strings.ReplaceAll(s, "&amp;", "&")
-	return s
-}
-func inferType(value string) any {
-	if value == "true" || value == "false" {
-		return value == "true"
-	}
-	if strings.Contains(value, ".") {
-		if f, err := strconv.ParseFloat(value, 64); err == nil {
-			return f
-		}
-	}
-	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
-		// 32bit環境での安全性を考慮し、int(i) ではなく int64(i) を返す
-		return int64(i)
-	}
-	return value
-}
 
 // Contentフィールドから文字列を安全に抽出する
 func extractStringContent(content any) string {
@@ -596,13 +816,38 @@ func generateToolsXML(tools []Tool) string {
 
 // リクエストにツール定義プロンプトを埋め込む
 // 既存のツール定義を削除してから最新版を追加（常に最新状態を保証）
-func embedToolsIntoPrompt(req *ChatCompletionRequest) {
+// プロンプトの形式はモデル別設定の tool_prompt で選んだレンダラーに従う（toolprompt.go）
+// profile を指定した場合は、そのプロファイルのテンプレートでシステムプロンプト全体を作る（promptprofile.go）
+func embedToolsIntoPrompt(req *ChatCompletionRequest, renderer toolPromptRenderer, ctx promptContext, profile string) error {
 	if len(req.Tools) == 0 {
-		return
+		return nil
+	}
+	// tool_choice: "none" ではツール定義を埋め込まない
+	if ctx.ToolChoice.Mode == toolChoiceNone {
+		req.Tools = nil
+		req.ToolChoice = nil
+		logDebug("Embedding Tools Skipped", map[string]any{"Tool Choice": toolChoiceNone})
+		return nil
 	}
 
-	toolsXML := generateToolsXML(req.Tools)
-	systemPrompt := strings.ReplaceAll(TOOL_SYSTEM_PROMPT, "{{TOOLS_XML}}", toolsXML)
+	ctx.Tools = req.Tools
+	ctx.ToolCount = len(req.Tools)
+	ctx.ToolsXML = generateToolsXML(req.Tools)
+	systemPrompt, err := renderer.SystemPrompt(ctx)
+	if err != nil {
+		return err
+	}
+	if profile != "" {
+		// プロファイルからはレンダラーのツール定義プロンプトを {{.ToolPrompt}} で参照できる
+		ctx.ToolPrompt = systemPrompt
+		if systemPrompt, err = renderPromptProfile(profile, ctx); err != nil {
+			return err
+		}
+	}
+	// tool_choice で呼び出しを強制する場合・parallel_tool_calls: false の場合の指示
+	if instruction := (toolCallRequirement{choice: ctx.ToolChoice, single: ctx.SingleCall}).instruction(); instruction != "" {
+		systemPrompt += "\n\n" + instruction
+	}
 
 	if len(req.Messages) > 0 && req.Messages[0].Role == "system" {
 		existingContent := extractStringContent(req.Messages[0].Content)
@@ -625,11 +870,18 @@ func embedToolsIntoPrompt(req *ChatCompletionRequest) {
 	}
 
 	req.Tools = nil      // ツール定義を削除 (Bifrostには送らない)
-	req.ToolChoice = nil // Tools が無いのに ToolChoice を送るとプロバイダーでエラーが返されるため、ここで削除しておく
+	req.ToolChoice = nil // Tools が無いのに ToolChoice を送るとプロバイダーでエラーが返されるため、ここで削除しておく（tool_choice は ctx.ToolChoice としてエミュレートする）
+	// 同様に parallel_tool_calls も削除する（ctx.SingleCall としてエミュレートする）
+	req.ParallelToolCalls = nil
 	logDebug("Embedding Tools", map[string]any{
 		"System Prompt Len": len(systemPrompt),
 		"Messages Count":    len(req.Messages),
+		"Prompt Profile":    profile,
+		"Locale":            ctx.Locale,
+		"Tool Choice":       ctx.ToolChoice.Mode,
+		"Single Tool Call":  ctx.SingleCall,
 	})
+	return nil
 }
 
 // システムメッセージから既存のツール定義を削除する
@@ -668,2733 +920,270 @@ func removeToolDefinitions(content string) string {
 	return strings.TrimSpace(result)
 }
 
-// XML形式のツール呼び出し抽出
-func extractXMLToolCalls(text string) []ToolCall {
-	fc := reFunctionCalls.FindString(text)
-	if fc == "" {
-		return nil
-	}
-	matches := reInvoke.FindAllStringSubmatch(fc, -1)
-	if len(matches) == 0 {
-		return nil
+// markupSpan はパーサーがツール呼び出しのマークアップとして消費したテキストの範囲（バイト位置、End は含まない）
+type markupSpan = parser.Span
+
+// removeMarkupSpans はテキストからマークアップの範囲を取り除き、残った文章を返す
+// 範囲の重なりは統合し、残った各部分はトリムして空でないものを改行で連結する
+func removeMarkupSpans(text string, spans []markupSpan) string {
+	if len(spans) == 0 {
+		return strings.TrimSpace(text)
 	}
-	var toolCalls []ToolCall
-	for _, m := range matches {
-		if len(m) < 3 { // m[0]=full, m[1]=name, m[2]=inner
+	sorted := make([]markupSpan, len(spans))
+	copy(sorted, spans)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var parts []string
+	pos := 0
+	for _, span := range sorted {
+		start := max(span.Start, pos)
+		end := min(span.End, len(text))
+		if start >= end {
 			continue
 		}
-		toolName := m[1]
-		inner := m[2]
-		paramMatches := reParameter.FindAllStringSubmatch(inner, -1)
-		params := map[string]any{}
-		for _, pm := range paramMatches {
-			if len(pm) >= 3 { // pm[0]=full, pm[1]=name, pm[2]=value
-				// パラメータ値のXMLエスケープを解除 (例: &apos; -> ')
-				params[pm[1]] = inferType(unescapeXML(pm[2]))
-			}
+		if part := strings.TrimSpace(text[pos:start]); part != "" {
+			parts = append(parts, part)
 		}
-		paramsJSON, _ := json.Marshal(params)
-		toolCalls = append(toolCalls, ToolCall{
-			ID:   generateToolCallID(),
-			Type: "function",
-			Function: ToolCallFunction{
-				Name:      toolName,
-				Arguments: string(paramsJSON),
-			},
-		})
+		pos = end
+	}
+	if part := strings.TrimSpace(text[pos:]); part != "" {
+		parts = append(parts, part)
 	}
-	return toolCalls
+	return strings.Join(parts, "\n")
 }
 
-// JSON形式のツール呼び出し抽出 (フォールバック)
-func extractJSONToolCalls(text string) []ToolCall {
-	j := reJSON.FindString(text)
-	if j == "" {
-		return nil
-	}
-	var data map[string]any
-	if err := json.Unmarshal([]byte(j), &data); err != nil {
-		return nil
-	}
-	tcs, ok := data["tool_calls"].([]any)
-	if !ok {
+// extractToolCalls はLLMの出力からツール呼び出しを抽出
+// parsers（toolCallParsersFor で求めたモデル別の抽出順）を順に試し、最初にツール呼び出しを返したパーサーの結果を採用する
+// 戻り値の markupSpan は、検出したパーサーがツール呼び出しとして消費したテキストの範囲
+func extractToolCalls(text string, parsers []parser.ToolCallParser) ([]ToolCall, []markupSpan) {
+	toolCalls, spans, _ := parser.Extract(parsers, text)
+	return toolCalls, spans
+}
+
+// toolCallParsersFor はモデル設定の parsers からパーサーの抽出順を求める
+// parsers を指定していないモデルは、ツール定義プロンプト（tool_prompt）の形式に対応するパーサーを先に試し、
+// 続けてレジストリの標準の抽出順（TOOL_PARSER_ORDER / TOOL_PARSER_DISABLE を反映）を使う
+// tool_choice: "none" の場合は出力中のマークアップをツール呼び出しとして解釈しないため、パーサーを返さない
+func toolCallParsersFor(modelConfig config.ModelConfig, choice toolChoice) []parser.ToolCallParser {
+	if choice.Mode == toolChoiceNone {
 		return nil
 	}
-	var toolCalls []ToolCall
-	for _, v := range tcs {
-		tc, ok := v.(map[string]any)
-		if !ok {
-			continue
+	names := modelConfig.Parsers
+	if names == nil {
+		preferred := toolPromptRendererFor(modelConfig.ToolPrompt).Parsers()
+		if len(preferred) == 0 {
+			return parser.Default.Parsers()
 		}
-		id, _ := tc["id"].(string)
-		if id == "" {
-			id = generateToolCallID()
-		}
-		fn, ok := tc["function"].(map[string]any)
-		if !ok {
-			continue
-		}
-		name, _ := fn["name"].(string)
-		args, _ := fn["arguments"].(string)
-		toolCalls = append(toolCalls, ToolCall{ID: id, Type: "function", Function: ToolCallFunction{Name: name, Arguments: args}})
+		names = append(append([]string(nil), preferred...), parser.DefaultChainName)
 	}
-	return toolCalls
-}
-
-// Markdown JSON形式のツール呼び出し抽出 (フォールバック)
-func extractMarkdownToolCalls(text string) []ToolCall {
-	ms := reMarkdownJSON.FindAllStringSubmatch(text, -1)
-	for _, m := range ms {
-		if len(m) >= 2 { // m[0]=full, m[1]=json_content
-			var data map[string]any
-			if err := json.Unmarshal([]byte(m[1]), &data); err != nil {
-				continue
-			}
-			tcs, ok := data["tool_calls"].([]any)
-			if !ok {
-				continue
-			}
-			var toolCalls []ToolCall
-			for _, v := range tcs {
-				tc, ok := v.(map[string]any)
-				if !ok {
-					continue
-				}
-				id, _ := tc["id"].(string)
-				if id == "" {
-					id = generateToolCallID()
-				}
-				fn, ok := tc["function"].(map[string]any)
-				if !ok {
-					continue
-				}
-				name, _ := fn["name"].(string)
-				args, _ := fn["arguments"].(string)
-				toolCalls = append(toolCalls, ToolCall{ID: id, Type: "function", Function: ToolCallFunction{Name: name, Arguments: args}})
-			}
-			if len(toolCalls) > 0 {
-				return toolCalls
-			}
-		}
+	chain, err := parser.Default.Chain(names)
+	if err != nil {
+		// 起動時に検証済みのため通常は発生しない
+		logDebug("Parser Chain Error", map[string]any{"Error": err.Error()})
+		return parser.Default.Parsers()
 	}
-	return nil
+	return chain
 }
 
-// extractToolCalls はLLMの出力からツール呼び出しを抽出
-// llama.cpp式の多段階パース戦略：モデルファミリー別 → 標準形式 → ジェネリック
-func extractToolCalls(text string) []ToolCall {
-	// Phase 1: モデルファミリー別パーサー（特定モデルの独自形式）
-	// llama.cppのcommon_chat_templates_apply_jinjaの検出順序に基づく
-
-	// DeepSeek V3.1
-	if xs := extractDeepSeekV31ToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "DeepSeek V3.1"})
-		return xs
-	}
-
-	// DeepSeek R1
-	if xs := extractDeepSeekR1ToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "DeepSeek R1"})
-		return xs
+// バックエンドのレスポンスから 'content' 文字列を安全に抽出
+func extractContentFromBackendResponse(m map[string]any) string {
+	choices, ok := m["choices"].([]any)
+	if !ok || len(choices) == 0 {
+		logDebug("Content Extraction Failed", map[string]any{
+			"reason": "choices field missing or empty",
+		})
+		return ""
 	}
+	return extractContentFromChoice(choices[0])
+}
 
-	// Command R7B
-	if xs := extractCommandR7BToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Command R7B"})
-		return xs
+// 選択肢（choices の要素）から 'content' 文字列を安全に抽出
+func extractContentFromChoice(item any) string {
+	choice, ok := item.(map[string]any)
+	if !ok {
+		logDebug("Content Extraction Failed", map[string]any{
+			"reason": "invalid choice structure",
+		})
+		return ""
 	}
-
-	// Granite (IBM)
-	if xs := extractGraniteToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Granite"})
-		return xs
+	message, ok := choice["message"].(map[string]any)
+	if !ok {
+		logDebug("Content Extraction Failed", map[string]any{
+			"reason": "message field missing",
+		})
+		return ""
 	}
-
-	// GLM 4.5（Hermes 2 Proより先にチェック - 両方とも<tool_call>を使用）
-	if xs := extractGLM45ToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "GLM 4.5"})
-		return xs
+	content, ok := message["content"]
+	if !ok || content == nil {
+		// tool_callsの場合はcontentがnullなので、これは正常なケース
+		return ""
 	}
-
-	// Qwen3-Coder XML（Hermes 2 Proより先にチェック）
-	if xs := extractQwen3CoderXMLToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Qwen3-Coder XML"})
-		return xs
+	if str, ok := content.(string); ok {
+		return str
 	}
+	logDebug("Content Extraction Failed", map[string]any{
+		"reason":       "content is not a string",
+		"content_type": fmt.Sprintf("%T", content),
+	})
+	return ""
+}
 
-	// Xiaomi MiMo（Hermes 2 Proより先にチェック）
-	if xs := extractXiaomiMiMoToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Xiaomi MiMo"})
-		return xs
-	}
+// backendChoice はバックエンドが返した1つの選択肢の本文と finish_reason
+type backendChoice struct {
+	content      string
+	finishReason string
+}
 
-	// Hermes 2 Pro, Qwen 2.5 Instruct
-	if xs := extractHermes2ProToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Hermes 2 Pro"})
-		return xs
+// extractBackendChoices はバックエンドのレスポンスのすべての選択肢を index 順に抽出する
+// レスポンスの choices も index 順に並べ替える（patchOpenAIResponse が同じ順で上書きするため）
+func extractBackendChoices(m map[string]any) []backendChoice {
+	choices, _ := m["choices"].([]any)
+	sort.SliceStable(choices, func(i, j int) bool {
+		return choiceIndex(choices[i]) < choiceIndex(choices[j])
+	})
+	result := make([]backendChoice, 0, len(choices))
+	for _, item := range choices {
+		bc := backendChoice{content: extractContentFromChoice(item), finishReason: "stop"}
+		if choice, ok := item.(map[string]any); ok {
+			if reason, _ := choice["finish_reason"].(string); reason != "" {
+				bc.finishReason = reason
+			}
+		}
+		result = append(result, bc)
 	}
+	return result
+}
 
-	// GPT-OSS
-	if xs := extractGPTOSSToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "GPT-OSS"})
-		return xs
-	}
+// choiceIndex は選択肢の index（ない場合は 0）
+func choiceIndex(item any) int {
+	choice, _ := item.(map[string]any)
+	index, _ := choice["index"].(float64)
+	return int(index)
+}
 
-	// Seed-OSS
-	if xs := extractSeedOSSToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Seed-OSS"})
-		return xs
+// OpenAI互換レスポンスを構築
+// ツール呼び出しがある場合、content にはマークアップを除いた文章を渡す（空の場合は null になる）
+// reasoning は本文から分離した推論テキスト（空の場合は reasoning_content を省略）
+// 選択肢ごとに index と finish_reason を付ける（n > 1 の場合は choices に複数の要素）
+func buildOpenAIResponse(model string, choices []emulatedChoice) ChatCompletionResponse {
+	respChoices := make([]Choice, 0, len(choices))
+	for i, ec := range choices {
+		content, reasoning := ec.content, ec.reasoning
+		msg := ResponseMessage{Role: "assistant"}
+		if reasoning != "" {
+			msg.ReasoningContent = &reasoning
+		}
+		if len(ec.toolCalls) > 0 {
+			msg.Content = nil // 文章が残っていない場合、ツール呼び出し時の content は null
+			if content != "" {
+				msg.Content = &content
+			}
+			msg.ToolCalls = ec.toolCalls
+		} else {
+			msg.Content = &content
+			msg.ToolCalls = []ToolCall{} // 空配列 (omitemptyにより省略される)
+		}
+		respChoices = append(respChoices, Choice{
+			Index:        i,
+			Message:      msg,
+			FinishReason: ec.finishReason,
+		})
 	}
-
-	// Nemotron v2
-	if xs := extractNemotronV2ToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Nemotron v2"})
-		return xs
+	return ChatCompletionResponse{
+		ID:      generateResponseID(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: respChoices,
+		Usage: Usage{
+			PromptTokens:     0,
+			CompletionTokens: 0,
+			TotalTokens:      0,
+		},
 	}
+}
 
-	// Apertus
-	if xs := extractApertusToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Apertus"})
-		return xs
-	}
+// Bifrostへリクエスト転送し、JSONレスポンスを返す
+func forwardToBifrost(req *ChatCompletionRequest) (map[string]any, error) {
+	return forwardToBifrostWithContext(context.Background(), req, time.Duration(requestTimeout)*time.Millisecond)
+}
 
-	// LFM2
-	if xs := extractLFM2ToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "LFM2"})
-		return xs
+// forwardToBifrostWithContext は REQUEST_TIMEOUT の代わりに timeout を使って転送する（修復の時間の上限に合わせる場合）
+// parent が終了した場合（クライアントの切断など）にも転送を中断する
+func forwardToBifrostWithContext(parent context.Context, req *ChatCompletionRequest, timeout time.Duration) (map[string]any, error) {
+	bodyBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("Internal error: failed to marshal request: %v", err)
 	}
 
-	// MiniMax-M2
-	if xs := extractMiniMaxM2ToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "MiniMax-M2"})
-		return xs
-	}
+	logDebug("Forwarding to Bifrost", map[string]any{
+		"URL":       bifrostURL + "/v1/chat/completions",
+		"Body Size": len(bodyBytes),
+		"Timeout":   timeout.Milliseconds(),
+	})
 
-	// Kimi K2
-	if xs := extractKimiK2ToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Kimi K2"})
-		return xs
-	}
+	client := &http.Client{}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
 
-	// Apriel 1.5
-	if xs := extractApriel15ToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Apriel 1.5"})
-		return xs
+	httpReq, err := newBifrostRequest(ctx, bodyBytes)
+	if err != nil {
+		return nil, err
 	}
 
-	// Functionary v3.2
-	if xs := extractFunctionaryV32ToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Functionary v3.2"})
-		return xs
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return bifrostTransportError(err)
 	}
+	defer resp.Body.Close()
 
-	// Firefunction v2
-	if xs := extractFirefunctionV2ToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Firefunction v2"})
-		return xs
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return map[string]any{"error": map[string]any{"message": fmt.Sprintf("Internal error: failed to read response body: %v", err), "type": "server_error"}}, fmt.Errorf("500")
 	}
 
-	// Functionary v3.1 Llama 3.1
-	if xs := extractFunctionaryV31Llama31ToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Functionary v3.1 Llama 3.1"})
-		return xs
-	}
-
-	// Llama 3.x
-	if xs := extractLlama3XToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Llama 3.x"})
-		return xs
-	}
-
-	// Magistral
-	if xs := extractMagistralToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Magistral"})
-		return xs
-	}
-
-	// Mistral Nemo
-	if xs := extractMistralNemoToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Mistral Nemo"})
-		return xs
-	}
-
-	// Phase 2: 標準形式パーサー（既存のTCGW形式）
-
-	// XML形式の検出
-	if xs := extractXMLToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "XML"})
-		return xs
-	}
-
-	// JSON形式の検出
-	if xs := extractJSONToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "JSON"})
-		return xs
-	}
-
-	// Markdown JSON形式の検出
-	if xs := extractMarkdownToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Markdown JSON"})
-		return xs
-	}
-
-	// Phase 3: ジェネリックパーサー（最後の砦）
-
-	// 汎用JSON形式の検出
-	if xs := extractGenericToolCalls(text); len(xs) > 0 {
-		logDebug("Tool Call Extraction", map[string]any{"Format": "Generic JSON"})
-		return xs
-	}
-
-	// どのパーサーでも検出できなかった場合
-	return nil
-}
-
-// extractGPTOSSToolCalls は GPT-OSS 独自形式のツール呼び出しを抽出
-// 形式: <|start|>assistant<|channel|>commentary to=functionName <|constrain|>json<|message|>{JSON}<|call|>
-func extractGPTOSSToolCalls(text string) []ToolCall {
-	// GPT-OSS形式の正規表現パターン
-	// <|channel|>commentary to=functionName または <|channel|>analysis to=functionName
-	// ドット区切りの関数名に対応（例: functions.calculatePrice）
-
-	matches := regexGPTOSS.FindAllStringSubmatch(text, -1)
-	if len(matches) == 0 {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-	for _, match := range matches {
-		if len(match) < 4 {
-			continue
-		}
-
-		channelType := match[1] // commentary or analysis
-		functionName := match[2]
-		argsText := strings.TrimSpace(match[3])
-
-		// <|message|> タグが含まれている場合は除去
-		argsText = strings.TrimPrefix(argsText, "<|message|>")
-		argsText = strings.TrimSpace(argsText)
-
-		// 空のツール名はスキップ
-		if functionName == "" {
-			continue
-		}
-
-		// JSON引数のパース
-		var argsMap map[string]any
-		if argsText != "" {
-			// JSONブロックを抽出（中括弧で囲まれた部分）
-			jsonStart := strings.Index(argsText, "{")
-			jsonEnd := strings.LastIndex(argsText, "}")
-
-			if jsonStart != -1 && jsonEnd != -1 && jsonEnd > jsonStart {
-				jsonStr := argsText[jsonStart : jsonEnd+1]
-				if err := json.Unmarshal([]byte(jsonStr), &argsMap); err == nil {
-					argsBytes, _ := json.Marshal(argsMap)
-
-					toolCalls = append(toolCalls, ToolCall{
-						ID:       generateToolCallID(),
-						Type:     "function",
-						Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-					})
-
-					logDebug("GPT-OSS Tool Call Detected", map[string]any{
-						"Channel":  channelType,
-						"Function": functionName,
-						"Args":     string(argsBytes),
-					})
-				}
-			}
-		}
-	}
-
-	return toolCalls
-}
-
-// extractHermes2ProToolCalls は Hermes 2 Pro 形式のツール呼び出しを抽出
-// llama.cppの実装を忠実に移植
-func extractHermes2ProToolCalls(text string) []ToolCall {
-	// Hermes 2 Pro形式の複雑な正規表現パターン
-	// llama.cppの open_regex に対応
-	matches := regexHermes2ProOpen.FindAllStringSubmatchIndex(text, -1)
-
-	if len(matches) == 0 {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	for _, match := range matches {
-		// match[0], match[1]: 全体マッチ
-		// match[2], match[3]: group 1 (block_start)
-		// match[4], match[5]: group 2 (open_tag)
-		// match[6], match[7]: group 3 (name in <name>...</name>)
-		// match[8], match[9]: group 4 (function name)
-		// match[10], match[11]: group 5 (function name alternative)
-
-		if len(match) < 12 {
-			continue
-		}
-
-		var functionName string
-		var openTag string
-		var closeTag string
-		var jsonStart int
-
-		// open_tag の取得
-		if match[4] != -1 && match[5] != -1 {
-			openTag = text[match[4]:match[5]]
-			// close_tag を構築（例: <tool_call> → </tool_call>）
-			if len(openTag) > 1 {
-				closeTag = "</" + openTag[1:] + ">"
-			}
-		}
-
-		// パターン1: <name>functionName</name> 形式
-		if match[6] != -1 && match[7] != -1 {
-			functionName = strings.TrimSpace(text[match[6]:match[7]])
-			jsonStart = match[7]
-		} else if match[8] != -1 && match[9] != -1 {
-			// パターン2: <function>functionName 形式
-			functionName = strings.TrimSpace(text[match[8]:match[9]])
-			jsonStart = match[9]
-		} else if match[10] != -1 && match[11] != -1 {
-			// パターン3: 代替の function name 形式
-			functionName = strings.TrimSpace(text[match[10]:match[11]])
-			jsonStart = match[11]
-		} else {
-			// 関数名が見つからない場合、JSONオブジェクトから抽出を試みる
-			jsonStart = match[1] // 全体マッチの終了位置から開始
-		}
-
-		// 関数名が空の場合はスキップ
-		if functionName == "" && openTag == "" {
-			continue
-		}
-
-		// JSON引数の抽出
-		remainingText := text[jsonStart:]
-
-		// closeTagがある場合はそこまでを抽出
-		var jsonText string
-		if closeTag != "" {
-			closeIdx := strings.Index(remainingText, closeTag)
-			if closeIdx != -1 {
-				jsonText = remainingText[:closeIdx]
-			} else {
-				jsonText = remainingText
-			}
-		} else {
-			jsonText = remainingText
-		}
-
-		// JSONブロックを抽出
-		jsonText = strings.TrimSpace(jsonText)
-		jsonStartIdx := strings.Index(jsonText, "{")
-		jsonEndIdx := strings.LastIndex(jsonText, "}")
-
-		if jsonStartIdx == -1 || jsonEndIdx == -1 || jsonEndIdx <= jsonStartIdx {
-			continue
-		}
-
-		jsonStr := jsonText[jsonStartIdx : jsonEndIdx+1]
-
-		// JSONパース
-		var toolCallData map[string]any
-		if err := json.Unmarshal([]byte(jsonStr), &toolCallData); err != nil {
-			continue
-		}
-
-		// 関数名がまだ取得できていない場合、JSONから取得
-		if functionName == "" {
-			if name, ok := toolCallData["name"].(string); ok {
-				functionName = name
-			} else if fn, ok := toolCallData["function"].(string); ok {
-				functionName = fn
-			}
-		}
-
-		// 関数名が依然として空の場合はスキップ
-		if functionName == "" {
-			continue
-		}
-
-		// 引数の取得
-		var argsBytes []byte
-		if args, exists := toolCallData["arguments"]; exists {
-			if argsMap, ok := args.(map[string]any); ok {
-				argsBytes, _ = json.Marshal(argsMap)
-			} else if argsStr, ok := args.(string); ok {
-				argsBytes = []byte(argsStr)
-			} else {
-				argsBytes, _ = json.Marshal(args)
-			}
-		} else {
-			// JSONオブジェクト全体を引数として使用（nameやfunctionキーを除外）
-			filteredArgs := make(map[string]any)
-			for k, v := range toolCallData {
-				if k != "name" && k != "function" {
-					filteredArgs[k] = v
-				}
-			}
-			if len(filteredArgs) > 0 {
-				argsBytes, _ = json.Marshal(filteredArgs)
-			} else {
-				argsBytes = []byte("{}")
-			}
-		}
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Hermes 2 Pro Tool Call Detected", map[string]any{
-			"OpenTag":  openTag,
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-	}
-
-	return toolCalls
-}
-
-// extractFunctionaryV32ToolCalls は Functionary v3.2 形式のツール呼び出しを抽出
-// llama.cppの実装を忠実に移植
-// 形式: >>>functionName\n{"arg1": "value1"}<<< または >>>python\ncode<<<
-func extractFunctionaryV32ToolCalls(text string) []ToolCall {
-	// Functionary v3.2形式の正規表現パターン
-	// >>> で開始（3つの>）、<<< で終了（3つの<）
-	closePattern := `<<<`
-	matches := regexFunctionaryV32.FindAllStringSubmatchIndex(text, -1)
-
-	if len(matches) == 0 {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	for _, match := range matches {
-		// match[0], match[1]: 全体マッチ（>>>functionName）
-		// match[2], match[3]: group 1 (functionName)
-
-		if len(match) < 4 {
-			continue
-		}
-
-		atStart := match[0] == 0
-		functionName := strings.TrimSpace(text[match[2]:match[3]])
-
-		// 関数名の末尾に '(' がある場合は削除
-		if len(functionName) > 0 && functionName[len(functionName)-1] == '(' {
-			functionName = strings.TrimRight(functionName, "(")
-		}
-
-		// 開始位置で "all" または "python" の場合はスキップ
-		if atStart && (functionName == "all" || functionName == "python") {
-			continue
-		}
-
-		// 空の関数名はスキップ
-		if functionName == "" {
-			continue
-		}
-
-		// 引数部分の抽出（>>> の後から <<< まで）
-		argsStart := match[1] // >>> の終了位置
-		remainingText := text[argsStart:]
-
-		// <<< を探す
-		closeIdx := strings.Index(remainingText, closePattern)
-		if closeIdx == -1 {
-			// 閉じタグが見つからない場合は残り全体
-			closeIdx = len(remainingText)
-		}
-
-		argsText := strings.TrimSpace(remainingText[:closeIdx])
-
-		// Pythonコードの特殊処理
-		if functionName == "python" && !strings.HasPrefix(argsText, "{") {
-			// Raw Pythonコード: JSON形式でラップ
-			codeJSON := map[string]any{
-				"code": argsText,
-			}
-			argsBytes, _ := json.Marshal(codeJSON)
-
-			toolCalls = append(toolCalls, ToolCall{
-				ID:       generateToolCallID(),
-				Type:     "function",
-				Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-			})
-
-			logDebug("Functionary v3.2 Tool Call Detected (Python)", map[string]any{
-				"Function": functionName,
-				"Code":     argsText,
-			})
-			continue
-		}
-
-		// JSON引数のパース
-		jsonStart := strings.Index(argsText, "{")
-		jsonEnd := strings.LastIndex(argsText, "}")
-
-		if jsonStart == -1 || jsonEnd == -1 || jsonEnd <= jsonStart {
-			// JSONが見つからない場合は空オブジェクト
-			toolCalls = append(toolCalls, ToolCall{
-				ID:       generateToolCallID(),
-				Type:     "function",
-				Function: ToolCallFunction{Name: functionName, Arguments: "{}"},
-			})
-			continue
-		}
-
-		jsonStr := argsText[jsonStart : jsonEnd+1]
-
-		// JSON検証
-		var argsMap map[string]any
-		if err := json.Unmarshal([]byte(jsonStr), &argsMap); err != nil {
-			continue
-		}
-
-		argsBytes, _ := json.Marshal(argsMap)
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Functionary v3.2 Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-	}
-
-	return toolCalls
-}
-
-// extractDeepSeekV31ToolCalls は DeepSeek V3.1 形式のツール呼び出しを抽出
-// 形式: <｜tool▁calls▁begin｜><｜tool▁call▁begin｜>functionName<｜tool▁sep｜>{JSON}<｜tool▁call▁end｜><｜tool▁calls▁end｜>
-func extractDeepSeekV31ToolCalls(text string) []ToolCall {
-	// DeepSeek V3.1の特殊トークン（全角文字を含む）
-	const (
-		toolCallsBegin = "<｜tool▁calls▁begin｜>"
-		toolCallBegin  = "<｜tool▁call▁begin｜>"
-		toolSep        = "<｜tool▁sep｜>"
-		toolCallEnd    = "<｜tool▁call▁end｜>"
-		toolCallsEnd   = "<｜tool▁calls▁end｜>"
-	)
-
-	// 複数のバリエーションに対応（llama.cppのtoolcalls_beginパターン）
-	toolCallsBeginVariants := []string{
-		"<｜tool▁calls▁begin｜>",
-		"<tool calls begin>",
-		"<toolcalls>",
-	}
-
-	// いずれかのバリエーションが存在するか確認
-	hasBegin := false
-	for _, variant := range toolCallsBeginVariants {
-		if strings.Contains(text, variant) {
-			hasBegin = true
-			break
-		}
-	}
-
-	if !hasBegin {
-		return nil
-	}
-
-	// toolCallsBeginからtoolCallsEndまでの範囲を抽出
-	startIdx := -1
-	for _, variant := range toolCallsBeginVariants {
-		idx := strings.Index(text, variant)
-		if idx != -1 {
-			startIdx = idx
-			break
-		}
-	}
-
-	if startIdx == -1 {
-		return nil
-	}
-
-	endIdx := strings.Index(text[startIdx:], toolCallsEnd)
-	var toolCallsText string
-	if endIdx == -1 {
-		// 終了タグが見つからない場合は残り全体
-		toolCallsText = text[startIdx:]
-	} else {
-		toolCallsText = text[startIdx : startIdx+endIdx+len(toolCallsEnd)]
-	}
-
-	var toolCalls []ToolCall
-
-	// 正規表現でツール呼び出しを抽出
-	// パターン: <｜tool▁call▁begin｜>functionName<｜tool▁sep｜>
-	matches := regexDeepSeekV31Function.FindAllStringSubmatchIndex(toolCallsText, -1)
-
-	for _, match := range matches {
-		// match[2], match[3]: 関数名のキャプチャグループ
-		if len(match) < 4 {
-			continue
-		}
-
-		functionName := strings.TrimSpace(toolCallsText[match[2]:match[3]])
-
-		// 空の関数名はスキップ
-		if functionName == "" {
-			continue
-		}
-
-		// JSON引数の抽出（<｜tool▁sep｜>から<｜tool▁call▁end｜>まで）
-		jsonStart := match[1] // <｜tool▁sep｜>の直後
-		remainingText := toolCallsText[jsonStart:]
-
-		jsonEnd := strings.Index(remainingText, toolCallEnd)
-		if jsonEnd == -1 {
-			continue
-		}
-
-		jsonText := strings.TrimSpace(remainingText[:jsonEnd])
-
-		// JSON引数のパース
-		var argsMap map[string]any
-		if jsonText != "" {
-			if err := json.Unmarshal([]byte(jsonText), &argsMap); err != nil {
-				continue
-			}
-		} else {
-			argsMap = make(map[string]any)
-		}
-
-		argsBytes, _ := json.Marshal(argsMap)
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("DeepSeek V3.1 Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-	}
-
-	return toolCalls
-}
-
-// extractDeepSeekR1ToolCalls は DeepSeek R1 形式のツール呼び出しを抽出
-// 形式: <｜tool▁calls▁begin｜><｜tool▁call▁begin｜>functionName<｜function▁tool▁sep｜>{JSON}<｜tool▁call▁end｜><｜tool▁calls▁end｜>
-func extractDeepSeekR1ToolCalls(text string) []ToolCall {
-	// DeepSeek R1の特殊トークン
-	const (
-		toolCallsBegin  = "<｜tool▁calls▁begin｜>"
-		toolCallBegin   = "<｜tool▁call▁begin｜>"
-		functionToolSep = "<｜function▁tool▁sep｜>"
-		toolCallEnd     = "<｜tool▁call▁end｜>"
-		toolCallsEnd    = "<｜tool▁calls▁end｜>"
-	)
-
-	// 複数のバリエーションに対応
-	toolCallsBeginVariants := []string{
-		"<｜tool▁calls▁begin｜>",
-		"<tool calls begin>",
-		"<｜tool▁calls▁begin｜>",
-		"<toolcalls>",
-	}
-
-	// いずれかのバリエーションが存在するか確認
-	hasBegin := false
-	for _, variant := range toolCallsBeginVariants {
-		if strings.Contains(text, variant) {
-			hasBegin = true
-			break
-		}
-	}
-
-	if !hasBegin {
-		return nil
-	}
-
-	// toolCallsBeginからtoolCallsEndまでの範囲を抽出
-	startIdx := -1
-	for _, variant := range toolCallsBeginVariants {
-		idx := strings.Index(text, variant)
-		if idx != -1 {
-			startIdx = idx
-			break
-		}
-	}
-
-	if startIdx == -1 {
-		return nil
-	}
-
-	endIdx := strings.Index(text[startIdx:], toolCallsEnd)
-	var toolCallsText string
-	if endIdx == -1 {
-		toolCallsText = text[startIdx:]
-	} else {
-		toolCallsText = text[startIdx : startIdx+endIdx+len(toolCallsEnd)]
-	}
-
-	var toolCalls []ToolCall
-
-	// 正規表現でツール呼び出しを抽出
-	// パターン1: <｜tool▁call▁begin｜>functionName<｜function▁tool▁sep｜>
-	// パターン2: <｜tool▁call▁begin｜><｜function▁tool▁sep｜> (関数名なし)
-	matches := regexDeepSeekR1Function.FindAllStringSubmatchIndex(toolCallsText, -1)
-
-	for _, match := range matches {
-		// match[0], match[1]: 全体マッチ
-		// match[2], match[3]: 関数名のキャプチャグループ（存在する場合）
-
-		var functionName string
-		if len(match) >= 4 && match[2] != -1 && match[3] != -1 {
-			functionName = strings.TrimSpace(toolCallsText[match[2]:match[3]])
-		}
-
-		// 関数名が空の場合はスキップ（パターン2の場合も）
-		if functionName == "" {
-			continue
-		}
-
-		// JSON引数の抽出（<｜function▁tool▁sep｜>から<｜tool▁call▁end｜>まで）
-		jsonStart := match[1] // マッチ全体の終了位置
-		remainingText := toolCallsText[jsonStart:]
-
-		jsonEnd := strings.Index(remainingText, toolCallEnd)
-		if jsonEnd == -1 {
-			continue
-		}
-
-		jsonText := strings.TrimSpace(remainingText[:jsonEnd])
-
-		// JSON引数のパース
-		var argsMap map[string]any
-		if jsonText != "" {
-			if err := json.Unmarshal([]byte(jsonText), &argsMap); err != nil {
-				continue
-			}
-		} else {
-			argsMap = make(map[string]any)
-		}
-
-		argsBytes, _ := json.Marshal(argsMap)
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("DeepSeek R1 Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-	}
-
-	return toolCalls
-}
-
-// extractCommandR7BToolCalls は Command R7B 形式のツール呼び出しを抽出
-// 形式: <|START_ACTION|>[{"tool_name": "func", "tool_call_id": "id", "parameters": {...}}]<|END_ACTION|>
-func extractCommandR7BToolCalls(text string) []ToolCall {
-	// Command R7Bの特殊トークン
-	const (
-		startAction = "<|START_ACTION|>"
-		endAction   = "<|END_ACTION|>"
-	)
-
-	// START_ACTIONの検出
-	startIdx := strings.Index(text, startAction)
-	if startIdx == -1 {
-		return nil
-	}
-
-	// END_ACTIONの検出
-	endIdx := strings.Index(text[startIdx:], endAction)
-	var actionText string
-	if endIdx == -1 {
-		actionText = text[startIdx+len(startAction):]
-	} else {
-		actionText = text[startIdx+len(startAction) : startIdx+endIdx]
-	}
-
-	actionText = strings.TrimSpace(actionText)
-
-	// JSON配列のパース
-	var toolCallsData []map[string]any
-	if err := json.Unmarshal([]byte(actionText), &toolCallsData); err != nil {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	for _, tcData := range toolCallsData {
-		// tool_nameの取得
-		functionName, ok := tcData["tool_name"].(string)
-		if !ok || functionName == "" {
-			continue
-		}
-
-		// tool_call_idの取得（オプション）
-		toolCallID := ""
-		if id, ok := tcData["tool_call_id"].(string); ok {
-			toolCallID = id
-		}
-
-		// parametersの取得
-		var argsBytes []byte
-		if params, exists := tcData["parameters"]; exists {
-			if paramsMap, ok := params.(map[string]any); ok {
-				argsBytes, _ = json.Marshal(paramsMap)
-			} else if paramsStr, ok := params.(string); ok {
-				// 文字列の場合はそのまま使用
-				argsBytes = []byte(paramsStr)
-			} else {
-				argsBytes, _ = json.Marshal(params)
-			}
-		} else {
-			argsBytes = []byte("{}")
-		}
-
-		// IDが指定されている場合はそれを使用、なければ生成
-		if toolCallID == "" {
-			toolCallID = generateToolCallID()
-		}
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       toolCallID,
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Command R7B Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"ID":       toolCallID,
-			"Args":     string(argsBytes),
-		})
-	}
-
-	return toolCalls
-}
-
-// extractGraniteToolCalls は Granite (IBM) 形式のツール呼び出しを抽出
-// 形式: <tool_call>[{"name": "func", "arguments": {...}}]
-func extractGraniteToolCalls(text string) []ToolCall {
-	// Graniteの特殊トークン
-	const toolCallTag = "<tool_call>"
-
-	// tool_callタグの検出
-	tagIdx := strings.Index(text, toolCallTag)
-	if tagIdx == -1 {
-		return nil
-	}
-
-	// JSON配列の抽出（<tool_call>の後から）
-	jsonText := strings.TrimSpace(text[tagIdx+len(toolCallTag):])
-
-	// JSON配列の開始を探す
-	if !strings.HasPrefix(jsonText, "[") {
-		return nil
-	}
-
-	// JSON配列の終了を探す
-	jsonEnd := strings.LastIndex(jsonText, "]")
-	if jsonEnd == -1 {
-		return nil
-	}
-
-	jsonText = jsonText[:jsonEnd+1]
-
-	// JSON配列のパース
-	var toolCallsData []map[string]any
-	if err := json.Unmarshal([]byte(jsonText), &toolCallsData); err != nil {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	for _, tcData := range toolCallsData {
-		// nameの取得
-		functionName, ok := tcData["name"].(string)
-		if !ok || functionName == "" {
-			continue
-		}
-
-		// argumentsの取得
-		var argsBytes []byte
-		if args, exists := tcData["arguments"]; exists {
-			if argsMap, ok := args.(map[string]any); ok {
-				argsBytes, _ = json.Marshal(argsMap)
-			} else if argsStr, ok := args.(string); ok {
-				// 文字列の場合、JSONとしてパース試行
-				var argsMap map[string]any
-				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
-					argsBytes = []byte(argsStr)
-				} else {
-					argsBytes = []byte("{}")
-				}
-			} else {
-				argsBytes, _ = json.Marshal(args)
-			}
-		} else {
-			argsBytes = []byte("{}")
-		}
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Granite Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-	}
-
-	return toolCalls
-}
-
-// extractGLM45ToolCalls は GLM 4.5 形式のツール呼び出しを抽出
-// 形式: <tool_call><arg_key>param1</arg_key><arg_value>value1</arg_value>...</tool_call>
-func extractGLM45ToolCalls(text string) []ToolCall {
-	// GLM 4.5のXML形式タグ
-	const (
-		toolCallStart = "<tool_call>"
-		toolCallEnd   = "</tool_call>"
-		argKeyStart   = "<arg_key>"
-		argKeyEnd     = "</arg_key>"
-		argValueStart = "<arg_value>"
-		argValueEnd   = "</arg_value>"
-	)
-
-	// tool_callタグの検出
-	if !strings.Contains(text, toolCallStart) {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	// 複数のtool_callを抽出
-	searchPos := 0
-	for {
-		// tool_callの開始を探す
-		startIdx := strings.Index(text[searchPos:], toolCallStart)
-		if startIdx == -1 {
-			break
-		}
-		startIdx += searchPos
-
-		// tool_callの終了を探す
-		endIdx := strings.Index(text[startIdx:], toolCallEnd)
-		if endIdx == -1 {
-			break
-		}
-		endIdx += startIdx
-
-		// 1つのツール呼び出しブロック
-		toolCallText := text[startIdx+len(toolCallStart) : endIdx]
-
-		// arg_key/arg_valueペアを抽出
-		args := make(map[string]any)
-		var functionName string
-
-		argSearchPos := 0
-		for {
-			// arg_keyの開始を探す
-			keyStartIdx := strings.Index(toolCallText[argSearchPos:], argKeyStart)
-			if keyStartIdx == -1 {
-				break
-			}
-			keyStartIdx += argSearchPos
-
-			// arg_keyの終了を探す
-			keyEndIdx := strings.Index(toolCallText[keyStartIdx:], argKeyEnd)
-			if keyEndIdx == -1 {
-				break
-			}
-			keyEndIdx += keyStartIdx
-
-			keyName := strings.TrimSpace(toolCallText[keyStartIdx+len(argKeyStart) : keyEndIdx])
-
-			// arg_valueの開始を探す（arg_keyの直後）
-			valueStartIdx := keyEndIdx + len(argKeyEnd)
-			if !strings.HasPrefix(toolCallText[valueStartIdx:], argValueStart) {
-				argSearchPos = valueStartIdx
-				continue
-			}
-
-			// arg_valueの終了を探す
-			valueEndIdx := strings.Index(toolCallText[valueStartIdx:], argValueEnd)
-			if valueEndIdx == -1 {
-				break
-			}
-			valueEndIdx += valueStartIdx
-
-			value := strings.TrimSpace(toolCallText[valueStartIdx+len(argValueStart) : valueEndIdx])
-
-			// 最初のarg_keyを関数名として扱う（GLM 4.5の仕様）
-			if functionName == "" && keyName != "" {
-				functionName = keyName
-				// 最初のキーは関数名なので、引数には含めない
-			} else if keyName != "" {
-				// JSON値としてパース試行
-				var jsonValue any
-				if err := json.Unmarshal([]byte(value), &jsonValue); err == nil {
-					args[keyName] = jsonValue
-				} else {
-					// JSONでない場合は文字列として扱う
-					args[keyName] = value
-				}
-			}
-
-			argSearchPos = valueEndIdx + len(argValueEnd)
-		}
-
-		// 関数名が見つからない場合はスキップ
-		if functionName == "" {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-
-		// 引数をJSONに変換
-		argsBytes, _ := json.Marshal(args)
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("GLM 4.5 Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-
-		searchPos = endIdx + len(toolCallEnd)
-	}
-
-	return toolCalls
-}
-
-// extractQwen3CoderXMLToolCalls は Qwen3-Coder XML 形式のツール呼び出しを抽出
-// 形式: <tool_call><function>funcName</function><parameter>key=value</parameter>...</tool_call>
-func extractQwen3CoderXMLToolCalls(text string) []ToolCall {
-	// Qwen3-Coder XMLのタグ
-	const (
-		toolCallStart = "<tool_call>"
-		toolCallEnd   = "</tool_call>"
-		functionStart = "<function>"
-		functionEnd   = "</function>"
-		paramStart    = "<parameter>"
-		paramEnd      = "</parameter>"
-	)
-
-	// tool_callタグの検出
-	if !strings.Contains(text, toolCallStart) {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	// 複数のtool_callを抽出
-	searchPos := 0
-	for {
-		// tool_callの開始を探す
-		startIdx := strings.Index(text[searchPos:], toolCallStart)
-		if startIdx == -1 {
-			break
-		}
-		startIdx += searchPos
-
-		// tool_callの終了を探す
-		endIdx := strings.Index(text[startIdx:], toolCallEnd)
-		if endIdx == -1 {
-			break
-		}
-		endIdx += startIdx
-
-		// 1つのツール呼び出しブロック
-		toolCallText := text[startIdx+len(toolCallStart) : endIdx]
-
-		// 関数名の抽出
-		funcStartIdx := strings.Index(toolCallText, functionStart)
-		if funcStartIdx == -1 {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-
-		funcEndIdx := strings.Index(toolCallText[funcStartIdx:], functionEnd)
-		if funcEndIdx == -1 {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-		funcEndIdx += funcStartIdx
-
-		functionName := strings.TrimSpace(toolCallText[funcStartIdx+len(functionStart) : funcEndIdx])
-
-		// 空の関数名はスキップ
-		if functionName == "" {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-
-		// パラメータの抽出
-		args := make(map[string]any)
-
-		paramSearchPos := funcEndIdx + len(functionEnd)
-		for {
-			// parameterの開始を探す
-			pStartIdx := strings.Index(toolCallText[paramSearchPos:], paramStart)
-			if pStartIdx == -1 {
-				break
-			}
-			pStartIdx += paramSearchPos
-
-			// parameterの終了を探す
-			pEndIdx := strings.Index(toolCallText[pStartIdx:], paramEnd)
-			if pEndIdx == -1 {
-				break
-			}
-			pEndIdx += pStartIdx
-
-			paramText := strings.TrimSpace(toolCallText[pStartIdx+len(paramStart) : pEndIdx])
-
-			// key=value形式をパース
-			eqIdx := strings.Index(paramText, "=")
-			if eqIdx != -1 {
-				key := strings.TrimSpace(paramText[:eqIdx])
-				value := strings.TrimSpace(paramText[eqIdx+1:])
-
-				if key != "" {
-					// JSON値としてパース試行
-					var jsonValue any
-					if err := json.Unmarshal([]byte(value), &jsonValue); err == nil {
-						args[key] = jsonValue
-					} else {
-						// JSONでない場合は文字列として扱う
-						args[key] = value
-					}
-				}
-			}
-
-			paramSearchPos = pEndIdx + len(paramEnd)
-		}
-
-		// 引数をJSONに変換
-		argsBytes, _ := json.Marshal(args)
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Qwen3-Coder XML Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-
-		searchPos = endIdx + len(toolCallEnd)
-	}
-
-	return toolCalls
-}
-
-// extractXiaomiMiMoToolCalls は Xiaomi MiMo 形式のツール呼び出しを抽出
-// 形式: <tool_call>name=functionName, arguments={JSON}</tool_call>
-func extractXiaomiMiMoToolCalls(text string) []ToolCall {
-	// Xiaomi MiMoのタグ
-	const (
-		toolCallStart = "<tool_call>"
-		toolCallEnd   = "</tool_call>"
-	)
-
-	// tool_callタグの検出
-	if !strings.Contains(text, toolCallStart) {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	// 複数のtool_callを抽出
-	searchPos := 0
-	for {
-		// tool_callの開始を探す
-		startIdx := strings.Index(text[searchPos:], toolCallStart)
-		if startIdx == -1 {
-			break
-		}
-		startIdx += searchPos
-
-		// tool_callの終了を探す
-		endIdx := strings.Index(text[startIdx:], toolCallEnd)
-		if endIdx == -1 {
-			break
-		}
-		endIdx += startIdx
-
-		// 1つのツール呼び出しブロック
-		toolCallText := strings.TrimSpace(text[startIdx+len(toolCallStart) : endIdx])
-
-		// "name=" で始まることを確認
-		if !strings.HasPrefix(toolCallText, "name=") {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-
-		// ", arguments=" で分割
-		parts := strings.SplitN(toolCallText, ", arguments=", 2)
-		if len(parts) != 2 {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-
-		// 関数名の抽出（"name=" を除去）
-		functionName := strings.TrimSpace(strings.TrimPrefix(parts[0], "name="))
-
-		// 空の関数名はスキップ
-		if functionName == "" {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-
-		// 引数の抽出
-		argsText := strings.TrimSpace(parts[1])
-
-		// JSON引数のパース
-		var argsMap map[string]any
-		if argsText != "" {
-			if err := json.Unmarshal([]byte(argsText), &argsMap); err != nil {
-				searchPos = endIdx + len(toolCallEnd)
-				continue
-			}
-		} else {
-			argsMap = make(map[string]any)
-		}
-
-		argsBytes, _ := json.Marshal(argsMap)
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Xiaomi MiMo Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-
-		searchPos = endIdx + len(toolCallEnd)
-	}
-
-	return toolCalls
-}
-
-// extractSeedOSSToolCalls は Seed-OSS 形式のツール呼び出しを抽出
-// 形式: <seed:tool_call><function>funcName</function><parameter>key=value</parameter>...</seed:tool_call>
-func extractSeedOSSToolCalls(text string) []ToolCall {
-	// Seed-OSSのタグ
-	const (
-		toolCallStart = "<seed:tool_call>"
-		toolCallEnd   = "</seed:tool_call>"
-		functionStart = "<function>"
-		functionEnd   = "</function>"
-		paramStart    = "<parameter>"
-		paramEnd      = "</parameter>"
-	)
-
-	// seed:tool_callタグの検出
-	if !strings.Contains(text, toolCallStart) {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	// 複数のseed:tool_callを抽出
-	searchPos := 0
-	for {
-		// seed:tool_callの開始を探す
-		startIdx := strings.Index(text[searchPos:], toolCallStart)
-		if startIdx == -1 {
-			break
-		}
-		startIdx += searchPos
-
-		// seed:tool_callの終了を探す
-		endIdx := strings.Index(text[startIdx:], toolCallEnd)
-		if endIdx == -1 {
-			break
-		}
-		endIdx += startIdx
-
-		// 1つのツール呼び出しブロック
-		toolCallText := text[startIdx+len(toolCallStart) : endIdx]
-
-		// 関数名の抽出
-		funcStartIdx := strings.Index(toolCallText, functionStart)
-		if funcStartIdx == -1 {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-
-		funcEndIdx := strings.Index(toolCallText[funcStartIdx:], functionEnd)
-		if funcEndIdx == -1 {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-		funcEndIdx += funcStartIdx
-
-		functionName := strings.TrimSpace(toolCallText[funcStartIdx+len(functionStart) : funcEndIdx])
-
-		// 空の関数名はスキップ
-		if functionName == "" {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-
-		// パラメータの抽出
-		args := make(map[string]any)
-
-		paramSearchPos := funcEndIdx + len(functionEnd)
-		for {
-			// parameterの開始を探す
-			pStartIdx := strings.Index(toolCallText[paramSearchPos:], paramStart)
-			if pStartIdx == -1 {
-				break
-			}
-			pStartIdx += paramSearchPos
-
-			// parameterの終了を探す
-			pEndIdx := strings.Index(toolCallText[pStartIdx:], paramEnd)
-			if pEndIdx == -1 {
-				break
-			}
-			pEndIdx += pStartIdx
-
-			paramText := strings.TrimSpace(toolCallText[pStartIdx+len(paramStart) : pEndIdx])
-
-			// key=value形式をパース
-			eqIdx := strings.Index(paramText, "=")
-			if eqIdx != -1 {
-				key := strings.TrimSpace(paramText[:eqIdx])
-				value := strings.TrimSpace(paramText[eqIdx+1:])
-
-				if key != "" {
-					// JSON値としてパース試行
-					var jsonValue any
-					if err := json.Unmarshal([]byte(value), &jsonValue); err == nil {
-						args[key] = jsonValue
-					} else {
-						// JSONでない場合は文字列として扱う
-						args[key] = value
-					}
-				}
-			}
-
-			paramSearchPos = pEndIdx + len(paramEnd)
-		}
-
-		// 引数をJSONに変換
-		argsBytes, _ := json.Marshal(args)
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Seed-OSS Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-
-		searchPos = endIdx + len(toolCallEnd)
-	}
-
-	return toolCalls
-}
-
-// extractNemotronV2ToolCalls は Nemotron v2 形式のツール呼び出しを抽出
-// 形式: <TOOLCALL>[{"name": "func", "arguments": {...}}]</TOOLCALL>
-func extractNemotronV2ToolCalls(text string) []ToolCall {
-	// Nemotron v2のタグ
-	const (
-		toolCallStart = "<TOOLCALL>"
-		toolCallEnd   = "</TOOLCALL>"
-	)
-
-	// TOOLCALLタグの検出
-	startIdx := strings.Index(text, toolCallStart)
-	if startIdx == -1 {
-		return nil
-	}
-
-	// TOOLCALLの終了を探す
-	endIdx := strings.Index(text[startIdx:], toolCallEnd)
-	var toolCallText string
-	if endIdx == -1 {
-		toolCallText = text[startIdx+len(toolCallStart):]
-	} else {
-		toolCallText = text[startIdx+len(toolCallStart) : startIdx+endIdx]
-	}
-
-	toolCallText = strings.TrimSpace(toolCallText)
-
-	// JSON配列のパース
-	var toolCallsData []map[string]any
-	if err := json.Unmarshal([]byte(toolCallText), &toolCallsData); err != nil {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	for _, tcData := range toolCallsData {
-		// nameの取得
-		functionName, ok := tcData["name"].(string)
-		if !ok || functionName == "" {
-			continue
-		}
-
-		// argumentsの取得
-		var argsBytes []byte
-		if args, exists := tcData["arguments"]; exists {
-			if argsMap, ok := args.(map[string]any); ok {
-				argsBytes, _ = json.Marshal(argsMap)
-			} else if argsStr, ok := args.(string); ok {
-				// 文字列の場合、JSONとしてパース試行
-				var argsMap map[string]any
-				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
-					argsBytes = []byte(argsStr)
-				} else {
-					argsBytes = []byte("{}")
-				}
-			} else {
-				argsBytes, _ = json.Marshal(args)
-			}
-		} else {
-			argsBytes = []byte("{}")
-		}
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Nemotron v2 Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-	}
-
-	return toolCalls
-}
-
-// extractApertusToolCalls は Apertus 形式のツール呼び出しを抽出
-// 形式: <|tools_prefix|>[{"functionName": {arguments}}]<|tools_suffix|>
-func extractApertusToolCalls(text string) []ToolCall {
-	// Apertusのタグ
-	const (
-		toolsPrefix = "<|tools_prefix|>"
-		toolsSuffix = "<|tools_suffix|>"
-	)
-
-	// tools_prefixタグの検出
-	startIdx := strings.Index(text, toolsPrefix)
-	if startIdx == -1 {
-		return nil
-	}
-
-	// tools_suffixの終了を探す
-	endIdx := strings.Index(text[startIdx:], toolsSuffix)
-	var toolsText string
-	if endIdx == -1 {
-		toolsText = text[startIdx+len(toolsPrefix):]
-	} else {
-		toolsText = text[startIdx+len(toolsPrefix) : startIdx+endIdx]
-	}
-
-	toolsText = strings.TrimSpace(toolsText)
-
-	// JSON配列のパース
-	var toolCallsData []map[string]any
-	if err := json.Unmarshal([]byte(toolsText), &toolCallsData); err != nil {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	for _, tcData := range toolCallsData {
-		// 各オブジェクトの最初のキーを関数名として扱う
-		for functionName, args := range tcData {
-			if functionName == "" {
-				continue
-			}
-
-			// 引数の処理
-			var argsBytes []byte
-			if argsMap, ok := args.(map[string]any); ok {
-				argsBytes, _ = json.Marshal(argsMap)
-			} else if argsStr, ok := args.(string); ok {
-				// 文字列の場合、JSONとしてパース試行
-				var argsMap map[string]any
-				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
-					argsBytes = []byte(argsStr)
-				} else {
-					argsBytes = []byte("{}")
-				}
-			} else {
-				argsBytes, _ = json.Marshal(args)
-			}
-
-			toolCalls = append(toolCalls, ToolCall{
-				ID:       generateToolCallID(),
-				Type:     "function",
-				Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-			})
-
-			logDebug("Apertus Tool Call Detected", map[string]any{
-				"Function": functionName,
-				"Args":     string(argsBytes),
-			})
-
-			// Apertus形式では各オブジェクトに1つのキーのみ
-			break
-		}
-	}
-
-	return toolCalls
-}
-
-// extractLFM2ToolCalls は LFM2 形式のツール呼び出しを抽出
-// 形式: <|tool_call_start|>[{"name": "func", "arguments": {...}}]<|tool_call_end|>
-func extractLFM2ToolCalls(text string) []ToolCall {
-	// LFM2のタグ
-	const (
-		toolCallStart = "<|tool_call_start|>"
-		toolCallEnd   = "<|tool_call_end|>"
-	)
-
-	// tool_call_startタグの検出
-	startIdx := strings.Index(text, toolCallStart)
-	if startIdx == -1 {
-		return nil
-	}
-
-	// tool_call_endの終了を探す
-	endIdx := strings.Index(text[startIdx:], toolCallEnd)
-	var toolCallText string
-	if endIdx == -1 {
-		toolCallText = text[startIdx+len(toolCallStart):]
-	} else {
-		toolCallText = text[startIdx+len(toolCallStart) : startIdx+endIdx]
-	}
-
-	toolCallText = strings.TrimSpace(toolCallText)
-
-	// JSON配列のパース
-	var toolCallsData []map[string]any
-	if err := json.Unmarshal([]byte(toolCallText), &toolCallsData); err != nil {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	for _, tcData := range toolCallsData {
-		// nameの取得
-		functionName, ok := tcData["name"].(string)
-		if !ok || functionName == "" {
-			continue
-		}
-
-		// argumentsの取得
-		var argsBytes []byte
-		if args, exists := tcData["arguments"]; exists {
-			if argsMap, ok := args.(map[string]any); ok {
-				argsBytes, _ = json.Marshal(argsMap)
-			} else if argsStr, ok := args.(string); ok {
-				// 文字列の場合、JSONとしてパース試行
-				var argsMap map[string]any
-				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
-					argsBytes = []byte(argsStr)
-				} else {
-					argsBytes = []byte("{}")
-				}
-			} else {
-				argsBytes, _ = json.Marshal(args)
-			}
-		} else {
-			argsBytes = []byte("{}")
-		}
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("LFM2 Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-	}
-
-	return toolCalls
-}
-
-// extractMiniMaxM2ToolCalls は MiniMax-M2 形式のツール呼び出しを抽出
-// 形式: <minimax:tool_call><invoke name="func"><parameter name="key">value</parameter>...</invoke></minimax:tool_call>
-func extractMiniMaxM2ToolCalls(text string) []ToolCall {
-	// MiniMax-M2のタグ
-	const (
-		toolCallStart = "<minimax:tool_call>"
-		toolCallEnd   = "</minimax:tool_call>"
-		invokeStart   = "<invoke name="
-		invokeEnd     = "</invoke>"
-		paramStart    = "<parameter name="
-		paramEnd      = "</parameter>"
-	)
-
-	// minimax:tool_callタグの検出
-	if !strings.Contains(text, toolCallStart) {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	// 複数のminimax:tool_callを抽出
-	searchPos := 0
-	for {
-		// minimax:tool_callの開始を探す
-		startIdx := strings.Index(text[searchPos:], toolCallStart)
-		if startIdx == -1 {
-			break
-		}
-		startIdx += searchPos
-
-		// minimax:tool_callの終了を探す
-		endIdx := strings.Index(text[startIdx:], toolCallEnd)
-		if endIdx == -1 {
-			break
-		}
-		endIdx += startIdx
-
-		// 1つのツール呼び出しブロック
-		toolCallText := text[startIdx+len(toolCallStart) : endIdx]
-
-		// invokeタグから関数名を抽出
-		invokeIdx := strings.Index(toolCallText, invokeStart)
-		if invokeIdx == -1 {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-
-		// 関数名の抽出（引用符で囲まれている）
-		nameStart := invokeIdx + len(invokeStart)
-		nameEnd := strings.Index(toolCallText[nameStart:], `"`)
-		if nameEnd == -1 {
-			// 単一引用符の場合も試す
-			nameEnd = strings.Index(toolCallText[nameStart:], `'`)
-		}
-		if nameEnd == -1 {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-		nameEnd += nameStart
-
-		functionName := strings.TrimSpace(toolCallText[nameStart:nameEnd])
-
-		// 空の関数名はスキップ
-		if functionName == "" {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-
-		// パラメータの抽出
-		args := make(map[string]any)
-
-		// invokeの終了位置を探す
-		invokeEndIdx := strings.Index(toolCallText[nameEnd:], invokeEnd)
-		if invokeEndIdx == -1 {
-			searchPos = endIdx + len(toolCallEnd)
-			continue
-		}
-		invokeEndIdx += nameEnd
-
-		invokeContent := toolCallText[nameEnd:invokeEndIdx]
-
-		paramSearchPos := 0
-		for {
-			// parameterの開始を探す
-			pStartIdx := strings.Index(invokeContent[paramSearchPos:], paramStart)
-			if pStartIdx == -1 {
-				break
-			}
-			pStartIdx += paramSearchPos
-
-			// パラメータ名の抽出（引用符で囲まれている）
-			pNameStart := pStartIdx + len(paramStart)
-			pNameEnd := strings.Index(invokeContent[pNameStart:], `"`)
-			if pNameEnd == -1 {
-				pNameEnd = strings.Index(invokeContent[pNameStart:], `'`)
-			}
-			if pNameEnd == -1 {
-				break
-			}
-			pNameEnd += pNameStart
-
-			paramName := strings.TrimSpace(invokeContent[pNameStart:pNameEnd])
-
-			// 値の開始（">" の後）
-			valueStart := strings.Index(invokeContent[pNameEnd:], ">")
-			if valueStart == -1 {
-				break
-			}
-			valueStart += pNameEnd + 1
-
-			// parameterの終了を探す
-			pEndIdx := strings.Index(invokeContent[valueStart:], paramEnd)
-			if pEndIdx == -1 {
-				break
-			}
-			pEndIdx += valueStart
-
-			paramValue := strings.TrimSpace(invokeContent[valueStart:pEndIdx])
-
-			if paramName != "" {
-				// JSON値としてパース試行
-				var jsonValue any
-				if err := json.Unmarshal([]byte(paramValue), &jsonValue); err == nil {
-					args[paramName] = jsonValue
-				} else {
-					// JSONでない場合は文字列として扱う
-					args[paramName] = paramValue
-				}
-			}
-
-			paramSearchPos = pEndIdx + len(paramEnd)
-		}
-
-		// 引数をJSONに変換
-		argsBytes, _ := json.Marshal(args)
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("MiniMax-M2 Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-
-		searchPos = endIdx + len(toolCallEnd)
-	}
-
-	return toolCalls
-}
-
-// extractKimiK2ToolCalls は Kimi K2 形式のツール呼び出しを抽出
-// 形式: <|tool_calls_section_begin|><|tool_call_begin|>functionName<|tool_call_argument_begin|>{JSON}<|tool_call_end|><|tool_calls_section_end|>
-func extractKimiK2ToolCalls(text string) []ToolCall {
-	// Kimi K2のタグ
-	const (
-		sectionBegin  = "<|tool_calls_section_begin|>"
-		sectionEnd    = "<|tool_calls_section_end|>"
-		toolCallBegin = "<|tool_call_begin|>"
-		toolCallEnd   = "<|tool_call_end|>"
-		argumentBegin = "<|tool_call_argument_begin|>"
-	)
-
-	// tool_calls_section_beginタグの検出
-	if !strings.Contains(text, sectionBegin) {
-		return nil
-	}
-
-	// セクション全体を抽出
-	startIdx := strings.Index(text, sectionBegin)
-	if startIdx == -1 {
-		return nil
-	}
-
-	endIdx := strings.Index(text[startIdx:], sectionEnd)
-	var sectionText string
-	if endIdx == -1 {
-		sectionText = text[startIdx+len(sectionBegin):]
-	} else {
-		sectionText = text[startIdx+len(sectionBegin) : startIdx+endIdx]
-	}
-
-	var toolCalls []ToolCall
-
-	// 複数のtool_callを抽出
-	searchPos := 0
-	for {
-		// tool_call_beginを探す
-		beginIdx := strings.Index(sectionText[searchPos:], toolCallBegin)
-		if beginIdx == -1 {
-			break
-		}
-		beginIdx += searchPos
-
-		// 関数名の開始位置
-		nameStart := beginIdx + len(toolCallBegin)
-
-		// tool_call_argument_beginを探す
-		argBeginIdx := strings.Index(sectionText[nameStart:], argumentBegin)
-		if argBeginIdx == -1 {
-			break
-		}
-		argBeginIdx += nameStart
-
-		// 関数名の抽出
-		functionName := strings.TrimSpace(sectionText[nameStart:argBeginIdx])
-
-		// 空の関数名はスキップ
-		if functionName == "" {
-			searchPos = argBeginIdx + len(argumentBegin)
-			continue
-		}
-
-		// 引数の開始位置
-		argsStart := argBeginIdx + len(argumentBegin)
-
-		// tool_call_endを探す
-		endIdx := strings.Index(sectionText[argsStart:], toolCallEnd)
-		if endIdx == -1 {
-			break
-		}
-		endIdx += argsStart
-
-		// 引数テキストの抽出
-		argsText := strings.TrimSpace(sectionText[argsStart:endIdx])
-
-		// JSON引数のパース
-		var argsMap map[string]any
-		if argsText != "" {
-			if err := json.Unmarshal([]byte(argsText), &argsMap); err != nil {
-				searchPos = endIdx + len(toolCallEnd)
-				continue
-			}
-		} else {
-			argsMap = make(map[string]any)
-		}
-
-		argsBytes, _ := json.Marshal(argsMap)
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Kimi K2 Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-
-		searchPos = endIdx + len(toolCallEnd)
-	}
-
-	return toolCalls
-}
-
-// extractApriel15ToolCalls は Apriel 1.5 形式のツール呼び出しを抽出
-// 形式: <tool_calls><name>func</name>, <arguments>{JSON}</arguments></tool_calls>
-func extractApriel15ToolCalls(text string) []ToolCall {
-	// Apriel 1.5のタグ
-	const (
-		toolCallsStart = "<tool_calls>"
-		toolCallsEnd   = "</tool_calls>"
-		nameStart      = "<name>"
-		nameEnd        = "</name>"
-		argumentsStart = "<arguments>"
-		argumentsEnd   = "</arguments>"
-	)
-
-	// tool_callsタグの検出
-	if !strings.Contains(text, toolCallsStart) {
-		return nil
-	}
-
-	// tool_callsセクション全体を抽出
-	startIdx := strings.Index(text, toolCallsStart)
-	if startIdx == -1 {
-		return nil
-	}
-
-	endIdx := strings.Index(text[startIdx:], toolCallsEnd)
-	var toolCallsText string
-	if endIdx == -1 {
-		toolCallsText = text[startIdx+len(toolCallsStart):]
-	} else {
-		toolCallsText = text[startIdx+len(toolCallsStart) : startIdx+endIdx]
-	}
-
-	var toolCalls []ToolCall
-
-	// 複数のname/argumentsペアを抽出
-	searchPos := 0
-	for {
-		// nameタグの開始を探す
-		nStartIdx := strings.Index(toolCallsText[searchPos:], nameStart)
-		if nStartIdx == -1 {
-			break
-		}
-		nStartIdx += searchPos
-
-		// nameタグの終了を探す
-		nEndIdx := strings.Index(toolCallsText[nStartIdx:], nameEnd)
-		if nEndIdx == -1 {
-			break
-		}
-		nEndIdx += nStartIdx
-
-		// 関数名の抽出
-		functionName := strings.TrimSpace(toolCallsText[nStartIdx+len(nameStart) : nEndIdx])
-
-		// 空の関数名はスキップ
-		if functionName == "" {
-			searchPos = nEndIdx + len(nameEnd)
-			continue
-		}
-
-		// argumentsタグの開始を探す（nameの後）
-		aStartIdx := strings.Index(toolCallsText[nEndIdx:], argumentsStart)
-		if aStartIdx == -1 {
-			searchPos = nEndIdx + len(nameEnd)
-			continue
-		}
-		aStartIdx += nEndIdx
-
-		// argumentsタグの終了を探す
-		aEndIdx := strings.Index(toolCallsText[aStartIdx:], argumentsEnd)
-		if aEndIdx == -1 {
-			break
-		}
-		aEndIdx += aStartIdx
-
-		// 引数テキストの抽出
-		argsText := strings.TrimSpace(toolCallsText[aStartIdx+len(argumentsStart) : aEndIdx])
-
-		// JSON引数のパース
-		var argsMap map[string]any
-		if argsText != "" {
-			if err := json.Unmarshal([]byte(argsText), &argsMap); err != nil {
-				searchPos = aEndIdx + len(argumentsEnd)
-				continue
-			}
-		} else {
-			argsMap = make(map[string]any)
-		}
-
-		argsBytes, _ := json.Marshal(argsMap)
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Apriel 1.5 Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-
-		searchPos = aEndIdx + len(argumentsEnd)
-	}
-
-	return toolCalls
-}
-
-// extractFirefunctionV2ToolCalls は Firefunction v2 形式のツール呼び出しを抽出
-// 形式:  functools[{"name": "func", "arguments": {...}}]
-func extractFirefunctionV2ToolCalls(text string) []ToolCall {
-	// Firefunction v2のプレフィックス
-	const prefix = " functools"
-
-	// プレフィックスの検出
-	prefixIdx := strings.Index(text, prefix)
-	if prefixIdx == -1 {
-		return nil
-	}
-
-	// JSON配列の開始位置（プレフィックスの直後）
-	jsonStart := prefixIdx + len(prefix)
-	jsonText := strings.TrimSpace(text[jsonStart:])
-
-	// JSON配列の開始を確認
-	if !strings.HasPrefix(jsonText, "[") {
-		return nil
-	}
-
-	// JSON配列の終了を探す
-	jsonEnd := strings.LastIndex(jsonText, "]")
-	if jsonEnd == -1 {
-		return nil
-	}
-
-	jsonText = jsonText[:jsonEnd+1]
-
-	// JSON配列のパース
-	var toolCallsData []map[string]any
-	if err := json.Unmarshal([]byte(jsonText), &toolCallsData); err != nil {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	for _, tcData := range toolCallsData {
-		// nameの取得
-		functionName, ok := tcData["name"].(string)
-		if !ok || functionName == "" {
-			continue
-		}
-
-		// argumentsの取得
-		var argsBytes []byte
-		if args, exists := tcData["arguments"]; exists {
-			if argsMap, ok := args.(map[string]any); ok {
-				argsBytes, _ = json.Marshal(argsMap)
-			} else if argsStr, ok := args.(string); ok {
-				// 文字列の場合、JSONとしてパース試行
-				var argsMap map[string]any
-				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
-					argsBytes = []byte(argsStr)
-				} else {
-					argsBytes = []byte("{}")
-				}
-			} else {
-				argsBytes, _ = json.Marshal(args)
-			}
-		} else {
-			argsBytes = []byte("{}")
-		}
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Firefunction v2 Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-	}
-
-	return toolCalls
-}
-
-// extractFunctionaryV31Llama31ToolCalls は Functionary v3.1 Llama 3.1 形式のツール呼び出しを抽出
-// 形式: <function=functionName>{JSON}</function>
-func extractFunctionaryV31Llama31ToolCalls(text string) []ToolCall {
-	// Functionary v3.1 Llama 3.1のタグパターン
-	// <function=functionName> ... </function>
-	closeTag := `</function>`
-	matches := regexFunctionaryV31Llama31.FindAllStringSubmatchIndex(text, -1)
-
-	if len(matches) == 0 {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	for _, match := range matches {
-		// match[0], match[1]: 全体マッチ（<function=functionName>）
-		// match[2], match[3]: 関数名のキャプチャグループ
-
-		if len(match) < 4 {
-			continue
-		}
-
-		functionName := strings.TrimSpace(text[match[2]:match[3]])
-
-		// 空の関数名はスキップ
-		if functionName == "" {
-			continue
-		}
-
-		// JSON引数の抽出（<function=...>の後から</function>まで）
-		jsonStart := match[1] // <function=...>の終了位置
-		remainingText := text[jsonStart:]
-
-		closeIdx := strings.Index(remainingText, closeTag)
-		if closeIdx == -1 {
-			continue
-		}
-
-		jsonText := strings.TrimSpace(remainingText[:closeIdx])
-
-		// JSON引数のパース
-		var argsMap map[string]any
-		if jsonText != "" {
-			if err := json.Unmarshal([]byte(jsonText), &argsMap); err != nil {
-				continue
-			}
-		} else {
-			argsMap = make(map[string]any)
-		}
-
-		argsBytes, _ := json.Marshal(argsMap)
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Functionary v3.1 Llama 3.1 Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-	}
-
-	return toolCalls
-}
-
-// extractLlama3XToolCalls は Llama 3.x 形式のツール呼び出しを抽出
-// 形式: {"type": "function", "name": "functionName", "parameters": {...}}
-func extractLlama3XToolCalls(text string) []ToolCall {
-	// Llama 3.xのJSON形式パターン
-	// {"type": "function", "name": "...", "parameters": {...}}
-	matches := regexLlama3X.FindAllStringSubmatchIndex(text, -1)
-
-	if len(matches) == 0 {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	for _, match := range matches {
-		// match[0], match[1]: 全体マッチ
-		// match[2], match[3]: 関数名のキャプチャグループ
-
-		if len(match) < 4 {
-			continue
-		}
-
-		functionName := strings.TrimSpace(text[match[2]:match[3]])
-
-		// 空の関数名はスキップ
-		if functionName == "" {
-			continue
-		}
-
-		// parametersの値を抽出（match[1]の位置から）
-		jsonStart := match[1]
-		remainingText := text[jsonStart:]
-
-		// parametersのJSONオブジェクトを抽出
-		// 中括弧のバランスを取りながら抽出
-		braceCount := 0
-		jsonEnd := -1
-		inString := false
-		escape := false
-
-		for i, ch := range remainingText {
-			if escape {
-				escape = false
-				continue
-			}
-
-			if ch == '\\' {
-				escape = true
-				continue
-			}
-
-			if ch == '"' {
-				inString = !inString
-				continue
-			}
-
-			if !inString {
-				if ch == '{' {
-					braceCount++
-				} else if ch == '}' {
-					braceCount--
-					if braceCount == 0 {
-						jsonEnd = i + 1
-						break
-					}
-				}
-			}
-		}
-
-		if jsonEnd == -1 {
-			continue
-		}
-
-		// 完全なJSONオブジェクトを抽出
-		fullJsonText := remainingText[:jsonEnd]
-
-		// JSON全体をパース
-		var fullObj map[string]any
-		if err := json.Unmarshal([]byte(fullJsonText), &fullObj); err != nil {
-			continue
-		}
-
-		// parametersを取得
-		var argsBytes []byte
-		if params, exists := fullObj["parameters"]; exists {
-			if paramsMap, ok := params.(map[string]any); ok {
-				argsBytes, _ = json.Marshal(paramsMap)
-			} else {
-				argsBytes = []byte("{}")
-			}
-		} else {
-			argsBytes = []byte("{}")
-		}
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Llama 3.x Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-	}
-
-	return toolCalls
-}
-
-// extractMagistralToolCalls は Magistral 形式のツール呼び出しを抽出
-// 形式: [TOOLCALLS][{"name": "func", "arguments": {...}}]
-func extractMagistralToolCalls(text string) []ToolCall {
-	// Magistralのプレフィックス
-	const prefix = "[TOOLCALLS]"
-
-	// プレフィックスの検出
-	prefixIdx := strings.Index(text, prefix)
-	if prefixIdx == -1 {
-		return nil
-	}
-
-	// JSON配列の開始位置（プレフィックスの直後）
-	jsonStart := prefixIdx + len(prefix)
-	jsonText := strings.TrimSpace(text[jsonStart:])
-
-	// JSON配列の開始を確認
-	if !strings.HasPrefix(jsonText, "[") {
-		return nil
-	}
-
-	// JSON配列の終了を探す
-	jsonEnd := strings.LastIndex(jsonText, "]")
-	if jsonEnd == -1 {
-		return nil
-	}
-
-	jsonText = jsonText[:jsonEnd+1]
-
-	// JSON配列のパース
-	var toolCallsData []map[string]any
-	if err := json.Unmarshal([]byte(jsonText), &toolCallsData); err != nil {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	for _, tcData := range toolCallsData {
-		// nameの取得
-		functionName, ok := tcData["name"].(string)
-		if !ok || functionName == "" {
-			continue
-		}
-
-		// argumentsの取得
-		var argsBytes []byte
-		if args, exists := tcData["arguments"]; exists {
-			if argsMap, ok := args.(map[string]any); ok {
-				argsBytes, _ = json.Marshal(argsMap)
-			} else if argsStr, ok := args.(string); ok {
-				// 文字列の場合、JSONとしてパース試行
-				var argsMap map[string]any
-				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
-					argsBytes = []byte(argsStr)
-				} else {
-					argsBytes = []byte("{}")
-				}
-			} else {
-				argsBytes, _ = json.Marshal(args)
-			}
-		} else {
-			argsBytes = []byte("{}")
-		}
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       generateToolCallID(),
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Magistral Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"Args":     string(argsBytes),
-		})
-	}
-
-	return toolCalls
-}
-
-// extractMistralNemoToolCalls は Mistral Nemo 形式のツール呼び出しを抽出
-// 形式: [TOOL_CALLS][{"name": "func", "arguments": {...}, "id": "123456789"}]
-func extractMistralNemoToolCalls(text string) []ToolCall {
-	// Mistral Nemoのプレフィックス
-	const prefix = "[TOOL_CALLS]"
-
-	// プレフィックスの検出
-	prefixIdx := strings.Index(text, prefix)
-	if prefixIdx == -1 {
-		return nil
-	}
-
-	// JSON配列の開始位置（プレフィックスの直後）
-	jsonStart := prefixIdx + len(prefix)
-	jsonText := strings.TrimSpace(text[jsonStart:])
-
-	// JSON配列の開始を確認
-	if !strings.HasPrefix(jsonText, "[") {
-		return nil
-	}
-
-	// JSON配列の終了を探す
-	jsonEnd := strings.LastIndex(jsonText, "]")
-	if jsonEnd == -1 {
-		return nil
-	}
-
-	jsonText = jsonText[:jsonEnd+1]
-
-	// JSON配列のパース
-	var toolCallsData []map[string]any
-	if err := json.Unmarshal([]byte(jsonText), &toolCallsData); err != nil {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	for _, tcData := range toolCallsData {
-		// nameの取得
-		functionName, ok := tcData["name"].(string)
-		if !ok || functionName == "" {
-			continue
-		}
-
-		// idの取得（Mistral Nemo特有）
-		toolCallID := ""
-		if id, ok := tcData["id"].(string); ok {
-			toolCallID = id
-		}
-
-		// IDが指定されていない場合は生成
-		if toolCallID == "" {
-			toolCallID = generateToolCallID()
-		}
-
-		// argumentsの取得
-		var argsBytes []byte
-		if args, exists := tcData["arguments"]; exists {
-			if argsMap, ok := args.(map[string]any); ok {
-				argsBytes, _ = json.Marshal(argsMap)
-			} else if argsStr, ok := args.(string); ok {
-				// 文字列の場合、JSONとしてパース試行
-				var argsMap map[string]any
-				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
-					argsBytes = []byte(argsStr)
-				} else {
-					argsBytes = []byte("{}")
-				}
-			} else {
-				argsBytes, _ = json.Marshal(args)
-			}
-		} else {
-			argsBytes = []byte("{}")
-		}
-
-		toolCalls = append(toolCalls, ToolCall{
-			ID:       toolCallID,
-			Type:     "function",
-			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-		})
-
-		logDebug("Mistral Nemo Tool Call Detected", map[string]any{
-			"Function": functionName,
-			"ID":       toolCallID,
-			"Args":     string(argsBytes),
-		})
-	}
-
-	return toolCalls
-}
-
-// extractGenericToolCalls は汎用的なJSONベースのツール呼び出しを抽出
-// 様々なフォーマットのJSONから toolcalls/toolcall フィールドを探索
-func extractGenericToolCalls(text string) []ToolCall {
-	// JSON全体をパース試行
-	text = strings.TrimSpace(text)
-
-	// JSONブロックの抽出（中括弧で始まる部分）
-	jsonStart := strings.Index(text, "{")
-	if jsonStart == -1 {
-		return nil
-	}
-
-	// 最後の閉じ括弧を探す
-	jsonEnd := strings.LastIndex(text, "}")
-	if jsonEnd == -1 || jsonEnd <= jsonStart {
-		return nil
-	}
-
-	jsonStr := text[jsonStart : jsonEnd+1]
-
-	var data map[string]any
-	if err := json.Unmarshal([]byte(jsonStr), &data); err != nil {
-		return nil
-	}
-
-	var toolCalls []ToolCall
-
-	// パターン1: "toolcalls" 配列フィールド
-	if toolCallsArray, ok := data["toolcalls"].([]any); ok {
-		for _, tc := range toolCallsArray {
-			if tcMap, ok := tc.(map[string]any); ok {
-				if toolCall := parseGenericToolCallObject(tcMap); toolCall != nil {
-					toolCalls = append(toolCalls, *toolCall)
-				}
-			}
-		}
-		if len(toolCalls) > 0 {
-			logDebug("Generic Tool Calls Detected", map[string]any{
-				"Pattern": "toolcalls array",
-				"Count":   len(toolCalls),
-			})
-			return toolCalls
-		}
-	}
-
-	// パターン2: "tool_calls" 配列フィールド（アンダースコア付き）
-	if toolCallsArray, ok := data["tool_calls"].([]any); ok {
-		for _, tc := range toolCallsArray {
-			if tcMap, ok := tc.(map[string]any); ok {
-				if toolCall := parseGenericToolCallObject(tcMap); toolCall != nil {
-					toolCalls = append(toolCalls, *toolCall)
-				}
-			}
-		}
-		if len(toolCalls) > 0 {
-			logDebug("Generic Tool Calls Detected", map[string]any{
-				"Pattern": "tool_calls array",
-				"Count":   len(toolCalls),
-			})
-			return toolCalls
-		}
-	}
-
-	// パターン3: "toolcall" 単一オブジェクト
-	if toolCallObj, ok := data["toolcall"].(map[string]any); ok {
-		if toolCall := parseGenericToolCallObject(toolCallObj); toolCall != nil {
-			logDebug("Generic Tool Call Detected", map[string]any{
-				"Pattern":  "toolcall object",
-				"Function": toolCall.Function.Name,
-			})
-			return []ToolCall{*toolCall}
-		}
-	}
-
-	// パターン4: "tool_call" 単一オブジェクト（アンダースコア付き）
-	if toolCallObj, ok := data["tool_call"].(map[string]any); ok {
-		if toolCall := parseGenericToolCallObject(toolCallObj); toolCall != nil {
-			logDebug("Generic Tool Call Detected", map[string]any{
-				"Pattern":  "tool_call object",
-				"Function": toolCall.Function.Name,
-			})
-			return []ToolCall{*toolCall}
-		}
-	}
-
-	// パターン5: "response" フィールド（llama.cpp互換）
-	if response, ok := data["response"]; ok {
-		// responseフィールドがある場合、これはコンテンツであってツール呼び出しではない
-		// TCGWはツール呼び出し抽出専用なので、nilを返す
-		logDebug("Generic Parser: response field detected (not a tool call)", map[string]any{
-			"Response": response,
-		})
-		return nil
-	}
-
-	return nil
-}
-
-// parseGenericToolCallObject は汎用的なツール呼び出しオブジェクトをパース
-func parseGenericToolCallObject(obj map[string]any) *ToolCall {
-	// 関数名の取得（複数のフィールド名に対応）
-	var functionName string
-	for _, key := range []string{"name", "function", "function_name", "tool", "tool_name"} {
-		if name, ok := obj[key].(string); ok && name != "" {
-			functionName = name
-			break
-		}
-	}
-
-	if functionName == "" {
-		return nil
-	}
+	logDebug("Bifrost Response Received", map[string]any{
+		"Status Code": resp.StatusCode,
+		"Body Size":   len(body),
+	})
 
-	// 引数の取得（複数のフィールド名に対応）
-	var argsBytes []byte
-	for _, key := range []string{"arguments", "args", "parameters", "params", "input"} {
-		if args, exists := obj[key]; exists {
-			if argsMap, ok := args.(map[string]any); ok {
-				argsBytes, _ = json.Marshal(argsMap)
-				break
-			} else if argsStr, ok := args.(string); ok {
-				// 文字列の場合、JSONとしてパース試行
-				var argsMap map[string]any
-				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
-					argsBytes = []byte(argsStr)
-				} else {
-					// JSONでない場合は空オブジェクト
-					argsBytes = []byte("{}")
-				}
-				break
-			}
+	if resp.StatusCode >= 400 {
+		var backendErr map[string]any
+		if json.Unmarshal(body, &backendErr) == nil {
+			// Bifrostからのエラーをそのまま転送
+			return backendErr, fmt.Errorf("%d", resp.StatusCode)
+		} else {
+			// BifrostがJSONでないエラーを返した場合
+			return map[string]any{"error": map[string]any{"message": "Invalid response from backend", "type": "server_error"}}, fmt.Errorf("502")
 		}
 	}
 
-	if argsBytes == nil {
-		argsBytes = []byte("{}")
-	}
-
-	return &ToolCall{
-		ID:       generateToolCallID(),
-		Type:     "function",
-		Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
-	}
-}
-
-// バックエンドのレスポンスから 'content' 文字列を安全に抽出
-func extractContentFromBackendResponse(m map[string]any) string {
-	choices, ok := m["choices"].([]any)
-	if !ok || len(choices) == 0 {
-		logDebug("Content Extraction Failed", map[string]any{
-			"reason": "choices field missing or empty",
-		})
-		return ""
-	}
-	choice, ok := choices[0].(map[string]any)
-	if !ok {
-		logDebug("Content Extraction Failed", map[string]any{
-			"reason": "invalid choice structure",
-		})
-		return ""
-	}
-	message, ok := choice["message"].(map[string]any)
-	if !ok {
-		logDebug("Content Extraction Failed", map[string]any{
-			"reason": "message field missing",
-		})
-		return ""
-	}
-	content, ok := message["content"]
-	if !ok || content == nil {
-		// tool_callsの場合はcontentがnullなので、これは正常なケース
-		return ""
-	}
-	if str, ok := content.(string); ok {
-		return str
-	}
-	logDebug("Content Extraction Failed", map[string]any{
-		"reason":       "content is not a string",
-		"content_type": fmt.Sprintf("%T", content),
-	})
-	return ""
-}
-
-// OpenAI互換レスポンスを構築
-func buildOpenAIResponse(model, originalContent string, toolCalls []ToolCall) ChatCompletionResponse {
-	msg := ResponseMessage{Role: "assistant"}
-	var finish string
-	if len(toolCalls) > 0 {
-		msg.Content = nil // ツール呼び出し時は content は null
-		msg.ToolCalls = toolCalls
-		finish = "tool_calls"
-	} else {
-		msg.Content = &originalContent
-		msg.ToolCalls = []ToolCall{} // 空配列 (omitemptyにより省略される)
-		finish = "stop"
-	}
-	return ChatCompletionResponse{
-		ID:      generateResponseID(),
-		Object:  "chat.completion",
-		Created: time.Now().Unix(),
-		Model:   model,
-		Choices: []Choice{
-			{
-				Index:        0,
-				Message:      msg,
-				FinishReason: finish,
-			},
-		},
-		Usage: Usage{
-			PromptTokens:     0,
-			CompletionTokens: 0,
-			TotalTokens:      0,
-		},
+	var backendResp map[string]any
+	if err := json.Unmarshal(body, &backendResp); err != nil {
+		return map[string]any{"error": map[string]any{"message": "Invalid response from backend (JSON parse failed)", "type": "server_error"}}, fmt.Errorf("502")
 	}
+	return backendResp, nil
 }
 
-// Bifrostへリクエスト転送し、JSONレスポンスを返す
-func forwardToBifrost(req *ChatCompletionRequest) (map[string]any, error) {
-	bodyBytes, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("Internal error: failed to marshal request: %v", err)
-	}
-
-	logDebug("Forwarding to Bifrost", map[string]any{
-		"URL":       bifrostURL + "/v1/chat/completions",
-		"Body Size": len(bodyBytes),
-		"Timeout":   requestTimeout,
-	})
+// Bifrostの path（/v1/models など）をGETで取得し、レスポンスを返す（エラーの返し方は forwardToBifrost と同じ）
+func getFromBifrost(path string) (map[string]any, error) {
+	logDebug("Fetching from Bifrost", map[string]any{"URL": bifrostURL + path})
 
 	client := &http.Client{}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(requestTimeout)*time.Millisecond)
 	defer cancel()
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, bifrostURL+"/v1/chat/completions", bytes.NewReader(bodyBytes))
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, bifrostURL+path, nil)
 	if err != nil {
-		return nil, fmt.Errorf("Internal error: failed to create request: %v", err)
+		return map[string]any{"error": map[string]any{"message": fmt.Sprintf("Internal error: failed to create request: %v", err), "type": "server_error"}}, fmt.Errorf("500")
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
 	if bifrostApiKey != "" {
 		httpReq.Header.Set("Authorization", "Bearer "+bifrostApiKey)
 	}
 
 	resp, err := client.Do(httpReq)
 	if err != nil {
-		// タイムアウト (os.IsTimeout ではなく context.DeadlineExceeded をチェック)
-		if errors.Is(err, context.DeadlineExceeded) {
-			return map[string]any{"error": map[string]any{"message": fmt.Sprintf("Request timeout after %dms", requestTimeout), "type": "server_error"}}, fmt.Errorf("500")
-		}
-		// DNS失敗や接続拒否
-		if strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "no such host") {
-			return map[string]any{"error": map[string]any{"message": fmt.Sprintf("Backend service unavailable: %v", err), "type": "service_unavailable_error"}}, fmt.Errorf("503")
-		}
-		// その他ネットワークエラー
-		return map[string]any{"error": map[string]any{"message": fmt.Sprintf("Backend service error: %v", err), "type": "server_error"}}, fmt.Errorf("500")
+		return bifrostTransportError(err)
 	}
 	defer resp.Body.Close()
 
@@ -3403,29 +1192,44 @@ func forwardToBifrost(req *ChatCompletionRequest) (map[string]any, error) {
 		return map[string]any{"error": map[string]any{"message": fmt.Sprintf("Internal error: failed to read response body: %v", err), "type": "server_error"}}, fmt.Errorf("500")
 	}
 
-	logDebug("Bifrost Response Received", map[string]any{
-		"Status Code": resp.StatusCode,
-		"Body Size":   len(body),
-	})
-
-	if resp.StatusCode >= 400 {
-		var backendErr map[string]any
-		if json.Unmarshal(body, &backendErr) == nil {
-			// Bifrostからのエラーをそのまま転送
-			return backendErr, fmt.Errorf("%d", resp.StatusCode)
-		} else {
-			// BifrostがJSONでないエラーを返した場合
-			return map[string]any{"error": map[string]any{"message": "Invalid response from backend", "type": "server_error"}}, fmt.Errorf("502")
-		}
-	}
-
 	var backendResp map[string]any
 	if err := json.Unmarshal(body, &backendResp); err != nil {
 		return map[string]any{"error": map[string]any{"message": "Invalid response from backend (JSON parse failed)", "type": "server_error"}}, fmt.Errorf("502")
 	}
+	if resp.StatusCode >= 400 {
+		// Bifrostからのエラーをそのまま転送
+		return backendResp, fmt.Errorf("%d", resp.StatusCode)
+	}
 	return backendResp, nil
 }
 
+// Bifrostの /v1/chat/completions へのPOSTリクエストを作成（認証ヘッダー付き）
+func newBifrostRequest(ctx context.Context, bodyBytes []byte) (*http.Request, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, bifrostURL+"/v1/chat/completions", bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("Internal error: failed to create request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if bifrostApiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+bifrostApiKey)
+	}
+	return httpReq, nil
+}
+
+// Bifrostへの通信エラーをOpenAI形式のエラーとステータスコードに変換
+func bifrostTransportError(err error) (map[string]any, error) {
+	// タイムアウト (os.IsTimeout ではなく context.DeadlineExceeded をチェック)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return map[string]any{"error": map[string]any{"message": fmt.Sprintf("Request timeout after %dms", requestTimeout), "type": "server_error"}}, fmt.Errorf("500")
+	}
+	// DNS失敗や接続拒否
+	if strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "no such host") {
+		return map[string]any{"error": map[string]any{"message": fmt.Sprintf("Backend service unavailable: %v", err), "type": "service_unavailable_error"}}, fmt.Errorf("503")
+	}
+	// その他ネットワークエラー
+	return map[string]any{"error": map[string]any{"message": fmt.Sprintf("Backend service error: %v", err), "type": "server_error"}}, fmt.Errorf("500")
+}
+
 // --- Ginハンドラー群 ---
 
 // エミュレートモード: ツール呼び出しをXML形式でエミュレート
@@ -3440,50 +1244,354 @@ func handleChatCompletionsEmulate(c *gin.Context) {
 		return
 	}
 
+	// 旧形式の functions / function_call は tools / tool_choice として扱う（応答も旧形式にする）
+	legacy := translateLegacyFunctions(&req)
+
+	e, apiErr := prepareEmulation(c, &req, legacy)
+	if apiErr != nil {
+		c.JSON(apiErr.status, apiErr.body)
+		return
+	}
+
+	// ストリーミングで n > 1 の場合は、すべての選択肢を受け取ってから選択肢ごとに送る（choices.go）
+	if req.Stream && requestedChoices(&req) > 1 {
+		handleChatCompletionsEmulateChoicesStream(c, e, legacy)
+		return
+	}
+	// ストリーミングはSSEでチャンクを中継する（stream.go）
 	if req.Stream {
-		c.JSON(501, ErrorResponse{Error: ErrorDetail{
-			Message: "Streaming is not currently supported",
-			Type:    "invalid_request_error",
-		}})
+		handleChatCompletionsEmulateStream(c, e, openAIStreamFrontEnd(c, &req, legacy))
+		return
+	}
+	result, apiErr := runEmulation(e, c.Writer.Header())
+	if apiErr != nil {
+		c.JSON(apiErr.status, apiErr.body)
+		return
+	}
+
+	// 部分的な上書きを実行
+	patchedResp := patchOpenAIResponse(result.backendResp, result.choices)
+	if patchedResp == nil {
+		// フォールバック: 従来の完全書き換え
+		resp := buildOpenAIResponse(req.Model, result.choices)
+		if legacy {
+			legacyFunctionCallMessage(&resp)
+		}
+		c.JSON(200, resp)
 		return
 	}
+	if legacy {
+		patchedResp = legacyFunctionCallResponse(patchedResp)
+	}
+
+	logDebug("Response Patched (Emulate Mode)", map[string]any{
+		"Choices Count":    len(result.choices),
+		"Tool Calls Count": len(result.toolCalls),
+		"Finish Reason":    result.finishReason,
+	})
+
+	c.JSON(200, patchedResp)
+}
+
+// emulation はツール定義の埋め込みまで終えたリクエストと、応答の処理に使う設定
+// Chat Completions 以外のAPI（Messages など）も、リクエストを ChatCompletionRequest に変換したうえで同じ処理に載せる
+type emulation struct {
+	req         *ChatCompletionRequest
+	modelConfig config.ModelConfig
+	requirement toolCallRequirement
+	renderer    toolPromptRenderer
+	ctx         context.Context // クライアントのリクエストのコンテキスト（切断された場合は分けて転送する残りのリクエストを止める）
+}
+
+// emulationError はエミュレートの処理で返すエラー（body は OpenAI 形式の ErrorResponse、またはバックエンドが返したエラー）
+type emulationError struct {
+	status int
+	body   any
+}
+
+// detail はエラーの本文から message と type を取り出す（OpenAI 以外の形式のエラーに変換する場合に使う）
+func (e *emulationError) detail() ErrorDetail {
+	detail := ErrorDetail{Message: http.StatusText(e.status), Type: "server_error"}
+	raw, err := json.Marshal(e.body)
+	if err != nil {
+		return detail
+	}
+	var parsed struct {
+		Error ErrorDetail `json:"error"`
+	}
+	if json.Unmarshal(raw, &parsed) == nil && parsed.Error.Message != "" {
+		detail.Message = parsed.Error.Message
+		if parsed.Error.Type != "" {
+			detail.Type = parsed.Error.Type
+		}
+	}
+	return detail
+}
 
+// prepareEmulation は tool_choice・モデル設定を解釈し、ツール呼び出し履歴の変換とツール定義の埋め込みを行う
+// legacy は旧形式の functions / function_call を書き換えたリクエストか（エラーの param に使う）
+func prepareEmulation(c *gin.Context, req *ChatCompletionRequest, legacy bool) (*emulation, *emulationError) {
 	logDebug("Request Received (Emulate Mode)", map[string]any{
 		"Model":         req.Model,
 		"Tool Count":    len(req.Tools),
 		"Message Count": len(req.Messages),
+		"Has Stream":    req.Stream,
 	})
 
-	embedToolsIntoPrompt(&req)
-	backendResp, ferr := forwardToBifrost(&req)
+	// tool_choice の解釈（ツール定義の埋め込み・抽出結果の確認に使う）
+	choice, err := parseToolChoice(req.ToolChoice, req.Tools)
+	if err != nil {
+		param := "tool_choice"
+		if legacy {
+			param = "function_call"
+		}
+		return nil, &emulationError{status: 400, body: ErrorResponse{Error: ErrorDetail{
+			Message: err.Error(),
+			Type:    "invalid_request_error",
+			Param:   stringPtr(param),
+			Code:    stringPtr("invalid_tool_choice"),
+		}}}
+	}
+
+	// n（選択肢の数）は選択肢ごとにツール呼び出しを抽出するため、上限を設ける（choices.go）
+	if req.N != nil && (*req.N < 1 || *req.N > maxChoices) {
+		return nil, &emulationError{status: 400, body: ErrorResponse{Error: ErrorDetail{
+			Message: fmt.Sprintf("n must be between 1 and %d", maxChoices),
+			Type:    "invalid_request_error",
+			Param:   stringPtr("n"),
+			Code:    stringPtr("invalid_value"),
+		}}}
+	}
+
+	// モデルの別名（MODEL_CONFIG_FILE の aliases）は Bifrost のモデル名に置き換えてから設定を引く
+	if target, ok := modelSettings.ResolveAlias(req.Model); ok {
+		logDebug("Model Alias Resolved", map[string]any{"Alias": req.Model, "Model": target})
+		req.Model = target
+	}
+
+	// モデル名に応じた設定（MODEL_CONFIG_FILE）
+	modelConfig := modelSettings.Resolve(req.Model)
+
+	// 過去のツール呼び出しと実行結果をテキストに書き換え、ツール定義をプロンプトに埋め込む
+	// プロンプトのプロファイルはリクエストの指定がモデル設定の prompt_profile より優先される
+	renderer := toolPromptRendererFor(modelConfig.ToolPrompt)
+	profile := requestedPromptProfile(c, req)
+	if profile != "" {
+		if _, ok := promptProfiles[profile]; !ok {
+			return nil, &emulationError{status: 400, body: ErrorResponse{Error: ErrorDetail{
+				Message: fmt.Sprintf("Unknown prompt profile %q (available: %s)", profile, strings.Join(promptProfileNames(), ", ")),
+				Type:    "invalid_request_error",
+				Code:    stringPtr("invalid_prompt_profile"),
+			}}}
+		}
+	} else {
+		profile = modelConfig.PromptProfile
+	}
+	req.Messages = translateToolHistory(req.Messages, renderer)
+	promptCtx := newPromptContext(c, req)
+	requirement := newToolCallRequirement(req, choice, modelConfig, renderer)
+	promptCtx.ToolChoice = choice
+	promptCtx.SingleCall = requirement.single
+	// response_format をエミュレートする場合は、バックエンドに送らずに出力形式をプロンプトで指示する（responseformat.go）
+	if resolveResponseFormatMode(modelConfig) == responseFormatEmulate {
+		format, err := parseResponseFormat(req.ResponseFormat)
+		if err != nil {
+			return nil, &emulationError{status: 400, body: ErrorResponse{Error: ErrorDetail{
+				Message: err.Error(),
+				Type:    "invalid_request_error",
+				Param:   stringPtr("response_format"),
+				Code:    stringPtr("invalid_response_format"),
+			}}}
+		}
+		requirement.format = format
+	}
+	withTools := len(req.Tools) > 0 && choice.Mode != toolChoiceNone
+	if err := embedToolsIntoPrompt(req, renderer, promptCtx, profile); err != nil {
+		return nil, &emulationError{status: 500, body: ErrorResponse{Error: ErrorDetail{
+			Message: fmt.Sprintf("Failed to render tool prompt: %v", err),
+			Type:    "server_error",
+			Code:    stringPtr("prompt_render_failed"),
+		}}}
+	}
+	embedResponseFormatIntoPrompt(req, requirement.format, withTools)
+
+	return &emulation{req: req, modelConfig: modelConfig, requirement: requirement, renderer: renderer, ctx: c.Request.Context()}, nil
+}
+
+// emulatedChoice はエミュレートした1つの選択肢
+type emulatedChoice struct {
+	content      string // クライアントに返す本文（ツール呼び出し時はマークアップを除いた文章）
+	reasoning    string // 本文から分離した推論テキスト
+	toolCalls    []ToolCall
+	finishReason string // "tool_calls"、またはバックエンドの finish_reason（"length" / "content_filter"。それ以外は "stop"）
+}
+
+// emulationResult はエミュレートの結果（ストリーミングなし）
+type emulationResult struct {
+	backendResp    map[string]any   // バックエンドのレスポンス（n: 1 で聞き直した場合は最後のもの）
+	emulatedChoice                  // 最初の選択肢（選択肢を1つだけ返すAPIの形式はこれを使う）
+	choices        []emulatedChoice // すべての選択肢（index 順。n > 1 の場合は2つ以上）
+}
+
+// usage はバックエンドのレスポンスの usage（含まれない場合はゼロ値）
+func (r *emulationResult) usage() Usage {
+	if u := extractUsageFromChunk(r.backendResp); u != nil {
+		return *u
+	}
+	return Usage{}
+}
+
+// choiceOutcome は1つの選択肢をエミュレートした結果
+type choiceOutcome struct {
+	choice    emulatedChoice
+	retryResp map[string]any // 聞き直しで条件を満たした場合の最後のレスポンス
+	attempts  int
+	repaired  bool
+	strictErr *strictToolCallError
+	reports   []toolCallValidationReport // 返す呼び出しの検証結果
+}
+
+// runEmulation はバックエンドに転送し、応答からツール呼び出しを抽出・確認する（必要なら聞き直す）
+// n > 1 の場合は選択肢ごとに並行して抽出・確認・聞き直しを行う（N_FANOUT の設定によっては n 回に分けて転送する。choices.go）
+// 修復の結果は header に設定する
+func runEmulation(e *emulation, header http.Header) (*emulationResult, *emulationError) {
+	req, modelConfig := e.req, e.modelConfig
+
+	backendResp, ferr := forwardChoices(e.ctx, req, requestedChoices(req), resolveNFanout(modelConfig))
 	if ferr != nil {
 		code := 500
 		if s, err := strconv.Atoi(ferr.Error()); err == nil {
 			code = s
 		}
 		logDebug("Bifrost Response Error", backendResp)
-		c.JSON(code, backendResp)
-		return
+		return nil, &emulationError{status: code, body: backendResp}
 	}
 
-	content := extractContentFromBackendResponse(backendResp)
-	toolCalls := extractToolCalls(content)
+	backendChoices := extractBackendChoices(backendResp)
+	if missing := requestedChoices(req) - len(backendChoices); missing > 0 && requestedChoices(req) > 1 {
+		// バックエンドが n を無視した場合や、分けて転送したリクエストの一部が失敗した場合は、足りない選択肢の数をヘッダーで示す
+		header.Set(headerChoicesMissing, strconv.Itoa(missing))
+	}
+	if len(backendChoices) == 0 {
+		// choices がない場合も、空の本文の選択肢として扱う
+		backendChoices = []backendChoice{{finishReason: "stop"}}
+	}
+	outcomes := make([]choiceOutcome, len(backendChoices))
+	if len(backendChoices) == 1 {
+		outcomes[0] = emulateChoice(e, backendChoices[0])
+	} else {
+		var wg sync.WaitGroup
+		for i, bc := range backendChoices {
+			wg.Add(1)
+			go func(i int, bc backendChoice) {
+				defer wg.Done()
+				outcomes[i] = emulateChoice(e, bc)
+			}(i, bc)
+		}
+		wg.Wait()
+	}
 
-	// 部分的な上書きを実行
-	patchedResp := patchOpenAIResponse(backendResp, toolCalls)
-	if patchedResp == nil {
-		// フォールバック: 従来の完全書き換え
-		resp := buildOpenAIResponse(req.Model, content, toolCalls)
-		c.JSON(200, resp)
-		return
+	// 修復の結果は選択肢の合計（聞き直したすべての選択肢で条件を満たした場合のみ repaired）
+	attempts, repaired := 0, true
+	for _, o := range outcomes {
+		attempts += o.attempts
+		if o.attempts > 0 && !o.repaired {
+			repaired = false
+		}
+	}
+	setRepairHeaders(header, e.requirement.repair, attempts, attempts > 0 && repaired)
+	perChoice := make([][]toolCallValidationReport, len(outcomes))
+	for i, o := range outcomes {
+		perChoice[i] = o.reports
+	}
+	setValidationHeader(header, perChoice, false)
+	for _, o := range outcomes {
+		if o.strictErr != nil {
+			// strict: true のツールの引数がスキーマに合わない場合は、合わない引数を返さずにエラーにする
+			return nil, &emulationError{status: 502, body: o.strictErr.response()}
+		}
 	}
 
-	logDebug("Response Patched (Emulate Mode)", map[string]any{
-		"Tool Calls Count": len(toolCalls),
-		"Finish Reason":    patchedResp["choices"].([]any)[0].(map[string]any)["finish_reason"],
-	})
+	result := &emulationResult{backendResp: backendResp, choices: make([]emulatedChoice, len(outcomes))}
+	for i, o := range outcomes {
+		result.choices[i] = o.choice
+	}
+	result.emulatedChoice = result.choices[0]
+	if len(outcomes) == 1 && outcomes[0].retryResp != nil {
+		result.backendResp = outcomes[0].retryResp
+	}
+	return result, nil
+}
 
-	c.JSON(200, patchedResp)
+// emulateChoice は1つの選択肢の本文からツール呼び出しを抽出・確認する（必要なら聞き直す）
+func emulateChoice(e *emulation, bc backendChoice) choiceOutcome {
+	req, modelConfig, requirement, renderer := e.req, e.modelConfig, e.requirement, e.renderer
+	requirement.validation = &validationRecord{}
+	choice := requirement.choice
+	var outcome choiceOutcome
+	finish := bc.finishReason
+
+	// 推論テキストを分離し、残りの本文のみからツール呼び出しを抽出する
+	reasoning, content := extractReasoning(bc.content)
+	parsers := toolCallParsersFor(modelConfig, choice)
+	toolCalls, spans := extractToolCalls(content, parsers)
+
+	// tool_choice・parallel_tool_calls: false の条件を満たしていない・壊れた呼び出しを修復する場合は聞き直す
+	var reask string
+	if toolCalls, reask = requirement.check(content, toolCalls); reask != "" {
+		var retry *toolCallRetryResult
+		if retry, outcome.attempts = retryForToolCalls(e.ctx, req, requirement, renderer, modelConfig, parsers, content, reask); retry != nil {
+			outcome.repaired = true
+			outcome.retryResp = retry.backendResp
+			finish = backendFinishReason(retry.backendResp)
+			content, reasoning = retry.content, retry.reasoning
+			toolCalls, spans = retry.toolCalls, retry.spans
+		} else if toolCalls, outcome.strictErr = requirement.fallback(toolCalls); outcome.strictErr != nil {
+			return outcome
+		}
+	}
+
+	// ツール呼び出し時の content: マークアップを除いた前後の文章（モデル設定で無効化した場合は null）
+	messageContent := content
+	if len(toolCalls) > 0 {
+		messageContent = ""
+		if modelConfig.KeepContentWithToolCalls {
+			messageContent = removeMarkupSpans(content, spans)
+		}
+	} else if len(spans) > 0 {
+		// 抽出した呼び出しをすべて取り除いた場合（検証で drop など）は、マークアップを除いた文章のみ返す
+		messageContent = removeMarkupSpans(content, spans)
+	}
+	if len(toolCalls) == 0 {
+		// response_format をエミュレートする場合は、最終回答から取り出した JSON のみ返す
+		messageContent = requirement.finalAnswer(messageContent)
+	}
+
+	outcome.choice = emulatedChoice{content: messageContent, reasoning: reasoning, toolCalls: toolCalls, finishReason: emulatedFinishReason(finish, toolCalls)}
+	outcome.reports = requirement.validation.reports
+	return outcome
+}
+
+// backendFinishReason は聞き直し（選択肢は1つ）のレスポンスの finish_reason（含まれない場合は "stop"）
+// クライアントに返す値への変換は emulatedFinishReason で行う
+func backendFinishReason(backendResp map[string]any) string {
+	choices := extractBackendChoices(backendResp)
+	if len(choices) == 0 {
+		return "stop"
+	}
+	return choices[0].finishReason
+}
+
+// emulatedFinishReason はクライアントに返す finish_reason
+// ツール呼び出しがある場合は "tool_calls"、ない場合はバックエンドの finish_reason（"length" / "content_filter" はそのまま、それ以外は "stop"）
+func emulatedFinishReason(backend string, toolCalls []ToolCall) string {
+	if len(toolCalls) > 0 {
+		return "tool_calls"
+	}
+	if backend == "length" || backend == "content_filter" {
+		return backend
+	}
+	return "stop"
 }
 
 // stringのポインタを返すヘルパー関数
@@ -3552,23 +1660,39 @@ func stringPtr(s string) *string {
 // }
 
 // バックエンドレスポンスを部分的に上書きしてOpenAI互換にする
-func patchOpenAIResponse(backendResp map[string]any, toolCalls []ToolCall) map[string]any {
-	choices, ok := backendResp["choices"].([]any)
-	if !ok || len(choices) == 0 {
+// choices は extractBackendChoices と同じ順（index 順）の選択肢で、バックエンドの choices を1つずつ上書きする
+// content はツール呼び出しがある場合、または推論を分離した場合に使用する本文（ツール呼び出し時は空なら null）
+// reasoning は本文から分離した推論テキストで、バックエンドが返した reasoning_content の後ろに追加する
+func patchOpenAIResponse(backendResp map[string]any, choices []emulatedChoice) map[string]any {
+	backendChoices, ok := backendResp["choices"].([]any)
+	if !ok || len(backendChoices) == 0 || len(backendChoices) != len(choices) {
 		logDebug("Patch Failed", map[string]any{
 			"reason": "choices field invalid",
 		})
 		return nil
 	}
 
-	choice, ok := choices[0].(map[string]any)
-	if !ok {
-		logDebug("Patch Failed", map[string]any{
-			"reason": "choice[0] is not a map",
-		})
-		return nil
+	patched := make([]any, 0, len(choices))
+	for i, ec := range choices {
+		choice, ok := backendChoices[i].(map[string]any)
+		if !ok {
+			logDebug("Patch Failed", map[string]any{
+				"reason": fmt.Sprintf("choice[%d] is not a map", i),
+			})
+			return nil
+		}
+		patchOpenAIChoice(choice, ec)
+		choice["index"] = i
+		patched = append(patched, choice)
 	}
 
+	backendResp["choices"] = patched
+	return backendResp
+}
+
+// patchOpenAIChoice はバックエンドの選択肢1つを上書きする
+func patchOpenAIChoice(choice map[string]any, ec emulatedChoice) {
+	toolCalls, content, reasoning := ec.toolCalls, ec.content, ec.reasoning
 	message, ok := choice["message"].(map[string]any)
 	if !ok {
 		// messageが存在しない場合は新規作成
@@ -3577,21 +1701,30 @@ func patchOpenAIResponse(backendResp map[string]any, toolCalls []ToolCall) map[s
 		logDebug("Patch: Created new message", map[string]any{})
 	}
 
+	// 推論テキストを reasoning_content へ（バックエンドが返した値はそのまま残す）
+	if reasoning != "" {
+		backendReasoning, _ := message["reasoning_content"].(string)
+		message["reasoning_content"] = mergeReasoning(backendReasoning, reasoning)
+	}
+
 	// ツール呼び出しの有無で分岐
 	if len(toolCalls) > 0 {
 		message["tool_calls"] = toolCalls
-		message["content"] = nil
-		choice["finish_reason"] = "tool_calls"
+		if content != "" {
+			message["content"] = content
+		} else {
+			message["content"] = nil
+		}
 		logDebug("Patch: Added tool_calls", map[string]any{
 			"count": len(toolCalls),
 		})
 	} else {
 		delete(message, "tool_calls")
-		choice["finish_reason"] = "stop"
+		if original, _ := message["content"].(string); reasoning != "" || original != content {
+			message["content"] = content // 推論・取り除いた呼び出しのマークアップを除いた本文
+		}
 	}
-
-	backendResp["choices"] = []any{choice}
-	return backendResp
+	choice["finish_reason"] = ec.finishReason
 }
 
 func handleHealthCheck(c *gin.Context) {
@@ -3600,8 +1733,12 @@ func handleHealthCheck(c *gin.Context) {
 		"service":   "tcgw",
 		"version":   config.VERSION,
 		"mode":      "dual-port",
+		"routing":   routingMode,
 		"timestamp": time.Now().Unix(),
 	}
+	if passthroughPort == "" {
+		health["mode"] = "single-port" // PASSTHROUGH_PORT=0 の場合はエミュレートのポートのみ
+	}
 
 	// Bifrost接続チェック（オプショナル）
 	client := &http.Client{Timeout: 2 * time.Second}
@@ -3636,9 +1773,36 @@ func main() {
 	emulateRouter := gin.Default()
 	emulateRouter.Use(cors.Default())
 	v1Emulate := emulateRouter.Group("/v1")
-	v1Emulate.POST("/chat/completions", handleChatCompletionsEmulate)
+	if routingMode == routingAuto {
+		// 自動モード: モデルごとの tool_calling でネイティブ・エミュレートを振り分ける（passthrough.go）
+		v1Emulate.POST("/chat/completions", handleChatCompletionsAuto)
+	} else {
+		v1Emulate.POST("/chat/completions", handleChatCompletionsEmulate)
+	}
+	v1Emulate.GET("/models", handleListModels) // モデル一覧（models.go）
+	v1Emulate.GET("/models/*id", handleGetModel)
+	v1Emulate.POST("/messages", handleAnthropicMessages) // Anthropic Messages API（anthropic.go）
+	v1Emulate.POST("/responses", handleResponses)        // OpenAI Responses API（responses.go）
+	v1Emulate.GET("/responses/:id", handleGetResponse)
+	v1Emulate.DELETE("/responses/:id", handleDeleteResponse)
+	// Gemini API（gemini.go）: /v1beta/models/{model}:generateContent / :streamGenerateContent
+	emulateRouter.POST("/v1beta/models/*action", handleGeminiModelAction)
+	// Ollama API（ollama.go）
+	emulateRouter.POST("/api/chat", handleOllamaChat)
+	emulateRouter.GET("/api/tags", handleOllamaTags)
 	emulateRouter.GET("/health", handleHealthCheck)
 
+	// パススルー用サーバー: すべてのリクエストを手を加えずに Bifrost へ転送する（passthrough.go）
+	if passthroughPort != "" {
+		passthroughRouter := newPassthroughRouter()
+		go func() {
+			if err := passthroughRouter.Run(passthroughPort); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to start passthrough server: %v\n", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// サーバー起動
 	if err := emulateRouter.Run(emulatePort); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to start server: %v\n", err)
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/t-kawata/tcgw/parser"
+)
+
+func TestExtractToolCallsAndRemoveMarkup(t *testing.T) {
+	tests := []struct {
+		name      string
+		parsers   []string // 空の場合は標準の順のすべてのパーサー
+		text      string
+		wantNames []string
+		wantRest  string // マークアップを取り除いた残りの文章
+	}{
+		{
+			name:      "xml keeps surrounding prose",
+			text:      "Let me check.\n<function_calls>\n<invoke name=\"get_weather\">\n<parameter name=\"city\">Tokyo</parameter>\n</invoke>\n</function_calls>\nOne moment.",
+			wantNames: []string{"get_weather"},
+			wantRest:  "Let me check.\nOne moment.",
+		},
+		{
+			name:      "hermes tool_call",
+			text:      "Sure.\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Tokyo\"}}\n</tool_call>",
+			wantNames: []string{"get_weather"},
+			wantRest:  "Sure.",
+		},
+		{
+			name:      "several calls between prose",
+			text:      "A <tool_call>{\"name\": \"f\", \"arguments\": {}}</tool_call> B <tool_call>{\"name\": \"g\", \"arguments\": {}}</tool_call> C",
+			wantNames: []string{"f", "g"},
+			wantRest:  "A\nB\nC",
+		},
+		{
+			name:      "qwen3-coder function tags",
+			parsers:   []string{"qwen3-coder"},
+			text:      "<tool_call>\n<function=get_time>\n<parameter=zone>\nUTC\n</parameter>\n</function>\n</tool_call>",
+			wantNames: []string{"get_time"},
+			wantRest:  "",
+		},
+		{
+			name:      "mistral markup only",
+			parsers:   []string{"mistral-nemo"},
+			text:      "[TOOL_CALLS][{\"name\": \"get_time\", \"arguments\": {}}]",
+			wantNames: []string{"get_time"},
+			wantRest:  "",
+		},
+		{
+			name:     "no tool call leaves the text as is",
+			text:     "  Just an answer.  ",
+			wantRest: "Just an answer.",
+		},
+		{
+			name:     "markup of an unselected parser is not a tool call",
+			parsers:  []string{"xml"},
+			text:     "<tool_call>{\"name\": \"f\", \"arguments\": {}}</tool_call>",
+			wantRest: "<tool_call>{\"name\": \"f\", \"arguments\": {}}</tool_call>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls, spans := extractToolCalls(tt.text, chainFor(t, tt.parsers...))
+			if len(calls) != len(tt.wantNames) {
+				t.Fatalf("extractToolCalls returned %d calls, want %d: %+v", len(calls), len(tt.wantNames), calls)
+			}
+			for i, call := range calls {
+				if call.Function.Name != tt.wantNames[i] {
+					t.Errorf("call %d name = %q, want %q", i, call.Function.Name, tt.wantNames[i])
+				}
+			}
+			if got := removeMarkupSpans(tt.text, spans); got != tt.wantRest {
+				t.Errorf("removeMarkupSpans = %q, want %q", got, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestRemoveMarkupSpans(t *testing.T) {
+	const text = "aaa <x> bbb <y> ccc"
+	tests := []struct {
+		name  string
+		spans []markupSpan
+		want  string
+	}{
+		{"no spans", nil, text},
+		{"unsorted spans", []markupSpan{{Start: 12, End: 15}, {Start: 4, End: 7}}, "aaa\nbbb\nccc"},
+		{"overlapping spans are merged", []markupSpan{{Start: 4, End: 10}, {Start: 8, End: 15}}, "aaa\nccc"},
+		{"span past the end is clamped", []markupSpan{{Start: 12, End: 100}}, "aaa <x> bbb"},
+		{"whole text", []markupSpan{{Start: 0, End: len(text)}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := removeMarkupSpans(text, tt.spans); got != tt.want {
+				t.Errorf("removeMarkupSpans(%v) = %q, want %q", tt.spans, got, tt.want)
+			}
+		})
+	}
+}
+
+// <function=名前> 形式の <tool_call> は、標準の順では hermes-2-pro より先に qwen3-coder が抽出する
+func TestExtractToolCallsReportsSelectedParser(t *testing.T) {
+	text := "<tool_call>\n<function=get_time>\n</function>\n</tool_call>"
+	_, _, name := parser.Extract(chainFor(t), text)
+	if name != "qwen3-coder" {
+		t.Errorf("parser = %q, want qwen3-coder", name)
+	}
+}
+
+func TestFinishReasonMapping(t *testing.T) {
+	calls := []ToolCall{{Type: "function", Function: ToolCallFunction{Name: "f", Arguments: "{}"}}}
+	tests := []struct {
+		backend   string
+		toolCalls []ToolCall
+		want      string // emulatedFinishReason
+		anthropic string
+		gemini    string
+		ollama    string
+		responses string // status
+	}{
+		{"stop", nil, "stop", "end_turn", "STOP", "stop", "completed"},
+		{"length", nil, "length", "max_tokens", "MAX_TOKENS", "length", "incomplete"},
+		{"content_filter", nil, "content_filter", "refusal", "SAFETY", "content_filter", "incomplete"},
+		{"function_call", nil, "stop", "end_turn", "STOP", "stop", "completed"},
+		{"length", calls, "tool_calls", "tool_use", "STOP", "stop", "completed"},
+	}
+	for _, tt := range tests {
+		finish := emulatedFinishReason(tt.backend, tt.toolCalls)
+		if finish != tt.want {
+			t.Errorf("emulatedFinishReason(%q, %d calls) = %q, want %q", tt.backend, len(tt.toolCalls), finish, tt.want)
+			continue
+		}
+		if got := anthropicStopReason(finish); got != tt.anthropic {
+			t.Errorf("anthropicStopReason(%q) = %q, want %q", finish, got, tt.anthropic)
+		}
+		if got := geminiFinishReason(finish); got != tt.gemini {
+			t.Errorf("geminiFinishReason(%q) = %q, want %q", finish, got, tt.gemini)
+		}
+		if got := ollamaDoneReason(finish); got != tt.ollama {
+			t.Errorf("ollamaDoneReason(%q) = %q, want %q", finish, got, tt.ollama)
+		}
+		if got := completeResponse(ResponseObject{}, nil, nil, finish).Status; got != tt.responses {
+			t.Errorf("completeResponse(%q).Status = %q, want %q", finish, got, tt.responses)
+		}
+	}
+}
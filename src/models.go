@@ -0,0 +1,158 @@
+/**
+ * models.go
+ *
+ * モデル一覧（GET /v1/models・GET /v1/models/{id}）
+ * モデル一覧を最初に取得する SDK のために、Bifrost の /v1/models をそのまま中継し、各モデルに TCGW の設定（tcgw フィールド）を加える。
+ *   - tool_calling: ツール呼び出しの扱い（"emulated"、ROUTING_MODE=auto でネイティブに振り分けるモデルは "native"）
+ *   - native_endpoints: "native" のモデルをネイティブに振り分けるエンドポイント（Chat Completions のみ。他のAPIではエミュレートする）
+ *   - tool_prompt / prompt_profile / parsers: ツール定義プロンプトの形式・プロファイル・抽出に使うパーサー（試す順）
+ *   - response_format: response_format の扱い（"passthrough" / "emulate"）
+ * MODEL_CONFIG_FILE の aliases で定義した別名も、別名先のモデルの設定（alias_of）とともに一覧に加える。
+ */
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// modelMetadata はモデルに適用される TCGW の設定（モデル一覧の tcgw フィールド）
+type modelMetadata struct {
+	ToolCalling     string   `json:"tool_calling"`               // "emulated" / "native"
+	NativeEndpoints []string `json:"native_endpoints,omitempty"` // native の場合にネイティブに振り分けるエンドポイント（それ以外のAPIではエミュレート）
+	ToolPrompt      string   `json:"tool_prompt"`
+	PromptProfile   *string  `json:"prompt_profile"` // nil は tool_prompt の形式のプロンプト
+	Parsers         []string `json:"parsers"`
+	ResponseFormat  string   `json:"response_format"`
+	AliasOf         string   `json:"alias_of,omitempty"` // 別名の場合の Bifrost のモデル名
+}
+
+// newModelMetadata はモデル名（別名の場合は別名先）に適用される設定をまとめる
+func newModelMetadata(model string) modelMetadata {
+	modelConfig := modelSettings.Resolve(model)
+	toolPrompt := modelConfig.ToolPrompt
+	if toolPrompt == "" {
+		toolPrompt = defaultToolPromptName
+	}
+	var profile *string
+	switch {
+	case modelConfig.PromptProfile != "":
+		profile = stringPtr(modelConfig.PromptProfile)
+	case toolPrompt == defaultToolPromptName:
+		profile = stringPtr(defaultPromptProfileName)
+	}
+	parsers := []string{}
+	for _, p := range toolCallParsersFor(modelConfig, toolChoice{Mode: toolChoiceAuto}) {
+		parsers = append(parsers, p.Name())
+	}
+	toolCalling := resolveToolCalling(modelConfig)
+	var nativeEndpoints []string
+	if toolCalling == toolCallingNative {
+		nativeEndpoints = nativeRoutedEndpoints
+	}
+	return modelMetadata{
+		ToolCalling:     toolCalling,
+		NativeEndpoints: nativeEndpoints,
+		ToolPrompt:      toolPrompt,
+		PromptProfile:   profile,
+		Parsers:         parsers,
+		ResponseFormat:  resolveResponseFormatMode(modelConfig),
+	}
+}
+
+// fetchModels は Bifrost のモデル一覧に別名を加え、各モデルに tcgw フィールドを付けて返す
+// エラーの場合はクライアントに返すステータスコードと本文
+func fetchModels() ([]map[string]any, *emulationError) {
+	backendResp, ferr := getFromBifrost("/v1/models")
+	if ferr != nil {
+		code := 500
+		if s, err := strconv.Atoi(ferr.Error()); err == nil {
+			code = s
+		}
+		logDebug("Bifrost Models Error", backendResp)
+		return nil, &emulationError{status: code, body: backendResp}
+	}
+
+	var models []map[string]any
+	byID := map[string]map[string]any{}
+	data, _ := backendResp["data"].([]any)
+	for _, item := range data {
+		entry, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		id, _ := entry["id"].(string)
+		if id == "" {
+			continue
+		}
+		entry["tcgw"] = newModelMetadata(id)
+		models = append(models, entry)
+		byID[id] = entry
+	}
+
+	if modelSettings != nil {
+		aliases := make([]string, 0, len(modelSettings.Aliases))
+		for alias := range modelSettings.Aliases {
+			aliases = append(aliases, alias)
+		}
+		sort.Strings(aliases)
+		for _, alias := range aliases {
+			target := modelSettings.Aliases[alias]
+			metadata := newModelMetadata(target)
+			metadata.AliasOf = target
+			entry := map[string]any{"id": alias, "object": "model", "created": 0, "owned_by": "tcgw", "tcgw": metadata}
+			if base, ok := byID[target]; ok {
+				for _, key := range []string{"created", "owned_by"} {
+					if value, ok := base[key]; ok {
+						entry[key] = value
+					}
+				}
+			}
+			models = append(models, entry)
+		}
+	}
+	return models, nil
+}
+
+// モデル一覧: Bifrost のモデルと別名を、OpenAI の形式（object: "list"）で返す
+func handleListModels(c *gin.Context) {
+	models, apiErr := fetchModels()
+	if apiErr != nil {
+		c.JSON(apiErr.status, apiErr.body)
+		return
+	}
+	if models == nil {
+		models = []map[string]any{}
+	}
+	c.JSON(200, gin.H{"object": "list", "data": models})
+}
+
+// モデルの取得: モデル名に "/" を含む場合（"openai/gpt-4o-mini" など）もあるため、パスの残り全体をモデル名とする
+func handleGetModel(c *gin.Context) {
+	id := strings.TrimPrefix(c.Param("id"), "/")
+	if id == "" {
+		handleListModels(c)
+		return
+	}
+	models, apiErr := fetchModels()
+	if apiErr != nil {
+		c.JSON(apiErr.status, apiErr.body)
+		return
+	}
+	for _, model := range models {
+		if model["id"] == id {
+			c.JSON(200, model)
+			return
+		}
+	}
+	c.JSON(404, ErrorResponse{Error: ErrorDetail{
+		Message: fmt.Sprintf("The model '%s' does not exist", id),
+		Type:    "invalid_request_error",
+		Param:   stringPtr("model"),
+		Code:    stringPtr("model_not_found"),
+	}})
+}
@@ -0,0 +1,446 @@
+/**
+ * ollama.go
+ *
+ * Ollama API（POST /api/chat・GET /api/tags）の受け付け
+ * Continue や Open WebUI などのローカル開発ツールは Ollama のプロトコルで話す。
+ * /api/chat のリクエストを ChatCompletionRequest に変換し、Chat Completions と同じエミュレートの処理に載せて、結果を Ollama の形式で返す。
+ *   - messages の images（base64）→ image_url、assistant の tool_calls（arguments はオブジェクト）→ tool_calls（JSON 文字列）
+ *   - role: "tool" のメッセージ → tool_call_id を持つ tool メッセージ（Ollama には呼び出しのIDがないため、tool_name か順番で直前の呼び出しに対応づける）
+ *   - format: "json" / JSON Schema → response_format、options（temperature / top_p / num_predict / stop / seed など）→ 各パラメータ
+ *   - 抽出したツール呼び出しは message.tool_calls（arguments はオブジェクト）として返す
+ * ストリーミング（stream を省略した場合も）では、1行1つの JSON（NDJSON）を送り、最後の行を done: true にする。
+ * /api/tags では、Bifrost の /v1/models のモデルと MODEL_CONFIG_FILE の別名（/v1/models と同じ一覧）を Ollama のモデル一覧の形式で返す。
+ */
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- 型定義 (Ollama API) ---
+
+// OllamaChatRequest は /api/chat のリクエスト
+type OllamaChatRequest struct {
+	Model     string          `json:"model"`
+	Messages  []OllamaMessage `json:"messages"`
+	Tools     []Tool          `json:"tools,omitempty"`   // Chat Completions と同じ形式
+	Format    any             `json:"format,omitempty"`  // "json" or JSON Schema
+	Options   *OllamaOptions  `json:"options,omitempty"` // 生成の設定
+	Stream    *bool           `json:"stream,omitempty"`  // デフォルトtrue
+	Think     any             `json:"think,omitempty"`   // 推論の有無（エミュレートでは推論テキストを分離するのみ）
+	KeepAlive any             `json:"keep_alive,omitempty"`
+}
+
+// OllamaMessage は /api/chat のメッセージ
+type OllamaMessage struct {
+	Role      string           `json:"role"` // "system", "user", "assistant", "tool"
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`   // 推論（思考）のテキスト
+	Images    []string         `json:"images,omitempty"`     // base64 の画像
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"` // assistantメッセージでのツール呼び出し
+	ToolName  string           `json:"tool_name,omitempty"`  // toolメッセージの実行したツールの名前
+}
+
+// OllamaToolCall はツール呼び出し（IDはなく、arguments はオブジェクト）
+type OllamaToolCall struct {
+	Function OllamaToolCallFunction `json:"function"`
+}
+
+// OllamaToolCallFunction は呼び出す関数と引数
+type OllamaToolCallFunction struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
+}
+
+// OllamaOptions は生成の設定（対応するもののみ。それ以外は無視する）
+type OllamaOptions struct {
+	Temperature      *float32 `json:"temperature,omitempty"`
+	TopP             *float32 `json:"top_p,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"` // 生成するトークン数の上限（-1 は無制限）
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
+}
+
+// OllamaChatResponse は /api/chat のレスポンス（ストリーミングでは1行分）
+type OllamaChatResponse struct {
+	Model           string        `json:"model"`
+	CreatedAt       string        `json:"created_at"`
+	Message         OllamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason,omitempty"` // "stop", "length"
+	TotalDuration   int64         `json:"total_duration,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+	EvalCount       int           `json:"eval_count,omitempty"`
+}
+
+// OllamaModel は /api/tags のモデル
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+// OllamaModelDetails はモデルの詳細（Bifrost 経由のモデルでは owned_by を family に入れるのみ）
+type OllamaModelDetails struct {
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// --- リクエストの変換 ---
+
+// stream は stream の指定（省略した場合は true）
+func (r *OllamaChatRequest) stream() bool {
+	return r.Stream == nil || *r.Stream
+}
+
+// toChatCompletionRequest は /api/chat のリクエストを ChatCompletionRequest に変換する
+func (r *OllamaChatRequest) toChatCompletionRequest() (*ChatCompletionRequest, error) {
+	req := &ChatCompletionRequest{Model: r.Model, Stream: r.stream(), Tools: r.Tools}
+	if o := r.Options; o != nil {
+		req.Temperature = o.Temperature
+		req.TopP = o.TopP
+		req.Seed = o.Seed
+		req.PresencePenalty = o.PresencePenalty
+		req.FrequencyPenalty = o.FrequencyPenalty
+		if o.NumPredict != nil && *o.NumPredict > 0 {
+			req.MaxTokens = o.NumPredict
+		}
+		if len(o.Stop) > 0 {
+			req.Stop = o.Stop
+		}
+	}
+	if req.Stream {
+		// 最後の行で eval_count などを返すため、バックエンドにも usage を求める
+		req.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+	switch f := r.Format.(type) {
+	case nil:
+	case string:
+		switch f {
+		case "":
+		case "json":
+			req.ResponseFormat = map[string]any{"type": "json_object"}
+		default:
+			return nil, fmt.Errorf("format: unsupported value %q", f)
+		}
+	case map[string]any:
+		req.ResponseFormat = map[string]any{"type": "json_schema", "json_schema": map[string]any{"name": "response", "schema": f}}
+	default:
+		return nil, fmt.Errorf("format must be \"json\" or a JSON Schema object")
+	}
+
+	// Ollama のツール呼び出しにはIDがないため、振ったIDを tool メッセージに順に対応づける
+	type pendingCall struct{ name, id string }
+	var pending []pendingCall
+	callCount := 0
+	for i, msg := range r.Messages {
+		switch msg.Role {
+		case "system", "user":
+			req.Messages = append(req.Messages, Message{Role: msg.Role, Content: ollamaContent(msg)})
+		case "assistant":
+			out := Message{Role: "assistant"}
+			if msg.Content != "" {
+				out.Content = msg.Content
+			}
+			pending = nil
+			for _, tc := range msg.ToolCalls {
+				callCount++
+				id := fmt.Sprintf("call_%s_%d", tc.Function.Name, callCount)
+				pending = append(pending, pendingCall{name: tc.Function.Name, id: id})
+				args := tc.Function.Arguments
+				if args == nil {
+					args = map[string]any{}
+				}
+				arguments, _ := json.Marshal(args)
+				out.ToolCalls = append(out.ToolCalls, ToolCall{ID: id, Type: "function", Function: ToolCallFunction{Name: tc.Function.Name, Arguments: string(arguments)}})
+			}
+			req.Messages = append(req.Messages, out)
+		case "tool":
+			// tool_name が同じ呼び出し（tool_name がなければ最初の呼び出し）に対応づける
+			out := Message{Role: "tool", Name: msg.ToolName, Content: msg.Content}
+			for j, call := range pending {
+				if msg.ToolName == "" || call.name == msg.ToolName {
+					out.ToolCallID = call.id
+					if out.Name == "" {
+						out.Name = call.name
+					}
+					pending = append(pending[:j], pending[j+1:]...)
+					break
+				}
+			}
+			req.Messages = append(req.Messages, out)
+		default:
+			return nil, fmt.Errorf("messages[%d].role: unsupported role %q", i, msg.Role)
+		}
+	}
+	return req, nil
+}
+
+// ollamaContent は images を含むメッセージを ContentPart の配列にする（画像がなければ文字列のまま）
+func ollamaContent(msg OllamaMessage) any {
+	if len(msg.Images) == 0 {
+		return msg.Content
+	}
+	var parts []ContentPart
+	if msg.Content != "" {
+		parts = append(parts, ContentPart{Type: "text", Text: msg.Content})
+	}
+	for _, image := range msg.Images {
+		parts = append(parts, ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: ollamaImageURL(image)}})
+	}
+	return parts
+}
+
+// ollamaImageURL は base64 の画像を data URL にする（MIMEタイプは中身から判定する）
+func ollamaImageURL(data string) string {
+	mimeType := "image/png"
+	if raw, err := base64.StdEncoding.DecodeString(data); err == nil {
+		if detected := http.DetectContentType(raw); strings.HasPrefix(detected, "image/") {
+			mimeType = detected
+		}
+	}
+	return "data:" + mimeType + ";base64," + data
+}
+
+// --- レスポンスの変換 ---
+
+// ollamaToolCalls はツール呼び出しを Ollama の形式にする（引数の JSON はオブジェクトとして返す）
+func ollamaToolCalls(toolCalls []ToolCall) []OllamaToolCall {
+	calls := make([]OllamaToolCall, len(toolCalls))
+	for i, tc := range toolCalls {
+		args := map[string]any{}
+		if strings.TrimSpace(tc.Function.Arguments) != "" {
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil || args == nil {
+				args = map[string]any{}
+			}
+		}
+		calls[i] = OllamaToolCall{Function: OllamaToolCallFunction{Name: tc.Function.Name, Arguments: args}}
+	}
+	return calls
+}
+
+// ollamaDoneReason は finish_reason を done_reason に変換する（ツール呼び出しで止まった場合も "stop"）
+// Ollama にはコンテンツフィルターを表す値がないため、"content_filter" はそのまま返す
+func ollamaDoneReason(finish string) string {
+	if finish == "length" || finish == "content_filter" {
+		return finish
+	}
+	return "stop"
+}
+
+// ollamaCreatedAt は created_at の時刻
+func ollamaCreatedAt() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// ollamaErrorBody は Ollama の形式のエラーの本文
+func ollamaErrorBody(message string) gin.H {
+	return gin.H{"error": message}
+}
+
+// writeOllamaError はエミュレートの処理のエラーを Ollama の形式で返す
+func writeOllamaError(c *gin.Context, apiErr *emulationError) {
+	c.JSON(apiErr.status, ollamaErrorBody(apiErr.detail().Message))
+}
+
+// --- ストリーミング ---
+
+// ollamaStreamWriter はクライアントへ /api/chat の応答を1行1つの JSON（NDJSON）で書き出す
+type ollamaStreamWriter struct {
+	c     *gin.Context
+	model string
+	start time.Time // total_duration の起点
+}
+
+// NDJSONのヘッダーを送出し、ストリーミング用ライターを作成
+func newOllamaStreamWriter(c *gin.Context, model string, start time.Time) *ollamaStreamWriter {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // リバースプロキシでのバッファリングを無効化
+	c.Status(http.StatusOK)
+	return &ollamaStreamWriter{c: c, model: model, start: start}
+}
+
+// writeLine は1行を書き出してフラッシュ
+func (w *ollamaStreamWriter) writeLine(data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w.c.Writer, "%s\n", b); err != nil {
+		return err
+	}
+	w.c.Writer.Flush()
+	return nil
+}
+
+// writeMessage はメッセージの差分を done: false の行として書き出す
+func (w *ollamaStreamWriter) writeMessage(msg OllamaMessage) error {
+	msg.Role = "assistant"
+	return w.writeLine(OllamaChatResponse{Model: w.model, CreatedAt: ollamaCreatedAt(), Message: msg})
+}
+
+// Ollama のストリームには role だけの行がないため何もしない
+func (w *ollamaStreamWriter) writeRole() error {
+	return nil
+}
+
+// テキストの差分を message.content として書き出す
+func (w *ollamaStreamWriter) writeContent(text string) error {
+	if text == "" {
+		return nil
+	}
+	return w.writeMessage(OllamaMessage{Content: text})
+}
+
+// 推論テキストの差分を message.thinking として書き出す
+func (w *ollamaStreamWriter) writeReasoning(text string) error {
+	if text == "" {
+		return nil
+	}
+	return w.writeMessage(OllamaMessage{Thinking: text})
+}
+
+// ツール呼び出しを message.tool_calls として1行で書き出す（Ollama では引数を分割しない）
+func (w *ollamaStreamWriter) writeToolCalls(toolCalls []ToolCall) error {
+	return w.writeMessage(OllamaMessage{ToolCalls: ollamaToolCalls(toolCalls)})
+}
+
+// done_reason とトークン数を done: true の最後の行として書き出す
+func (w *ollamaStreamWriter) writeFinish(reason string, usage *Usage) error {
+	resp := OllamaChatResponse{
+		Model:         w.model,
+		CreatedAt:     ollamaCreatedAt(),
+		Message:       OllamaMessage{Role: "assistant"},
+		Done:          true,
+		DoneReason:    ollamaDoneReason(reason),
+		TotalDuration: time.Since(w.start).Nanoseconds(),
+	}
+	if usage != nil {
+		resp.PromptEvalCount = usage.PromptTokens
+		resp.EvalCount = usage.CompletionTokens
+	}
+	return w.writeLine(resp)
+}
+
+// エラーを1行で書き出す（ストリーム開始後にエラーが発生した場合）
+func (w *ollamaStreamWriter) writeError(status int, body map[string]any) error {
+	apiErr := &emulationError{status: status, body: body}
+	return w.writeLine(ollamaErrorBody(apiErr.detail().Message))
+}
+
+// NDJSON には終端の行がないため何もしない（最後の行は done: true）
+func (w *ollamaStreamWriter) writeDone() error {
+	return nil
+}
+
+// --- Ginハンドラー ---
+
+// Ollama API: /api/chat のリクエストを変換してエミュレートし、Ollama の形式で返す
+func handleOllamaChat(c *gin.Context) {
+	start := time.Now()
+	var oreq OllamaChatRequest
+	if err := c.ShouldBindJSON(&oreq); err != nil {
+		c.JSON(400, ollamaErrorBody(fmt.Sprintf("Invalid JSON: %v", err)))
+		return
+	}
+	if oreq.Model == "" {
+		c.JSON(400, ollamaErrorBody("model is required"))
+		return
+	}
+	req, err := oreq.toChatCompletionRequest()
+	if err != nil {
+		c.JSON(400, ollamaErrorBody(err.Error()))
+		return
+	}
+	logDebug("Request Received (Ollama API)", map[string]any{
+		"Model":         req.Model,
+		"Tool Count":    len(req.Tools),
+		"Message Count": len(req.Messages),
+		"Has Stream":    req.Stream,
+	})
+
+	e, apiErr := prepareEmulation(c, req, false)
+	if apiErr != nil {
+		writeOllamaError(c, apiErr)
+		return
+	}
+	if req.Stream {
+		handleChatCompletionsEmulateStream(c, e, streamFrontEnd{
+			newWriter: func() emulationStreamWriter { return newOllamaStreamWriter(c, req.Model, start) },
+			fail: func(status int, body any) {
+				writeOllamaError(c, &emulationError{status: status, body: body})
+			},
+		})
+		return
+	}
+	result, apiErr := runEmulation(e, c.Writer.Header())
+	if apiErr != nil {
+		writeOllamaError(c, apiErr)
+		return
+	}
+	usage := result.usage()
+	resp := OllamaChatResponse{
+		Model:     req.Model,
+		CreatedAt: ollamaCreatedAt(),
+		Message: OllamaMessage{
+			Role:     "assistant",
+			Content:  result.content,
+			Thinking: result.reasoning,
+		},
+		Done:            true,
+		DoneReason:      ollamaDoneReason(result.finishReason),
+		TotalDuration:   time.Since(start).Nanoseconds(),
+		PromptEvalCount: usage.PromptTokens,
+		EvalCount:       usage.CompletionTokens,
+	}
+	if len(result.toolCalls) > 0 {
+		resp.Message.ToolCalls = ollamaToolCalls(result.toolCalls)
+	}
+	logDebug("Response Generated (Ollama API)", map[string]any{
+		"Done Reason":      resp.DoneReason,
+		"Tool Calls Count": len(result.toolCalls),
+	})
+	c.JSON(200, resp)
+}
+
+// Ollama API: モデル一覧（Bifrost の /v1/models のモデルと別名。fetchModels）を /api/tags の形式で返す
+func handleOllamaTags(c *gin.Context) {
+	entries, apiErr := fetchModels()
+	if apiErr != nil {
+		writeOllamaError(c, apiErr)
+		return
+	}
+	models := []OllamaModel{}
+	for _, entry := range entries {
+		id, _ := entry["id"].(string)
+		family, _ := entry["owned_by"].(string)
+		modifiedAt := time.Now().UTC()
+		if created, ok := entry["created"].(float64); ok && created > 0 {
+			modifiedAt = time.Unix(int64(created), 0).UTC()
+		}
+		models = append(models, OllamaModel{
+			Name:       id,
+			Model:      id,
+			ModifiedAt: modifiedAt.Format(time.RFC3339Nano),
+			Details:    OllamaModelDetails{Family: family},
+		})
+	}
+	c.JSON(200, gin.H{"models": models})
+}
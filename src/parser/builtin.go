@@ -0,0 +1,110 @@
+/**
+ * builtin.go
+ *
+ * 組み込みパーサーの登録
+ * llama.cpp式の多段階パース戦略：モデルファミリー別 → 標準形式 → ジェネリック
+ * 優先度は100刻みで、登録順がそのまま従来の検出順になる（間に独自パーサーを差し込めるよう間隔を空けている）。
+ */
+package parser
+
+import "strings"
+
+// builtinParser は抽出関数と Detect 用のマーカーで構成される組み込みパーサー
+type builtinParser struct {
+	name    string
+	markers []string // いずれかを含むテキストのみ Parse を試す
+	parse   func(text string) ([]ToolCall, []Span)
+}
+
+func (p *builtinParser) Name() string { return p.name }
+
+func (p *builtinParser) Detect(text string) bool {
+	for _, marker := range p.markers {
+		if strings.Contains(text, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *builtinParser) Parse(text string) ([]ToolCall, []Span) {
+	calls, spans := p.parse(text)
+	if len(calls) > 0 {
+		logDebug("Tool Call Extraction", map[string]any{"Format": p.name})
+	}
+	return calls, spans
+}
+
+// builtinParsers は組み込みパーサーを従来の検出順に並べたもの
+var builtinParsers = []*builtinParser{
+	// Phase 1: モデルファミリー別パーサー（特定モデルの独自形式）
+	// llama.cppのcommon_chat_templates_apply_jinjaの検出順序に基づく
+
+	// DeepSeek V3.1
+	{name: "deepseek-v3.1", markers: []string{"<｜tool▁calls▁begin｜>", "<tool calls begin>", "<toolcalls>"}, parse: extractDeepSeekV31ToolCalls},
+	// DeepSeek R1
+	{name: "deepseek-r1", markers: []string{"<｜tool▁calls▁begin｜>", "<tool calls begin>", "<toolcalls>"}, parse: extractDeepSeekR1ToolCalls},
+	// Command R7B
+	{name: "command-r7b", markers: []string{"<|START_ACTION|>"}, parse: extractCommandR7BToolCalls},
+	// Granite (IBM)
+	{name: "granite", markers: []string{"<tool_call>"}, parse: extractGraniteToolCalls},
+	// GLM 4.5（Hermes 2 Proより先にチェック - 両方とも<tool_call>を使用）
+	{name: "glm-4.5", markers: []string{"<tool_call>"}, parse: extractGLM45ToolCalls},
+	// Qwen3-Coder XML（Hermes 2 Proより先にチェック）
+	{name: "qwen3-coder", markers: []string{"<tool_call>"}, parse: extractQwen3CoderXMLToolCalls},
+	// Xiaomi MiMo（Hermes 2 Proより先にチェック）
+	{name: "xiaomi-mimo", markers: []string{"<tool_call>"}, parse: extractXiaomiMiMoToolCalls},
+	// Hermes 2 Pro, Qwen 2.5 Instruct（開始タグは省略可能なため、JSONの有無で判定）
+	{name: "hermes-2-pro", markers: []string{"{"}, parse: extractHermes2ProToolCalls},
+	// GPT-OSS
+	{name: "gpt-oss", markers: []string{"<|channel|>"}, parse: extractGPTOSSToolCalls},
+	// Seed-OSS
+	{name: "seed-oss", markers: []string{"<seed:tool_call>"}, parse: extractSeedOSSToolCalls},
+	// Nemotron v2
+	{name: "nemotron-v2", markers: []string{"<TOOLCALL>"}, parse: extractNemotronV2ToolCalls},
+	// Apertus
+	{name: "apertus", markers: []string{"<|tools_prefix|>"}, parse: extractApertusToolCalls},
+	// LFM2
+	{name: "lfm2", markers: []string{"<|tool_call_start|>"}, parse: extractLFM2ToolCalls},
+	// MiniMax-M2
+	{name: "minimax-m2", markers: []string{"<minimax:tool_call>"}, parse: extractMiniMaxM2ToolCalls},
+	// Kimi K2
+	{name: "kimi-k2", markers: []string{"<|tool_calls_section_begin|>"}, parse: extractKimiK2ToolCalls},
+	// Apriel 1.5
+	{name: "apriel-1.5", markers: []string{"<tool_calls>"}, parse: extractApriel15ToolCalls},
+	// Functionary v3.2
+	{name: "functionary-v3.2", markers: []string{">>>"}, parse: extractFunctionaryV32ToolCalls},
+	// Firefunction v2
+	{name: "firefunction-v2", markers: []string{" functools"}, parse: extractFirefunctionV2ToolCalls},
+	// Functionary v3.1 Llama 3.1
+	{name: "functionary-v3.1-llama-3.1", markers: []string{"<function="}, parse: extractFunctionaryV31Llama31ToolCalls},
+	// Llama 3.x
+	{name: "llama-3.x", markers: []string{`"parameters"`}, parse: extractLlama3XToolCalls},
+	// Magistral
+	{name: "magistral", markers: []string{"[TOOLCALLS]"}, parse: extractMagistralToolCalls},
+	// Mistral Nemo
+	{name: "mistral-nemo", markers: []string{"[TOOL_CALLS]"}, parse: extractMistralNemoToolCalls},
+
+	// Phase 2: 標準形式パーサー（既存のTCGW形式）
+
+	// XML形式の検出
+	{name: "xml", markers: []string{"<function_calls>"}, parse: extractXMLToolCalls},
+	// JSON形式の検出
+	{name: "json", markers: []string{`"tool_calls"`}, parse: extractJSONToolCalls},
+	// Markdown JSON形式の検出
+	{name: "markdown-json", markers: []string{"`"}, parse: extractMarkdownToolCalls},
+
+	// Phase 3: ジェネリックパーサー（最後の砦）
+
+	// 汎用JSON形式の検出
+	{name: "generic", markers: []string{"{"}, parse: extractGenericToolCalls},
+}
+
+// BuiltinPriorityStep は組み込みパーサーの優先度の間隔
+const BuiltinPriorityStep = 100
+
+func init() {
+	for i, p := range builtinParsers {
+		Register(p, (i+1)*BuiltinPriorityStep)
+	}
+}
@@ -0,0 +1,2406 @@
+/**
+ * families.go
+ *
+ * モデルファミリー別パーサー
+ * DeepSeek・Qwen・GLM・Mistral・GPT-OSS など、各モデルが学習した独自形式のツール呼び出しを抽出する。
+ * 多くは llama.cpp の実装を移植したもの。登録順と検出の手がかり（マーカー）は builtin.go を参照。
+ */
+package parser
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ========================================
+// 正規表現の事前コンパイル（パフォーマンス最適化）
+// すべての正規表現を起動時に一度だけコンパイル
+// ========================================
+
+var (
+	// GPT-OSS
+	regexGPTOSS = regexp.MustCompile(`<\|channel\|>(commentary|analysis)\s+to=(?:functions\.)?([a-zA-Z0-9_]+)(?:\s+<\|constrain\|>[a-zA-Z0-9_-]+)?(?:\s+<\|message\|>)?(.*?)(?:<\|call\|>|$)`)
+
+	// Hermes 2 Pro - 複雑な開始パターン
+	// 先頭のグループは llama.cpp の block_start（```json などのコードフェンス）
+	// 以前の (<|\[)? は "<tool_call>" の "<" だけにマッチして開始タグを取りこぼしていた
+	regexHermes2ProOpen = regexp.MustCompile("(?:(```(?:xml|json)?\n\\s*)?)?" +
+		`(<tool_call>|<functioncall>|<function>|<tool>|<tools>|<response>|<json>|<xml>|<JSON>)?` +
+		`\s*` +
+		`(?:<name>([^<]+)</name>)?` +
+		`(?:<function>([^<(]+))?` +
+		`(?:<function>([^<]+))?`)
+
+	// Functionary v3.2
+	regexFunctionaryV32 = regexp.MustCompile(`>>>(\w+)`)
+
+	// Functionary v3.1 Llama 3.1
+	regexFunctionaryV31Llama31 = regexp.MustCompile(`<function=([^>]+)>`)
+
+	// Qwen3-Coder（チャットテンプレートの形式: <function=名前><parameter=キー>値</parameter></function>）
+	regexQwen3CoderFunction  = regexp.MustCompile(`(?s)^\s*<function=([^>\s]+)>(.*?)</function>\s*$`)
+	regexQwen3CoderParameter = regexp.MustCompile(`(?s)<parameter=([^>\s]+)>(.*?)</parameter>`)
+
+	// Llama 3.x
+	// Llama 3.1 の {"name": ..., "parameters": ...} と、Llama 3.2 などの "type": "function" 付きの両方に対応
+	regexLlama3X = regexp.MustCompile(`\{\s*(?:"type":\s*"function",\s*)?"name":\s*"([^"]+)",\s*"parameters":\s*`)
+
+	// DeepSeek V3.1
+	regexDeepSeekV31Function = regexp.MustCompile(`<｜tool▁call▁begin｜>([^<｜]*)<｜tool▁sep｜>`)
+
+	// DeepSeek R1
+	regexDeepSeekR1Function = regexp.MustCompile(`<｜tool▁call▁begin｜>([^<｜]*)<｜function▁tool▁sep｜>|<｜tool▁call▁begin｜><｜function▁tool▁sep｜>`)
+)
+
+// extractGPTOSSToolCalls は GPT-OSS 独自形式のツール呼び出しを抽出
+// 形式: <|start|>assistant<|channel|>commentary to=functionName <|constrain|>json<|message|>{JSON}<|call|>
+func extractGPTOSSToolCalls(text string) ([]ToolCall, []Span) {
+	// GPT-OSS形式の正規表現パターン
+	// <|channel|>commentary to=functionName または <|channel|>analysis to=functionName
+	// ドット区切りの関数名に対応（例: functions.calculatePrice）
+
+	matches := regexGPTOSS.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+	var spans []Span
+	for _, match := range matches {
+		if len(match) < 8 || match[6] == -1 {
+			continue
+		}
+
+		channelType := text[match[2]:match[3]] // commentary or analysis
+		functionName := text[match[4]:match[5]]
+		argsText := strings.TrimSpace(text[match[6]:match[7]])
+
+		// <|message|> タグが含まれている場合は除去
+		argsText = strings.TrimPrefix(argsText, "<|message|>")
+		argsText = strings.TrimSpace(argsText)
+
+		// 空のツール名はスキップ
+		if functionName == "" {
+			continue
+		}
+
+		// JSON引数のパース
+		var argsMap map[string]any
+		if argsText != "" {
+			// JSONブロックを抽出（中括弧で囲まれた部分）
+			jsonStart := strings.Index(argsText, "{")
+			jsonEnd := strings.LastIndex(argsText, "}")
+
+			if jsonStart != -1 && jsonEnd != -1 && jsonEnd > jsonStart {
+				jsonStr := argsText[jsonStart : jsonEnd+1]
+				if err := json.Unmarshal([]byte(jsonStr), &argsMap); err == nil {
+					argsBytes, _ := json.Marshal(argsMap)
+
+					toolCalls = append(toolCalls, ToolCall{
+						ID:       generateToolCallID(),
+						Type:     "function",
+						Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+					})
+
+					// 直前の <|start|>assistant もマークアップとして扱う
+					spanStart := match[0]
+					if strings.HasSuffix(text[:spanStart], "<|start|>assistant") {
+						spanStart -= len("<|start|>assistant")
+					}
+					spans = append(spans, Span{Start: spanStart, End: match[1]})
+
+					logDebug("GPT-OSS Tool Call Detected", map[string]any{
+						"Channel":  channelType,
+						"Function": functionName,
+						"Args":     string(argsBytes),
+					})
+				}
+			}
+		}
+	}
+
+	return toolCalls, spans
+}
+
+// extractHermes2ProToolCalls は Hermes 2 Pro 形式のツール呼び出しを抽出
+// llama.cppの実装を忠実に移植
+func extractHermes2ProToolCalls(text string) ([]ToolCall, []Span) {
+	// Hermes 2 Pro形式の複雑な正規表現パターン
+	// llama.cppの open_regex に対応
+	matches := regexHermes2ProOpen.FindAllStringSubmatchIndex(text, -1)
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+	var spans []Span
+
+	for _, match := range matches {
+		// match[0], match[1]: 全体マッチ
+		// match[2], match[3]: group 1 (block_start)
+		// match[4], match[5]: group 2 (open_tag)
+		// match[6], match[7]: group 3 (name in <name>...</name>)
+		// match[8], match[9]: group 4 (function name)
+		// match[10], match[11]: group 5 (function name alternative)
+
+		if len(match) < 12 {
+			continue
+		}
+
+		var functionName string
+		var openTag string
+		var closeTag string
+		var jsonStart int
+
+		// open_tag の取得
+		if match[4] != -1 && match[5] != -1 {
+			openTag = text[match[4]:match[5]]
+			// close_tag を構築（例: <tool_call> → </tool_call>）
+			if len(openTag) > 1 {
+				closeTag = "</" + openTag[1:] // openTag は ">" で終わるため、そのまま閉じタグになる
+			}
+		}
+
+		// パターン1: <name>functionName</name> 形式
+		if match[6] != -1 && match[7] != -1 {
+			functionName = strings.TrimSpace(text[match[6]:match[7]])
+			jsonStart = match[7]
+		} else if match[8] != -1 && match[9] != -1 {
+			// パターン2: <function>functionName 形式
+			functionName = strings.TrimSpace(text[match[8]:match[9]])
+			jsonStart = match[9]
+		} else if match[10] != -1 && match[11] != -1 {
+			// パターン3: 代替の function name 形式
+			functionName = strings.TrimSpace(text[match[10]:match[11]])
+			jsonStart = match[11]
+		} else {
+			// 関数名が見つからない場合、JSONオブジェクトから抽出を試みる
+			jsonStart = match[1] // 全体マッチの終了位置から開始
+		}
+
+		// 関数名が空の場合はスキップ
+		if functionName == "" && openTag == "" {
+			continue
+		}
+
+		// JSON引数の抽出
+		remainingText := text[jsonStart:]
+
+		// closeTagがある場合はそこまでを抽出
+		var jsonText string
+		spanEnd := -1 // マークアップの終了位置（closeTagがない場合はJSONの終わり）
+		if closeTag != "" {
+			closeIdx := strings.Index(remainingText, closeTag)
+			if closeIdx != -1 {
+				jsonText = remainingText[:closeIdx]
+				spanEnd = jsonStart + closeIdx + len(closeTag)
+			} else {
+				jsonText = remainingText
+			}
+		} else {
+			jsonText = remainingText
+		}
+
+		// JSONブロックを抽出（位置を保つため前後の空白はトリムせずに探す）
+		jsonStartIdx := strings.Index(jsonText, "{")
+		jsonEndIdx := strings.LastIndex(jsonText, "}")
+
+		if jsonStartIdx == -1 || jsonEndIdx == -1 || jsonEndIdx <= jsonStartIdx {
+			continue
+		}
+
+		jsonStr := jsonText[jsonStartIdx : jsonEndIdx+1]
+
+		// JSONパース
+		var toolCallData map[string]any
+		if err := json.Unmarshal([]byte(jsonStr), &toolCallData); err != nil {
+			continue
+		}
+
+		// 関数名がまだ取得できていない場合、JSONから取得
+		if functionName == "" {
+			if name, ok := toolCallData["name"].(string); ok {
+				functionName = name
+			} else if fn, ok := toolCallData["function"].(string); ok {
+				functionName = fn
+			}
+		}
+
+		// 関数名が依然として空の場合はスキップ
+		if functionName == "" {
+			continue
+		}
+
+		// 引数の取得
+		var argsBytes []byte
+		if args, exists := toolCallData["arguments"]; exists {
+			if argsMap, ok := args.(map[string]any); ok {
+				argsBytes, _ = json.Marshal(argsMap)
+			} else if argsStr, ok := args.(string); ok {
+				argsBytes = []byte(argsStr)
+			} else {
+				argsBytes, _ = json.Marshal(args)
+			}
+		} else {
+			// JSONオブジェクト全体を引数として使用（nameやfunctionキーを除外）
+			filteredArgs := make(map[string]any)
+			for k, v := range toolCallData {
+				if k != "name" && k != "function" {
+					filteredArgs[k] = v
+				}
+			}
+			if len(filteredArgs) > 0 {
+				argsBytes, _ = json.Marshal(filteredArgs)
+			} else {
+				argsBytes = []byte("{}")
+			}
+		}
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+		if spanEnd == -1 {
+			spanEnd = jsonStart + jsonEndIdx + 1
+		}
+		spans = append(spans, Span{Start: match[0], End: spanEnd})
+
+		logDebug("Hermes 2 Pro Tool Call Detected", map[string]any{
+			"OpenTag":  openTag,
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+	}
+
+	return toolCalls, spans
+}
+
+// extractFunctionaryV32ToolCalls は Functionary v3.2 形式のツール呼び出しを抽出
+// llama.cppの実装を忠実に移植
+// 形式: >>>functionName\n{"arg1": "value1"}<<< または >>>python\ncode<<<
+func extractFunctionaryV32ToolCalls(text string) ([]ToolCall, []Span) {
+	// Functionary v3.2形式の正規表現パターン
+	// >>> で開始（3つの>）、<<< で終了（3つの<）
+	closePattern := `<<<`
+	matches := regexFunctionaryV32.FindAllStringSubmatchIndex(text, -1)
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+	var spans []Span
+
+	for _, match := range matches {
+		// match[0], match[1]: 全体マッチ（>>>functionName）
+		// match[2], match[3]: group 1 (functionName)
+
+		if len(match) < 4 {
+			continue
+		}
+
+		atStart := match[0] == 0
+		functionName := strings.TrimSpace(text[match[2]:match[3]])
+
+		// 関数名の末尾に '(' がある場合は削除
+		if len(functionName) > 0 && functionName[len(functionName)-1] == '(' {
+			functionName = strings.TrimRight(functionName, "(")
+		}
+
+		// 開始位置で "all" または "python" の場合はスキップ
+		if atStart && (functionName == "all" || functionName == "python") {
+			continue
+		}
+
+		// 空の関数名はスキップ
+		if functionName == "" {
+			continue
+		}
+
+		// 引数部分の抽出（>>> の後から <<< まで）
+		argsStart := match[1] // >>> の終了位置
+		remainingText := text[argsStart:]
+
+		// <<< を探す
+		closeIdx := strings.Index(remainingText, closePattern)
+		span := Span{Start: match[0], End: argsStart + closeIdx + len(closePattern)}
+		if closeIdx == -1 {
+			// 閉じタグが見つからない場合は残り全体
+			closeIdx = len(remainingText)
+			span.End = len(text)
+		}
+
+		argsText := strings.TrimSpace(remainingText[:closeIdx])
+
+		// Pythonコードの特殊処理
+		if functionName == "python" && !strings.HasPrefix(argsText, "{") {
+			// Raw Pythonコード: JSON形式でラップ
+			codeJSON := map[string]any{
+				"code": argsText,
+			}
+			argsBytes, _ := json.Marshal(codeJSON)
+
+			toolCalls = append(toolCalls, ToolCall{
+				ID:       generateToolCallID(),
+				Type:     "function",
+				Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+			})
+			spans = append(spans, span)
+
+			logDebug("Functionary v3.2 Tool Call Detected (Python)", map[string]any{
+				"Function": functionName,
+				"Code":     argsText,
+			})
+			continue
+		}
+
+		// JSON引数のパース
+		jsonStart := strings.Index(argsText, "{")
+		jsonEnd := strings.LastIndex(argsText, "}")
+
+		if jsonStart == -1 || jsonEnd == -1 || jsonEnd <= jsonStart {
+			// JSONが見つからない場合は空オブジェクト
+			toolCalls = append(toolCalls, ToolCall{
+				ID:       generateToolCallID(),
+				Type:     "function",
+				Function: ToolCallFunction{Name: functionName, Arguments: "{}"},
+			})
+			spans = append(spans, span)
+			continue
+		}
+
+		jsonStr := argsText[jsonStart : jsonEnd+1]
+
+		// JSON検証
+		var argsMap map[string]any
+		if err := json.Unmarshal([]byte(jsonStr), &argsMap); err != nil {
+			continue
+		}
+
+		argsBytes, _ := json.Marshal(argsMap)
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+		spans = append(spans, span)
+
+		logDebug("Functionary v3.2 Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+	}
+
+	return toolCalls, spans
+}
+
+// extractDeepSeekV31ToolCalls は DeepSeek V3.1 形式のツール呼び出しを抽出
+// 形式: <｜tool▁calls▁begin｜><｜tool▁call▁begin｜>functionName<｜tool▁sep｜>{JSON}<｜tool▁call▁end｜><｜tool▁calls▁end｜>
+func extractDeepSeekV31ToolCalls(text string) ([]ToolCall, []Span) {
+	// DeepSeek V3.1の特殊トークン（全角文字を含む）
+	const (
+		toolCallsBegin = "<｜tool▁calls▁begin｜>"
+		toolCallBegin  = "<｜tool▁call▁begin｜>"
+		toolSep        = "<｜tool▁sep｜>"
+		toolCallEnd    = "<｜tool▁call▁end｜>"
+		toolCallsEnd   = "<｜tool▁calls▁end｜>"
+	)
+
+	// 複数のバリエーションに対応（llama.cppのtoolcalls_beginパターン）
+	toolCallsBeginVariants := []string{
+		"<｜tool▁calls▁begin｜>",
+		"<tool calls begin>",
+		"<toolcalls>",
+	}
+
+	// いずれかのバリエーションが存在するか確認
+	hasBegin := false
+	for _, variant := range toolCallsBeginVariants {
+		if strings.Contains(text, variant) {
+			hasBegin = true
+			break
+		}
+	}
+
+	if !hasBegin {
+		return nil, nil
+	}
+
+	// toolCallsBeginからtoolCallsEndまでの範囲を抽出
+	startIdx := -1
+	for _, variant := range toolCallsBeginVariants {
+		idx := strings.Index(text, variant)
+		if idx != -1 {
+			startIdx = idx
+			break
+		}
+	}
+
+	if startIdx == -1 {
+		return nil, nil
+	}
+
+	endIdx := strings.Index(text[startIdx:], toolCallsEnd)
+	var toolCallsText string
+	section := Span{Start: startIdx, End: len(text)}
+	if endIdx != -1 {
+		section.End = startIdx + endIdx + len(toolCallsEnd)
+	}
+	if endIdx == -1 {
+		// 終了タグが見つからない場合は残り全体
+		toolCallsText = text[startIdx:]
+	} else {
+		toolCallsText = text[startIdx : startIdx+endIdx+len(toolCallsEnd)]
+	}
+
+	var toolCalls []ToolCall
+
+	// 正規表現でツール呼び出しを抽出
+	// パターン: <｜tool▁call▁begin｜>functionName<｜tool▁sep｜>
+	matches := regexDeepSeekV31Function.FindAllStringSubmatchIndex(toolCallsText, -1)
+
+	for _, match := range matches {
+		// match[2], match[3]: 関数名のキャプチャグループ
+		if len(match) < 4 {
+			continue
+		}
+
+		functionName := strings.TrimSpace(toolCallsText[match[2]:match[3]])
+
+		// 空の関数名はスキップ
+		if functionName == "" {
+			continue
+		}
+
+		// JSON引数の抽出（<｜tool▁sep｜>から<｜tool▁call▁end｜>まで）
+		jsonStart := match[1] // <｜tool▁sep｜>の直後
+		remainingText := toolCallsText[jsonStart:]
+
+		jsonEnd := strings.Index(remainingText, toolCallEnd)
+		if jsonEnd == -1 {
+			continue
+		}
+
+		jsonText := strings.TrimSpace(remainingText[:jsonEnd])
+
+		// JSON引数のパース
+		var argsMap map[string]any
+		if jsonText != "" {
+			if err := json.Unmarshal([]byte(jsonText), &argsMap); err != nil {
+				continue
+			}
+		} else {
+			argsMap = make(map[string]any)
+		}
+
+		argsBytes, _ := json.Marshal(argsMap)
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+
+		logDebug("DeepSeek V3.1 Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+	}
+
+	if len(toolCalls) == 0 {
+		return nil, nil
+	}
+	return toolCalls, []Span{section}
+}
+
+// extractDeepSeekR1ToolCalls は DeepSeek R1 形式のツール呼び出しを抽出
+// 形式: <｜tool▁calls▁begin｜><｜tool▁call▁begin｜>functionName<｜function▁tool▁sep｜>{JSON}<｜tool▁call▁end｜><｜tool▁calls▁end｜>
+func extractDeepSeekR1ToolCalls(text string) ([]ToolCall, []Span) {
+	// DeepSeek R1の特殊トークン
+	const (
+		toolCallsBegin  = "<｜tool▁calls▁begin｜>"
+		toolCallBegin   = "<｜tool▁call▁begin｜>"
+		functionToolSep = "<｜function▁tool▁sep｜>"
+		toolCallEnd     = "<｜tool▁call▁end｜>"
+		toolCallsEnd    = "<｜tool▁calls▁end｜>"
+	)
+
+	// 複数のバリエーションに対応
+	toolCallsBeginVariants := []string{
+		"<｜tool▁calls▁begin｜>",
+		"<tool calls begin>",
+		"<｜tool▁calls▁begin｜>",
+		"<toolcalls>",
+	}
+
+	// いずれかのバリエーションが存在するか確認
+	hasBegin := false
+	for _, variant := range toolCallsBeginVariants {
+		if strings.Contains(text, variant) {
+			hasBegin = true
+			break
+		}
+	}
+
+	if !hasBegin {
+		return nil, nil
+	}
+
+	// toolCallsBeginからtoolCallsEndまでの範囲を抽出
+	startIdx := -1
+	for _, variant := range toolCallsBeginVariants {
+		idx := strings.Index(text, variant)
+		if idx != -1 {
+			startIdx = idx
+			break
+		}
+	}
+
+	if startIdx == -1 {
+		return nil, nil
+	}
+
+	endIdx := strings.Index(text[startIdx:], toolCallsEnd)
+	var toolCallsText string
+	section := Span{Start: startIdx, End: len(text)}
+	if endIdx != -1 {
+		section.End = startIdx + endIdx + len(toolCallsEnd)
+	}
+	if endIdx == -1 {
+		toolCallsText = text[startIdx:]
+	} else {
+		toolCallsText = text[startIdx : startIdx+endIdx+len(toolCallsEnd)]
+	}
+
+	var toolCalls []ToolCall
+
+	// 正規表現でツール呼び出しを抽出
+	// パターン1: <｜tool▁call▁begin｜>functionName<｜function▁tool▁sep｜>
+	// パターン2: <｜tool▁call▁begin｜><｜function▁tool▁sep｜> (関数名なし)
+	matches := regexDeepSeekR1Function.FindAllStringSubmatchIndex(toolCallsText, -1)
+
+	for _, match := range matches {
+		// match[0], match[1]: 全体マッチ
+		// match[2], match[3]: 関数名のキャプチャグループ（存在する場合）
+
+		var functionName string
+		if len(match) >= 4 && match[2] != -1 && match[3] != -1 {
+			functionName = strings.TrimSpace(toolCallsText[match[2]:match[3]])
+		}
+
+		// 関数名が空の場合はスキップ（パターン2の場合も）
+		if functionName == "" {
+			continue
+		}
+
+		// JSON引数の抽出（<｜function▁tool▁sep｜>から<｜tool▁call▁end｜>まで）
+		jsonStart := match[1] // マッチ全体の終了位置
+		remainingText := toolCallsText[jsonStart:]
+
+		jsonEnd := strings.Index(remainingText, toolCallEnd)
+		if jsonEnd == -1 {
+			continue
+		}
+
+		jsonText := strings.TrimSpace(remainingText[:jsonEnd])
+
+		// JSON引数のパース
+		var argsMap map[string]any
+		if jsonText != "" {
+			if err := json.Unmarshal([]byte(jsonText), &argsMap); err != nil {
+				continue
+			}
+		} else {
+			argsMap = make(map[string]any)
+		}
+
+		argsBytes, _ := json.Marshal(argsMap)
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+
+		logDebug("DeepSeek R1 Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+	}
+
+	if len(toolCalls) == 0 {
+		return nil, nil
+	}
+	return toolCalls, []Span{section}
+}
+
+// extractCommandR7BToolCalls は Command R7B 形式のツール呼び出しを抽出
+// 形式: <|START_ACTION|>[{"tool_name": "func", "tool_call_id": "id", "parameters": {...}}]<|END_ACTION|>
+func extractCommandR7BToolCalls(text string) ([]ToolCall, []Span) {
+	// Command R7Bの特殊トークン
+	const (
+		startAction = "<|START_ACTION|>"
+		endAction   = "<|END_ACTION|>"
+	)
+
+	// START_ACTIONの検出
+	startIdx := strings.Index(text, startAction)
+	if startIdx == -1 {
+		return nil, nil
+	}
+
+	// END_ACTIONの検出
+	endIdx := strings.Index(text[startIdx:], endAction)
+	var actionText string
+	section := Span{Start: startIdx, End: len(text)}
+	if endIdx != -1 {
+		section.End = startIdx + endIdx + len(endAction)
+	}
+	if endIdx == -1 {
+		actionText = text[startIdx+len(startAction):]
+	} else {
+		actionText = text[startIdx+len(startAction) : startIdx+endIdx]
+	}
+
+	actionText = strings.TrimSpace(actionText)
+
+	// JSON配列のパース
+	var toolCallsData []map[string]any
+	if err := json.Unmarshal([]byte(actionText), &toolCallsData); err != nil {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+
+	for _, tcData := range toolCallsData {
+		// tool_nameの取得
+		functionName, ok := tcData["tool_name"].(string)
+		if !ok || functionName == "" {
+			continue
+		}
+
+		// tool_call_idの取得（オプション）
+		toolCallID := ""
+		if id, ok := tcData["tool_call_id"].(string); ok {
+			toolCallID = id
+		}
+
+		// parametersの取得
+		var argsBytes []byte
+		if params, exists := tcData["parameters"]; exists {
+			if paramsMap, ok := params.(map[string]any); ok {
+				argsBytes, _ = json.Marshal(paramsMap)
+			} else if paramsStr, ok := params.(string); ok {
+				// 文字列の場合はそのまま使用
+				argsBytes = []byte(paramsStr)
+			} else {
+				argsBytes, _ = json.Marshal(params)
+			}
+		} else {
+			argsBytes = []byte("{}")
+		}
+
+		// IDが指定されている場合はそれを使用、なければ生成
+		if toolCallID == "" {
+			toolCallID = generateToolCallID()
+		}
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       toolCallID,
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+
+		logDebug("Command R7B Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"ID":       toolCallID,
+			"Args":     string(argsBytes),
+		})
+	}
+
+	if len(toolCalls) == 0 {
+		return nil, nil
+	}
+	return toolCalls, []Span{section}
+}
+
+// extractGraniteToolCalls は Granite (IBM) 形式のツール呼び出しを抽出
+// 形式: <tool_call>[{"name": "func", "arguments": {...}}]
+func extractGraniteToolCalls(text string) ([]ToolCall, []Span) {
+	// Graniteの特殊トークン
+	const toolCallTag = "<tool_call>"
+
+	// tool_callタグの検出
+	tagIdx := strings.Index(text, toolCallTag)
+	if tagIdx == -1 {
+		return nil, nil
+	}
+
+	// JSON配列の抽出（<tool_call>の後から）
+	rest := text[tagIdx+len(toolCallTag):]
+	jsonText := strings.TrimSpace(rest)
+	leading := len(rest) - len(strings.TrimLeftFunc(rest, unicode.IsSpace)) // トリムした先頭空白の長さ（位置計算用）
+
+	// JSON配列の開始を探す
+	if !strings.HasPrefix(jsonText, "[") {
+		return nil, nil
+	}
+
+	// JSON配列の終了を探す
+	jsonEnd := strings.LastIndex(jsonText, "]")
+	if jsonEnd == -1 {
+		return nil, nil
+	}
+
+	jsonText = jsonText[:jsonEnd+1]
+	section := Span{Start: tagIdx, End: tagIdx + len(toolCallTag) + leading + jsonEnd + 1}
+
+	// JSON配列のパース
+	var toolCallsData []map[string]any
+	if err := json.Unmarshal([]byte(jsonText), &toolCallsData); err != nil {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+
+	for _, tcData := range toolCallsData {
+		// nameの取得
+		functionName, ok := tcData["name"].(string)
+		if !ok || functionName == "" {
+			continue
+		}
+
+		// argumentsの取得
+		var argsBytes []byte
+		if args, exists := tcData["arguments"]; exists {
+			if argsMap, ok := args.(map[string]any); ok {
+				argsBytes, _ = json.Marshal(argsMap)
+			} else if argsStr, ok := args.(string); ok {
+				// 文字列の場合、JSONとしてパース試行
+				var argsMap map[string]any
+				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
+					argsBytes = []byte(argsStr)
+				} else {
+					argsBytes = []byte("{}")
+				}
+			} else {
+				argsBytes, _ = json.Marshal(args)
+			}
+		} else {
+			argsBytes = []byte("{}")
+		}
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+
+		logDebug("Granite Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+	}
+
+	if len(toolCalls) == 0 {
+		return nil, nil
+	}
+	return toolCalls, []Span{section}
+}
+
+// extractGLM45ToolCalls は GLM 4.5 形式のツール呼び出しを抽出
+// 形式: <tool_call><arg_key>param1</arg_key><arg_value>value1</arg_value>...</tool_call>
+func extractGLM45ToolCalls(text string) ([]ToolCall, []Span) {
+	// GLM 4.5のXML形式タグ
+	const (
+		toolCallStart = "<tool_call>"
+		toolCallEnd   = "</tool_call>"
+		argKeyStart   = "<arg_key>"
+		argKeyEnd     = "</arg_key>"
+		argValueStart = "<arg_value>"
+		argValueEnd   = "</arg_value>"
+	)
+
+	// tool_callタグの検出
+	if !strings.Contains(text, toolCallStart) {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+	var spans []Span
+
+	// 複数のtool_callを抽出
+	searchPos := 0
+	for {
+		// tool_callの開始を探す
+		startIdx := strings.Index(text[searchPos:], toolCallStart)
+		if startIdx == -1 {
+			break
+		}
+		startIdx += searchPos
+
+		// tool_callの終了を探す
+		endIdx := strings.Index(text[startIdx:], toolCallEnd)
+		if endIdx == -1 {
+			break
+		}
+		endIdx += startIdx
+
+		// 1つのツール呼び出しブロック
+		toolCallText := text[startIdx+len(toolCallStart) : endIdx]
+
+		// arg_key/arg_valueペアを抽出
+		args := make(map[string]any)
+		raw := make(map[string]string)
+		var functionName string
+
+		argSearchPos := 0
+		for {
+			// arg_keyの開始を探す
+			keyStartIdx := strings.Index(toolCallText[argSearchPos:], argKeyStart)
+			if keyStartIdx == -1 {
+				break
+			}
+			keyStartIdx += argSearchPos
+
+			// arg_keyの終了を探す
+			keyEndIdx := strings.Index(toolCallText[keyStartIdx:], argKeyEnd)
+			if keyEndIdx == -1 {
+				break
+			}
+			keyEndIdx += keyStartIdx
+
+			keyName := strings.TrimSpace(toolCallText[keyStartIdx+len(argKeyStart) : keyEndIdx])
+
+			// arg_valueの開始を探す（arg_keyの直後）
+			valueStartIdx := keyEndIdx + len(argKeyEnd)
+			if !strings.HasPrefix(toolCallText[valueStartIdx:], argValueStart) {
+				argSearchPos = valueStartIdx
+				continue
+			}
+
+			// arg_valueの終了を探す
+			valueEndIdx := strings.Index(toolCallText[valueStartIdx:], argValueEnd)
+			if valueEndIdx == -1 {
+				break
+			}
+			valueEndIdx += valueStartIdx
+
+			value := strings.TrimSpace(toolCallText[valueStartIdx+len(argValueStart) : valueEndIdx])
+
+			// 最初のarg_keyを関数名として扱う（GLM 4.5の仕様）
+			if functionName == "" && keyName != "" {
+				functionName = keyName
+				// 最初のキーは関数名なので、引数には含めない
+			} else if keyName != "" {
+				raw[keyName] = value // スキーマに基づく型変換のため、書かれたままの値も保持する
+				// JSON値としてパース試行
+				var jsonValue any
+				if err := json.Unmarshal([]byte(value), &jsonValue); err == nil {
+					args[keyName] = jsonValue
+				} else {
+					// JSONでない場合は文字列として扱う
+					args[keyName] = value
+				}
+			}
+
+			argSearchPos = valueEndIdx + len(argValueEnd)
+		}
+
+		// 関数名が見つからない場合はスキップ
+		if functionName == "" {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+
+		// 引数をJSONに変換
+		argsBytes, _ := json.Marshal(args)
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:           generateToolCallID(),
+			Type:         "function",
+			Function:     ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+			RawArguments: raw,
+		})
+		spans = append(spans, Span{Start: startIdx, End: endIdx + len(toolCallEnd)})
+
+		logDebug("GLM 4.5 Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+
+		searchPos = endIdx + len(toolCallEnd)
+	}
+
+	return toolCalls, spans
+}
+
+// extractQwen3CoderXMLToolCalls は Qwen3-Coder XML 形式のツール呼び出しを抽出
+// 形式: <tool_call><function>funcName</function><parameter>key=value</parameter>...</tool_call>
+// または: <tool_call><function=funcName><parameter=key>value</parameter>...</function></tool_call>（Qwen3-Coder のチャットテンプレート）
+func extractQwen3CoderXMLToolCalls(text string) ([]ToolCall, []Span) {
+	// Qwen3-Coder XMLのタグ
+	const (
+		toolCallStart = "<tool_call>"
+		toolCallEnd   = "</tool_call>"
+		functionStart = "<function>"
+		functionEnd   = "</function>"
+		paramStart    = "<parameter>"
+		paramEnd      = "</parameter>"
+	)
+
+	// tool_callタグの検出
+	if !strings.Contains(text, toolCallStart) {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+	var spans []Span
+
+	// 複数のtool_callを抽出
+	searchPos := 0
+	for {
+		// tool_callの開始を探す
+		startIdx := strings.Index(text[searchPos:], toolCallStart)
+		if startIdx == -1 {
+			break
+		}
+		startIdx += searchPos
+
+		// tool_callの終了を探す
+		endIdx := strings.Index(text[startIdx:], toolCallEnd)
+		if endIdx == -1 {
+			break
+		}
+		endIdx += startIdx
+
+		// 1つのツール呼び出しブロック
+		toolCallText := text[startIdx+len(toolCallStart) : endIdx]
+
+		// チャットテンプレートの形式（<function=funcName>）
+		if functionName, args, raw, ok := parseQwen3CoderFunctionTag(toolCallText); ok {
+			argsBytes, _ := json.Marshal(args)
+			toolCalls = append(toolCalls, ToolCall{
+				ID:           generateToolCallID(),
+				Type:         "function",
+				Function:     ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+				RawArguments: raw,
+			})
+			spans = append(spans, Span{Start: startIdx, End: endIdx + len(toolCallEnd)})
+			logDebug("Qwen3-Coder XML Tool Call Detected", map[string]any{
+				"Function": functionName,
+				"Args":     string(argsBytes),
+			})
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+
+		// 関数名の抽出
+		funcStartIdx := strings.Index(toolCallText, functionStart)
+		if funcStartIdx == -1 {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+
+		funcEndIdx := strings.Index(toolCallText[funcStartIdx:], functionEnd)
+		if funcEndIdx == -1 {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+		funcEndIdx += funcStartIdx
+
+		functionName := strings.TrimSpace(toolCallText[funcStartIdx+len(functionStart) : funcEndIdx])
+
+		// 空の関数名はスキップ
+		if functionName == "" {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+
+		// パラメータの抽出
+		args := make(map[string]any)
+		raw := make(map[string]string)
+
+		paramSearchPos := funcEndIdx + len(functionEnd)
+		for {
+			// parameterの開始を探す
+			pStartIdx := strings.Index(toolCallText[paramSearchPos:], paramStart)
+			if pStartIdx == -1 {
+				break
+			}
+			pStartIdx += paramSearchPos
+
+			// parameterの終了を探す
+			pEndIdx := strings.Index(toolCallText[pStartIdx:], paramEnd)
+			if pEndIdx == -1 {
+				break
+			}
+			pEndIdx += pStartIdx
+
+			paramText := strings.TrimSpace(toolCallText[pStartIdx+len(paramStart) : pEndIdx])
+
+			// key=value形式をパース
+			eqIdx := strings.Index(paramText, "=")
+			if eqIdx != -1 {
+				key := strings.TrimSpace(paramText[:eqIdx])
+				value := strings.TrimSpace(paramText[eqIdx+1:])
+
+				if key != "" {
+					raw[key] = value // スキーマに基づく型変換のため、書かれたままの値も保持する
+					// JSON値としてパース試行
+					var jsonValue any
+					if err := json.Unmarshal([]byte(value), &jsonValue); err == nil {
+						args[key] = jsonValue
+					} else {
+						// JSONでない場合は文字列として扱う
+						args[key] = value
+					}
+				}
+			}
+
+			paramSearchPos = pEndIdx + len(paramEnd)
+		}
+
+		// 引数をJSONに変換
+		argsBytes, _ := json.Marshal(args)
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:           generateToolCallID(),
+			Type:         "function",
+			Function:     ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+			RawArguments: raw,
+		})
+		spans = append(spans, Span{Start: startIdx, End: endIdx + len(toolCallEnd)})
+
+		logDebug("Qwen3-Coder XML Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+
+		searchPos = endIdx + len(toolCallEnd)
+	}
+
+	return toolCalls, spans
+}
+
+// parseQwen3CoderFunctionTag は <tool_call> の中身が <function=funcName>…</function> の形式であれば、関数名と引数を返す
+// 値は前後の空白（テンプレートが入れる改行）を除き、JSON として解釈できれば JSON の値、それ以外は文字列とする
+func parseQwen3CoderFunctionTag(toolCallText string) (string, map[string]any, map[string]string, bool) {
+	match := regexQwen3CoderFunction.FindStringSubmatch(toolCallText)
+	if match == nil {
+		return "", nil, nil, false
+	}
+	args := make(map[string]any)
+	raw := make(map[string]string)
+	for _, param := range regexQwen3CoderParameter.FindAllStringSubmatch(match[2], -1) {
+		key, value := param[1], strings.TrimSpace(param[2])
+		raw[key] = value // スキーマに基づく型変換のため、書かれたままの値も保持する
+		var jsonValue any
+		if err := json.Unmarshal([]byte(value), &jsonValue); err == nil {
+			args[key] = jsonValue
+		} else {
+			args[key] = value
+		}
+	}
+	return match[1], args, raw, true
+}
+
+// extractXiaomiMiMoToolCalls は Xiaomi MiMo 形式のツール呼び出しを抽出
+// 形式: <tool_call>name=functionName, arguments={JSON}</tool_call>
+func extractXiaomiMiMoToolCalls(text string) ([]ToolCall, []Span) {
+	// Xiaomi MiMoのタグ
+	const (
+		toolCallStart = "<tool_call>"
+		toolCallEnd   = "</tool_call>"
+	)
+
+	// tool_callタグの検出
+	if !strings.Contains(text, toolCallStart) {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+	var spans []Span
+
+	// 複数のtool_callを抽出
+	searchPos := 0
+	for {
+		// tool_callの開始を探す
+		startIdx := strings.Index(text[searchPos:], toolCallStart)
+		if startIdx == -1 {
+			break
+		}
+		startIdx += searchPos
+
+		// tool_callの終了を探す
+		endIdx := strings.Index(text[startIdx:], toolCallEnd)
+		if endIdx == -1 {
+			break
+		}
+		endIdx += startIdx
+
+		// 1つのツール呼び出しブロック
+		toolCallText := strings.TrimSpace(text[startIdx+len(toolCallStart) : endIdx])
+
+		// "name=" で始まることを確認
+		if !strings.HasPrefix(toolCallText, "name=") {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+
+		// ", arguments=" で分割
+		parts := strings.SplitN(toolCallText, ", arguments=", 2)
+		if len(parts) != 2 {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+
+		// 関数名の抽出（"name=" を除去）
+		functionName := strings.TrimSpace(strings.TrimPrefix(parts[0], "name="))
+
+		// 空の関数名はスキップ
+		if functionName == "" {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+
+		// 引数の抽出
+		argsText := strings.TrimSpace(parts[1])
+
+		// JSON引数のパース
+		var argsMap map[string]any
+		if argsText != "" {
+			if err := json.Unmarshal([]byte(argsText), &argsMap); err != nil {
+				searchPos = endIdx + len(toolCallEnd)
+				continue
+			}
+		} else {
+			argsMap = make(map[string]any)
+		}
+
+		argsBytes, _ := json.Marshal(argsMap)
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+		spans = append(spans, Span{Start: startIdx, End: endIdx + len(toolCallEnd)})
+
+		logDebug("Xiaomi MiMo Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+
+		searchPos = endIdx + len(toolCallEnd)
+	}
+
+	return toolCalls, spans
+}
+
+// extractSeedOSSToolCalls は Seed-OSS 形式のツール呼び出しを抽出
+// 形式: <seed:tool_call><function>funcName</function><parameter>key=value</parameter>...</seed:tool_call>
+func extractSeedOSSToolCalls(text string) ([]ToolCall, []Span) {
+	// Seed-OSSのタグ
+	const (
+		toolCallStart = "<seed:tool_call>"
+		toolCallEnd   = "</seed:tool_call>"
+		functionStart = "<function>"
+		functionEnd   = "</function>"
+		paramStart    = "<parameter>"
+		paramEnd      = "</parameter>"
+	)
+
+	// seed:tool_callタグの検出
+	if !strings.Contains(text, toolCallStart) {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+	var spans []Span
+
+	// 複数のseed:tool_callを抽出
+	searchPos := 0
+	for {
+		// seed:tool_callの開始を探す
+		startIdx := strings.Index(text[searchPos:], toolCallStart)
+		if startIdx == -1 {
+			break
+		}
+		startIdx += searchPos
+
+		// seed:tool_callの終了を探す
+		endIdx := strings.Index(text[startIdx:], toolCallEnd)
+		if endIdx == -1 {
+			break
+		}
+		endIdx += startIdx
+
+		// 1つのツール呼び出しブロック
+		toolCallText := text[startIdx+len(toolCallStart) : endIdx]
+
+		// 関数名の抽出
+		funcStartIdx := strings.Index(toolCallText, functionStart)
+		if funcStartIdx == -1 {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+
+		funcEndIdx := strings.Index(toolCallText[funcStartIdx:], functionEnd)
+		if funcEndIdx == -1 {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+		funcEndIdx += funcStartIdx
+
+		functionName := strings.TrimSpace(toolCallText[funcStartIdx+len(functionStart) : funcEndIdx])
+
+		// 空の関数名はスキップ
+		if functionName == "" {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+
+		// パラメータの抽出
+		args := make(map[string]any)
+		raw := make(map[string]string)
+
+		paramSearchPos := funcEndIdx + len(functionEnd)
+		for {
+			// parameterの開始を探す
+			pStartIdx := strings.Index(toolCallText[paramSearchPos:], paramStart)
+			if pStartIdx == -1 {
+				break
+			}
+			pStartIdx += paramSearchPos
+
+			// parameterの終了を探す
+			pEndIdx := strings.Index(toolCallText[pStartIdx:], paramEnd)
+			if pEndIdx == -1 {
+				break
+			}
+			pEndIdx += pStartIdx
+
+			paramText := strings.TrimSpace(toolCallText[pStartIdx+len(paramStart) : pEndIdx])
+
+			// key=value形式をパース
+			eqIdx := strings.Index(paramText, "=")
+			if eqIdx != -1 {
+				key := strings.TrimSpace(paramText[:eqIdx])
+				value := strings.TrimSpace(paramText[eqIdx+1:])
+
+				if key != "" {
+					raw[key] = value // スキーマに基づく型変換のため、書かれたままの値も保持する
+					// JSON値としてパース試行
+					var jsonValue any
+					if err := json.Unmarshal([]byte(value), &jsonValue); err == nil {
+						args[key] = jsonValue
+					} else {
+						// JSONでない場合は文字列として扱う
+						args[key] = value
+					}
+				}
+			}
+
+			paramSearchPos = pEndIdx + len(paramEnd)
+		}
+
+		// 引数をJSONに変換
+		argsBytes, _ := json.Marshal(args)
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:           generateToolCallID(),
+			Type:         "function",
+			Function:     ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+			RawArguments: raw,
+		})
+		spans = append(spans, Span{Start: startIdx, End: endIdx + len(toolCallEnd)})
+
+		logDebug("Seed-OSS Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+
+		searchPos = endIdx + len(toolCallEnd)
+	}
+
+	return toolCalls, spans
+}
+
+// extractNemotronV2ToolCalls は Nemotron v2 形式のツール呼び出しを抽出
+// 形式: <TOOLCALL>[{"name": "func", "arguments": {...}}]</TOOLCALL>
+func extractNemotronV2ToolCalls(text string) ([]ToolCall, []Span) {
+	// Nemotron v2のタグ
+	const (
+		toolCallStart = "<TOOLCALL>"
+		toolCallEnd   = "</TOOLCALL>"
+	)
+
+	// TOOLCALLタグの検出
+	startIdx := strings.Index(text, toolCallStart)
+	if startIdx == -1 {
+		return nil, nil
+	}
+
+	// TOOLCALLの終了を探す
+	endIdx := strings.Index(text[startIdx:], toolCallEnd)
+	var toolCallText string
+	section := Span{Start: startIdx, End: len(text)}
+	if endIdx != -1 {
+		section.End = startIdx + endIdx + len(toolCallEnd)
+	}
+	if endIdx == -1 {
+		toolCallText = text[startIdx+len(toolCallStart):]
+	} else {
+		toolCallText = text[startIdx+len(toolCallStart) : startIdx+endIdx]
+	}
+
+	toolCallText = strings.TrimSpace(toolCallText)
+
+	// JSON配列のパース
+	var toolCallsData []map[string]any
+	if err := json.Unmarshal([]byte(toolCallText), &toolCallsData); err != nil {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+
+	for _, tcData := range toolCallsData {
+		// nameの取得
+		functionName, ok := tcData["name"].(string)
+		if !ok || functionName == "" {
+			continue
+		}
+
+		// argumentsの取得
+		var argsBytes []byte
+		if args, exists := tcData["arguments"]; exists {
+			if argsMap, ok := args.(map[string]any); ok {
+				argsBytes, _ = json.Marshal(argsMap)
+			} else if argsStr, ok := args.(string); ok {
+				// 文字列の場合、JSONとしてパース試行
+				var argsMap map[string]any
+				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
+					argsBytes = []byte(argsStr)
+				} else {
+					argsBytes = []byte("{}")
+				}
+			} else {
+				argsBytes, _ = json.Marshal(args)
+			}
+		} else {
+			argsBytes = []byte("{}")
+		}
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+
+		logDebug("Nemotron v2 Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+	}
+
+	if len(toolCalls) == 0 {
+		return nil, nil
+	}
+	return toolCalls, []Span{section}
+}
+
+// extractApertusToolCalls は Apertus 形式のツール呼び出しを抽出
+// 形式: <|tools_prefix|>[{"functionName": {arguments}}]<|tools_suffix|>
+func extractApertusToolCalls(text string) ([]ToolCall, []Span) {
+	// Apertusのタグ
+	const (
+		toolsPrefix = "<|tools_prefix|>"
+		toolsSuffix = "<|tools_suffix|>"
+	)
+
+	// tools_prefixタグの検出
+	startIdx := strings.Index(text, toolsPrefix)
+	if startIdx == -1 {
+		return nil, nil
+	}
+
+	// tools_suffixの終了を探す
+	endIdx := strings.Index(text[startIdx:], toolsSuffix)
+	var toolsText string
+	section := Span{Start: startIdx, End: len(text)}
+	if endIdx != -1 {
+		section.End = startIdx + endIdx + len(toolsSuffix)
+	}
+	if endIdx == -1 {
+		toolsText = text[startIdx+len(toolsPrefix):]
+	} else {
+		toolsText = text[startIdx+len(toolsPrefix) : startIdx+endIdx]
+	}
+
+	toolsText = strings.TrimSpace(toolsText)
+
+	// JSON配列のパース
+	var toolCallsData []map[string]any
+	if err := json.Unmarshal([]byte(toolsText), &toolCallsData); err != nil {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+
+	for _, tcData := range toolCallsData {
+		// 各オブジェクトの最初のキーを関数名として扱う
+		for functionName, args := range tcData {
+			if functionName == "" {
+				continue
+			}
+
+			// 引数の処理
+			var argsBytes []byte
+			if argsMap, ok := args.(map[string]any); ok {
+				argsBytes, _ = json.Marshal(argsMap)
+			} else if argsStr, ok := args.(string); ok {
+				// 文字列の場合、JSONとしてパース試行
+				var argsMap map[string]any
+				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
+					argsBytes = []byte(argsStr)
+				} else {
+					argsBytes = []byte("{}")
+				}
+			} else {
+				argsBytes, _ = json.Marshal(args)
+			}
+
+			toolCalls = append(toolCalls, ToolCall{
+				ID:       generateToolCallID(),
+				Type:     "function",
+				Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+			})
+
+			logDebug("Apertus Tool Call Detected", map[string]any{
+				"Function": functionName,
+				"Args":     string(argsBytes),
+			})
+
+			// Apertus形式では各オブジェクトに1つのキーのみ
+			break
+		}
+	}
+
+	if len(toolCalls) == 0 {
+		return nil, nil
+	}
+	return toolCalls, []Span{section}
+}
+
+// extractLFM2ToolCalls は LFM2 形式のツール呼び出しを抽出
+// 形式: <|tool_call_start|>[{"name": "func", "arguments": {...}}]<|tool_call_end|>
+func extractLFM2ToolCalls(text string) ([]ToolCall, []Span) {
+	// LFM2のタグ
+	const (
+		toolCallStart = "<|tool_call_start|>"
+		toolCallEnd   = "<|tool_call_end|>"
+	)
+
+	// tool_call_startタグの検出
+	startIdx := strings.Index(text, toolCallStart)
+	if startIdx == -1 {
+		return nil, nil
+	}
+
+	// tool_call_endの終了を探す
+	endIdx := strings.Index(text[startIdx:], toolCallEnd)
+	var toolCallText string
+	section := Span{Start: startIdx, End: len(text)}
+	if endIdx != -1 {
+		section.End = startIdx + endIdx + len(toolCallEnd)
+	}
+	if endIdx == -1 {
+		toolCallText = text[startIdx+len(toolCallStart):]
+	} else {
+		toolCallText = text[startIdx+len(toolCallStart) : startIdx+endIdx]
+	}
+
+	toolCallText = strings.TrimSpace(toolCallText)
+
+	// JSON配列のパース
+	var toolCallsData []map[string]any
+	if err := json.Unmarshal([]byte(toolCallText), &toolCallsData); err != nil {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+
+	for _, tcData := range toolCallsData {
+		// nameの取得
+		functionName, ok := tcData["name"].(string)
+		if !ok || functionName == "" {
+			continue
+		}
+
+		// argumentsの取得
+		var argsBytes []byte
+		if args, exists := tcData["arguments"]; exists {
+			if argsMap, ok := args.(map[string]any); ok {
+				argsBytes, _ = json.Marshal(argsMap)
+			} else if argsStr, ok := args.(string); ok {
+				// 文字列の場合、JSONとしてパース試行
+				var argsMap map[string]any
+				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
+					argsBytes = []byte(argsStr)
+				} else {
+					argsBytes = []byte("{}")
+				}
+			} else {
+				argsBytes, _ = json.Marshal(args)
+			}
+		} else {
+			argsBytes = []byte("{}")
+		}
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+
+		logDebug("LFM2 Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+	}
+
+	if len(toolCalls) == 0 {
+		return nil, nil
+	}
+	return toolCalls, []Span{section}
+}
+
+// extractMiniMaxM2ToolCalls は MiniMax-M2 形式のツール呼び出しを抽出
+// 形式: <minimax:tool_call><invoke name="func"><parameter name="key">value</parameter>...</invoke></minimax:tool_call>
+func extractMiniMaxM2ToolCalls(text string) ([]ToolCall, []Span) {
+	// MiniMax-M2のタグ
+	const (
+		toolCallStart = "<minimax:tool_call>"
+		toolCallEnd   = "</minimax:tool_call>"
+		invokeStart   = "<invoke name="
+		invokeEnd     = "</invoke>"
+		paramStart    = "<parameter name="
+		paramEnd      = "</parameter>"
+	)
+
+	// minimax:tool_callタグの検出
+	if !strings.Contains(text, toolCallStart) {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+	var spans []Span
+
+	// 複数のminimax:tool_callを抽出
+	searchPos := 0
+	for {
+		// minimax:tool_callの開始を探す
+		startIdx := strings.Index(text[searchPos:], toolCallStart)
+		if startIdx == -1 {
+			break
+		}
+		startIdx += searchPos
+
+		// minimax:tool_callの終了を探す
+		endIdx := strings.Index(text[startIdx:], toolCallEnd)
+		if endIdx == -1 {
+			break
+		}
+		endIdx += startIdx
+
+		// 1つのツール呼び出しブロック
+		toolCallText := text[startIdx+len(toolCallStart) : endIdx]
+
+		// invokeタグから関数名を抽出
+		invokeIdx := strings.Index(toolCallText, invokeStart)
+		if invokeIdx == -1 {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+
+		// 関数名の抽出（引用符で囲まれている）
+		nameStart := invokeIdx + len(invokeStart)
+		nameEnd := strings.Index(toolCallText[nameStart:], `"`)
+		if nameEnd == -1 {
+			// 単一引用符の場合も試す
+			nameEnd = strings.Index(toolCallText[nameStart:], `'`)
+		}
+		if nameEnd == -1 {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+		nameEnd += nameStart
+
+		functionName := strings.TrimSpace(toolCallText[nameStart:nameEnd])
+
+		// 空の関数名はスキップ
+		if functionName == "" {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+
+		// パラメータの抽出
+		args := make(map[string]any)
+		raw := make(map[string]string)
+
+		// invokeの終了位置を探す
+		invokeEndIdx := strings.Index(toolCallText[nameEnd:], invokeEnd)
+		if invokeEndIdx == -1 {
+			searchPos = endIdx + len(toolCallEnd)
+			continue
+		}
+		invokeEndIdx += nameEnd
+
+		invokeContent := toolCallText[nameEnd:invokeEndIdx]
+
+		paramSearchPos := 0
+		for {
+			// parameterの開始を探す
+			pStartIdx := strings.Index(invokeContent[paramSearchPos:], paramStart)
+			if pStartIdx == -1 {
+				break
+			}
+			pStartIdx += paramSearchPos
+
+			// パラメータ名の抽出（引用符で囲まれている）
+			pNameStart := pStartIdx + len(paramStart)
+			pNameEnd := strings.Index(invokeContent[pNameStart:], `"`)
+			if pNameEnd == -1 {
+				pNameEnd = strings.Index(invokeContent[pNameStart:], `'`)
+			}
+			if pNameEnd == -1 {
+				break
+			}
+			pNameEnd += pNameStart
+
+			paramName := strings.TrimSpace(invokeContent[pNameStart:pNameEnd])
+
+			// 値の開始（">" の後）
+			valueStart := strings.Index(invokeContent[pNameEnd:], ">")
+			if valueStart == -1 {
+				break
+			}
+			valueStart += pNameEnd + 1
+
+			// parameterの終了を探す
+			pEndIdx := strings.Index(invokeContent[valueStart:], paramEnd)
+			if pEndIdx == -1 {
+				break
+			}
+			pEndIdx += valueStart
+
+			paramValue := strings.TrimSpace(invokeContent[valueStart:pEndIdx])
+
+			if paramName != "" {
+				raw[paramName] = paramValue // スキーマに基づく型変換のため、書かれたままの値も保持する
+				// JSON値としてパース試行
+				var jsonValue any
+				if err := json.Unmarshal([]byte(paramValue), &jsonValue); err == nil {
+					args[paramName] = jsonValue
+				} else {
+					// JSONでない場合は文字列として扱う
+					args[paramName] = paramValue
+				}
+			}
+
+			paramSearchPos = pEndIdx + len(paramEnd)
+		}
+
+		// 引数をJSONに変換
+		argsBytes, _ := json.Marshal(args)
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:           generateToolCallID(),
+			Type:         "function",
+			Function:     ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+			RawArguments: raw,
+		})
+		spans = append(spans, Span{Start: startIdx, End: endIdx + len(toolCallEnd)})
+
+		logDebug("MiniMax-M2 Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+
+		searchPos = endIdx + len(toolCallEnd)
+	}
+
+	return toolCalls, spans
+}
+
+// extractKimiK2ToolCalls は Kimi K2 形式のツール呼び出しを抽出
+// 形式: <|tool_calls_section_begin|><|tool_call_begin|>functionName<|tool_call_argument_begin|>{JSON}<|tool_call_end|><|tool_calls_section_end|>
+func extractKimiK2ToolCalls(text string) ([]ToolCall, []Span) {
+	// Kimi K2のタグ
+	const (
+		sectionBegin  = "<|tool_calls_section_begin|>"
+		sectionEnd    = "<|tool_calls_section_end|>"
+		toolCallBegin = "<|tool_call_begin|>"
+		toolCallEnd   = "<|tool_call_end|>"
+		argumentBegin = "<|tool_call_argument_begin|>"
+	)
+
+	// tool_calls_section_beginタグの検出
+	if !strings.Contains(text, sectionBegin) {
+		return nil, nil
+	}
+
+	// セクション全体を抽出
+	startIdx := strings.Index(text, sectionBegin)
+	if startIdx == -1 {
+		return nil, nil
+	}
+
+	endIdx := strings.Index(text[startIdx:], sectionEnd)
+	var sectionText string
+	section := Span{Start: startIdx, End: len(text)}
+	if endIdx != -1 {
+		section.End = startIdx + endIdx + len(sectionEnd)
+	}
+	if endIdx == -1 {
+		sectionText = text[startIdx+len(sectionBegin):]
+	} else {
+		sectionText = text[startIdx+len(sectionBegin) : startIdx+endIdx]
+	}
+
+	var toolCalls []ToolCall
+
+	// 複数のtool_callを抽出
+	searchPos := 0
+	for {
+		// tool_call_beginを探す
+		beginIdx := strings.Index(sectionText[searchPos:], toolCallBegin)
+		if beginIdx == -1 {
+			break
+		}
+		beginIdx += searchPos
+
+		// 関数名の開始位置
+		nameStart := beginIdx + len(toolCallBegin)
+
+		// tool_call_argument_beginを探す
+		argBeginIdx := strings.Index(sectionText[nameStart:], argumentBegin)
+		if argBeginIdx == -1 {
+			break
+		}
+		argBeginIdx += nameStart
+
+		// 関数名の抽出
+		functionName := strings.TrimSpace(sectionText[nameStart:argBeginIdx])
+
+		// 空の関数名はスキップ
+		if functionName == "" {
+			searchPos = argBeginIdx + len(argumentBegin)
+			continue
+		}
+
+		// 引数の開始位置
+		argsStart := argBeginIdx + len(argumentBegin)
+
+		// tool_call_endを探す
+		endIdx := strings.Index(sectionText[argsStart:], toolCallEnd)
+		if endIdx == -1 {
+			break
+		}
+		endIdx += argsStart
+
+		// 引数テキストの抽出
+		argsText := strings.TrimSpace(sectionText[argsStart:endIdx])
+
+		// JSON引数のパース
+		var argsMap map[string]any
+		if argsText != "" {
+			if err := json.Unmarshal([]byte(argsText), &argsMap); err != nil {
+				searchPos = endIdx + len(toolCallEnd)
+				continue
+			}
+		} else {
+			argsMap = make(map[string]any)
+		}
+
+		argsBytes, _ := json.Marshal(argsMap)
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+
+		logDebug("Kimi K2 Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+
+		searchPos = endIdx + len(toolCallEnd)
+	}
+
+	if len(toolCalls) == 0 {
+		return nil, nil
+	}
+	return toolCalls, []Span{section}
+}
+
+// extractApriel15ToolCalls は Apriel 1.5 形式のツール呼び出しを抽出
+// 形式: <tool_calls><name>func</name>, <arguments>{JSON}</arguments></tool_calls>
+func extractApriel15ToolCalls(text string) ([]ToolCall, []Span) {
+	// Apriel 1.5のタグ
+	const (
+		toolCallsStart = "<tool_calls>"
+		toolCallsEnd   = "</tool_calls>"
+		nameStart      = "<name>"
+		nameEnd        = "</name>"
+		argumentsStart = "<arguments>"
+		argumentsEnd   = "</arguments>"
+	)
+
+	// tool_callsタグの検出
+	if !strings.Contains(text, toolCallsStart) {
+		return nil, nil
+	}
+
+	// tool_callsセクション全体を抽出
+	startIdx := strings.Index(text, toolCallsStart)
+	if startIdx == -1 {
+		return nil, nil
+	}
+
+	endIdx := strings.Index(text[startIdx:], toolCallsEnd)
+	var toolCallsText string
+	section := Span{Start: startIdx, End: len(text)}
+	if endIdx != -1 {
+		section.End = startIdx + endIdx + len(toolCallsEnd)
+	}
+	if endIdx == -1 {
+		toolCallsText = text[startIdx+len(toolCallsStart):]
+	} else {
+		toolCallsText = text[startIdx+len(toolCallsStart) : startIdx+endIdx]
+	}
+
+	var toolCalls []ToolCall
+
+	// 複数のname/argumentsペアを抽出
+	searchPos := 0
+	for {
+		// nameタグの開始を探す
+		nStartIdx := strings.Index(toolCallsText[searchPos:], nameStart)
+		if nStartIdx == -1 {
+			break
+		}
+		nStartIdx += searchPos
+
+		// nameタグの終了を探す
+		nEndIdx := strings.Index(toolCallsText[nStartIdx:], nameEnd)
+		if nEndIdx == -1 {
+			break
+		}
+		nEndIdx += nStartIdx
+
+		// 関数名の抽出
+		functionName := strings.TrimSpace(toolCallsText[nStartIdx+len(nameStart) : nEndIdx])
+
+		// 空の関数名はスキップ
+		if functionName == "" {
+			searchPos = nEndIdx + len(nameEnd)
+			continue
+		}
+
+		// argumentsタグの開始を探す（nameの後）
+		aStartIdx := strings.Index(toolCallsText[nEndIdx:], argumentsStart)
+		if aStartIdx == -1 {
+			searchPos = nEndIdx + len(nameEnd)
+			continue
+		}
+		aStartIdx += nEndIdx
+
+		// argumentsタグの終了を探す
+		aEndIdx := strings.Index(toolCallsText[aStartIdx:], argumentsEnd)
+		if aEndIdx == -1 {
+			break
+		}
+		aEndIdx += aStartIdx
+
+		// 引数テキストの抽出
+		argsText := strings.TrimSpace(toolCallsText[aStartIdx+len(argumentsStart) : aEndIdx])
+
+		// JSON引数のパース
+		var argsMap map[string]any
+		if argsText != "" {
+			if err := json.Unmarshal([]byte(argsText), &argsMap); err != nil {
+				searchPos = aEndIdx + len(argumentsEnd)
+				continue
+			}
+		} else {
+			argsMap = make(map[string]any)
+		}
+
+		argsBytes, _ := json.Marshal(argsMap)
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+
+		logDebug("Apriel 1.5 Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+
+		searchPos = aEndIdx + len(argumentsEnd)
+	}
+
+	if len(toolCalls) == 0 {
+		return nil, nil
+	}
+	return toolCalls, []Span{section}
+}
+
+// extractFirefunctionV2ToolCalls は Firefunction v2 形式のツール呼び出しを抽出
+// 形式:  functools[{"name": "func", "arguments": {...}}]
+func extractFirefunctionV2ToolCalls(text string) ([]ToolCall, []Span) {
+	// Firefunction v2のプレフィックス
+	const prefix = " functools"
+
+	// プレフィックスの検出
+	prefixIdx := strings.Index(text, prefix)
+	if prefixIdx == -1 {
+		return nil, nil
+	}
+
+	// JSON配列の開始位置（プレフィックスの直後）
+	jsonStart := prefixIdx + len(prefix)
+	jsonText := strings.TrimSpace(text[jsonStart:])
+	leading := len(text[jsonStart:]) - len(strings.TrimLeftFunc(text[jsonStart:], unicode.IsSpace)) // トリムした先頭空白の長さ（位置計算用）
+
+	// JSON配列の開始を確認
+	if !strings.HasPrefix(jsonText, "[") {
+		return nil, nil
+	}
+
+	// JSON配列の終了を探す
+	jsonEnd := strings.LastIndex(jsonText, "]")
+	if jsonEnd == -1 {
+		return nil, nil
+	}
+
+	jsonText = jsonText[:jsonEnd+1]
+	section := Span{Start: prefixIdx, End: jsonStart + leading + jsonEnd + 1}
+
+	// JSON配列のパース
+	var toolCallsData []map[string]any
+	if err := json.Unmarshal([]byte(jsonText), &toolCallsData); err != nil {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+
+	for _, tcData := range toolCallsData {
+		// nameの取得
+		functionName, ok := tcData["name"].(string)
+		if !ok || functionName == "" {
+			continue
+		}
+
+		// argumentsの取得
+		var argsBytes []byte
+		if args, exists := tcData["arguments"]; exists {
+			if argsMap, ok := args.(map[string]any); ok {
+				argsBytes, _ = json.Marshal(argsMap)
+			} else if argsStr, ok := args.(string); ok {
+				// 文字列の場合、JSONとしてパース試行
+				var argsMap map[string]any
+				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
+					argsBytes = []byte(argsStr)
+				} else {
+					argsBytes = []byte("{}")
+				}
+			} else {
+				argsBytes, _ = json.Marshal(args)
+			}
+		} else {
+			argsBytes = []byte("{}")
+		}
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+
+		logDebug("Firefunction v2 Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+	}
+
+	if len(toolCalls) == 0 {
+		return nil, nil
+	}
+	return toolCalls, []Span{section}
+}
+
+// extractFunctionaryV31Llama31ToolCalls は Functionary v3.1 Llama 3.1 形式のツール呼び出しを抽出
+// 形式: <function=functionName>{JSON}</function>
+func extractFunctionaryV31Llama31ToolCalls(text string) ([]ToolCall, []Span) {
+	// Functionary v3.1 Llama 3.1のタグパターン
+	// <function=functionName> ... </function>
+	closeTag := `</function>`
+	matches := regexFunctionaryV31Llama31.FindAllStringSubmatchIndex(text, -1)
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+	var spans []Span
+
+	for _, match := range matches {
+		// match[0], match[1]: 全体マッチ（<function=functionName>）
+		// match[2], match[3]: 関数名のキャプチャグループ
+
+		if len(match) < 4 {
+			continue
+		}
+
+		functionName := strings.TrimSpace(text[match[2]:match[3]])
+
+		// 空の関数名はスキップ
+		if functionName == "" {
+			continue
+		}
+
+		// JSON引数の抽出（<function=...>の後から</function>まで）
+		jsonStart := match[1] // <function=...>の終了位置
+		remainingText := text[jsonStart:]
+
+		closeIdx := strings.Index(remainingText, closeTag)
+		if closeIdx == -1 {
+			continue
+		}
+
+		jsonText := strings.TrimSpace(remainingText[:closeIdx])
+
+		// JSON引数のパース
+		var argsMap map[string]any
+		if jsonText != "" {
+			if err := json.Unmarshal([]byte(jsonText), &argsMap); err != nil {
+				continue
+			}
+		} else {
+			argsMap = make(map[string]any)
+		}
+
+		argsBytes, _ := json.Marshal(argsMap)
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+		spans = append(spans, Span{Start: match[0], End: jsonStart + closeIdx + len(closeTag)})
+
+		logDebug("Functionary v3.1 Llama 3.1 Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+	}
+
+	return toolCalls, spans
+}
+
+// extractLlama3XToolCalls は Llama 3.x 形式のツール呼び出しを抽出
+// 形式: {"type": "function", "name": "functionName", "parameters": {...}}
+func extractLlama3XToolCalls(text string) ([]ToolCall, []Span) {
+	// Llama 3.xのJSON形式パターン
+	// {"type": "function", "name": "...", "parameters": {...}}
+	matches := regexLlama3X.FindAllStringSubmatchIndex(text, -1)
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+	var spans []Span
+
+	for _, match := range matches {
+		// match[0], match[1]: 全体マッチ
+		// match[2], match[3]: 関数名のキャプチャグループ
+
+		if len(match) < 4 {
+			continue
+		}
+
+		functionName := strings.TrimSpace(text[match[2]:match[3]])
+
+		// 空の関数名はスキップ
+		if functionName == "" {
+			continue
+		}
+
+		// parametersの値を抽出（match[1]の位置から）
+		jsonStart := match[1]
+		remainingText := text[jsonStart:]
+
+		// parametersのJSONオブジェクトを抽出
+		// 中括弧のバランスを取りながら抽出
+		braceCount := 0
+		jsonEnd := -1
+		inString := false
+		escape := false
+
+		for i, ch := range remainingText {
+			if escape {
+				escape = false
+				continue
+			}
+
+			if ch == '\\' {
+				escape = true
+				continue
+			}
+
+			if ch == '"' {
+				inString = !inString
+				continue
+			}
+
+			if !inString {
+				if ch == '{' {
+					braceCount++
+				} else if ch == '}' {
+					braceCount--
+					if braceCount == 0 {
+						jsonEnd = i + 1
+						break
+					}
+				}
+			}
+		}
+
+		if jsonEnd == -1 {
+			continue
+		}
+
+		// 完全なJSONオブジェクトを抽出
+		fullJsonText := remainingText[:jsonEnd]
+
+		// parametersのJSONオブジェクトをパース
+		// （正規表現は "parameters": の直後までにマッチするため、抽出したのは parameters の値そのもの）
+		var paramsMap map[string]any
+		if err := json.Unmarshal([]byte(fullJsonText), &paramsMap); err != nil {
+			continue
+		}
+		argsBytes, _ := json.Marshal(paramsMap)
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+
+		// parameters の後ろにある外側オブジェクトの閉じ括弧までをマークアップとして扱う
+		spanEnd := jsonStart + jsonEnd
+		if after := strings.TrimLeftFunc(text[spanEnd:], unicode.IsSpace); strings.HasPrefix(after, "}") {
+			spanEnd = len(text) - len(after) + 1
+		}
+		spans = append(spans, Span{Start: match[0], End: spanEnd})
+
+		logDebug("Llama 3.x Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+	}
+
+	return toolCalls, spans
+}
+
+// extractMagistralToolCalls は Magistral 形式のツール呼び出しを抽出
+// 形式: [TOOLCALLS][{"name": "func", "arguments": {...}}]
+func extractMagistralToolCalls(text string) ([]ToolCall, []Span) {
+	// Magistralのプレフィックス
+	const prefix = "[TOOLCALLS]"
+
+	// プレフィックスの検出
+	prefixIdx := strings.Index(text, prefix)
+	if prefixIdx == -1 {
+		return nil, nil
+	}
+
+	// JSON配列の開始位置（プレフィックスの直後）
+	jsonStart := prefixIdx + len(prefix)
+	jsonText := strings.TrimSpace(text[jsonStart:])
+	leading := len(text[jsonStart:]) - len(strings.TrimLeftFunc(text[jsonStart:], unicode.IsSpace)) // トリムした先頭空白の長さ（位置計算用）
+
+	// JSON配列の開始を確認
+	if !strings.HasPrefix(jsonText, "[") {
+		return nil, nil
+	}
+
+	// JSON配列の終了を探す
+	jsonEnd := strings.LastIndex(jsonText, "]")
+	if jsonEnd == -1 {
+		return nil, nil
+	}
+
+	jsonText = jsonText[:jsonEnd+1]
+	section := Span{Start: prefixIdx, End: jsonStart + leading + jsonEnd + 1}
+
+	// JSON配列のパース
+	var toolCallsData []map[string]any
+	if err := json.Unmarshal([]byte(jsonText), &toolCallsData); err != nil {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+
+	for _, tcData := range toolCallsData {
+		// nameの取得
+		functionName, ok := tcData["name"].(string)
+		if !ok || functionName == "" {
+			continue
+		}
+
+		// argumentsの取得
+		var argsBytes []byte
+		if args, exists := tcData["arguments"]; exists {
+			if argsMap, ok := args.(map[string]any); ok {
+				argsBytes, _ = json.Marshal(argsMap)
+			} else if argsStr, ok := args.(string); ok {
+				// 文字列の場合、JSONとしてパース試行
+				var argsMap map[string]any
+				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
+					argsBytes = []byte(argsStr)
+				} else {
+					argsBytes = []byte("{}")
+				}
+			} else {
+				argsBytes, _ = json.Marshal(args)
+			}
+		} else {
+			argsBytes = []byte("{}")
+		}
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       generateToolCallID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+
+		logDebug("Magistral Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"Args":     string(argsBytes),
+		})
+	}
+
+	if len(toolCalls) == 0 {
+		return nil, nil
+	}
+	return toolCalls, []Span{section}
+}
+
+// extractMistralNemoToolCalls は Mistral Nemo 形式のツール呼び出しを抽出
+// 形式: [TOOL_CALLS][{"name": "func", "arguments": {...}, "id": "123456789"}]
+func extractMistralNemoToolCalls(text string) ([]ToolCall, []Span) {
+	// Mistral Nemoのプレフィックス
+	const prefix = "[TOOL_CALLS]"
+
+	// プレフィックスの検出
+	prefixIdx := strings.Index(text, prefix)
+	if prefixIdx == -1 {
+		return nil, nil
+	}
+
+	// JSON配列の開始位置（プレフィックスの直後）
+	jsonStart := prefixIdx + len(prefix)
+	jsonText := strings.TrimSpace(text[jsonStart:])
+	leading := len(text[jsonStart:]) - len(strings.TrimLeftFunc(text[jsonStart:], unicode.IsSpace)) // トリムした先頭空白の長さ（位置計算用）
+
+	// JSON配列の開始を確認
+	if !strings.HasPrefix(jsonText, "[") {
+		return nil, nil
+	}
+
+	// JSON配列の終了を探す
+	jsonEnd := strings.LastIndex(jsonText, "]")
+	if jsonEnd == -1 {
+		return nil, nil
+	}
+
+	jsonText = jsonText[:jsonEnd+1]
+	section := Span{Start: prefixIdx, End: jsonStart + leading + jsonEnd + 1}
+
+	// JSON配列のパース
+	var toolCallsData []map[string]any
+	if err := json.Unmarshal([]byte(jsonText), &toolCallsData); err != nil {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+
+	for _, tcData := range toolCallsData {
+		// nameの取得
+		functionName, ok := tcData["name"].(string)
+		if !ok || functionName == "" {
+			continue
+		}
+
+		// idの取得（Mistral Nemo特有）
+		toolCallID := ""
+		if id, ok := tcData["id"].(string); ok {
+			toolCallID = id
+		}
+
+		// IDが指定されていない場合は生成
+		if toolCallID == "" {
+			toolCallID = generateToolCallID()
+		}
+
+		// argumentsの取得
+		var argsBytes []byte
+		if args, exists := tcData["arguments"]; exists {
+			if argsMap, ok := args.(map[string]any); ok {
+				argsBytes, _ = json.Marshal(argsMap)
+			} else if argsStr, ok := args.(string); ok {
+				// 文字列の場合、JSONとしてパース試行
+				var argsMap map[string]any
+				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
+					argsBytes = []byte(argsStr)
+				} else {
+					argsBytes = []byte("{}")
+				}
+			} else {
+				argsBytes, _ = json.Marshal(args)
+			}
+		} else {
+			argsBytes = []byte("{}")
+		}
+
+		toolCalls = append(toolCalls, ToolCall{
+			ID:       toolCallID,
+			Type:     "function",
+			Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+		})
+
+		logDebug("Mistral Nemo Tool Call Detected", map[string]any{
+			"Function": functionName,
+			"ID":       toolCallID,
+			"Args":     string(argsBytes),
+		})
+	}
+
+	if len(toolCalls) == 0 {
+		return nil, nil
+	}
+	return toolCalls, []Span{section}
+}
@@ -0,0 +1,273 @@
+/**
+ * parser.go
+ *
+ * ツール呼び出しパーサーのインターフェースとレジストリ
+ * LLMの出力テキストからツール呼び出しを抽出するパーサーは ToolCallParser を実装し、優先度付きでレジストリに登録する。
+ * 抽出時は優先度の小さい順にパーサーを試し、最初にツール呼び出しを返したパーサーの結果を採用する。
+ * 組み込みパーサー（builtin.go）も同じ仕組みで登録されているため、社内独自形式のパーサーを main.go に手を入れずに追加でき、
+ * 設定（TOOL_PARSER_ORDER / TOOL_PARSER_DISABLE）で並び順の変更や無効化ができる。
+ */
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ToolCallFunction はツール呼び出しの関数情報
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON文字列
+}
+
+// ToolCall はツール呼び出し情報
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"` // "function"
+	Function ToolCallFunction `json:"function"`
+	// RawArguments はパラメータ値がテキストで書かれる形式（XML など）で、型を推定する前の値をパラメータ名ごとに保持する
+	// ツール定義のスキーマに基づいて型を決め直すために使う（JSON 形式の呼び出しでは nil。レスポンスには含めない）
+	RawArguments map[string]string `json:"-"`
+}
+
+// Span はパーサーがツール呼び出しのマークアップとして消費したテキストの範囲（バイト位置、End は含まない）
+type Span struct {
+	Start int
+	End   int
+}
+
+// ToolCallParser はツール呼び出しの形式ごとのパーサー
+type ToolCallParser interface {
+	// Name はパーサーの識別名（設定ファイルや環境変数で指定する名前。例: "hermes-2-pro"）
+	Name() string
+	// Detect はテキストがこの形式のツール呼び出しを含みうるかを安価に判定する（false の場合 Parse は呼ばれない）
+	Detect(text string) bool
+	// Parse はテキストからツール呼び出しを抽出し、消費したマークアップの範囲とともに返す（見つからない場合は nil）
+	Parse(text string) ([]ToolCall, []Span)
+}
+
+// StreamTriggerer はストリーミング時の保留開始となる文字列を提供するパーサーが任意で実装するインターフェース
+// 組み込みパーサーのトリガーはストリーミング処理側で定義済みのため、独自パーサーのみが実装すればよい
+type StreamTriggerer interface {
+	StreamTriggers() []string
+}
+
+// DebugLog はパーサー内部のデバッグログの出力先（main で logDebug を設定する）
+var DebugLog = func(section string, data map[string]any) {}
+
+func logDebug(section string, data map[string]any) {
+	DebugLog(section, data)
+}
+
+// registryEntry はレジストリに登録されたパーサーと優先度
+type registryEntry struct {
+	parser   ToolCallParser
+	priority int
+	seq      int // 同じ優先度の場合は登録順
+}
+
+// Registry は優先度付きのパーサー一覧
+type Registry struct {
+	mu      sync.RWMutex
+	entries []registryEntry
+	active  []ToolCallParser // Configure で並び替え・無効化を反映した抽出順（nil の場合は優先度順のすべて）
+}
+
+// NewRegistry は空のレジストリを作成する
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default は組み込みパーサーが登録されるレジストリ
+var Default = NewRegistry()
+
+// Register は Default レジストリにパーサーを登録する（優先度は小さいほど先に試される）
+// 同じ名前のパーサーが登録済みの場合は panic する（init 時の登録ミスを早期に検出するため）
+func Register(p ToolCallParser, priority int) {
+	if err := Default.Register(p, priority); err != nil {
+		panic(err)
+	}
+}
+
+// Register はパーサーを登録する（優先度は小さいほど先に試される）
+func (r *Registry) Register(p ToolCallParser, priority int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.entries {
+		if e.parser.Name() == p.Name() {
+			return fmt.Errorf("tool call parser %q is already registered", p.Name())
+		}
+	}
+	r.entries = append(r.entries, registryEntry{parser: p, priority: priority, seq: len(r.entries)})
+	sort.SliceStable(r.entries, func(i, j int) bool {
+		if r.entries[i].priority != r.entries[j].priority {
+			return r.entries[i].priority < r.entries[j].priority
+		}
+		return r.entries[i].seq < r.entries[j].seq
+	})
+	r.active = nil // 登録が変わったため設定による並び順は無効にする
+	return nil
+}
+
+// Lookup は名前からパーサーを探す
+func (r *Registry) Lookup(name string) (ToolCallParser, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, e := range r.entries {
+		if e.parser.Name() == name {
+			return e.parser, true
+		}
+	}
+	return nil, false
+}
+
+// Names は登録済みのパーサー名を優先度順に返す
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, len(r.entries))
+	for i, e := range r.entries {
+		names[i] = e.parser.Name()
+	}
+	return names
+}
+
+// Configure は抽出に使うパーサーの並び順と無効化を設定する
+// order に書いたパーサーを先頭にその順で並べ、残りは優先度順に続ける。disabled に書いたパーサーは使わない
+// 未登録の名前が含まれる場合はエラーを返す
+func (r *Registry) Configure(order, disabled []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	find := func(name string) (ToolCallParser, bool) {
+		for _, e := range r.entries {
+			if e.parser.Name() == name {
+				return e.parser, true
+			}
+		}
+		return nil, false
+	}
+
+	skip := make(map[string]bool)
+	for _, name := range disabled {
+		if _, ok := find(name); !ok {
+			return fmt.Errorf("unknown tool call parser %q (available: %s)", name, strings.Join(r.namesLocked(), ", "))
+		}
+		skip[name] = true
+	}
+
+	var active []ToolCallParser
+	placed := make(map[string]bool)
+	for _, name := range order {
+		p, ok := find(name)
+		if !ok {
+			return fmt.Errorf("unknown tool call parser %q (available: %s)", name, strings.Join(r.namesLocked(), ", "))
+		}
+		if skip[name] || placed[name] {
+			continue
+		}
+		active = append(active, p)
+		placed[name] = true
+	}
+	for _, e := range r.entries {
+		name := e.parser.Name()
+		if skip[name] || placed[name] {
+			continue
+		}
+		active = append(active, e.parser)
+	}
+	r.active = active
+	return nil
+}
+
+func (r *Registry) namesLocked() []string {
+	names := make([]string, len(r.entries))
+	for i, e := range r.entries {
+		names[i] = e.parser.Name()
+	}
+	return names
+}
+
+// Parsers は抽出に使うパーサーを試す順に返す
+func (r *Registry) Parsers() []ToolCallParser {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.active != nil {
+		return append([]ToolCallParser(nil), r.active...)
+	}
+	parsers := make([]ToolCallParser, len(r.entries))
+	for i, e := range r.entries {
+		parsers[i] = e.parser
+	}
+	return parsers
+}
+
+// DefaultChainName はパーサー名のリスト中で、残りの標準の抽出順（Parsers）に展開される名前
+const DefaultChainName = "default"
+
+// Chain はパーサー名のリストを、試す順に並んだパーサーに変換する
+// "default" はリスト中でまだ挙げていないパーサーを標準の抽出順（並び替え・無効化を反映済み）で展開する
+// 名前で明示したパーサーは、TOOL_PARSER_DISABLE で無効化されていても使われる
+func (r *Registry) Chain(names []string) ([]ToolCallParser, error) {
+	// 先に明示された名前を確認する（"default" の展開時に除外するため）
+	explicit := make(map[string]bool)
+	for _, name := range names {
+		if name == DefaultChainName {
+			continue
+		}
+		if _, ok := r.Lookup(name); !ok {
+			return nil, fmt.Errorf("unknown tool call parser %q (available: %s)", name, strings.Join(r.Names(), ", "))
+		}
+		explicit[name] = true
+	}
+
+	var chain []ToolCallParser
+	placed := make(map[string]bool)
+	for _, name := range names {
+		if name == DefaultChainName {
+			for _, p := range r.Parsers() {
+				if !explicit[p.Name()] && !placed[p.Name()] {
+					chain = append(chain, p)
+					placed[p.Name()] = true
+				}
+			}
+			continue
+		}
+		if !placed[name] {
+			p, _ := r.Lookup(name)
+			chain = append(chain, p)
+			placed[name] = true
+		}
+	}
+	return chain, nil
+}
+
+// StreamTriggers は StreamTriggerer を実装したパーサーが提供するトリガー文字列をまとめて返す
+func (r *Registry) StreamTriggers() []string {
+	var triggers []string
+	for _, p := range r.Parsers() {
+		if t, ok := p.(StreamTriggerer); ok {
+			triggers = append(triggers, t.StreamTriggers()...)
+		}
+	}
+	return triggers
+}
+
+// Extract はレジストリのパーサーを順に試し、最初に見つかったツール呼び出しと、検出したパーサーの名前を返す
+func (r *Registry) Extract(text string) ([]ToolCall, []Span, string) {
+	return Extract(r.Parsers(), text)
+}
+
+// Extract は指定したパーサーを順に試し、最初に見つかったツール呼び出しと、検出したパーサーの名前を返す
+func Extract(parsers []ToolCallParser, text string) ([]ToolCall, []Span, string) {
+	for _, p := range parsers {
+		if !p.Detect(text) {
+			continue
+		}
+		if calls, spans := p.Parse(text); len(calls) > 0 {
+			return calls, spans, p.Name()
+		}
+	}
+	return nil, nil, ""
+}
@@ -0,0 +1,293 @@
+package parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubParser は marker を含むテキストから、marker 自体を呼び出しとして返すテスト用のパーサー
+type stubParser struct {
+	name   string
+	marker string
+}
+
+func (p stubParser) Name() string            { return p.name }
+func (p stubParser) Detect(text string) bool { return strings.Contains(text, p.marker) }
+func (p stubParser) Parse(text string) ([]ToolCall, []Span) {
+	idx := strings.Index(text, p.marker)
+	if idx == -1 {
+		return nil, nil
+	}
+	return []ToolCall{{Type: "function", Function: ToolCallFunction{Name: p.name, Arguments: "{}"}}},
+		[]Span{{Start: idx, End: idx + len(p.marker)}}
+}
+
+// parserNames はパーサーの名前を並び順のまま返す
+func parserNames(parsers []ToolCallParser) []string {
+	names := make([]string, len(parsers))
+	for i, p := range parsers {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+// newStubRegistry は a(100), b(200), c(100), d(300) を登録したレジストリを作成する
+func newStubRegistry(t *testing.T) *Registry {
+	t.Helper()
+	r := NewRegistry()
+	for _, e := range []struct {
+		name     string
+		priority int
+	}{{"a", 100}, {"b", 200}, {"c", 100}, {"d", 300}} {
+		if err := r.Register(stubParser{name: e.name, marker: "<" + e.name + ">"}, e.priority); err != nil {
+			t.Fatalf("Register(%s): %v", e.name, err)
+		}
+	}
+	return r
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := newStubRegistry(t)
+	// 優先度の小さい順、同じ優先度は登録順
+	if got, want := r.Names(), []string{"a", "c", "b", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+	if err := r.Register(stubParser{name: "b"}, 50); err == nil {
+		t.Error("Register of a duplicate name should fail")
+	}
+	if _, ok := r.Lookup("c"); !ok {
+		t.Error("Lookup(c) should find the parser")
+	}
+	if _, ok := r.Lookup("missing"); ok {
+		t.Error("Lookup(missing) should not find a parser")
+	}
+}
+
+func TestRegistryConfigure(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    []string
+		disabled []string
+		want     []string
+		wantErr  bool
+	}{
+		{"no settings", nil, nil, []string{"a", "c", "b", "d"}, false},
+		{"order moves parsers to the front", []string{"d", "b"}, nil, []string{"d", "b", "a", "c"}, false},
+		{"disabled parsers are removed", nil, []string{"c"}, []string{"a", "b", "d"}, false},
+		{"disabled wins over order", []string{"c", "d"}, []string{"c"}, []string{"d", "a", "b"}, false},
+		{"duplicates in order are ignored", []string{"b", "b"}, nil, []string{"b", "a", "c", "d"}, false},
+		{"unknown name in order", []string{"x"}, nil, nil, true},
+		{"unknown name in disabled", nil, []string{"x"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newStubRegistry(t)
+			err := r.Configure(tt.order, tt.disabled)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Configure error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := parserNames(r.Parsers()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parsers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		want    []string
+		wantErr bool
+	}{
+		{"explicit only", []string{"d", "a"}, []string{"d", "a"}, false},
+		{"default expands the rest", []string{"b", "default"}, []string{"b", "a", "d"}, false},
+		{"default in the middle skips later explicit names", []string{"d", "default", "a"}, []string{"d", "b", "a"}, false},
+		{"explicit disabled parser is still used", []string{"c"}, []string{"c"}, false},
+		{"default respects disabled", []string{"default"}, []string{"a", "b", "d"}, false},
+		{"unknown name", []string{"x", "default"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newStubRegistry(t)
+			// c は無効化しておく（default の展開には含まれないが、名前で明示すれば使われる）
+			if err := r.Configure(nil, []string{"c"}); err != nil {
+				t.Fatal(err)
+			}
+			chain, err := r.Chain(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Chain error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := parserNames(chain); !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Chain(%v) = %v, want %v", tt.names, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractUsesFirstParserWithCalls(t *testing.T) {
+	r := newStubRegistry(t)
+	text := "x <b> y <d>"
+	calls, spans, name := r.Extract(text)
+	if name != "b" || len(calls) != 1 {
+		t.Fatalf("Extract = %v, %q; want one call from b", calls, name)
+	}
+	if got := text[spans[0].Start:spans[0].End]; got != "<b>" {
+		t.Errorf("span = %q, want %q", got, "<b>")
+	}
+	if calls, _, name := r.Extract("no markup"); calls != nil || name != "" {
+		t.Errorf("Extract without markup = %v, %q; want nothing", calls, name)
+	}
+}
+
+func TestBuiltinPriorities(t *testing.T) {
+	names := Default.Names()
+	index := map[string]int{}
+	for i, name := range names {
+		index[name] = i
+	}
+	// モデルファミリー別 → 標準形式 → ジェネリック、<tool_call> の派生は hermes-2-pro より先
+	for _, pair := range [][2]string{
+		{"deepseek-v3.1", "deepseek-r1"},
+		{"glm-4.5", "hermes-2-pro"},
+		{"qwen3-coder", "hermes-2-pro"},
+		{"mistral-nemo", "xml"},
+		{"xml", "json"},
+		{"markdown-json", "generic"},
+	} {
+		if index[pair[0]] >= index[pair[1]] {
+			t.Errorf("%s should be tried before %s (order: %v)", pair[0], pair[1], names)
+		}
+	}
+	if names[len(names)-1] != "generic" {
+		t.Errorf("generic should be the last parser, got %s", names[len(names)-1])
+	}
+}
+
+// wantCall は抽出された呼び出しの関数名と引数（JSON として比較する）
+type wantCall struct {
+	name string
+	args string
+}
+
+func TestBuiltinParserFixtures(t *testing.T) {
+	tests := []struct {
+		parser string
+		text   string
+		calls  []wantCall
+		spans  []string // 消費したマークアップ（text の部分文字列そのもの）
+	}{
+		{
+			parser: "xml",
+			text:   "Let me check.\n<function_calls>\n<invoke name=\"get_weather\">\n<parameter name=\"city\">Tokyo</parameter>\n<parameter name=\"days\">3</parameter>\n</invoke>\n<invoke name=\"get_time\">\n</invoke>\n</function_calls>\nDone.",
+			calls:  []wantCall{{"get_weather", `{"city":"Tokyo","days":3}`}, {"get_time", `{}`}},
+			spans:  []string{"<function_calls>\n<invoke name=\"get_weather\">\n<parameter name=\"city\">Tokyo</parameter>\n<parameter name=\"days\">3</parameter>\n</invoke>\n<invoke name=\"get_time\">\n</invoke>\n</function_calls>"},
+		},
+		{
+			parser: "hermes-2-pro",
+			text:   "Sure.\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Tokyo\"}}\n</tool_call>\nBye",
+			calls:  []wantCall{{"get_weather", `{"city":"Tokyo"}`}},
+			spans:  []string{"<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Tokyo\"}}\n</tool_call>"},
+		},
+		{
+			parser: "llama-3.x",
+			text:   "Calling: {\"name\": \"get_weather\", \"parameters\": {\"city\": \"Tokyo\"}} ok",
+			calls:  []wantCall{{"get_weather", `{"city":"Tokyo"}`}},
+			spans:  []string{"{\"name\": \"get_weather\", \"parameters\": {\"city\": \"Tokyo\"}}"},
+		},
+		{
+			parser: "mistral-nemo",
+			text:   "Hi [TOOL_CALLS][{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Tokyo\"}}, {\"name\": \"get_time\", \"arguments\": {}}]",
+			calls:  []wantCall{{"get_weather", `{"city":"Tokyo"}`}, {"get_time", `{}`}},
+			spans:  []string{"[TOOL_CALLS][{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Tokyo\"}}, {\"name\": \"get_time\", \"arguments\": {}}]"},
+		},
+		{
+			parser: "qwen3-coder",
+			text:   "Sure\n<tool_call>\n<function=get_weather>\n<parameter=city>\nTokyo\n</parameter>\n<parameter=days>\n3\n</parameter>\n</function>\n</tool_call>\nafter",
+			calls:  []wantCall{{"get_weather", `{"city":"Tokyo","days":3}`}},
+			spans:  []string{"<tool_call>\n<function=get_weather>\n<parameter=city>\nTokyo\n</parameter>\n<parameter=days>\n3\n</parameter>\n</function>\n</tool_call>"},
+		},
+		{
+			parser: "qwen3-coder",
+			text:   "<tool_call><function>get_weather</function><parameter>city=Tokyo</parameter></tool_call> tail",
+			calls:  []wantCall{{"get_weather", `{"city":"Tokyo"}`}},
+			spans:  []string{"<tool_call><function>get_weather</function><parameter>city=Tokyo</parameter></tool_call>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.parser, func(t *testing.T) {
+			p, ok := Default.Lookup(tt.parser)
+			if !ok {
+				t.Fatalf("parser %q is not registered", tt.parser)
+			}
+			if !p.Detect(tt.text) {
+				t.Fatalf("%s did not detect the fixture", tt.parser)
+			}
+			calls, spans := p.Parse(tt.text)
+			assertCalls(t, calls, tt.calls)
+			assertSpans(t, tt.text, spans, tt.spans)
+
+			// 標準の抽出順でも同じパーサーが選ばれる
+			if _, _, name := Extract(Default.Parsers(), tt.text); name != tt.parser {
+				t.Errorf("Extract with the default order picked %q, want %q", name, tt.parser)
+			}
+		})
+	}
+}
+
+func TestBuiltinParsersIgnoreProse(t *testing.T) {
+	for _, text := range []string{
+		"just prose, no calls",
+		"a set {like this} is not a call",
+		"<tool_call> without a body",
+	} {
+		if calls, _, name := Extract(Default.Parsers(), text); len(calls) > 0 {
+			t.Errorf("Extract(%q) = %v from %s, want no calls", text, calls, name)
+		}
+	}
+}
+
+func assertCalls(t *testing.T, got []ToolCall, want []wantCall) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d calls %v, want %d", len(got), got, len(want))
+	}
+	for i, w := range want {
+		if got[i].Function.Name != w.name {
+			t.Errorf("call %d name = %q, want %q", i, got[i].Function.Name, w.name)
+		}
+		var gotArgs, wantArgs any
+		if err := json.Unmarshal([]byte(got[i].Function.Arguments), &gotArgs); err != nil {
+			t.Errorf("call %d arguments are not JSON: %q", i, got[i].Function.Arguments)
+			continue
+		}
+		_ = json.Unmarshal([]byte(w.args), &wantArgs)
+		if !reflect.DeepEqual(gotArgs, wantArgs) {
+			t.Errorf("call %d arguments = %s, want %s", i, got[i].Function.Arguments, w.args)
+		}
+		if got[i].ID == "" || got[i].Type != "function" {
+			t.Errorf("call %d id/type = %q/%q", i, got[i].ID, got[i].Type)
+		}
+	}
+}
+
+func assertSpans(t *testing.T, text string, got []Span, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d spans %v, want %d", len(got), got, len(want))
+	}
+	for i, w := range want {
+		if got[i].Start < 0 || got[i].End > len(text) || got[i].Start >= got[i].End {
+			t.Errorf("span %d out of range: %+v", i, got[i])
+			continue
+		}
+		if s := text[got[i].Start:got[i].End]; s != w {
+			t.Errorf("span %d = %q, want %q", i, s, w)
+		}
+	}
+}
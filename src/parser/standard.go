@@ -0,0 +1,309 @@
+/**
+ * standard.go
+ *
+ * 標準形式（TCGW形式）とジェネリックのパーサー
+ * システムプロンプトで指示する <function_calls> 形式と、JSON・Markdown JSON・汎用JSONのフォールバックを扱う。
+ */
+package parser
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// --- 標準形式（TCGW形式）のパターン ---
+const (
+	FUNCTION_CALLS_PATTERN = `<function_calls>([\s\S]*?)</function_calls>`
+	INVOKE_PATTERN         = `<invoke\s+name="([^"]+)">([\s\S]*?)</invoke>`
+	PARAMETER_PATTERN      = `<parameter\s+name="([^"]+)">([\s\S]*?)</parameter>`
+	JSON_PATTERN           = `\{[^{}]*"tool_calls"[^{}]*\}`
+	MARKDOWN_JSON_PATTERN  = `(?s)` + "`" + `(?:json)?\s*([^` + "`" + `]+)` + "`" + `(?:` + "`" + `|$)`
+)
+
+// --- 正規表現 (グローバルコンパイル) ---
+// パフォーマンス向上のため、正規表現を起動時に一度だけコンパイルします
+var (
+	reFunctionCalls = regexp.MustCompile(FUNCTION_CALLS_PATTERN)
+	reInvoke        = regexp.MustCompile(INVOKE_PATTERN)
+	reParameter     = regexp.MustCompile(PARAMETER_PATTERN)
+	reJSON          = regexp.MustCompile(JSON_PATTERN)
+	reMarkdownJSON  = regexp.MustCompile(MARKDOWN_JSON_PATTERN)
+)
+
+// XML形式のツール呼び出し抽出
+func extractXMLToolCalls(text string) ([]ToolCall, []Span) {
+	loc := reFunctionCalls.FindStringIndex(text)
+	if loc == nil {
+		return nil, nil
+	}
+	fc := text[loc[0]:loc[1]]
+	matches := reInvoke.FindAllStringSubmatch(fc, -1)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	var toolCalls []ToolCall
+	for _, m := range matches {
+		if len(m) < 3 { // m[0]=full, m[1]=name, m[2]=inner
+			continue
+		}
+		toolName := m[1]
+		inner := m[2]
+		paramMatches := reParameter.FindAllStringSubmatch(inner, -1)
+		params := map[string]any{}
+		raw := map[string]string{}
+		for _, pm := range paramMatches {
+			if len(pm) >= 3 { // pm[0]=full, pm[1]=name, pm[2]=value
+				// パラメータ値のXMLエスケープを解除 (例: &apos; -> ')
+				raw[pm[1]] = unescapeXML(pm[2])
+				// 型の推定はスキーマがない場合のフォールバック（スキーマがある場合は main で raw から型を決め直す）
+				params[pm[1]] = inferType(raw[pm[1]])
+			}
+		}
+		paramsJSON, _ := json.Marshal(params)
+		toolCalls = append(toolCalls, ToolCall{
+			ID:   generateToolCallID(),
+			Type: "function",
+			Function: ToolCallFunction{
+				Name:      toolName,
+				Arguments: string(paramsJSON),
+			},
+			RawArguments: raw,
+		})
+	}
+	return toolCalls, []Span{{Start: loc[0], End: loc[1]}}
+}
+
+// JSON形式のツール呼び出し抽出 (フォールバック)
+func extractJSONToolCalls(text string) ([]ToolCall, []Span) {
+	loc := reJSON.FindStringIndex(text)
+	if loc == nil {
+		return nil, nil
+	}
+	j := text[loc[0]:loc[1]]
+	var data map[string]any
+	if err := json.Unmarshal([]byte(j), &data); err != nil {
+		return nil, nil
+	}
+	tcs, ok := data["tool_calls"].([]any)
+	if !ok {
+		return nil, nil
+	}
+	var toolCalls []ToolCall
+	for _, v := range tcs {
+		tc, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		id, _ := tc["id"].(string)
+		if id == "" {
+			id = generateToolCallID()
+		}
+		fn, ok := tc["function"].(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := fn["name"].(string)
+		args, _ := fn["arguments"].(string)
+		toolCalls = append(toolCalls, ToolCall{ID: id, Type: "function", Function: ToolCallFunction{Name: name, Arguments: args}})
+	}
+	if len(toolCalls) == 0 {
+		return nil, nil
+	}
+	return toolCalls, []Span{{Start: loc[0], End: loc[1]}}
+}
+
+// Markdown JSON形式のツール呼び出し抽出 (フォールバック)
+func extractMarkdownToolCalls(text string) ([]ToolCall, []Span) {
+	ms := reMarkdownJSON.FindAllStringSubmatchIndex(text, -1)
+	for _, m := range ms {
+		if len(m) >= 4 { // m[0:2]=full, m[2:4]=json_content
+			var data map[string]any
+			if err := json.Unmarshal([]byte(text[m[2]:m[3]]), &data); err != nil {
+				continue
+			}
+			tcs, ok := data["tool_calls"].([]any)
+			if !ok {
+				continue
+			}
+			var toolCalls []ToolCall
+			for _, v := range tcs {
+				tc, ok := v.(map[string]any)
+				if !ok {
+					continue
+				}
+				id, _ := tc["id"].(string)
+				if id == "" {
+					id = generateToolCallID()
+				}
+				fn, ok := tc["function"].(map[string]any)
+				if !ok {
+					continue
+				}
+				name, _ := fn["name"].(string)
+				args, _ := fn["arguments"].(string)
+				toolCalls = append(toolCalls, ToolCall{ID: id, Type: "function", Function: ToolCallFunction{Name: name, Arguments: args}})
+			}
+			if len(toolCalls) > 0 {
+				// 正規表現はフェンスの一部のみにマッチするため、前後に連続するバッククォートも範囲に含める
+				span := Span{Start: m[0], End: m[1]}
+				for span.Start > 0 && text[span.Start-1] == '`' {
+					span.Start--
+				}
+				for span.End < len(text) && text[span.End] == '`' {
+					span.End++
+				}
+				return toolCalls, []Span{span}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// extractGenericToolCalls は汎用的なJSONベースのツール呼び出しを抽出
+// 様々なフォーマットのJSONから toolcalls/toolcall フィールドを探索
+func extractGenericToolCalls(text string) ([]ToolCall, []Span) {
+	// JSON全体をパース試行
+	offset := len(text) - len(strings.TrimLeftFunc(text, unicode.IsSpace)) // トリムした先頭空白の長さ（位置計算用）
+	text = strings.TrimSpace(text)
+
+	// JSONブロックの抽出（中括弧で始まる部分）
+	jsonStart := strings.Index(text, "{")
+	if jsonStart == -1 {
+		return nil, nil
+	}
+
+	// 最後の閉じ括弧を探す
+	jsonEnd := strings.LastIndex(text, "}")
+	if jsonEnd == -1 || jsonEnd <= jsonStart {
+		return nil, nil
+	}
+
+	jsonStr := text[jsonStart : jsonEnd+1]
+
+	var data map[string]any
+	if err := json.Unmarshal([]byte(jsonStr), &data); err != nil {
+		return nil, nil
+	}
+
+	var toolCalls []ToolCall
+	spans := []Span{{Start: offset + jsonStart, End: offset + jsonEnd + 1}}
+
+	// パターン1: "toolcalls" 配列フィールド
+	if toolCallsArray, ok := data["toolcalls"].([]any); ok {
+		for _, tc := range toolCallsArray {
+			if tcMap, ok := tc.(map[string]any); ok {
+				if toolCall := parseGenericToolCallObject(tcMap); toolCall != nil {
+					toolCalls = append(toolCalls, *toolCall)
+				}
+			}
+		}
+		if len(toolCalls) > 0 {
+			logDebug("Generic Tool Calls Detected", map[string]any{
+				"Pattern": "toolcalls array",
+				"Count":   len(toolCalls),
+			})
+			return toolCalls, spans
+		}
+	}
+
+	// パターン2: "tool_calls" 配列フィールド（アンダースコア付き）
+	if toolCallsArray, ok := data["tool_calls"].([]any); ok {
+		for _, tc := range toolCallsArray {
+			if tcMap, ok := tc.(map[string]any); ok {
+				if toolCall := parseGenericToolCallObject(tcMap); toolCall != nil {
+					toolCalls = append(toolCalls, *toolCall)
+				}
+			}
+		}
+		if len(toolCalls) > 0 {
+			logDebug("Generic Tool Calls Detected", map[string]any{
+				"Pattern": "tool_calls array",
+				"Count":   len(toolCalls),
+			})
+			return toolCalls, spans
+		}
+	}
+
+	// パターン3: "toolcall" 単一オブジェクト
+	if toolCallObj, ok := data["toolcall"].(map[string]any); ok {
+		if toolCall := parseGenericToolCallObject(toolCallObj); toolCall != nil {
+			logDebug("Generic Tool Call Detected", map[string]any{
+				"Pattern":  "toolcall object",
+				"Function": toolCall.Function.Name,
+			})
+			return []ToolCall{*toolCall}, spans
+		}
+	}
+
+	// パターン4: "tool_call" 単一オブジェクト（アンダースコア付き）
+	if toolCallObj, ok := data["tool_call"].(map[string]any); ok {
+		if toolCall := parseGenericToolCallObject(toolCallObj); toolCall != nil {
+			logDebug("Generic Tool Call Detected", map[string]any{
+				"Pattern":  "tool_call object",
+				"Function": toolCall.Function.Name,
+			})
+			return []ToolCall{*toolCall}, spans
+		}
+	}
+
+	// パターン5: "response" フィールド（llama.cpp互換）
+	if response, ok := data["response"]; ok {
+		// responseフィールドがある場合、これはコンテンツであってツール呼び出しではない
+		// TCGWはツール呼び出し抽出専用なので、nilを返す
+		logDebug("Generic Parser: response field detected (not a tool call)", map[string]any{
+			"Response": response,
+		})
+		return nil, nil
+	}
+
+	return nil, nil
+}
+
+// parseGenericToolCallObject は汎用的なツール呼び出しオブジェクトをパース
+func parseGenericToolCallObject(obj map[string]any) *ToolCall {
+	// 関数名の取得（複数のフィールド名に対応）
+	var functionName string
+	for _, key := range []string{"name", "function", "function_name", "tool", "tool_name"} {
+		if name, ok := obj[key].(string); ok && name != "" {
+			functionName = name
+			break
+		}
+	}
+
+	if functionName == "" {
+		return nil
+	}
+
+	// 引数の取得（複数のフィールド名に対応）
+	var argsBytes []byte
+	for _, key := range []string{"arguments", "args", "parameters", "params", "input"} {
+		if args, exists := obj[key]; exists {
+			if argsMap, ok := args.(map[string]any); ok {
+				argsBytes, _ = json.Marshal(argsMap)
+				break
+			} else if argsStr, ok := args.(string); ok {
+				// 文字列の場合、JSONとしてパース試行
+				var argsMap map[string]any
+				if err := json.Unmarshal([]byte(argsStr), &argsMap); err == nil {
+					argsBytes = []byte(argsStr)
+				} else {
+					// JSONでない場合は空オブジェクト
+					argsBytes = []byte("{}")
+				}
+				break
+			}
+		}
+	}
+
+	if argsBytes == nil {
+		argsBytes = []byte("{}")
+	}
+
+	return &ToolCall{
+		ID:       generateToolCallID(),
+		Type:     "function",
+		Function: ToolCallFunction{Name: functionName, Arguments: string(argsBytes)},
+	}
+}
@@ -0,0 +1,49 @@
+/**
+ * util.go
+ *
+ * パーサー共通のヘルパー関数（ツール呼び出しIDの生成、XMLエスケープの解除、パラメータ値の型推定）
+ */
+package parser
+
+import (
+	cryptorand "crypto/rand"
+	"strconv"
+	"strings"
+)
+
+func generateToolCallID() string {
+	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 8)
+	for i := range b {
+		// crypto/randを使用してより安全なランダム生成
+		var randomByte [1]byte
+		_, _ = cryptorand.Read(randomByte[:])
+		b[i] = charset[int(randomByte[0])%len(charset)]
+	}
+	return "call_" + string(b)
+}
+
+func unescapeXML(s string) string {
+	s = strings.ReplaceAll(s, "&apos;", "'")
+	s = strings.ReplaceAll(s, "&quot;", "\"")
+	s = strings.ReplaceAll(s, "&gt;", ">")
+	s = strings.ReplaceAll(s, "&lt;", "<")
+	s = strings.ReplaceAll(s, "&amp;", "&")
+	return s
+}
+
+func inferType(value string) any {
+	if value == "true" || value == "false" {
+		return value == "true"
+	}
+	if strings.Contains(value, ".") {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// 32bit環境での安全性を考慮し、int(i) ではなく int64(i) を返す
+		return int64(i)
+	}
+	return value
+}
@@ -0,0 +1,206 @@
+/**
+ * passthrough.go
+ *
+ * ネイティブ Tool Calling（パススルー）
+ * - パススルーのポート（PASSTHROUGH_PORT、既定 3001）: すべてのリクエストを手を加えずに Bifrost へ転送し、応答（SSE を含む）をそのまま返す。
+ *   tools もそのまま送るため、ネイティブの Tool Calling に対応したモデルはバックエンドの機能で呼び出しを返す。
+ * - 自動モード（ROUTING_MODE=auto）: エミュレートのポートの /v1/chat/completions で、モデルごとの tool_calling（MODEL_CONFIG_FILE）を見て、
+ *   "native" のモデルはパススルーと同じく Bifrost にそのまま転送し、それ以外のモデルはエミュレートする。
+ *   クライアントは1つのURLだけを使い、ネイティブ対応のモデルはツール定義のプロンプト埋め込みを経由しない。
+ * どちらの処理をしたかは X-TCGW-Routing ヘッダー（"native" / "emulated"）で返す。
+ */
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/t-kawata/tcgw/config"
+)
+
+// リクエストの振り分け方（ROUTING_MODE）
+const (
+	routingEmulate = "emulate" // すべてのモデルをエミュレートする
+	routingAuto    = "auto"    // モデルごとの tool_calling でネイティブ・エミュレートを選ぶ
+)
+
+// モデルごとのツール呼び出しの扱い（tool_calling）
+const (
+	toolCallingEmulated = "emulated" // プロンプトに埋め込んでエミュレートする
+	toolCallingNative   = "native"   // バックエンドの Tool Calling をそのまま使う
+)
+
+// headerRouting はリクエストをネイティブ・エミュレートのどちらで処理したかを返すヘッダー
+const headerRouting = "X-TCGW-Routing"
+
+// validRoutingMode は ROUTING_MODE として有効な値か
+func validRoutingMode(mode string) bool {
+	return mode == routingEmulate || mode == routingAuto
+}
+
+// validToolCalling は tool_calling として有効な値か
+func validToolCalling(mode string) bool {
+	return mode == toolCallingEmulated || mode == toolCallingNative
+}
+
+// nativeRoutedEndpoints は自動モードで tool_calling: "native" のモデルを Bifrost へそのまま転送するエンドポイント
+// Anthropic Messages API・Responses API・Gemini API・Ollama API は形式の変換が必要なため、native のモデルでも常にエミュレートする
+var nativeRoutedEndpoints = []string{"/v1/chat/completions"}
+
+// resolveToolCalling はエミュレートのポートでモデルに適用されるツール呼び出しの扱い
+// 自動モードでない場合は、モデル設定にかかわらず常にエミュレートする
+func resolveToolCalling(modelConfig config.ModelConfig) string {
+	if routingMode == routingAuto && modelConfig.ToolCalling == toolCallingNative {
+		return toolCallingNative
+	}
+	return toolCallingEmulated
+}
+
+// hopByHopHeaders は転送しないヘッダー（接続ごとのヘッダー）
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Host":                true,
+	"Content-Length":      true,
+}
+
+// proxyToBifrost はリクエストを Bifrost の同じパス（クエリを含む）へ転送し、応答をそのままクライアントへ返す
+// SSE などの逐次的な応答も、受け取ったそばからクライアントへ書き出してフラッシュする
+// BIFROST_API_KEY を設定した場合は Authorization をそのキーに置き換え、それ以外のヘッダーはそのまま送る
+// contentLength は body の長さ（-1 は不明）
+func proxyToBifrost(c *gin.Context, body io.Reader, contentLength int64) {
+	target := bifrostURL + c.Request.URL.Path
+	if c.Request.URL.RawQuery != "" {
+		target += "?" + c.Request.URL.RawQuery
+	}
+	logDebug("Forwarding to Bifrost (Passthrough)", map[string]any{
+		"Method": c.Request.Method,
+		"URL":    target,
+	})
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(requestTimeout)*time.Millisecond)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, c.Request.Method, target, body)
+	if err != nil {
+		c.JSON(500, map[string]any{"error": map[string]any{"message": fmt.Sprintf("Internal error: failed to create request: %v", err), "type": "server_error"}})
+		return
+	}
+	httpReq.ContentLength = contentLength
+	for key, values := range c.Request.Header {
+		if hopByHopHeaders[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		httpReq.Header[key] = values
+	}
+	if bifrostApiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+bifrostApiKey)
+	}
+
+	resp, err := (&http.Client{}).Do(httpReq)
+	if err != nil {
+		errBody, ferr := bifrostTransportError(err)
+		code := 500
+		if s, err := strconv.Atoi(ferr.Error()); err == nil {
+			code = s
+		}
+		logDebug("Bifrost Response Error", errBody)
+		c.JSON(code, errBody)
+		return
+	}
+	defer resp.Body.Close()
+
+	logDebug("Bifrost Response Received (Passthrough)", map[string]any{
+		"Status Code":  resp.StatusCode,
+		"Content Type": resp.Header.Get("Content-Type"),
+	})
+
+	for key, values := range resp.Header {
+		if hopByHopHeaders[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		c.Writer.Header()[key] = values
+	}
+	c.Status(resp.StatusCode)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := c.Writer.Write(buf[:n]); werr != nil {
+				return // クライアント切断
+			}
+			c.Writer.Flush()
+		}
+		if err != nil {
+			if err != io.EOF {
+				logDebug("Passthrough Stream Error", map[string]any{"Error": err.Error()})
+			}
+			return
+		}
+	}
+}
+
+// パススルー: どのパスのリクエストも手を加えずに Bifrost へ転送する
+func handlePassthrough(c *gin.Context) {
+	c.Header(headerRouting, toolCallingNative)
+	proxyToBifrost(c, c.Request.Body, c.Request.ContentLength)
+}
+
+// 自動モードの /v1/chat/completions: モデルの tool_calling が "native" の場合は Bifrost にそのまま転送し、それ以外はエミュレートする
+// 別名（aliases）を指定した場合は、ネイティブでも model のみ Bifrost のモデル名に置き換える
+func handleChatCompletionsAuto(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(400, ErrorResponse{Error: ErrorDetail{
+			Message: fmt.Sprintf("Failed to read request body: %v", err),
+			Type:    "invalid_request_error",
+			Code:    stringPtr("invalid_request"),
+		}})
+		return
+	}
+
+	// JSON として読めない場合はエミュレートの処理に任せる（同じ 400 エラーを返すため）
+	var peek map[string]any
+	if json.Unmarshal(body, &peek) == nil {
+		requested, _ := peek["model"].(string)
+		model, aliased := modelSettings.ResolveAlias(requested)
+		if resolveToolCalling(modelSettings.Resolve(model)) == toolCallingNative {
+			logDebug("Routing: Native Tool Calling", map[string]any{"Model": model})
+			if aliased {
+				peek["model"] = model
+				if replaced, err := json.Marshal(peek); err == nil {
+					body = replaced
+				}
+			}
+			c.Header(headerRouting, toolCallingNative)
+			proxyToBifrost(c, bytes.NewReader(body), int64(len(body)))
+			return
+		}
+	}
+
+	c.Header(headerRouting, toolCallingEmulated)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	handleChatCompletionsEmulate(c)
+}
+
+// newPassthroughRouter はパススルーのポートのルーターを作成する（/health 以外のすべてのパスを転送する）
+func newPassthroughRouter() *gin.Engine {
+	router := gin.Default()
+	router.Use(cors.Default())
+	router.GET("/health", handleHealthCheck)
+	router.NoRoute(handlePassthrough)
+	return router
+}
@@ -0,0 +1,183 @@
+/**
+ * promptprofile.go
+ *
+ * テンプレートによるシステムプロンプトのプロファイル
+ * 従来はツール定義プロンプト（TOOL_SYSTEM_PROMPT）が定数で、回答言語（日本語）や例文を変えるには再コンパイルが必要だった。
+ * プロンプトを text/template のプロファイルとして名前で管理し、PROMPT_PROFILE_DIR のファイルから追加・上書きできるようにする。
+ * テンプレートにはツール定義・ツール数・ユーザーのロケール・リクエストのメタデータを渡し、
+ * プロファイルはモデル別設定（prompt_profile）またはリクエストごと（X-TCGW-Prompt-Profile ヘッダー / metadata.prompt_profile）に選べる。
+ * TOOL_SYSTEM_PROMPT は組み込みの "default" プロファイルとしてそのまま残る。
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultPromptProfileName は TCGW標準のツール定義プロンプト（tool_prompt: "tcgw"）に使うプロファイル
+const defaultPromptProfileName = "default"
+
+// promptProfileFileExt はプロファイルとして読み込むファイルの拡張子（拡張子を除いたファイル名がプロファイル名になる）
+const promptProfileFileExt = ".tmpl"
+
+// promptProfileHeader はリクエストごとにプロファイルを選ぶHTTPヘッダー
+const promptProfileHeader = "X-TCGW-Prompt-Profile"
+
+// promptContext はプロンプトテンプレートに渡す値
+type promptContext struct {
+	Model      string         // リクエストのモデル名
+	Tools      []Tool         // リクエストのツール定義
+	ToolCount  int            // ツール定義の数
+	ToolsXML   string         // TCGW標準の <tool> 要素の列（TOOL_SYSTEM_PROMPT の <tools> 内と同じ）
+	ToolPrompt string         // tool_prompt で選んだ形式のツール定義プロンプト（プロファイルを選んだ場合のみ）
+	Locale     string         // ユーザーのロケール（metadata.locale → Accept-Language の先頭。不明な場合は空）
+	Metadata   map[string]any // リクエストの metadata
+	ToolChoice toolChoice     // リクエストの tool_choice（.ToolChoice.Mode / .ToolChoice.Name）
+	SingleCall bool           // parallel_tool_calls: false（1回の応答で呼び出せるツールは1つ）
+}
+
+// promptProfiles は名前で選べるプロファイルの一覧
+var promptProfiles = map[string]*template.Template{}
+
+// promptTemplateFuncs はテンプレートで使える関数
+var promptTemplateFuncs = template.FuncMap{
+	"json":     promptJSON,     // 値を1行のJSONにする（例: {{json .Metadata}}）
+	"language": localeLanguage, // ロケールを英語の言語名にする（例: {{language .Locale}} → "English"）
+	"join":     strings.Join,
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+}
+
+func init() {
+	// 従来の TOOL_SYSTEM_PROMPT を組み込みの default プロファイルとして登録する
+	promptProfiles[defaultPromptProfileName] = template.Must(parsePromptProfile(defaultPromptProfileName, TOOL_SYSTEM_PROMPT))
+}
+
+// parsePromptProfile はプロファイルのテンプレートを解釈する
+func parsePromptProfile(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(promptTemplateFuncs).Parse(text)
+}
+
+// loadPromptProfiles はディレクトリ内の *.tmpl をプロファイルとして読み込む
+// 組み込みと同じ名前のファイル（default.tmpl）は組み込みのプロファイルを上書きする
+func loadPromptProfiles(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+promptProfileFileExt))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(path), promptProfileFileExt)
+		tmpl, err := parsePromptProfile(name, string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(path), err)
+		}
+		promptProfiles[name] = tmpl
+	}
+	return nil
+}
+
+// promptProfileNames は登録済みのプロファイル名を返す（エラーメッセージ用）
+func promptProfileNames() []string {
+	names := make([]string, 0, len(promptProfiles))
+	for name := range promptProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// renderPromptProfile はプロファイルのテンプレートを実行する
+func renderPromptProfile(name string, ctx promptContext) (string, error) {
+	tmpl, ok := promptProfiles[name]
+	if !ok {
+		return "", fmt.Errorf("unknown prompt profile %q (available: %s)", name, strings.Join(promptProfileNames(), ", "))
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, ctx); err != nil {
+		return "", fmt.Errorf("prompt profile %q: %v", name, err)
+	}
+	return sb.String(), nil
+}
+
+// newPromptContext はリクエストからテンプレートに渡す値（ツール定義以外）を作る
+func newPromptContext(c *gin.Context, req *ChatCompletionRequest) promptContext {
+	locale, _ := req.Metadata["locale"].(string)
+	if locale == "" {
+		locale = acceptLanguageLocale(c.GetHeader("Accept-Language"))
+	}
+	return promptContext{
+		Model:    req.Model,
+		Locale:   strings.TrimSpace(locale),
+		Metadata: req.Metadata,
+	}
+}
+
+// requestedPromptProfile はリクエストで指定されたプロファイル名を返す（ヘッダー → metadata.prompt_profile の順。未指定の場合は空）
+func requestedPromptProfile(c *gin.Context, req *ChatCompletionRequest) string {
+	if name := strings.TrimSpace(c.GetHeader(promptProfileHeader)); name != "" {
+		return name
+	}
+	name, _ := req.Metadata["prompt_profile"].(string)
+	return strings.TrimSpace(name)
+}
+
+// acceptLanguageLocale は Accept-Language ヘッダーの先頭の言語タグを返す（例: "en-US,en;q=0.9" → "en-US"）
+func acceptLanguageLocale(header string) string {
+	first, _, _ := strings.Cut(header, ",")
+	tag, _, _ := strings.Cut(first, ";")
+	tag = strings.TrimSpace(tag)
+	if tag == "*" {
+		return ""
+	}
+	return tag
+}
+
+// localeLanguages は主な言語サブタグと英語の言語名の対応
+var localeLanguages = map[string]string{
+	"ja": "Japanese",
+	"en": "English",
+	"zh": "Chinese",
+	"ko": "Korean",
+	"fr": "French",
+	"de": "German",
+	"es": "Spanish",
+	"pt": "Portuguese",
+	"it": "Italian",
+	"ru": "Russian",
+	"vi": "Vietnamese",
+	"th": "Thai",
+	"id": "Indonesian",
+}
+
+// localeLanguage はロケール（"en-US", "ja_JP" など）を英語の言語名にする（対応表にない場合はロケールをそのまま返す）
+func localeLanguage(locale string) string {
+	primary, _, _ := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+	if name, ok := localeLanguages[strings.ToLower(primary)]; ok {
+		return name
+	}
+	return locale
+}
+
+// promptJSON は値を1行のJSONにする（変換できない場合は空文字列）
+func promptJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
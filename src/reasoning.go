@@ -0,0 +1,191 @@
+/**
+ * reasoning.go
+ *
+ * 推論（思考）テキストの分離
+ * DeepSeek R1 / Qwen3 の <think>…</think>、Magistral の [THINK]…[/THINK]、GPT-OSS の analysis チャンネルなど、
+ * モデルが本文に混ぜて出力する推論テキストを、ツール呼び出しの抽出より前に取り出して reasoning_content に移す。
+ * 推論中に書かれたツール呼び出しの例が実際の呼び出しとして抽出されたり、生の推論がエンドユーザーに見えたりするのを防ぐ。
+ */
+package main
+
+import (
+	"strings"
+)
+
+// reasoningDelimiter は推論テキストの開始・終了マーカー
+type reasoningDelimiter struct {
+	open  string
+	close string
+}
+
+// reasoningDelimiters は推論テキストとして扱う区間のマーカー
+// 長いマーカーを先に並べる（同じ位置で複数がマッチした場合は先に書かれたものを優先する）
+var reasoningDelimiters = []reasoningDelimiter{
+	// DeepSeek R1 / Qwen3 など
+	{open: "<think>", close: "</think>"},
+	{open: "<thinking>", close: "</thinking>"},
+	// Magistral
+	{open: "[THINK]", close: "[/THINK]"},
+	// GPT-OSS（analysis チャンネル。"analysis to=関数名" のツール呼び出しは対象外）
+	{open: "<|start|>assistant<|channel|>analysis<|message|>", close: "<|end|>"},
+	{open: "<|channel|>analysis<|message|>", close: "<|end|>"},
+}
+
+// reasoningDropMarkers は推論ではないが本文から取り除くマーカー（GPT-OSS の final チャンネルのヘッダーなど）
+var reasoningDropMarkers = []string{
+	"<|start|>assistant<|channel|>final<|message|>",
+	"<|channel|>final<|message|>",
+	"<|return|>",
+}
+
+// extractReasoning はテキストから推論部分を取り出し、(推論テキスト, 残りのテキスト) を返す
+// 推論が見つからない場合は ("", text) を返す
+func extractReasoning(text string) (string, string) {
+	var reasoning []string
+	var content strings.Builder
+
+	// DeepSeek R1 はチャットテンプレートが <think> を挿入するため、出力は </think> から始まる推論の終わりだけを含むことがある
+	// 最初の終了マーカーより前に開始マーカーがない場合、その手前までを推論とみなす
+	if idx := strings.Index(text, "</think>"); idx != -1 && !strings.Contains(text[:idx], "<think>") {
+		if r := strings.TrimSpace(text[:idx]); r != "" {
+			reasoning = append(reasoning, r)
+		}
+		text = text[idx+len("</think>"):]
+	}
+
+	rest := text
+	for {
+		idx, delim, drop := findReasoningMarker(rest)
+		if idx == -1 {
+			content.WriteString(rest)
+			break
+		}
+		content.WriteString(rest[:idx])
+		if drop != "" {
+			rest = rest[idx+len(drop):]
+			continue
+		}
+		rest = rest[idx+len(delim.open):]
+		end := strings.Index(rest, delim.close)
+		if end == -1 {
+			// 閉じマーカーがない場合（出力が途中で切れた場合など）は残り全体を推論とする
+			if r := strings.TrimSpace(rest); r != "" {
+				reasoning = append(reasoning, r)
+			}
+			rest = ""
+			continue
+		}
+		if r := strings.TrimSpace(rest[:end]); r != "" {
+			reasoning = append(reasoning, r)
+		}
+		rest = rest[end+len(delim.close):]
+	}
+
+	if len(reasoning) == 0 {
+		return "", content.String()
+	}
+	logDebug("Reasoning Extracted", map[string]any{
+		"Blocks":        len(reasoning),
+		"Reasoning Len": len(strings.Join(reasoning, "")),
+	})
+	return strings.Join(reasoning, "\n\n"), strings.TrimSpace(content.String())
+}
+
+// テキスト中で最も手前にある推論の開始マーカー、または取り除くマーカーを返す
+// 見つからない場合の位置は -1（開始マーカーの場合は drop が空文字列）
+func findReasoningMarker(text string) (int, reasoningDelimiter, string) {
+	best := -1
+	var bestDelim reasoningDelimiter
+	bestDrop := ""
+	for _, delim := range reasoningDelimiters {
+		if idx := strings.Index(text, delim.open); idx != -1 && (best == -1 || idx < best) {
+			best, bestDelim, bestDrop = idx, delim, ""
+		}
+	}
+	for _, marker := range reasoningDropMarkers {
+		if idx := strings.Index(text, marker); idx != -1 && (best == -1 || idx < best) {
+			best, bestDelim, bestDrop = idx, reasoningDelimiter{}, marker
+		}
+	}
+	return best, bestDelim, bestDrop
+}
+
+// mergeReasoning はバックエンドが返した reasoning_content と本文から抽出した推論を統合する
+// バックエンドの値はそのまま先頭に残し、抽出した推論がある場合のみ後ろに追加する
+func mergeReasoning(backendReasoning, extracted string) string {
+	if backendReasoning == "" {
+		return extracted
+	}
+	if extracted == "" {
+		return backendReasoning
+	}
+	return backendReasoning + "\n\n" + extracted
+}
+
+// --- ストリーミング用 ---
+
+// reasoningStreamSplitter はストリーミング中のテキストを推論と本文に振り分ける
+// マーカーが断片の境界で分割される場合に備え、マーカーの途中までと一致する末尾は次の断片まで保留する
+// なお、開始マーカーを含まない DeepSeek R1 形式は、</think> を受信する前に本文として送出済みのため分離できない
+type reasoningStreamSplitter struct {
+	pending     string
+	inReasoning bool
+	close       string // 推論中の場合の終了マーカー
+}
+
+// feed はテキスト片を受け取り、すぐに送出してよい (本文, 推論) を返す
+func (s *reasoningStreamSplitter) feed(text string) (string, string) {
+	s.pending += text
+	var content, reasoning strings.Builder
+	for {
+		if s.inReasoning {
+			if idx := strings.Index(s.pending, s.close); idx != -1 {
+				reasoning.WriteString(s.pending[:idx])
+				s.pending = s.pending[idx+len(s.close):]
+				s.inReasoning = false
+				continue
+			}
+			keep := partialSuffixLen(s.pending, []string{s.close})
+			reasoning.WriteString(s.pending[:len(s.pending)-keep])
+			s.pending = s.pending[len(s.pending)-keep:]
+			break
+		}
+
+		idx, delim, drop := findReasoningMarker(s.pending)
+		if idx != -1 {
+			content.WriteString(s.pending[:idx])
+			if drop != "" {
+				s.pending = s.pending[idx+len(drop):]
+				continue
+			}
+			s.pending = s.pending[idx+len(delim.open):]
+			s.inReasoning = true
+			s.close = delim.close
+			continue
+		}
+		keep := partialSuffixLen(s.pending, reasoningMarkers())
+		content.WriteString(s.pending[:len(s.pending)-keep])
+		s.pending = s.pending[len(s.pending)-keep:]
+		break
+	}
+	return content.String(), reasoning.String()
+}
+
+// flush はストリーム終了時に保留中のテキストを (本文, 推論) として返す
+func (s *reasoningStreamSplitter) flush() (string, string) {
+	rest := s.pending
+	s.pending = ""
+	if s.inReasoning {
+		return "", rest
+	}
+	return rest, ""
+}
+
+// 推論の開始マーカーと取り除くマーカーの一覧
+func reasoningMarkers() []string {
+	markers := make([]string, 0, len(reasoningDelimiters)+len(reasoningDropMarkers))
+	for _, delim := range reasoningDelimiters {
+		markers = append(markers, delim.open)
+	}
+	return append(markers, reasoningDropMarkers...)
+}
@@ -0,0 +1,210 @@
+/**
+ * repair.go
+ *
+ * 壊れた・検証に失敗したツール呼び出しの修復（聞き直し）
+ * 性能の低いモデルは、閉じタグの欠落・JSON の末尾のカンマ・必須パラメータの欠落など「ほぼ正しい」ツール呼び出しを出力しやすい。
+ * TOOL_REPAIR_ATTEMPTS（モデルごとには repair_attempts）を 1 以上にすると、ツール呼び出しの意図があるのに抽出できなかった場合や
+ * 引数の検証に失敗した場合に、エラーの内容を示して正しい呼び出しを出力し直すようモデルに聞き直す。
+ * 聞き直しは回数（TOOL_REPAIR_ATTEMPTS）と時間（TOOL_REPAIR_TIMEOUT_MS）の上限まで繰り返し、
+ * クライアントには最終的な結果のみを返す（聞き直した回数と結果はレスポンスヘッダーで知らせる）。
+ */
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/t-kawata/tcgw/config"
+	"github.com/t-kawata/tcgw/parser"
+)
+
+// 修復の結果を知らせるレスポンスヘッダー（ストリーミング時はトレーラー）
+const (
+	headerRepairAttempts = "X-TCGW-Repair-Attempts" // 聞き直した回数
+	headerRepairResult   = "X-TCGW-Repair-Result"   // "none"（聞き直し不要）, "repaired", "failed"
+)
+
+// maxRepairQuoteLen は聞き直しのメッセージに引用する壊れたマークアップの上限バイト数
+const maxRepairQuoteLen = 2000
+
+// repairIntentMarkers は開始部分が崩れた場合でもツール呼び出しの意図とみなす文字列（パーサー名 → 文字列）
+// ストリーミングのトリガー（streamToolCallTriggers）に加えて使う
+var repairIntentMarkers = map[string][]string{
+	"xml": {"<invoke ", "<invoke>"}, // <function_calls> を省略・書き誤った <invoke name="...">
+}
+
+// toolCallRepair は修復の設定
+type toolCallRepair struct {
+	attempts int             // 聞き直す回数の上限（0 は無効）
+	budget   time.Duration   // 聞き直しに使える時間の上限（0 は無制限）
+	prefix   string          // システムプロンプトで指示した形式の開始部分（常にツール呼び出しの意図とみなす）
+	triggers []streamTrigger // 選ばれたパーサーが抽出する形式の開始部分（続く内容を確かめられた場合に意図とみなす）
+	markers  []string        // 選ばれたパーサーの repairIntentMarkers
+}
+
+// newToolCallRepair はモデル設定（未指定の項目は TOOL_REPAIR_ATTEMPTS / TOOL_REPAIR_TIMEOUT_MS）から修復の設定を作る
+// parsers はモデルで選ばれたパーサー（ツール呼び出しの意図の判定に使う）
+func newToolCallRepair(modelConfig config.ModelConfig, renderer toolPromptRenderer, parsers []parser.ToolCallParser) toolCallRepair {
+	attempts, timeoutMs := toolRepairAttempts, toolRepairTimeoutMs
+	if modelConfig.RepairAttempts != nil {
+		attempts = *modelConfig.RepairAttempts
+	}
+	if modelConfig.RepairTimeoutMs != nil {
+		timeoutMs = *modelConfig.RepairTimeoutMs
+	}
+	var markers []string
+	for _, p := range parsers {
+		markers = append(markers, repairIntentMarkers[p.Name()]...)
+	}
+	return toolCallRepair{
+		attempts: attempts,
+		budget:   time.Duration(timeoutMs) * time.Millisecond,
+		prefix:   strings.TrimSpace(renderer.CallPrefix("")),
+		triggers: streamTriggersFor(parsers),
+		markers:  markers,
+	}
+}
+
+// enabled は修復が有効か
+func (r toolCallRepair) enabled() bool {
+	return r.attempts > 0
+}
+
+// intentIndex は出力の中でツール呼び出しの意図が始まる位置を返す（ない場合は -1）
+// 指示した形式の開始部分・repairIntentMarkers はそのまま、選ばれたパーサーのトリガーは続く内容を確かめられた場合のみ意図とみなす
+// （"<tool>" のように文章にも現れる文字列だけでは意図とみなさない）
+func (r toolCallRepair) intentIndex(content string) int {
+	idx := -1
+	earliest := func(i int) {
+		if i != -1 && (idx == -1 || i < idx) {
+			idx = i
+		}
+	}
+	if r.prefix != "" {
+		earliest(strings.Index(content, r.prefix))
+	}
+	for _, marker := range r.markers {
+		earliest(strings.Index(content, marker))
+	}
+	for _, t := range r.triggers {
+		for offset := 0; offset < len(content); {
+			i := strings.Index(content[offset:], t.text)
+			if i == -1 {
+				break
+			}
+			i += offset
+			if t.confirm == nil || t.confirm.MatchString(content[i:]) {
+				earliest(i)
+				break
+			}
+			offset = i + len(t.text)
+		}
+	}
+	return idx
+}
+
+// malformed はツール呼び出しを1つも抽出できなかった出力に、呼び出しの意図（選ばれたパーサーの形式の開始部分）があるかを判定する
+// ある場合は、壊れた部分を引用して正しい呼び出しを求める user メッセージを返す（ない場合は空）
+func (r toolCallRepair) malformed(content string) string {
+	if !r.enabled() {
+		return ""
+	}
+	idx := r.intentIndex(content)
+	if idx == -1 {
+		return ""
+	}
+	fragment := strings.TrimSpace(content[idx:])
+	problem := diagnoseToolCallMarkup(fragment)
+	logDebug("Tool Call Repair: Malformed Markup", map[string]any{
+		"Problem":  problem,
+		"Fragment": fragment,
+	})
+	if len(fragment) > maxRepairQuoteLen {
+		// マルチバイト文字の途中で切らない
+		cut := maxRepairQuoteLen
+		for cut > 0 && !utf8.RuneStart(fragment[cut]) {
+			cut--
+		}
+		fragment = fragment[:cut] + "..."
+	}
+	var sb strings.Builder
+	sb.WriteString("Your previous response tried to call a tool, but the tool call could not be parsed")
+	sb.WriteString(" (" + problem + "):\n")
+	sb.WriteString(fragment)
+	sb.WriteString("\nCall the tool again with a complete, well-formed tool call, using the exact tool call format described in the system prompt. Respond with the tool call only.")
+	return sb.String()
+}
+
+// repairTags は閉じタグの欠落を調べるタグ（開始タグの書き出しと閉じタグ）
+var repairTags = []struct{ open, close string }{
+	{"<function_calls>", "</function_calls>"},
+	{"<invoke ", "</invoke>"},
+	{"<parameter ", "</parameter>"},
+	{"<tool_call>", "</tool_call>"},
+}
+
+// diagnoseToolCallMarkup は抽出できなかったマークアップの問題点を推定する（聞き直しのメッセージに使う）
+func diagnoseToolCallMarkup(fragment string) string {
+	var problems []string
+	for _, tag := range repairTags {
+		if strings.Count(fragment, tag.open) > strings.Count(fragment, tag.close) {
+			problems = append(problems, "missing closing tag "+tag.close)
+		}
+	}
+	// JSON 形式の引数（最初の "{" から最後の "}" まで）
+	if start, end := strings.Index(fragment, "{"), strings.LastIndex(fragment, "}"); start != -1 {
+		if end < start {
+			problems = append(problems, "unterminated JSON object")
+		} else {
+			var value any
+			if err := json.Unmarshal([]byte(fragment[start:end+1]), &value); err != nil {
+				problems = append(problems, "invalid JSON: "+err.Error())
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return "the markup does not follow the required format"
+	}
+	return strings.Join(problems, "; ")
+}
+
+// setRepairHeaders は聞き直した回数と結果をヘッダーに設定する（修復が無効で聞き直しもしなかった場合は何もしない）
+func setRepairHeaders(header http.Header, repair toolCallRepair, attempts int, repaired bool) {
+	if !repair.enabled() && attempts == 0 {
+		return
+	}
+	result := "none"
+	if attempts > 0 {
+		result = "failed"
+		if repaired {
+			result = "repaired"
+		}
+	}
+	header.Set(headerRepairAttempts, strconv.Itoa(attempts))
+	header.Set(headerRepairResult, result)
+}
+
+// deadline は聞き直しを始めた時刻から期限を求める（時間の上限がない場合はゼロ値）
+func (r toolCallRepair) deadline(start time.Time) time.Time {
+	if !r.enabled() || r.budget <= 0 {
+		return time.Time{}
+	}
+	return start.Add(r.budget)
+}
+
+// remainingTimeout は期限までの残り時間と REQUEST_TIMEOUT の短い方を返す（期限切れの場合は 0）
+func remainingTimeout(deadline time.Time) time.Duration {
+	timeout := time.Duration(requestTimeout) * time.Millisecond
+	if deadline.IsZero() {
+		return timeout
+	}
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return 0
+	}
+	return min(remaining, timeout)
+}
@@ -0,0 +1,267 @@
+/**
+ * responseformat.go
+ *
+ * response_format（json_object / json_schema）のエミュレーション
+ * 構造化出力に対応していないバックエンドや小さなモデルは response_format を無視することが多い。
+ * RESPONSE_FORMAT_MODE（モデルごとには response_format_mode）を emulate にすると、response_format をバックエンドに送らず、
+ * 出力形式（json_schema の場合はスキーマ）をシステムプロンプトで指示し、応答から JSON を取り出して（コードフェンス内も含む）検証する。
+ * 検証に失敗した場合は tool_choice と同じ聞き直しの仕組みでモデルに聞き直し、クライアントには取り出した JSON のみを content として返す。
+ * ツール呼び出しは最終回答より優先し、ツール呼び出しを含む応答には形式の確認を行わない。
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/t-kawata/tcgw/config"
+	"github.com/t-kawata/tcgw/schema"
+)
+
+// response_format の扱い
+const (
+	responseFormatPassthrough = "passthrough" // そのままバックエンドに送る
+	responseFormatEmulate     = "emulate"     // プロンプトで指示し、応答を検証する
+)
+
+// reCodeFence は Markdown のコードフェンス（```json ... ```）
+var reCodeFence = regexp.MustCompile("(?s)```[A-Za-z]*[ \t]*\r?\n?(.*?)```")
+
+// validResponseFormatMode は response_format_mode として有効な値か
+func validResponseFormatMode(mode string) bool {
+	return mode == responseFormatPassthrough || mode == responseFormatEmulate
+}
+
+// resolveResponseFormatMode はモデル設定の response_format_mode（未指定の場合は RESPONSE_FORMAT_MODE）を返す
+func resolveResponseFormatMode(modelConfig config.ModelConfig) string {
+	if modelConfig.ResponseFormatMode != "" {
+		return modelConfig.ResponseFormatMode
+	}
+	return responseFormatMode
+}
+
+// responseFormat はリクエストの response_format を解釈したもの
+type responseFormat struct {
+	Type        string         // "json_object", "json_schema"（"" は指定なし・"text"）
+	Name        string         // json_schema の名前
+	Description string         // json_schema の説明
+	Schema      map[string]any // json_schema のスキーマ（strict の場合は未定義のプロパティを許可しないもの）
+}
+
+// parseResponseFormat は response_format（ResponseFormat の形の JSON）を解釈する
+// 未知の type や、json_schema にスキーマがない場合はエラー
+func parseResponseFormat(value any) (responseFormat, error) {
+	if value == nil {
+		return responseFormat{}, nil
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return responseFormat{}, fmt.Errorf("invalid response_format: %w", err)
+	}
+	var rf ResponseFormat
+	if err := json.Unmarshal(raw, &rf); err != nil {
+		return responseFormat{}, fmt.Errorf("invalid response_format: %w", err)
+	}
+	switch rf.Type {
+	case "", "text":
+		return responseFormat{}, nil
+	case "json_object":
+		return responseFormat{Type: rf.Type}, nil
+	case "json_schema":
+		if rf.JSONSchema == nil || rf.JSONSchema.Schema == nil {
+			return responseFormat{}, fmt.Errorf("response_format.json_schema.schema is required")
+		}
+		s := rf.JSONSchema.Schema
+		if rf.JSONSchema.Strict != nil && *rf.JSONSchema.Strict {
+			s = schema.Strict(s)
+		}
+		return responseFormat{Type: rf.Type, Name: rf.JSONSchema.Name, Description: rf.JSONSchema.Description, Schema: s}, nil
+	default:
+		return responseFormat{}, fmt.Errorf("unsupported response_format type %q", rf.Type)
+	}
+}
+
+// active は応答の形式を確認するか
+func (f responseFormat) active() bool {
+	return f.Type != ""
+}
+
+// instruction はシステムプロンプトの末尾に加える出力形式の指示（withTools はツール定義を埋め込んだ場合）
+func (f responseFormat) instruction(withTools bool) string {
+	var sb strings.Builder
+	sb.WriteString("RESPONSE FORMAT: ")
+	if withTools {
+		sb.WriteString("When you give the final answer (not a tool call), it ")
+	} else {
+		sb.WriteString("Your answer ")
+	}
+	if f.Type == "json_schema" {
+		schemaJSON, _ := json.Marshal(f.Schema)
+		sb.WriteString("MUST be a single JSON value that conforms to the following JSON Schema")
+		if f.Name != "" {
+			sb.WriteString(fmt.Sprintf(" (%s)", f.Name))
+		}
+		sb.WriteString(":\n")
+		if f.Description != "" {
+			sb.WriteString(f.Description + "\n")
+		}
+		sb.Write(schemaJSON)
+		sb.WriteString("\n")
+	} else {
+		sb.WriteString("MUST be a single valid JSON object.\n")
+	}
+	sb.WriteString("Output ONLY the JSON. Do NOT add explanations, markdown or code fences.")
+	if withTools {
+		sb.WriteString(" If you still need a tool, call it using the tool call format instead; tool calls take priority over the final answer.")
+	}
+	return sb.String()
+}
+
+// check は最終回答が形式を満たすかを判定し、満たさない場合は聞き直すための user メッセージを返す（満たす場合は空）
+func (f responseFormat) check(content string) string {
+	answer, ok := extractJSONAnswer(content)
+	if !ok {
+		return "Your previous response did not contain valid JSON. " + f.reaskFormat()
+	}
+	if f.Type == "json_object" && !strings.HasPrefix(answer, "{") {
+		return "Your previous response was valid JSON but not a JSON object. " + f.reaskFormat()
+	}
+	if f.Type != "json_schema" {
+		return ""
+	}
+	errs := schema.ValidateJSON(f.Schema, answer)
+	if len(errs) == 0 {
+		return ""
+	}
+	logDebug("Response Format Validation Failed", map[string]any{"Errors": len(errs), "Answer": answer})
+	var sb strings.Builder
+	sb.WriteString("Your previous response did not conform to the required JSON Schema:\n")
+	for _, e := range errs {
+		path := e.Path
+		if path == "" {
+			path = "(root)"
+		}
+		sb.WriteString(fmt.Sprintf("- %s: %s\n", path, e.Message))
+	}
+	sb.WriteString(f.reaskFormat())
+	return sb.String()
+}
+
+// reaskFormat は聞き直しのメッセージの末尾に加える、求める形式の説明
+func (f responseFormat) reaskFormat() string {
+	if f.Type == "json_schema" {
+		return "Respond again with ONLY a JSON value that conforms to the JSON Schema given in the system prompt, without any other text or code fences."
+	}
+	return "Respond again with ONLY a single JSON object, without any other text or code fences."
+}
+
+// clean は最終回答から取り出した JSON を返す（取り出せない場合は元の本文）
+func (f responseFormat) clean(content string) string {
+	if answer, ok := extractJSONAnswer(content); ok {
+		return answer
+	}
+	return content
+}
+
+// finalAnswer はツール呼び出しがない応答でクライアントに返す本文（response_format をエミュレートする場合は取り出した JSON）
+func (r toolCallRequirement) finalAnswer(content string) string {
+	if !r.format.active() {
+		return content
+	}
+	return r.format.clean(content)
+}
+
+// embedResponseFormatIntoPrompt は出力形式の指示をシステムプロンプトの末尾に加え、response_format をバックエンドに送らないようにする
+// withTools はツール定義を埋め込んだか（ツール呼び出しを最終回答より優先するよう指示する）
+func embedResponseFormatIntoPrompt(req *ChatCompletionRequest, format responseFormat, withTools bool) {
+	if !format.active() {
+		return
+	}
+	instruction := format.instruction(withTools)
+	if len(req.Messages) > 0 && req.Messages[0].Role == "system" {
+		if existing := extractStringContent(req.Messages[0].Content); existing != "" {
+			instruction = existing + "\n\n" + instruction
+		}
+		setStringContent(&req.Messages[0], instruction)
+	} else {
+		req.Messages = append([]Message{{Role: "system", Content: instruction}}, req.Messages...)
+	}
+	req.ResponseFormat = nil
+	logDebug("Embedding Response Format", map[string]any{
+		"Type":       format.Type,
+		"Name":       format.Name,
+		"With Tools": withTools,
+	})
+}
+
+// extractJSONAnswer は応答から JSON を取り出す
+// 本文全体 → コードフェンスの中身 → 本文中で最初に閉じる {...} / [...] の順に試す
+func extractJSONAnswer(content string) (string, bool) {
+	trimmed := strings.TrimSpace(content)
+	if trimmed != "" && json.Valid([]byte(trimmed)) {
+		return trimmed, true
+	}
+	for _, m := range reCodeFence.FindAllStringSubmatch(content, -1) {
+		if inner := strings.TrimSpace(m[1]); inner != "" && json.Valid([]byte(inner)) {
+			return inner, true
+		}
+	}
+	return findBracketedJSON(content)
+}
+
+// bracketSpan は本文中の対応する括弧の範囲（end は閉じ括弧の位置）
+type bracketSpan struct{ start, end int }
+
+// findBracketedJSON は本文を1度だけ走査し、JSON として正しい最初の {...} / [...] を返す（文字列リテラル内の括弧は数えない）
+// 閉じた最も外側の括弧の範囲だけを検証し、正しくなければその後ろから続ける（中に入れ子になった範囲は試さない）
+// 閉じない括弧がある場合は、その中で閉じた範囲（入れ子の最も外側のもの）を最後に先頭から順に検証する
+func findBracketedJSON(content string) (string, bool) {
+	var open []int            // 閉じていない括弧の位置
+	var pending []bracketSpan // 閉じない括弧の中で閉じた範囲の候補（入れ子の外側のもののみ）
+	inString, escaped := false, false
+	for i := 0; i < len(content); i++ {
+		ch := content[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				inString = false
+			}
+			continue
+		}
+		switch ch {
+		case '"':
+			inString = len(open) > 0 // 括弧の外の引用符は文章の一部
+		case '{', '[':
+			open = append(open, i)
+		case '}', ']':
+			if len(open) == 0 {
+				continue
+			}
+			start := open[len(open)-1]
+			open = open[:len(open)-1]
+			// この範囲の中で閉じた候補は、外側のこの範囲に置き換える
+			for len(pending) > 0 && pending[len(pending)-1].start > start {
+				pending = pending[:len(pending)-1]
+			}
+			if len(open) > 0 {
+				pending = append(pending, bracketSpan{start, i})
+				continue
+			}
+			if candidate := content[start : i+1]; json.Valid([]byte(candidate)) {
+				return candidate, true
+			}
+		}
+	}
+	for _, span := range pending {
+		if candidate := content[span.start : span.end+1]; json.Valid([]byte(candidate)) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtractJSONAnswer(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantOK  bool
+	}{
+		{"whole content", "  {\"a\": 1}\n", `{"a": 1}`, true},
+		{"whole content array", "[1, 2]", `[1, 2]`, true},
+		{"json code fence", "Here you go:\n```json\n{\"a\": 1}\n```\nThanks.", `{"a": 1}`, true},
+		{"bare code fence", "```\n[true]\n```", `[true]`, true},
+		{"first valid fence", "```\nnot json\n```\n```json\n{\"b\": 2}\n```", `{"b": 2}`, true},
+		{"embedded in prose", "The answer is {\"a\": {\"b\": [1, 2]}} as requested.", `{"a": {"b": [1, 2]}}`, true},
+		{"brackets inside strings", "Result: {\"text\": \"a } and ] b\"} done", `{"text": "a } and ] b"}`, true},
+		{"invalid bracket before JSON", "Options [a, b] then {\"pick\": \"a\"}", `{"pick": "a"}`, true},
+		{"unclosed bracket before JSON", "I think { the answer is {\"a\": 1} and [2]", `{"a": 1}`, true},
+		{"stray closing bracket", "] } {\"a\": 1}", `{"a": 1}`, true},
+		{"quotes outside brackets are prose", "He said \"ok\": {\"a\": 1}", `{"a": 1}`, true},
+		{"no JSON", "There is no JSON here.", "", false},
+		{"only invalid brackets", "{not json} [also, not]", "", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := extractJSONAnswer(tt.content)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("extractJSONAnswer(%q) = %q, %v; want %q, %v", tt.content, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// 閉じない括弧・正しくない括弧が大量にあっても、走査は本文の長さに比例する
+func TestExtractJSONAnswerLargeInput(t *testing.T) {
+	inputs := []string{
+		strings.Repeat("{", 200000),
+		strings.Repeat("{x} ", 50000),
+		strings.Repeat("[", 100000) + strings.Repeat("]", 100000),
+	}
+	for _, content := range inputs {
+		start := time.Now()
+		if _, ok := extractJSONAnswer(content); ok {
+			t.Errorf("extractJSONAnswer(%q...) found JSON in invalid input", content[:10])
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("extractJSONAnswer(%q...) took %v", content[:10], elapsed)
+		}
+	}
+}
+
+func TestResponseFormatCheck(t *testing.T) {
+	object := responseFormat{Type: "json_object"}
+	withSchema := responseFormat{Type: "json_schema", Schema: map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"city": map[string]any{"type": "string"}},
+		"required":   []any{"city"},
+	}}
+	tests := []struct {
+		name      string
+		format    responseFormat
+		content   string
+		wantReask string // 聞き直しのメッセージの先頭（空の場合は条件を満たす）
+	}{
+		{"json_object accepts an object", object, `{"a": 1}`, ""},
+		{"json_object accepts a fenced object", object, "```json\n{\"a\": 1}\n```", ""},
+		{"json_object rejects an array", object, `[1, 2]`, "Your previous response was valid JSON but not a JSON object."},
+		{"json_object rejects a fenced array", object, "```json\n[{\"a\": 1}]\n```", "Your previous response was valid JSON but not a JSON object."},
+		{"json_object rejects prose", object, "no json", "Your previous response did not contain valid JSON."},
+		{"json_schema accepts a match", withSchema, `{"city": "Tokyo"}`, ""},
+		{"json_schema reports violations", withSchema, `{"town": "Tokyo"}`, "Your previous response did not conform to the required JSON Schema:"},
+		{"json_schema rejects an array for an object schema", withSchema, `[1]`, "Your previous response did not conform to the required JSON Schema:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.format.check(tt.content)
+			if tt.wantReask == "" {
+				if got != "" {
+					t.Errorf("check(%q) = %q, want no re-ask", tt.content, got)
+				}
+				return
+			}
+			if !strings.HasPrefix(got, tt.wantReask) {
+				t.Errorf("check(%q) = %q, want prefix %q", tt.content, got, tt.wantReask)
+			}
+		})
+	}
+}
@@ -0,0 +1,859 @@
+/**
+ * responses.go
+ *
+ * OpenAI Responses API（POST /v1/responses）の受け付け
+ * 新しい OpenAI SDK やエージェントフレームワークは Chat Completions ではなく Responses API を使う。
+ * リクエストを ChatCompletionRequest に変換し、Chat Completions と同じエミュレートの処理に載せて、結果を output 配列の形式で返す。
+ *   - instructions → system メッセージ（previous_response_id で引き継がない）
+ *   - input の message → 各 role のメッセージ（developer は system）、function_call → assistant の tool_calls、
+ *     function_call_output → role: "tool" のメッセージ（call_id で対応づける）
+ *   - tools（type: "function" のみ）→ tools、tool_choice → tool_choice、text.format → response_format
+ *   - 抽出したツール呼び出しは function_call アイテム（call_id と文字列の arguments）として返す
+ * store: false 以外のレスポンスは、会話の履歴とともにメモリ上のストア（RESPONSES_STORE_LIMIT 件まで。古いものから削除）に保存し、
+ * previous_response_id で続きの会話を、GET /v1/responses/{id} で保存したレスポンスを取得できる。
+ * ストリーミング（stream: true）では、Responses API のイベント（response.created / response.output_item.added / ... / response.completed）を送る。
+ * ストリーム開始後のエラーは error イベントに続けて response.failed（status: "failed" と error を含むレスポンス）を送る。
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- 型定義 (Responses API) ---
+
+// ResponsesRequest は Responses API のリクエスト
+type ResponsesRequest struct {
+	Model              string               `json:"model"`
+	Input              any                  `json:"input"` // string or []ResponsesInputItem
+	Instructions       string               `json:"instructions,omitempty"`
+	Tools              []ResponsesTool      `json:"tools,omitempty"`
+	ToolChoice         any                  `json:"tool_choice,omitempty"` // "none", "auto", "required", or {"type": "function", "name": ...}
+	ParallelToolCalls  *bool                `json:"parallel_tool_calls,omitempty"`
+	PreviousResponseID string               `json:"previous_response_id,omitempty"`
+	Store              *bool                `json:"store,omitempty"` // デフォルトtrue
+	Stream             bool                 `json:"stream,omitempty"`
+	MaxOutputTokens    *int                 `json:"max_output_tokens,omitempty"`
+	Temperature        *float32             `json:"temperature,omitempty"`
+	TopP               *float32             `json:"top_p,omitempty"`
+	Text               *ResponsesTextConfig `json:"text,omitempty"`
+	Metadata           map[string]any       `json:"metadata,omitempty"`
+	User               string               `json:"user,omitempty"`
+}
+
+// ResponsesInputItem は input 配列の1要素
+type ResponsesInputItem struct {
+	Type      string `json:"type,omitempty"`      // "message"（省略可）, "function_call", "function_call_output", "reasoning"
+	Role      string `json:"role,omitempty"`      // message: "user", "assistant", "system", "developer"
+	Content   any    `json:"content,omitempty"`   // message: string or []{"type": "input_text" | "output_text" | "input_image", ...}
+	CallID    string `json:"call_id,omitempty"`   // function_call / function_call_output
+	Name      string `json:"name,omitempty"`      // function_call
+	Arguments string `json:"arguments,omitempty"` // function_call
+	Output    any    `json:"output,omitempty"`    // function_call_output: string or []{"type": "input_text", ...}
+}
+
+// ResponsesContentPart は message の content の1要素
+type ResponsesContentPart struct {
+	Type     string `json:"type"` // "input_text", "output_text", "input_image", "refusal"
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"` // input_image
+	Detail   string `json:"detail,omitempty"`    // input_image
+}
+
+// ResponsesTool はツール定義（Chat Completions と異なり function の中身が平らに並ぶ）
+type ResponsesTool struct {
+	Type        string         `json:"type"` // "function" のみ対応
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
+
+// ResponsesTextConfig は出力テキストの形式の指定
+type ResponsesTextConfig struct {
+	Format *ResponsesTextFormat `json:"format,omitempty"`
+}
+
+// ResponsesTextFormat は出力テキストの形式（Chat Completions の response_format に相当）
+type ResponsesTextFormat struct {
+	Type        string         `json:"type"` // "text", "json_object", "json_schema"
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Schema      map[string]any `json:"schema,omitempty"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
+
+// ResponseObject は Responses API のレスポンス
+type ResponseObject struct {
+	ID                 string                      `json:"id"`
+	Object             string                      `json:"object"` // "response"
+	CreatedAt          int64                       `json:"created_at"`
+	Status             string                      `json:"status"` // "in_progress", "completed", "incomplete", "failed"
+	Model              string                      `json:"model"`
+	Output             []ResponsesOutputItem       `json:"output"`
+	Instructions       *string                     `json:"instructions"`
+	PreviousResponseID *string                     `json:"previous_response_id"`
+	Tools              []ResponsesTool             `json:"tools"`
+	ToolChoice         any                         `json:"tool_choice"`
+	ParallelToolCalls  bool                        `json:"parallel_tool_calls"`
+	Temperature        *float32                    `json:"temperature"`
+	TopP               *float32                    `json:"top_p"`
+	MaxOutputTokens    *int                        `json:"max_output_tokens"`
+	Store              bool                        `json:"store"`
+	Text               *ResponsesTextConfig        `json:"text,omitempty"`
+	Metadata           map[string]any              `json:"metadata"`
+	Usage              *ResponsesUsage             `json:"usage"`
+	Error              any                         `json:"error"`
+	IncompleteDetails  *ResponsesIncompleteDetails `json:"incomplete_details"`
+}
+
+// ResponsesOutputItem は output 配列の1要素
+type ResponsesOutputItem struct {
+	Type      string                 `json:"type"` // "message", "function_call", "reasoning"
+	ID        string                 `json:"id"`
+	Status    string                 `json:"status,omitempty"`    // message / function_call
+	Role      string                 `json:"role,omitempty"`      // message: "assistant"
+	Content   []ResponsesOutputText  `json:"content,omitempty"`   // message
+	CallID    string                 `json:"call_id,omitempty"`   // function_call
+	Name      string                 `json:"name,omitempty"`      // function_call
+	Arguments *string                `json:"arguments,omitempty"` // function_call（JSON文字列。引数なしの場合も "{}" を返す）
+	Summary   []ResponsesSummaryPart `json:"summary,omitempty"`   // reasoning
+}
+
+// ResponsesOutputText は message アイテムの出力テキスト
+type ResponsesOutputText struct {
+	Type        string `json:"type"` // "output_text"
+	Text        string `json:"text"`
+	Annotations []any  `json:"annotations"`
+}
+
+// ResponsesSummaryPart は reasoning アイテムの要約（エミュレートでは分離した推論テキストをそのまま入れる）
+type ResponsesSummaryPart struct {
+	Type string `json:"type"` // "summary_text"
+	Text string `json:"text"`
+}
+
+// ResponsesUsage はトークン使用量
+type ResponsesUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// ResponsesIncompleteDetails は status: "incomplete" の理由
+type ResponsesIncompleteDetails struct {
+	Reason string `json:"reason"` // "max_output_tokens", "content_filter"
+}
+
+// --- レスポンスのストア ---
+
+// storedResponse は保存したレスポンスと、そのレスポンスまでの会話（instructions を除く。previous_response_id で引き継ぐ）
+type storedResponse struct {
+	response ResponseObject
+	messages []Message
+}
+
+// responseStore はレスポンスをメモリ上に保存する（上限を超えた場合は古いものから削除）
+type responseStore struct {
+	mu      sync.Mutex
+	entries map[string]storedResponse
+	order   []string // 保存した順のID
+	limit   int      // 保存する件数の上限（0 は保存しない）
+}
+
+// responsesStore は RESPONSES_STORE_LIMIT 件までのレスポンスのストア（initConfig で作る）
+var responsesStore = newResponseStore(1000)
+
+// newResponseStore は limit 件まで保存するストアを作る
+func newResponseStore(limit int) *responseStore {
+	return &responseStore{entries: make(map[string]storedResponse), limit: limit}
+}
+
+// put はレスポンスと会話を保存する
+func (s *responseStore) put(resp ResponseObject, messages []Message) {
+	if s.limit <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[resp.ID]; !ok {
+		s.order = append(s.order, resp.ID)
+	}
+	s.entries[resp.ID] = storedResponse{response: resp, messages: messages}
+	for len(s.order) > s.limit {
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// get は保存したレスポンスを返す
+func (s *responseStore) get(id string) (storedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	return entry, ok
+}
+
+// delete は保存したレスポンスを削除する（保存していなかった場合は false）
+func (s *responseStore) delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[id]; !ok {
+		return false
+	}
+	delete(s.entries, id)
+	for i, stored := range s.order {
+		if stored == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// --- リクエストの変換 ---
+
+// responsesConversation は変換したリクエストと、ストアに保存する会話
+type responsesConversation struct {
+	req     *ChatCompletionRequest
+	history []Message // previous_response_id で引き継いだ会話と今回の input（instructions を除く）
+}
+
+// toChatCompletionRequest は Responses API のリクエストを ChatCompletionRequest に変換する
+// previous では previous_response_id で引き継ぐ会話を渡す
+func (r *ResponsesRequest) toChatCompletionRequest(previous []Message) (*responsesConversation, error) {
+	req := &ChatCompletionRequest{
+		Model:             r.Model,
+		Stream:            r.Stream,
+		Temperature:       r.Temperature,
+		TopP:              r.TopP,
+		MaxTokens:         r.MaxOutputTokens,
+		ParallelToolCalls: r.ParallelToolCalls,
+		Metadata:          r.Metadata,
+		User:              r.User,
+	}
+	if r.Stream {
+		// response.completed で usage を返すため、バックエンドにも usage を求める
+		req.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+
+	input, err := responsesInputMessages(r.Input)
+	if err != nil {
+		return nil, err
+	}
+	history := append(append([]Message(nil), previous...), input...)
+	if r.Instructions != "" {
+		req.Messages = append(req.Messages, Message{Role: "system", Content: r.Instructions})
+	}
+	req.Messages = append(req.Messages, history...)
+
+	for i, tool := range r.Tools {
+		if tool.Type != "function" {
+			return nil, fmt.Errorf("tools[%d].type: unsupported tool type %q (only \"function\" is supported)", i, tool.Type)
+		}
+		params := tool.Parameters
+		if params == nil {
+			params = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		req.Tools = append(req.Tools, Tool{Type: "function", Function: FunctionDef{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  params,
+			Strict:      tool.Strict,
+		}})
+	}
+	switch tc := r.ToolChoice.(type) {
+	case nil:
+	case map[string]any:
+		// {"type": "function", "name": ...} → {"type": "function", "function": {"name": ...}}
+		if name, ok := tc["name"].(string); ok {
+			req.ToolChoice = map[string]any{"type": "function", "function": map[string]any{"name": name}}
+		} else {
+			req.ToolChoice = tc // parseToolChoice で不正な値として扱う
+		}
+	default:
+		req.ToolChoice = tc
+	}
+
+	if format := r.textFormat(); format != nil {
+		req.ResponseFormat = format
+	}
+	return &responsesConversation{req: req, history: history}, nil
+}
+
+// textFormat は text.format を Chat Completions の response_format にする（指定なし・"text" の場合は nil）
+func (r *ResponsesRequest) textFormat() map[string]any {
+	if r.Text == nil || r.Text.Format == nil {
+		return nil
+	}
+	switch f := r.Text.Format; f.Type {
+	case "json_object":
+		return map[string]any{"type": "json_object"}
+	case "json_schema":
+		schema := map[string]any{"name": f.Name, "schema": f.Schema}
+		if f.Description != "" {
+			schema["description"] = f.Description
+		}
+		if f.Strict != nil {
+			schema["strict"] = *f.Strict
+		}
+		return map[string]any{"type": "json_schema", "json_schema": schema}
+	}
+	return nil
+}
+
+// responsesInputMessages は input（文字列またはアイテムの配列）をメッセージにする
+// 連続する function_call は1つの assistant メッセージの tool_calls にまとめる
+func responsesInputMessages(input any) ([]Message, error) {
+	switch v := input.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []Message{{Role: "user", Content: v}}, nil
+	}
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	var items []ResponsesInputItem
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, fmt.Errorf("input must be a string or an array of input items")
+	}
+
+	var messages []Message
+	for i, item := range items {
+		switch item.Type {
+		case "", "message":
+			role := item.Role
+			if role == "developer" {
+				role = "system"
+			}
+			if role != "user" && role != "assistant" && role != "system" {
+				return nil, fmt.Errorf("input[%d].role: unsupported role %q", i, item.Role)
+			}
+			messages = append(messages, Message{Role: role, Content: responsesMessageContent(item.Content)})
+		case "function_call":
+			arguments := item.Arguments
+			if strings.TrimSpace(arguments) == "" {
+				arguments = "{}"
+			}
+			call := ToolCall{ID: item.CallID, Type: "function", Function: ToolCallFunction{Name: item.Name, Arguments: arguments}}
+			// 直前が assistant メッセージ（本文または function_call）であれば、その tool_calls に加える
+			if n := len(messages); n > 0 && messages[n-1].Role == "assistant" {
+				messages[n-1].ToolCalls = append(messages[n-1].ToolCalls, call)
+			} else {
+				messages = append(messages, Message{Role: "assistant", ToolCalls: []ToolCall{call}})
+			}
+		case "function_call_output":
+			messages = append(messages, Message{Role: "tool", ToolCallID: item.CallID, Content: responsesText(item.Output)})
+		case "reasoning":
+			// 推論の要約は履歴に含めない
+		default:
+			return nil, fmt.Errorf("input[%d].type: unsupported item type %q", i, item.Type)
+		}
+	}
+	return messages, nil
+}
+
+// responsesMessageContent は message の content を Chat Completions の content にする
+// 画像を含む場合は ContentPart の配列、テキストのみの場合は連結した文字列
+func responsesMessageContent(content any) any {
+	parts := responsesContentParts(content)
+	hasImage := false
+	var texts []string
+	var out []ContentPart
+	for _, part := range parts {
+		switch part.Type {
+		case "input_text", "output_text", "text":
+			texts = append(texts, part.Text)
+			out = append(out, ContentPart{Type: "text", Text: part.Text})
+		case "input_image":
+			if part.ImageURL == "" {
+				continue
+			}
+			out = append(out, ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: part.ImageURL, Detail: part.Detail}})
+			hasImage = true
+		}
+	}
+	if hasImage {
+		return out
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// responsesContentParts は文字列または content の配列を content の配列にする
+func responsesContentParts(content any) []ResponsesContentPart {
+	if s, ok := content.(string); ok {
+		return []ResponsesContentPart{{Type: "input_text", Text: s}}
+	}
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return nil
+	}
+	var parts []ResponsesContentPart
+	_ = json.Unmarshal(raw, &parts)
+	return parts
+}
+
+// responsesText は文字列または content の配列からテキストを連結する（function_call_output の output など）
+func responsesText(content any) string {
+	if content == nil {
+		return ""
+	}
+	if s, ok := content.(string); ok {
+		return s
+	}
+	var texts []string
+	for _, part := range responsesContentParts(content) {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// --- レスポンスの変換 ---
+
+// generateResponsesID は Responses API の形式のID（"resp_", "msg_", "fc_", "rs_" など）を生成する
+func generateResponsesID(prefix string) string {
+	return prefix + strings.TrimPrefix(generateResponseID(), "chatcmpl-")
+}
+
+// newResponseObject はリクエストの設定を写した status: "in_progress" のレスポンスを作る
+func (r *ResponsesRequest) newResponseObject() ResponseObject {
+	resp := ResponseObject{
+		ID:                generateResponsesID("resp_"),
+		Object:            "response",
+		CreatedAt:         time.Now().Unix(),
+		Status:            "in_progress",
+		Model:             r.Model,
+		Output:            []ResponsesOutputItem{},
+		Tools:             r.Tools,
+		ToolChoice:        r.ToolChoice,
+		ParallelToolCalls: r.ParallelToolCalls == nil || *r.ParallelToolCalls,
+		Temperature:       r.Temperature,
+		TopP:              r.TopP,
+		MaxOutputTokens:   r.MaxOutputTokens,
+		Store:             r.Store == nil || *r.Store,
+		Text:              r.Text,
+		Metadata:          r.Metadata,
+	}
+	if resp.Tools == nil {
+		resp.Tools = []ResponsesTool{}
+	}
+	if resp.ToolChoice == nil {
+		resp.ToolChoice = "auto"
+	}
+	if resp.Metadata == nil {
+		resp.Metadata = map[string]any{}
+	}
+	if r.Instructions != "" {
+		resp.Instructions = &r.Instructions
+	}
+	if r.PreviousResponseID != "" {
+		resp.PreviousResponseID = &r.PreviousResponseID
+	}
+	return resp
+}
+
+// responsesMessageItem は本文を message アイテムにする
+func responsesMessageItem(id, text string) ResponsesOutputItem {
+	return ResponsesOutputItem{
+		Type:    "message",
+		ID:      id,
+		Status:  "completed",
+		Role:    "assistant",
+		Content: []ResponsesOutputText{{Type: "output_text", Text: text, Annotations: []any{}}},
+	}
+}
+
+// responsesFunctionCallItem はツール呼び出しを function_call アイテムにする（call_id はツール呼び出しのID）
+func responsesFunctionCallItem(id string, tc ToolCall) ResponsesOutputItem {
+	arguments := tc.Function.Arguments
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+	return ResponsesOutputItem{
+		Type:      "function_call",
+		ID:        id,
+		Status:    "completed",
+		CallID:    tc.ID,
+		Name:      tc.Function.Name,
+		Arguments: &arguments,
+	}
+}
+
+// responsesReasoningItem は推論テキストを reasoning アイテムにする
+func responsesReasoningItem(id, text string) ResponsesOutputItem {
+	return ResponsesOutputItem{Type: "reasoning", ID: id, Summary: []ResponsesSummaryPart{{Type: "summary_text", Text: text}}}
+}
+
+// completeResponse は output と usage を設定して status を確定する
+// バックエンドが長さの上限・コンテンツフィルターで止まった場合は "incomplete"（理由は incomplete_details）
+func completeResponse(resp ResponseObject, output []ResponsesOutputItem, usage *Usage, finish string) ResponseObject {
+	resp.Output = output
+	resp.Status = "completed"
+	switch finish {
+	case "length":
+		resp.Status = "incomplete"
+		resp.IncompleteDetails = &ResponsesIncompleteDetails{Reason: "max_output_tokens"}
+	case "content_filter":
+		resp.Status = "incomplete"
+		resp.IncompleteDetails = &ResponsesIncompleteDetails{Reason: "content_filter"}
+	}
+	u := Usage{}
+	if usage != nil {
+		u = *usage
+	}
+	resp.Usage = &ResponsesUsage{InputTokens: u.PromptTokens, OutputTokens: u.CompletionTokens, TotalTokens: u.PromptTokens + u.CompletionTokens}
+	return resp
+}
+
+// responsesAssistantMessage は output を履歴に加える assistant メッセージにする（推論は含めない）
+func responsesAssistantMessage(output []ResponsesOutputItem) Message {
+	msg := Message{Role: "assistant"}
+	var texts []string
+	for _, item := range output {
+		switch item.Type {
+		case "message":
+			for _, part := range item.Content {
+				texts = append(texts, part.Text)
+			}
+		case "function_call":
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: item.CallID, Type: "function", Function: ToolCallFunction{Name: item.Name, Arguments: *item.Arguments}})
+		}
+	}
+	if len(texts) > 0 {
+		msg.Content = strings.Join(texts, "\n\n")
+	}
+	return msg
+}
+
+// storeCompletedResponse は store: false でなければ、レスポンスと会話（今回の出力を含む）を保存する
+func storeCompletedResponse(resp ResponseObject, history []Message) {
+	if !resp.Store {
+		return
+	}
+	messages := append(append([]Message(nil), history...), responsesAssistantMessage(resp.Output))
+	responsesStore.put(resp, messages)
+}
+
+// --- ストリーミング ---
+
+// responsesStreamWriter はクライアントへ Responses API のイベントをSSEで書き出す
+// 出力アイテムは種類（reasoning / message / function_call）が変わるたびに閉じて、次の output_index で開く
+// 最後に完成したレスポンスを response.completed で送り、ストアに保存する
+type responsesStreamWriter struct {
+	c        *gin.Context
+	resp     ResponseObject // response.created で送り、出力を加えて response.completed で送るレスポンス
+	history  []Message      // ストアに保存する会話
+	sequence int            // sequence_number
+	output   []ResponsesOutputItem
+	current  *ResponsesOutputItem // 開いているアイテム（reasoning / message。nil は開いていない）
+	text     strings.Builder      // 開いているアイテムのテキスト
+}
+
+// SSEヘッダーを送出し、ストリーミング用ライターを作成
+func newResponsesStreamWriter(c *gin.Context, resp ResponseObject, history []Message) *responsesStreamWriter {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // リバースプロキシでのバッファリングを無効化
+	c.Status(http.StatusOK)
+	return &responsesStreamWriter{c: c, resp: resp, history: history}
+}
+
+// writeEvent は type と sequence_number を付けたイベントを1つ書き出してフラッシュ
+func (w *responsesStreamWriter) writeEvent(eventType string, fields gin.H) error {
+	fields["type"] = eventType
+	fields["sequence_number"] = w.sequence
+	w.sequence++
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w.c.Writer, "event: %s\ndata: %s\n\n", eventType, b); err != nil {
+		return err
+	}
+	w.c.Writer.Flush()
+	return nil
+}
+
+// openItem は kind のアイテム（reasoning / message）を開く（同じ種類のアイテムが開いている場合はそのまま続ける）
+func (w *responsesStreamWriter) openItem(kind string) error {
+	if w.current != nil && w.current.Type == kind {
+		return nil
+	}
+	if err := w.closeItem(); err != nil {
+		return err
+	}
+	index := len(w.output)
+	switch kind {
+	case "reasoning":
+		w.current = &ResponsesOutputItem{Type: "reasoning", ID: generateResponsesID("rs_")}
+		if err := w.writeEvent("response.output_item.added", gin.H{"output_index": index, "item": gin.H{"type": "reasoning", "id": w.current.ID, "summary": []any{}}}); err != nil {
+			return err
+		}
+		return w.writeEvent("response.reasoning_summary_part.added", gin.H{"item_id": w.current.ID, "output_index": index, "summary_index": 0, "part": gin.H{"type": "summary_text", "text": ""}})
+	default:
+		w.current = &ResponsesOutputItem{Type: "message", ID: generateResponsesID("msg_")}
+		item := gin.H{"type": "message", "id": w.current.ID, "status": "in_progress", "role": "assistant", "content": []any{}}
+		if err := w.writeEvent("response.output_item.added", gin.H{"output_index": index, "item": item}); err != nil {
+			return err
+		}
+		return w.writeEvent("response.content_part.added", gin.H{"item_id": w.current.ID, "output_index": index, "content_index": 0, "part": gin.H{"type": "output_text", "text": "", "annotations": []any{}}})
+	}
+}
+
+// closeItem は開いているアイテムを閉じて output に加える
+func (w *responsesStreamWriter) closeItem() error {
+	if w.current == nil {
+		return nil
+	}
+	index := len(w.output)
+	text := w.text.String()
+	var item ResponsesOutputItem
+	var err error
+	if w.current.Type == "reasoning" {
+		item = responsesReasoningItem(w.current.ID, text)
+		if err = w.writeEvent("response.reasoning_summary_text.done", gin.H{"item_id": item.ID, "output_index": index, "summary_index": 0, "text": text}); err == nil {
+			err = w.writeEvent("response.reasoning_summary_part.done", gin.H{"item_id": item.ID, "output_index": index, "summary_index": 0, "part": item.Summary[0]})
+		}
+	} else {
+		item = responsesMessageItem(w.current.ID, text)
+		if err = w.writeEvent("response.output_text.done", gin.H{"item_id": item.ID, "output_index": index, "content_index": 0, "text": text}); err == nil {
+			err = w.writeEvent("response.content_part.done", gin.H{"item_id": item.ID, "output_index": index, "content_index": 0, "part": item.Content[0]})
+		}
+	}
+	w.current = nil
+	w.text.Reset()
+	if err != nil {
+		return err
+	}
+	w.output = append(w.output, item)
+	return w.writeEvent("response.output_item.done", gin.H{"output_index": index, "item": item})
+}
+
+// response.created と response.in_progress を書き出す
+func (w *responsesStreamWriter) writeRole() error {
+	if err := w.writeEvent("response.created", gin.H{"response": w.resp}); err != nil {
+		return err
+	}
+	return w.writeEvent("response.in_progress", gin.H{"response": w.resp})
+}
+
+// テキストの差分を message アイテムの response.output_text.delta として書き出す
+func (w *responsesStreamWriter) writeContent(text string) error {
+	if text == "" {
+		return nil
+	}
+	if err := w.openItem("message"); err != nil {
+		return err
+	}
+	w.text.WriteString(text)
+	return w.writeEvent("response.output_text.delta", gin.H{"item_id": w.current.ID, "output_index": len(w.output), "content_index": 0, "delta": text})
+}
+
+// 推論テキストの差分を reasoning アイテムの response.reasoning_summary_text.delta として書き出す
+func (w *responsesStreamWriter) writeReasoning(text string) error {
+	if text == "" {
+		return nil
+	}
+	if err := w.openItem("reasoning"); err != nil {
+		return err
+	}
+	w.text.WriteString(text)
+	return w.writeEvent("response.reasoning_summary_text.delta", gin.H{"item_id": w.current.ID, "output_index": len(w.output), "summary_index": 0, "delta": text})
+}
+
+// ツール呼び出しを function_call アイテムとして書き出す（arguments は response.function_call_arguments.delta で断片ごとに送る）
+func (w *responsesStreamWriter) writeToolCalls(toolCalls []ToolCall) error {
+	if err := w.closeItem(); err != nil {
+		return err
+	}
+	for _, tc := range toolCalls {
+		index := len(w.output)
+		item := responsesFunctionCallItem(generateResponsesID("fc_"), tc)
+		added := gin.H{"type": "function_call", "id": item.ID, "status": "in_progress", "call_id": item.CallID, "name": item.Name, "arguments": ""}
+		if err := w.writeEvent("response.output_item.added", gin.H{"output_index": index, "item": added}); err != nil {
+			return err
+		}
+		for _, fragment := range splitArguments(*item.Arguments, streamArgumentsChunkSize) {
+			if err := w.writeEvent("response.function_call_arguments.delta", gin.H{"item_id": item.ID, "output_index": index, "delta": fragment}); err != nil {
+				return err
+			}
+		}
+		if err := w.writeEvent("response.function_call_arguments.done", gin.H{"item_id": item.ID, "output_index": index, "arguments": *item.Arguments}); err != nil {
+			return err
+		}
+		w.output = append(w.output, item)
+		if err := w.writeEvent("response.output_item.done", gin.H{"output_index": index, "item": item}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 開いているアイテムを閉じ、完成したレスポンスを保存して response.completed（長さの上限・コンテンツフィルターで止まった場合は response.incomplete）を書き出す
+func (w *responsesStreamWriter) writeFinish(reason string, usage *Usage) error {
+	if err := w.closeItem(); err != nil {
+		return err
+	}
+	output := w.output
+	if output == nil {
+		output = []ResponsesOutputItem{}
+	}
+	resp := completeResponse(w.resp, output, usage, reason)
+	storeCompletedResponse(resp, w.history)
+	event := "response.completed"
+	if resp.Status == "incomplete" {
+		event = "response.incomplete"
+	}
+	return w.writeEvent(event, gin.H{"response": resp})
+}
+
+// エラーイベントと、status: "failed" のレスポンスを含む response.failed を書き出す（ストリーム開始後にエラーが発生した場合）
+// 失敗したレスポンスはストアに保存しない
+func (w *responsesStreamWriter) writeError(status int, body map[string]any) error {
+	detail := (&emulationError{status: status, body: body}).detail()
+	if err := w.writeEvent("error", gin.H{"code": detail.Type, "message": detail.Message, "param": nil}); err != nil {
+		return err
+	}
+	failed := w.resp
+	failed.Status = "failed"
+	failed.Output = w.output
+	if failed.Output == nil {
+		failed.Output = []ResponsesOutputItem{}
+	}
+	failed.Error = gin.H{"code": detail.Type, "message": detail.Message}
+	return w.writeEvent("response.failed", gin.H{"response": failed})
+}
+
+// Responses API のストリームには終端の [DONE] がないため何もしない
+func (w *responsesStreamWriter) writeDone() error {
+	return nil
+}
+
+// --- Ginハンドラー ---
+
+// Responses API: リクエストを変換してエミュレートし、output 配列の形式で返す
+func handleResponses(c *gin.Context) {
+	var rreq ResponsesRequest
+	if err := c.ShouldBindJSON(&rreq); err != nil {
+		c.JSON(400, ErrorResponse{Error: ErrorDetail{
+			Message: fmt.Sprintf("Invalid JSON: %v", err),
+			Type:    "invalid_request_error",
+			Code:    stringPtr("invalid_request"),
+		}})
+		return
+	}
+
+	// previous_response_id で保存した会話を引き継ぐ
+	var previous []Message
+	if rreq.PreviousResponseID != "" {
+		stored, ok := responsesStore.get(rreq.PreviousResponseID)
+		if !ok {
+			c.JSON(400, ErrorResponse{Error: ErrorDetail{
+				Message: fmt.Sprintf("Previous response with id '%s' not found.", rreq.PreviousResponseID),
+				Type:    "invalid_request_error",
+				Param:   stringPtr("previous_response_id"),
+				Code:    stringPtr("previous_response_not_found"),
+			}})
+			return
+		}
+		previous = stored.messages
+	}
+	conv, err := rreq.toChatCompletionRequest(previous)
+	if err != nil {
+		c.JSON(400, ErrorResponse{Error: ErrorDetail{
+			Message: err.Error(),
+			Type:    "invalid_request_error",
+			Code:    stringPtr("invalid_request"),
+		}})
+		return
+	}
+	req := conv.req
+	logDebug("Request Received (Responses API)", map[string]any{
+		"Model":                req.Model,
+		"Tool Count":           len(req.Tools),
+		"Message Count":        len(req.Messages),
+		"Previous Response ID": rreq.PreviousResponseID,
+		"Has Stream":           req.Stream,
+	})
+
+	e, apiErr := prepareEmulation(c, req, false)
+	if apiErr != nil {
+		c.JSON(apiErr.status, apiErr.body)
+		return
+	}
+	base := rreq.newResponseObject()
+	if req.Stream {
+		handleChatCompletionsEmulateStream(c, e, streamFrontEnd{
+			newWriter: func() emulationStreamWriter { return newResponsesStreamWriter(c, base, conv.history) },
+			fail:      func(status int, body any) { c.JSON(status, body) },
+		})
+		return
+	}
+	result, apiErr := runEmulation(e, c.Writer.Header())
+	if apiErr != nil {
+		c.JSON(apiErr.status, apiErr.body)
+		return
+	}
+
+	output := []ResponsesOutputItem{}
+	if result.reasoning != "" {
+		output = append(output, responsesReasoningItem(generateResponsesID("rs_"), result.reasoning))
+	}
+	if result.content != "" {
+		output = append(output, responsesMessageItem(generateResponsesID("msg_"), result.content))
+	}
+	for _, tc := range result.toolCalls {
+		output = append(output, responsesFunctionCallItem(generateResponsesID("fc_"), tc))
+	}
+	usage := result.usage()
+	resp := completeResponse(base, output, &usage, result.finishReason)
+	storeCompletedResponse(resp, conv.history)
+
+	logDebug("Response Generated (Responses API)", map[string]any{
+		"Response ID":      resp.ID,
+		"Status":           resp.Status,
+		"Tool Calls Count": len(result.toolCalls),
+		"Stored":           resp.Store,
+	})
+	c.JSON(200, resp)
+}
+
+// 保存したレスポンスを返す（GET /v1/responses/{id}）
+func handleGetResponse(c *gin.Context) {
+	stored, ok := responsesStore.get(c.Param("id"))
+	if !ok {
+		c.JSON(404, ErrorResponse{Error: ErrorDetail{
+			Message: fmt.Sprintf("No response found with id '%s'.", c.Param("id")),
+			Type:    "invalid_request_error",
+			Code:    stringPtr("response_not_found"),
+		}})
+		return
+	}
+	c.JSON(200, stored.response)
+}
+
+// 保存したレスポンスを削除する（DELETE /v1/responses/{id}）
+func handleDeleteResponse(c *gin.Context) {
+	id := c.Param("id")
+	if !responsesStore.delete(id) {
+		c.JSON(404, ErrorResponse{Error: ErrorDetail{
+			Message: fmt.Sprintf("No response found with id '%s'.", id),
+			Type:    "invalid_request_error",
+			Code:    stringPtr("response_not_found"),
+		}})
+		return
+	}
+	c.JSON(200, gin.H{"id": id, "object": "response.deleted", "deleted": true})
+}
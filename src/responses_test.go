@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sseEvents はストリームの本文から各イベントの data を順に取り出す
+func sseEvents(t *testing.T, body string) []map[string]any {
+	t.Helper()
+	var events []map[string]any
+	for _, line := range strings.Split(body, "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event map[string]any
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("invalid event data %q: %v", data, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestResponsesStreamWriterError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	w := newResponsesStreamWriter(c, ResponseObject{ID: "resp_1", Object: "response", Status: "in_progress"}, nil)
+
+	if err := w.writeContent("partial"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writeError(502, map[string]any{"error": map[string]any{"message": "bad arguments", "type": "server_error"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	events := sseEvents(t, rec.Body.String())
+	if len(events) < 2 {
+		t.Fatalf("got %d events, want at least 2", len(events))
+	}
+	errEvent, failedEvent := events[len(events)-2], events[len(events)-1]
+	if errEvent["type"] != "error" || errEvent["message"] != "bad arguments" || errEvent["code"] != "server_error" {
+		t.Errorf("error event = %v", errEvent)
+	}
+	if failedEvent["type"] != "response.failed" {
+		t.Fatalf("last event type = %v, want response.failed", failedEvent["type"])
+	}
+	resp, _ := failedEvent["response"].(map[string]any)
+	if resp["id"] != "resp_1" || resp["status"] != "failed" {
+		t.Errorf("failed response = %v, want id resp_1 and status failed", resp)
+	}
+	if apiErr, _ := resp["error"].(map[string]any); apiErr["message"] != "bad arguments" || apiErr["code"] != "server_error" {
+		t.Errorf("failed response error = %v", resp["error"])
+	}
+	if seq := failedEvent["sequence_number"].(float64); seq != errEvent["sequence_number"].(float64)+1 {
+		t.Errorf("sequence_number = %v, want the next after the error event", seq)
+	}
+}
@@ -0,0 +1,250 @@
+/**
+ * coerce.go
+ *
+ * スキーマに基づくパラメータ値の型変換
+ * XML形式のツール呼び出しではパラメータ値がすべてテキストで書かれるため、値の見た目から型を推定すると
+ * 郵便番号 "01234" が 1234 に、文字列のバージョン "1.10" が 1.1 になってしまう。
+ * ツール定義で宣言されたプロパティの型に従って変換する（string はそのまま、integer / number は数値として解釈、
+ * boolean は一般的な表記を受け付け、array / object は JSON またはネストした XML 要素から組み立てる）。
+ * 型が決まらない場合は変換しない（呼び出し側で従来の推定を使う）。
+ */
+package schema
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CoerceText はテキストで書かれたパラメータ値を、プロパティのスキーマ（prop）の型に合わせて変換する
+// root は $ref の解決に使うツール定義の parameters 全体
+// スキーマから型が決まらない場合は ok=false を返す（変換できない値は、検証で型エラーになるよう文字列のまま返す）
+func CoerceText(root, prop map[string]any, text string) (any, bool) {
+	prop = resolveRef(root, prop, 0)
+	t, nullable := declaredType(prop)
+	if t == "" {
+		return nil, false
+	}
+	trimmed := strings.TrimSpace(text)
+	if nullable && (trimmed == "null" || trimmed == "") && t != "string" {
+		return nil, true
+	}
+
+	switch t {
+	case "string":
+		return text, true
+	case "integer":
+		if i, err := strconv.ParseInt(trimmed, 10, 64); err == nil {
+			return json.Number(strconv.FormatInt(i, 10)), true
+		}
+		if f, err := strconv.ParseFloat(trimmed, 64); err == nil && f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return json.Number(strconv.FormatFloat(f, 'f', -1, 64)), true // "3.0" のような整数値
+		}
+	case "number":
+		if f, err := strconv.ParseFloat(trimmed, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			if json.Valid([]byte(trimmed)) {
+				return json.Number(trimmed), true // 書かれた表記のまま（"1.10" → 1.10）
+			}
+			return f, true
+		}
+	case "boolean":
+		switch strings.ToLower(trimmed) {
+		case "true", "yes", "y", "on", "1":
+			return true, true
+		case "false", "no", "n", "off", "0":
+			return false, true
+		}
+	case "null":
+		if trimmed == "null" || trimmed == "" {
+			return nil, true
+		}
+	case "array", "object":
+		// JSON として書かれた値
+		if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{") {
+			if value, ok := decodeJSON(trimmed); ok {
+				return CoerceValue(root, prop, value), true
+			}
+		}
+		// ネストした XML 要素として書かれた値（<item>a</item><item>b</item> / <city>Paris</city>）
+		if node, ok := parseXMLChildren(trimmed); ok {
+			return coerceNode(root, prop, node), true
+		}
+	}
+	return text, true
+}
+
+// CoerceValue は JSON としてデコードした値のうち、文字列で書かれた数値・真偽値・配列・オブジェクトを
+// スキーマの型に合わせて変換する（オブジェクトのプロパティ・配列の要素にも再帰的に適用する）
+// 型が決まらない値・既に型が合っている値はそのまま返す
+func CoerceValue(root, prop map[string]any, value any) any {
+	prop = resolveRef(root, prop, 0)
+	switch v := value.(type) {
+	case string:
+		if t, _ := declaredType(prop); t != "" && t != "string" {
+			if coerced, ok := CoerceText(root, prop, v); ok {
+				return coerced
+			}
+		}
+	case map[string]any:
+		props, _ := prop["properties"].(map[string]any)
+		additional, _ := prop["additionalProperties"].(map[string]any)
+		for key, item := range v {
+			if sub, ok := props[key].(map[string]any); ok {
+				v[key] = CoerceValue(root, sub, item)
+			} else if additional != nil {
+				v[key] = CoerceValue(root, additional, item)
+			}
+		}
+	case []any:
+		if items, ok := prop["items"].(map[string]any); ok {
+			for i, item := range v {
+				v[i] = CoerceValue(root, items, item)
+			}
+		}
+	}
+	return value
+}
+
+// declaredType はスキーマで宣言された型を返す（"null" との複数型の場合は null 以外の型と nullable=true）
+// type がない場合、enum の値がすべて文字列であれば "string" とする
+func declaredType(prop map[string]any) (string, bool) {
+	switch t := prop["type"].(type) {
+	case string:
+		return t, false
+	case []any:
+		name, nullable := "", false
+		for _, item := range t {
+			s, _ := item.(string)
+			if s == "null" {
+				nullable = true
+			} else if name == "" {
+				name = s
+			}
+		}
+		if name == "" && nullable {
+			return "null", false
+		}
+		return name, nullable
+	}
+	if enum, ok := prop["enum"].([]any); ok && len(enum) > 0 {
+		for _, v := range enum {
+			if _, ok := v.(string); !ok {
+				return "", false
+			}
+		}
+		return "string", false
+	}
+	return "", false
+}
+
+// resolveRef はスキーマが $ref の場合に参照先を返す（解決できない場合は元のスキーマ）
+func resolveRef(root, prop map[string]any, depth int) map[string]any {
+	ref, ok := prop["$ref"].(string)
+	if !ok || depth >= maxRefDepth {
+		return prop
+	}
+	target, err := (&validator{root: root}).resolve(ref)
+	if err != nil {
+		return prop
+	}
+	return resolveRef(root, target, depth+1)
+}
+
+// decodeJSON は JSON 文字列をデコードする（数値は表記を保つため json.Number）
+func decodeJSON(text string) (any, bool) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil || dec.More() {
+		return nil, false
+	}
+	return value, true
+}
+
+// xmlNode はパラメータ値の中にネストした XML 要素
+type xmlNode struct {
+	name     string
+	text     string
+	children []*xmlNode
+}
+
+// parseXMLChildren はテキストを子要素の並びとして解釈する（子要素が1つもない場合・XML として不正な場合は ok=false）
+func parseXMLChildren(text string) (*xmlNode, bool) {
+	if !strings.Contains(text, "<") {
+		return nil, false
+	}
+	dec := xml.NewDecoder(strings.NewReader("<value>" + text + "</value>"))
+	dec.Strict = false
+	root := &xmlNode{}
+	stack := []*xmlNode{}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name.Local}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, false
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		}
+	}
+	if len(stack) != 0 || len(root.children) == 0 {
+		return nil, false
+	}
+	return root, true
+}
+
+// coerceNode は XML 要素をスキーマの型に合わせて変換する
+// array は子要素を（要素名によらず）順に items の型で、object は子要素名をプロパティ名として変換する
+func coerceNode(root, prop map[string]any, node *xmlNode) any {
+	prop = resolveRef(root, prop, 0)
+	t, _ := declaredType(prop)
+	if len(node.children) == 0 {
+		if value, ok := CoerceText(root, prop, node.text); ok {
+			return value
+		}
+		return node.text
+	}
+	switch t {
+	case "array":
+		items, _ := prop["items"].(map[string]any)
+		values := make([]any, len(node.children))
+		for i, child := range node.children {
+			values[i] = coerceNode(root, items, child)
+		}
+		return values
+	default:
+		props, _ := prop["properties"].(map[string]any)
+		additional, _ := prop["additionalProperties"].(map[string]any)
+		obj := make(map[string]any, len(node.children))
+		for _, child := range node.children {
+			sub, _ := props[child.name].(map[string]any)
+			if sub == nil {
+				sub = additional
+			}
+			obj[child.name] = coerceNode(root, sub, child)
+		}
+		return obj
+	}
+}
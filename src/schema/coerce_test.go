@@ -0,0 +1,178 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// asJSON は変換結果を比較用の JSON 文字列にする（json.Number は数値として書かれる、< > はエスケープしない）
+func asJSON(t *testing.T, v any) string {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		t.Fatalf("cannot marshal %#v: %v", v, err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func TestCoerceText(t *testing.T) {
+	const params = `{
+		"type": "object",
+		"properties": {
+			"zip": {"type": "string"},
+			"n": {"type": "integer"},
+			"price": {"type": "number"},
+			"flag": {"type": "boolean"},
+			"maybe": {"type": ["integer", "null"]},
+			"note": {"type": ["string", "null"]},
+			"nothing": {"type": "null"},
+			"any": {"description": "no type"},
+			"seat": {"enum": ["window", "aisle"]},
+			"ref": {"$ref": "#/$defs/count"},
+			"nums": {"type": "array", "items": {"type": "integer"}},
+			"addr": {
+				"type": "object",
+				"properties": {"city": {"type": "string"}, "zip": {"type": "string"}, "floor": {"type": "integer"}}
+			},
+			"counts": {"type": "object", "additionalProperties": {"type": "integer"}},
+			"trip": {
+				"type": "object",
+				"properties": {
+					"stops": {"type": "array", "items": {"$ref": "#/$defs/stop"}},
+					"direct": {"type": "boolean"}
+				}
+			},
+			"loose": {"type": "object"}
+		},
+		"$defs": {
+			"count": {"type": "integer"},
+			"stop": {"type": "object", "properties": {"name": {"type": "string"}, "nights": {"type": "integer"}}}
+		}
+	}`
+	root := mustSchema(t, params)
+	props := root["properties"].(map[string]any)
+
+	tests := []struct {
+		name   string
+		prop   string
+		text   string
+		want   string // 変換結果の JSON
+		wantOK bool
+	}{
+		// string はそのまま
+		{"string keeps leading zeros", "zip", "01234", `"01234"`, true},
+		{"string keeps spaces", "zip", " a b ", `" a b "`, true},
+		{"enum of strings is a string", "seat", "1", `"1"`, true},
+
+		// 数値
+		{"integer", "n", " 42 ", `42`, true},
+		{"integer written as float", "n", "3.0", `3`, true},
+		{"integer rejects fraction", "n", "3.5", `"3.5"`, true},
+		{"integer rejects text", "n", "many", `"many"`, true},
+		{"number keeps notation", "price", "1.10", `1.10`, true},
+		{"number exponent", "price", "1e3", `1e3`, true},
+		{"number not valid as JSON", "price", ".5", `0.5`, true},
+		{"number rejects NaN", "price", "NaN", `"NaN"`, true},
+		{"$ref to integer", "ref", "7", `7`, true},
+
+		// 真偽値
+		{"boolean true", "flag", "true", `true`, true},
+		{"boolean yes", "flag", "Yes", `true`, true},
+		{"boolean 0", "flag", "0", `false`, true},
+		{"boolean rejects text", "flag", "maybe", `"maybe"`, true},
+
+		// null
+		{"nullable integer null", "maybe", "null", `null`, true},
+		{"nullable integer empty", "maybe", "", `null`, true},
+		{"nullable integer value", "maybe", "5", `5`, true},
+		{"nullable string keeps null text", "note", "null", `"null"`, true},
+		{"null type", "nothing", "null", `null`, true},
+
+		// 型が決まらない
+		{"no type", "any", "5", `null`, false},
+
+		// array / object（JSON）
+		{"array JSON", "nums", "[1, 2]", `[1,2]`, true},
+		{"array JSON with string items", "nums", `["1", "2"]`, `[1,2]`, true},
+		{"object JSON", "addr", `{"city": "Paris", "floor": "3"}`, `{"city":"Paris","floor":3}`, true},
+		{"invalid JSON is kept as text", "nums", "[1, 2", `"[1, 2"`, true},
+		{"plain text for array", "nums", "1, 2", `"1, 2"`, true},
+
+		// array / object（ネストした XML）
+		{"array XML", "nums", "<item>1</item>\n<item>2</item>", `[1,2]`, true},
+		{"array XML ignores element names", "nums", "<a>1</a><b>2</b>", `[1,2]`, true},
+		{"object XML", "addr", "<city>Paris</city><zip>01234</zip><floor>3</floor>", `{"city":"Paris","floor":3,"zip":"01234"}`, true},
+		{"object XML additionalProperties", "counts", "<a>1</a><b>2</b>", `{"a":1,"b":2}`, true},
+		{"object XML without property types", "loose", "<x>5</x>", `{"x":"5"}`, true},
+		{
+			"nested XML",
+			"trip",
+			"<stops><stop><name>Rome</name><nights>2</nights></stop><stop><name>Nice</name><nights>1</nights></stop></stops><direct>no</direct>",
+			`{"direct":false,"stops":[{"name":"Rome","nights":2},{"name":"Nice","nights":1}]}`,
+			true,
+		},
+		{"broken XML is kept as text", "addr", "<city>Paris</zip>", `"<city>Paris</zip>"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := CoerceText(root, props[tt.prop].(map[string]any), tt.text)
+			if ok != tt.wantOK {
+				t.Fatalf("CoerceText(%s, %q) ok = %v, want %v", tt.prop, tt.text, ok, tt.wantOK)
+			}
+			if ok {
+				if s := asJSON(t, got); s != tt.want {
+					t.Errorf("CoerceText(%s, %q) = %s, want %s", tt.prop, tt.text, s, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCoerceValue(t *testing.T) {
+	const params = `{
+		"type": "object",
+		"properties": {
+			"zip": {"type": "string"},
+			"n": {"type": "integer"},
+			"flag": {"type": "boolean"},
+			"nums": {"type": "array", "items": {"type": "integer"}},
+			"opts": {"type": "object", "properties": {"limit": {"type": "integer"}, "exact": {"type": "boolean"}}},
+			"places": {"type": "array", "items": {"$ref": "#/$defs/place"}}
+		},
+		"$defs": {
+			"place": {"type": "object", "properties": {"id": {"type": "integer"}}}
+		}
+	}`
+	root := mustSchema(t, params)
+
+	tests := []struct {
+		name string
+		args string
+		want string
+	}{
+		{"typed values are unchanged", `{"zip": "01234", "n": 5, "flag": true}`, `{"flag":true,"n":5,"zip":"01234"}`},
+		{"string number and boolean", `{"n": "5", "flag": "false"}`, `{"flag":false,"n":5}`},
+		{"string holding a JSON array", `{"nums": "[1, \"2\"]"}`, `{"nums":[1,2]}`},
+		{"string holding a JSON object", `{"opts": "{\"limit\": \"10\"}"}`, `{"opts":{"limit":10}}`},
+		{"nested object", `{"opts": {"limit": "10", "exact": "yes"}}`, `{"opts":{"exact":true,"limit":10}}`},
+		{"array items", `{"nums": ["1", 2, "3"]}`, `{"nums":[1,2,3]}`},
+		{"array of $ref objects", `{"places": [{"id": "1"}, {"id": 2}]}`, `{"places":[{"id":1},{"id":2}]}`},
+		{"unconvertible value is kept", `{"n": "five"}`, `{"n":"five"}`},
+		{"undeclared property is kept", `{"extra": "5"}`, `{"extra":"5"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, ok := decodeJSON(tt.args)
+			if !ok {
+				t.Fatalf("invalid test arguments: %s", tt.args)
+			}
+			if got := asJSON(t, CoerceValue(root, root, value)); got != tt.want {
+				t.Errorf("CoerceValue(%s) = %s, want %s", tt.args, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+/**
+ * schema.go
+ *
+ * ツール呼び出しの引数を検証する JSON Schema バリデーター
+ * ツール定義の parameters（JSON Schema）に対し、モデルが出力した引数を検証し、違反を JSON ポインター形式のパス付きで返す。
+ * 対応するキーワードは draft 2020-12 のうちツール定義でよく使われるもの（type, required, enum, properties, items,
+ * additionalProperties, $ref / $defs）に限る。それ以外のキーワードは無視する（検証に失敗させない）。
+ */
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxRefDepth は $ref を連続して辿る上限（循環参照で無限に辿らないため）
+const maxRefDepth = 32
+
+// ValidationError は1件の検証エラー
+type ValidationError struct {
+	Path    string `json:"path"`    // 違反した値の位置（JSON ポインター。ルートは ""）
+	Keyword string `json:"keyword"` // 違反したキーワード（"type", "required" など）
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidateJSON は JSON 文字列を schema に対して検証する（JSON として解釈できない場合は keyword "json" のエラーを返す）
+func ValidateJSON(schema map[string]any, data string) []ValidationError {
+	trimmed := strings.TrimSpace(data)
+	if trimmed == "" {
+		trimmed = "{}"
+	}
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	dec.UseNumber() // 整数かどうかを正確に判定するため
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return []ValidationError{{Keyword: "json", Message: fmt.Sprintf("arguments is not valid JSON: %v", err)}}
+	}
+	if dec.More() {
+		return []ValidationError{{Keyword: "json", Message: "arguments contains trailing data after the JSON value"}}
+	}
+	return Validate(schema, value)
+}
+
+// Validate は値（encoding/json でデコードしたもの）を schema に対して検証する
+// schema が nil または空の場合はすべての値を受け付ける
+func Validate(schema map[string]any, value any) []ValidationError {
+	v := &validator{root: schema}
+	v.validate(schema, value, "", 0)
+	return v.errors
+}
+
+type validator struct {
+	root   map[string]any
+	errors []ValidationError
+}
+
+func (v *validator) fail(path, keyword, format string, args ...any) {
+	v.errors = append(v.errors, ValidationError{Path: path, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(schema map[string]any, value any, path string, depth int) {
+	if len(schema) == 0 {
+		return
+	}
+
+	// $ref（2020-12 では他のキーワードと併用できるため、参照先の検証に加えて残りのキーワードも検証する）
+	if ref, ok := schema["$ref"].(string); ok {
+		if depth >= maxRefDepth {
+			v.fail(path, "$ref", "$ref %q is nested too deeply", ref)
+			return
+		}
+		target, err := v.resolve(ref)
+		if err != nil {
+			v.fail(path, "$ref", "%v", err)
+		} else {
+			v.validate(target, value, path, depth+1)
+		}
+	}
+
+	if !v.validateType(schema, value, path) {
+		return // 型が違う場合、型ごとのキーワードの検証は意味がないため行わない
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		matched := false
+		for _, candidate := range enum {
+			if equalJSON(candidate, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "enum", "value %s is not one of %s", compactJSON(value), compactJSON(enum))
+		}
+	}
+
+	switch val := value.(type) {
+	case map[string]any:
+		v.validateObject(schema, val, path, depth)
+	case []any:
+		v.validateArray(schema, val, path, depth)
+	}
+}
+
+// validateType は type キーワードを検証する（type がない場合・一致した場合は true）
+func (v *validator) validateType(schema map[string]any, value any, path string) bool {
+	var types []string
+	switch t := schema["type"].(type) {
+	case string:
+		types = []string{t}
+	case []any:
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+	default:
+		return true
+	}
+	for _, t := range types {
+		if matchesType(t, value) {
+			return true
+		}
+	}
+	v.fail(path, "type", "expected %s, got %s", strings.Join(types, " or "), typeName(value))
+	return false
+}
+
+func (v *validator) validateObject(schema map[string]any, obj map[string]any, path string, depth int) {
+	if required, ok := schema["required"].([]any); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, exists := obj[name]; name != "" && !exists {
+				v.fail(path, "required", "missing required property %q", name)
+			}
+		}
+	}
+
+	props, _ := schema["properties"].(map[string]any)
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // エラーの順序を安定させる
+
+	for _, key := range keys {
+		childPath := path + "/" + escapePointer(key)
+		if propSchema, ok := props[key]; ok {
+			if sub, ok := propSchema.(map[string]any); ok {
+				v.validate(sub, obj[key], childPath, depth)
+			} else if b, ok := propSchema.(bool); ok && !b {
+				v.fail(childPath, "properties", "property %q is not allowed", key)
+			}
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.fail(childPath, "additionalProperties", "unknown property %q", key)
+			}
+		case map[string]any:
+			v.validate(additional, obj[key], childPath, depth)
+		}
+	}
+}
+
+func (v *validator) validateArray(schema map[string]any, arr []any, path string, depth int) {
+	switch items := schema["items"].(type) {
+	case map[string]any:
+		for i, item := range arr {
+			v.validate(items, item, path+"/"+strconv.Itoa(i), depth)
+		}
+	case bool:
+		if !items && len(arr) > 0 {
+			v.fail(path, "items", "array must be empty")
+		}
+	case []any:
+		// draft 2019-09 以前のタプル形式（2020-12 の prefixItems 相当）
+		for i, item := range arr {
+			if i >= len(items) {
+				break
+			}
+			if sub, ok := items[i].(map[string]any); ok {
+				v.validate(sub, item, path+"/"+strconv.Itoa(i), depth)
+			}
+		}
+	}
+}
+
+// resolve はドキュメント内の $ref（"#", "#/$defs/Name", "#/definitions/Name" などの JSON ポインター）を解決する
+func (v *validator) resolve(ref string) (map[string]any, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q (only local references are supported)", ref)
+	}
+	var current any = v.root
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch node := current.(type) {
+		case map[string]any:
+			next, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("$ref %q cannot be resolved", ref)
+			}
+			current = next
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("$ref %q cannot be resolved", ref)
+			}
+			current = node[i]
+		default:
+			return nil, fmt.Errorf("$ref %q cannot be resolved", ref)
+		}
+	}
+	target, ok := current.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("$ref %q does not point to a schema object", ref)
+	}
+	return target, nil
+}
+
+// matchesType は値が JSON Schema の型に当てはまるかを判定する
+func matchesType(t string, value any) bool {
+	switch t {
+	case "null":
+		return value == nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "number":
+		_, ok := toFloat(value)
+		return ok
+	case "integer":
+		f, ok := toFloat(value)
+		return ok && f == math.Trunc(f) && !math.IsInf(f, 0)
+	}
+	return true // 未知の型名は検証しない
+}
+
+// typeName はエラーメッセージ用の値の型名
+func typeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	}
+	if f, ok := toFloat(value); ok {
+		if f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// toFloat は数値（json.Number または Go の数値型）を float64 にする
+func toFloat(value any) (float64, bool) {
+	switch n := value.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// equalJSON は2つの値を JSON の値として比較する（数値は表記によらず値で比較する）
+func equalJSON(a, b any) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, x := range av {
+			y, exists := bv[k]
+			if !exists || !equalJSON(x, y) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equalJSON(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compactJSON はエラーメッセージ用に値を1行のJSONにする
+func compactJSON(value any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// escapePointer はプロパティ名を JSON ポインターのトークンとしてエスケープする
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
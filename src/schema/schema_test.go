@@ -0,0 +1,167 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// mustSchema はテスト用に JSON 文字列のスキーマをデコードする
+func mustSchema(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(s), &schema); err != nil {
+		t.Fatalf("invalid test schema: %v", err)
+	}
+	return schema
+}
+
+// violation はエラーの位置とキーワード（メッセージは比較しない）
+type violation struct {
+	path    string
+	keyword string
+}
+
+func violations(errs []ValidationError) []violation {
+	var out []violation
+	for _, e := range errs {
+		out = append(out, violation{path: e.Path, keyword: e.Keyword})
+	}
+	return out
+}
+
+func TestValidateJSON(t *testing.T) {
+	const booking = `{
+		"type": "object",
+		"properties": {
+			"city": {"type": "string"},
+			"n": {"type": "integer"},
+			"seat": {"enum": ["window", "aisle"]},
+			"note": {"type": ["string", "null"]},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		},
+		"required": ["city"]
+	}`
+	const defs = `{
+		"type": "object",
+		"properties": {
+			"from": {"$ref": "#/$defs/place"},
+			"stops": {"type": "array", "items": {"$ref": "#/$defs/place"}}
+		},
+		"$defs": {
+			"place": {
+				"type": "object",
+				"properties": {"name": {"type": "string"}, "kind": {"enum": ["city", "airport"]}},
+				"required": ["name"]
+			}
+		}
+	}`
+	const closed = `{
+		"type": "object",
+		"properties": {"city": {"type": "string"}},
+		"additionalProperties": false
+	}`
+	const typedExtra = `{
+		"type": "object",
+		"properties": {"city": {"type": "string"}},
+		"additionalProperties": {"type": "integer"}
+	}`
+
+	tests := []struct {
+		name   string
+		schema string
+		data   string
+		want   []violation
+	}{
+		// type
+		{"type: valid", booking, `{"city": "Tokyo", "n": 2, "note": null}`, nil},
+		{"type: string expected", booking, `{"city": 1}`, []violation{{"/city", "type"}}},
+		{"type: integer rejects fraction", booking, `{"city": "Tokyo", "n": 1.5}`, []violation{{"/n", "type"}}},
+		{"type: integer accepts 2.0", booking, `{"city": "Tokyo", "n": 2.0}`, nil},
+		{"type: union", booking, `{"city": "Tokyo", "note": 3}`, []violation{{"/note", "type"}}},
+		{"type: array items", booking, `{"city": "Tokyo", "tags": ["a", 2]}`, []violation{{"/tags/1", "type"}}},
+		{"type: root", booking, `[1]`, []violation{{"", "type"}}},
+
+		// required
+		{"required: missing", booking, `{"n": 1}`, []violation{{"", "required"}}},
+		{"required: empty arguments", booking, ``, []violation{{"", "required"}}},
+
+		// enum
+		{"enum: match", booking, `{"city": "Tokyo", "seat": "aisle"}`, nil},
+		{"enum: no match", booking, `{"city": "Tokyo", "seat": "middle"}`, []violation{{"/seat", "enum"}}},
+		{"enum: case sensitive", booking, `{"city": "Tokyo", "seat": "Window"}`, []violation{{"/seat", "enum"}}},
+
+		// $ref / $defs
+		{"$ref: valid", defs, `{"from": {"name": "HND", "kind": "airport"}}`, nil},
+		{"$ref: required in target", defs, `{"from": {"kind": "city"}}`, []violation{{"/from", "required"}}},
+		{"$ref: enum in target", defs, `{"from": {"name": "X", "kind": "port"}}`, []violation{{"/from/kind", "enum"}}},
+		{"$ref: from items", defs, `{"stops": [{"name": "A"}, {"name": 1}]}`, []violation{{"/stops/1/name", "type"}}},
+		{"$ref: unresolvable", `{"$ref": "#/$defs/missing"}`, `{}`, []violation{{"", "$ref"}}},
+		{"$ref: remote is unsupported", `{"$ref": "https://example.com/s.json"}`, `{}`, []violation{{"", "$ref"}}},
+		{"$ref: cycle stops", `{"$ref": "#"}`, `{}`, []violation{{"", "$ref"}}},
+
+		// additionalProperties
+		{"additionalProperties: allowed by default", booking, `{"city": "Tokyo", "extra": true}`, nil},
+		{"additionalProperties: false", closed, `{"city": "Tokyo", "extra": true}`, []violation{{"/extra", "additionalProperties"}}},
+		{"additionalProperties: schema", typedExtra, `{"city": "Tokyo", "count": 3, "label": "x"}`, []violation{{"/label", "type"}}},
+
+		// JSON として解釈できない引数
+		{"json: invalid", booking, `{"city": `, []violation{{"", "json"}}},
+		{"json: trailing data", booking, `{"city": "a"} {}`, []violation{{"", "json"}}},
+
+		// 複数の違反は順序が安定する
+		{"multiple", booking, `{"seat": "middle", "n": "2"}`, []violation{{"", "required"}, {"/n", "type"}, {"/seat", "enum"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := violations(ValidateJSON(mustSchema(t, tt.schema), tt.data))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateJSON(%s) = %v, want %v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateEmptySchema(t *testing.T) {
+	if errs := ValidateJSON(nil, `{"anything": [1, "two"]}`); len(errs) != 0 {
+		t.Errorf("nil schema should accept any value, got %v", errs)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	const nested = `{
+		"type": "object",
+		"properties": {
+			"city": {"type": "string"},
+			"opts": {"type": "object", "properties": {"unit": {"type": "string"}}},
+			"open": {"type": "object", "additionalProperties": true}
+		},
+		"$defs": {"p": {"type": "object", "properties": {"x": {"type": "integer"}}}}
+	}`
+	tests := []struct {
+		name string
+		data string
+		want []violation
+	}{
+		{"valid", `{"city": "a", "opts": {"unit": "c"}}`, nil},
+		{"unknown at root", `{"city": "a", "extra": 1}`, []violation{{"/extra", "additionalProperties"}}},
+		{"unknown nested", `{"opts": {"unit": "c", "x": 1}}`, []violation{{"/opts/x", "additionalProperties"}}},
+		{"explicit true kept", `{"open": {"any": 1}}`, nil},
+	}
+	original := mustSchema(t, nested)
+	strict := Strict(original)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := violations(ValidateJSON(strict, tt.data))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateJSON(Strict, %s) = %v, want %v", tt.data, got, tt.want)
+			}
+		})
+	}
+	if _, ok := original["additionalProperties"]; ok {
+		t.Error("Strict modified the original schema")
+	}
+	if def := strict["$defs"].(map[string]any)["p"].(map[string]any); def["additionalProperties"] != false {
+		t.Errorf("Strict did not close $defs schema: %v", def)
+	}
+}
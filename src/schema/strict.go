@@ -0,0 +1,70 @@
+/**
+ * strict.go
+ *
+ * strict モード（OpenAI の Structured Outputs 相当）のスキーマ
+ * strict: true のツールでは、スキーマに書かれていなくても未定義のプロパティを許可しない。
+ * 元のスキーマは書き換えず、すべてのオブジェクトのスキーマに additionalProperties: false を補ったコピーを作る。
+ */
+package schema
+
+// Strict は schema のコピーを返す（additionalProperties を指定していないオブジェクトのスキーマには false を補う）
+// properties / items / $defs / anyOf などの下位のスキーマにも再帰的に適用する
+func Strict(schema map[string]any) map[string]any {
+	if schema == nil {
+		return nil
+	}
+	out := make(map[string]any, len(schema)+1)
+	for key, value := range schema {
+		switch key {
+		case "properties", "patternProperties", "$defs", "definitions":
+			// 名前 → スキーマ
+			if m, ok := value.(map[string]any); ok {
+				sub := make(map[string]any, len(m))
+				for name, item := range m {
+					sub[name] = strictSubschema(item)
+				}
+				value = sub
+			}
+		case "items", "additionalProperties", "not", "contains", "anyOf", "allOf", "oneOf", "prefixItems":
+			value = strictSubschema(value)
+		}
+		out[key] = value
+	}
+	if _, ok := schema["additionalProperties"]; !ok && isObjectSchema(schema) {
+		out["additionalProperties"] = false
+	}
+	return out
+}
+
+// strictSubschema は下位のスキーマ（またはスキーマの配列）に Strict を適用する（true / false のスキーマはそのまま）
+func strictSubschema(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		return Strict(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = strictSubschema(item)
+		}
+		return out
+	}
+	return value
+}
+
+// isObjectSchema はオブジェクトのスキーマか（type が "object" を含む、または properties を持つ）
+func isObjectSchema(s map[string]any) bool {
+	if _, ok := s["properties"].(map[string]any); ok {
+		return true
+	}
+	switch t := s["type"].(type) {
+	case string:
+		return t == "object"
+	case []any:
+		for _, item := range t {
+			if item == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,842 @@
+/**
+ * stream.go
+ *
+ * エミュレートモードのストリーミング（stream: true）対応
+ * Bifrostへストリーミングでリクエストし、受信したテキストからツール呼び出しを抽出して、
+ * OpenAI互換の chat.completion.chunk イベント（SSE）としてクライアントへ再送出する。
+ */
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/t-kawata/tcgw/parser"
+)
+
+// ツール呼び出しの引数JSONを分割送信する際の1断片あたりの文字数（rune単位）
+const streamArgumentsChunkSize = 32
+
+// streamBackendError はストリーム途中でバックエンドがエラーイベントを返したことを示す
+type streamBackendError struct {
+	body map[string]any
+}
+
+func (e *streamBackendError) Error() string {
+	return fmt.Sprintf("backend stream error: %v", e.body["error"])
+}
+
+// --- クライアント向けSSEライター ---
+
+// emulationStreamWriter はエミュレートの結果をクライアント向けAPI（OpenAI / Anthropic など）の形式のストリームとして書き出す
+// finish_reason は OpenAI の値（"stop" / "tool_calls" / "length" / "content_filter"）で渡し、各ライターがAPIごとの値に変換する
+// "length" / "content_filter" はツール呼び出しがない場合に、バックエンドが最後に返した finish_reason をそのまま渡す
+type emulationStreamWriter interface {
+	writeRole() error                          // ストリームの開始
+	writeContent(text string) error            // テキストの差分
+	writeReasoning(text string) error          // 推論テキストの差分
+	writeToolCalls(toolCalls []ToolCall) error // 抽出したツール呼び出し
+	writeFinish(reason string, usage *Usage) error
+	writeError(status int, body map[string]any) error // ストリーム開始後のエラー（status は相当するHTTPステータス、body は OpenAI形式の {"error": {...}}）
+	writeDone() error                                 // ストリームの終端
+}
+
+// streamFrontEnd はクライアント向けAPIごとのストリーミング応答の返し方
+type streamFrontEnd struct {
+	newWriter func() emulationStreamWriter // SSEヘッダーを送出してライターを作る
+	fail      func(status int, body any)   // ストリーム開始前のエラーを返す（body は OpenAI 形式）
+}
+
+// openAIStreamFrontEnd は Chat Completions API のストリーミング応答
+func openAIStreamFrontEnd(c *gin.Context, req *ChatCompletionRequest, legacy bool) streamFrontEnd {
+	return streamFrontEnd{
+		newWriter: func() emulationStreamWriter {
+			w := newOpenAIStreamWriter(c, req.Model)
+			w.legacy = legacy
+			w.includeUsage = req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+			return w
+		},
+		fail: func(status int, body any) { c.JSON(status, body) },
+	}
+}
+
+// openAIStreamWriter はクライアントへ chat.completion.chunk をSSEで書き出す
+type openAIStreamWriter struct {
+	c       *gin.Context
+	id      string
+	model   string
+	created int64
+	legacy  bool // 旧形式（functions）のリクエストへの応答（tool_calls の代わりに function_call を送る）
+	// includeUsage は stream_options.include_usage=true の場合（finish_reason の後に usage のみのチャンクを送る）
+	includeUsage bool
+	index        int // 書き出す選択肢の index（n > 1 の場合は選択肢ごとに切り替える。choices.go）
+}
+
+// SSEヘッダーを送出し、ストリーミング用ライターを作成
+func newOpenAIStreamWriter(c *gin.Context, model string) *openAIStreamWriter {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // リバースプロキシでのバッファリングを無効化
+	c.Status(http.StatusOK)
+	return &openAIStreamWriter{
+		c:       c,
+		id:      generateResponseID(),
+		model:   model,
+		created: time.Now().Unix(),
+	}
+}
+
+// SSEの data: 行を1つ書き出してフラッシュ
+func (w *openAIStreamWriter) writeData(data []byte) error {
+	if _, err := fmt.Fprintf(w.c.Writer, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	w.c.Writer.Flush()
+	return nil
+}
+
+// チャンクを1つ書き出す
+func (w *openAIStreamWriter) writeChunk(choices []ChunkChoice, usage *Usage) error {
+	if choices == nil {
+		choices = []ChunkChoice{} // include_usage の最終チャンクでも "choices": [] とする
+	}
+	chunk := ChatCompletionChunk{
+		ID:      w.id,
+		Object:  "chat.completion.chunk",
+		Created: w.created,
+		Model:   w.model,
+		Choices: choices,
+		Usage:   usage,
+	}
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return err
+	}
+	return w.writeData(data)
+}
+
+// 最初のチャンク（role: assistant）を書き出す
+func (w *openAIStreamWriter) writeRole() error {
+	return w.writeChunk([]ChunkChoice{{Index: w.index, Delta: ChunkDelta{Role: "assistant", Content: stringPtr("")}}}, nil)
+}
+
+// テキストの差分を delta.content として書き出す
+func (w *openAIStreamWriter) writeContent(text string) error {
+	if text == "" {
+		return nil
+	}
+	return w.writeChunk([]ChunkChoice{{Index: w.index, Delta: ChunkDelta{Content: &text}}}, nil)
+}
+
+// 推論テキストの差分を delta.reasoning_content として書き出す
+func (w *openAIStreamWriter) writeReasoning(text string) error {
+	if text == "" {
+		return nil
+	}
+	return w.writeChunk([]ChunkChoice{{Index: w.index, Delta: ChunkDelta{ReasoningContent: &text}}}, nil)
+}
+
+// ツール呼び出しを delta.tool_calls として書き出す
+// 各呼び出しの最初の差分で id / type / name を送り、以降は arguments を断片ごとに送る
+// 旧形式の応答では、最初の呼び出しのみを delta.function_call として同様に送る
+func (w *openAIStreamWriter) writeToolCalls(toolCalls []ToolCall) error {
+	if w.legacy && len(toolCalls) > 0 {
+		tc := toolCalls[0]
+		head := ChunkDelta{FunctionCall: &ToolCallFunctionDelta{Name: tc.Function.Name, Arguments: ""}}
+		if err := w.writeChunk([]ChunkChoice{{Index: w.index, Delta: head}}, nil); err != nil {
+			return err
+		}
+		for _, fragment := range splitArguments(tc.Function.Arguments, streamArgumentsChunkSize) {
+			delta := ChunkDelta{FunctionCall: &ToolCallFunctionDelta{Arguments: fragment}}
+			if err := w.writeChunk([]ChunkChoice{{Index: w.index, Delta: delta}}, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, tc := range toolCalls {
+		head := ToolCallDelta{
+			Index:    i,
+			ID:       tc.ID,
+			Type:     "function",
+			Function: ToolCallFunctionDelta{Name: tc.Function.Name, Arguments: ""},
+		}
+		if err := w.writeChunk([]ChunkChoice{{Index: w.index, Delta: ChunkDelta{ToolCalls: []ToolCallDelta{head}}}}, nil); err != nil {
+			return err
+		}
+		for _, fragment := range splitArguments(tc.Function.Arguments, streamArgumentsChunkSize) {
+			delta := ToolCallDelta{Index: i, Function: ToolCallFunctionDelta{Arguments: fragment}}
+			if err := w.writeChunk([]ChunkChoice{{Index: w.index, Delta: ChunkDelta{ToolCalls: []ToolCallDelta{delta}}}}, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// finish_reason 付きの最終チャンクを書き出す（include_usage の場合は続けて usage のみのチャンクも書き出す）
+// 旧形式の応答では "tool_calls" を "function_call" にする
+func (w *openAIStreamWriter) writeFinish(reason string, usage *Usage) error {
+	if w.legacy && reason == "tool_calls" {
+		reason = legacyFinishReason
+	}
+	if err := w.writeChunk([]ChunkChoice{{Index: w.index, Delta: ChunkDelta{}, FinishReason: &reason}}, nil); err != nil {
+		return err
+	}
+	if !w.includeUsage {
+		return nil
+	}
+	if usage == nil {
+		usage = &Usage{}
+	}
+	return w.writeUsage(*usage)
+}
+
+// usage のみを含むチャンクを書き出す（stream_options.include_usage=true の場合）
+func (w *openAIStreamWriter) writeUsage(usage Usage) error {
+	return w.writeChunk(nil, &usage)
+}
+
+// エラーイベントを書き出す（ストリーム開始後にエラーが発生した場合。OpenAI の形式にはステータスがないため status は使わない）
+func (w *openAIStreamWriter) writeError(status int, body map[string]any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return w.writeData(data)
+}
+
+// ストリーム終端 [DONE] を書き出す
+func (w *openAIStreamWriter) writeDone() error {
+	return w.writeData([]byte("[DONE]"))
+}
+
+// 引数JSONを指定文字数ごとの断片に分割（マルチバイト文字を途中で切らない）
+func splitArguments(args string, size int) []string {
+	if args == "" {
+		return nil
+	}
+	var fragments []string
+	for len(args) > 0 {
+		n := 0
+		end := 0
+		for end < len(args) && n < size {
+			_, w := utf8.DecodeRuneInString(args[end:])
+			end += w
+			n++
+		}
+		fragments = append(fragments, args[:end])
+		args = args[end:]
+	}
+	return fragments
+}
+
+// --- Bifrostからのストリーム受信 ---
+
+// Bifrostへストリーミングリクエストを送り、成功時はレスポンスを返す
+// 失敗時は forwardToBifrost と同じく (エラーボディ, ステータスコード文字列のerror) を返す
+func openBifrostStream(ctx context.Context, req *ChatCompletionRequest) (*http.Response, map[string]any, error) {
+	bodyBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Internal error: failed to marshal request: %v", err)
+	}
+
+	logDebug("Forwarding to Bifrost (Stream)", map[string]any{
+		"URL":       bifrostURL + "/v1/chat/completions",
+		"Body Size": len(bodyBytes),
+		"Timeout":   requestTimeout,
+	})
+
+	httpReq, err := newBifrostRequest(ctx, bodyBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpReq.Header.Set("Accept", "text/event-stream")
+
+	resp, err := (&http.Client{}).Do(httpReq)
+	if err != nil {
+		errBody, code := bifrostTransportError(err)
+		return nil, errBody, code
+	}
+
+	logDebug("Bifrost Stream Opened", map[string]any{
+		"Status Code":  resp.StatusCode,
+		"Content Type": resp.Header.Get("Content-Type"),
+	})
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		var backendErr map[string]any
+		if json.Unmarshal(body, &backendErr) == nil {
+			// Bifrostからのエラーをそのまま転送
+			return nil, backendErr, fmt.Errorf("%d", resp.StatusCode)
+		}
+		return nil, map[string]any{"error": map[string]any{"message": "Invalid response from backend", "type": "server_error"}}, fmt.Errorf("502")
+	}
+	return resp, nil, nil
+}
+
+// SSEストリームを読み、data: 行のペイロードごとに onData を呼ぶ
+// [DONE] を受信した場合やストリームが終端に達した場合は正常終了として nil を返す
+func readSSEEvents(body io.Reader, onData func(data []byte) error) error {
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "data:") {
+			payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if payload == "[DONE]" {
+				return nil
+			}
+			if payload != "" {
+				if cbErr := onData([]byte(payload)); cbErr != nil {
+					return cbErr
+				}
+			}
+		}
+		// event: / id: / コメント行（:）/ 空行は読み飛ばす
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// ストリームのチャンクから choices[0].delta の文字列フィールド（content / reasoning_content）を取り出す
+func extractDeltaFieldFromChunk(event map[string]any, field string) string {
+	choices, ok := event["choices"].([]any)
+	if !ok || len(choices) == 0 {
+		return ""
+	}
+	choice, ok := choices[0].(map[string]any)
+	if !ok {
+		return ""
+	}
+	delta, ok := choice["delta"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	value, _ := delta[field].(string)
+	return value
+}
+
+// ストリームのチャンクから finish_reason を取り出す（null・含まれない場合は空文字）
+func extractFinishReasonFromChunk(event map[string]any) string {
+	choices, ok := event["choices"].([]any)
+	if !ok || len(choices) == 0 {
+		return ""
+	}
+	choice, ok := choices[0].(map[string]any)
+	if !ok {
+		return ""
+	}
+	reason, _ := choice["finish_reason"].(string)
+	return reason
+}
+
+// ストリームのチャンクから usage を取り出す（含まれない場合は nil）
+func extractUsageFromChunk(event map[string]any) *Usage {
+	raw, ok := event["usage"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var usage Usage
+	if err := json.Unmarshal(b, &usage); err != nil {
+		return nil
+	}
+	return &usage
+}
+
+// addUsage は2つの usage を合計する（どちらかが nil の場合はもう一方、詳細の項目も合計する）
+func addUsage(a, b *Usage) *Usage {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	sum := Usage{
+		PromptTokens:     a.PromptTokens + b.PromptTokens,
+		CompletionTokens: a.CompletionTokens + b.CompletionTokens,
+		TotalTokens:      a.TotalTokens + b.TotalTokens,
+	}
+	if a.PromptTokensDetails != nil || b.PromptTokensDetails != nil {
+		pa, pb := PromptTokensDetails{}, PromptTokensDetails{}
+		if a.PromptTokensDetails != nil {
+			pa = *a.PromptTokensDetails
+		}
+		if b.PromptTokensDetails != nil {
+			pb = *b.PromptTokensDetails
+		}
+		sum.PromptTokensDetails = &PromptTokensDetails{
+			CachedTokens: pa.CachedTokens + pb.CachedTokens,
+			AudioTokens:  pa.AudioTokens + pb.AudioTokens,
+		}
+	}
+	if a.CompletionTokensDetails != nil || b.CompletionTokensDetails != nil {
+		ca, cb := CompletionTokensDetails{}, CompletionTokensDetails{}
+		if a.CompletionTokensDetails != nil {
+			ca = *a.CompletionTokensDetails
+		}
+		if b.CompletionTokensDetails != nil {
+			cb = *b.CompletionTokensDetails
+		}
+		sum.CompletionTokensDetails = &CompletionTokensDetails{
+			ReasoningTokens:          ca.ReasoningTokens + cb.ReasoningTokens,
+			AudioTokens:              ca.AudioTokens + cb.AudioTokens,
+			AcceptedPredictionTokens: ca.AcceptedPredictionTokens + cb.AcceptedPredictionTokens,
+			RejectedPredictionTokens: ca.RejectedPredictionTokens + cb.RejectedPredictionTokens,
+		}
+	}
+	return &sum
+}
+
+// --- ツール呼び出しマークアップの保留バッファ ---
+
+// streamTrigger はストリーミング中に保留を始める文字列と、それに続くべき内容
+// confirm はトリガーから始まる保留テキストの先頭が、ツール呼び出しとして続いていることを確かめる正規表現
+// （nil はトリガー自体が特殊トークンなどで文章に現れない場合で、検出した時点で確定とみなす）
+// 保留テキストが streamTriggerWindow バイトを超えても confirm に一致しない場合は、文章（誤検出）として解放する
+type streamTrigger struct {
+	text    string
+	confirm *regexp.Regexp
+	parsers []string // このトリガーで始まる形式を抽出するパーサー名（モデルで選ばれたパーサーに含まれる場合のみ使う）
+}
+
+// streamTriggerWindow はトリガーの後に続くべき内容（タグの中身・JSON の開始・関数名など）を確かめるまでに保留する上限バイト数
+const streamTriggerWindow = 128
+
+// trigger は streamToolCallTriggers の要素を作成する（confirm が空の場合は検出した時点で確定）
+func trigger(text, confirm string, parsers ...string) streamTrigger {
+	t := streamTrigger{text: text, parsers: parsers}
+	if confirm != "" {
+		t.confirm = regexp.MustCompile(confirm)
+	}
+	return t
+}
+
+// streamToolCallTriggers はツール呼び出しの開始となりうる文字列（extractToolCalls が理解する形式の開始部分）
+// ストリーミング中にこれらが現れたら、それ以降のテキストはクライアントへ送らずに保留する
+var streamToolCallTriggers = []streamTrigger{
+	// TCGW標準（XML）
+	trigger("<function_calls>", `^<function_calls>\s*<invoke\s`, "xml"),
+	// DeepSeek V3.1 / R1
+	trigger("<｜tool▁calls▁begin｜>", "", "deepseek-v3.1", "deepseek-r1"),
+	trigger("<tool calls begin>", "", "deepseek-v3.1", "deepseek-r1"),
+	trigger("<toolcalls>", "", "deepseek-v3.1", "deepseek-r1"),
+	// Command R7B
+	trigger("<|START_ACTION|>", "", "command-r7b"),
+	// Granite / GLM 4.5 / Qwen3-Coder / Xiaomi MiMo / Hermes 2 Pro
+	// （JSON・<function=name>・GLM 4.5 の「関数名 + 改行 / <arg_key>」のいずれかが続く）
+	trigger("<tool_call>", `^<tool_call>\s*(\{|\[|<function=|<name>|[A-Za-z_][\w.\-]*\s*(<arg_key>|\n|\{))`,
+		"granite", "glm-4.5", "qwen3-coder", "xiaomi-mimo", "hermes-2-pro"),
+	trigger("<functioncall>", `^<functioncall>\s*(\{|<name>)`, "hermes-2-pro"),
+	trigger("<function>", `^<function>\s*(\{|<name>)`, "hermes-2-pro"),
+	trigger("<tool>", `^<tool>\s*(\{|<name>)`, "hermes-2-pro"),
+	trigger("<tools>", `^<tools>\s*(\{|<name>)`, "hermes-2-pro"),
+	trigger("<response>", `^<response>\s*(\{|<name>)`, "hermes-2-pro"),
+	trigger("<json>", `^<json>\s*(\{|<name>)`, "hermes-2-pro"),
+	trigger("<xml>", `^<xml>\s*(\{|<name>)`, "hermes-2-pro"),
+	trigger("<JSON>", `^<JSON>\s*(\{|<name>)`, "hermes-2-pro"),
+	// GPT-OSS（Harmony のツール呼び出しは <|start|>assistant から始まることがある）
+	// ツール呼び出しは "to=関数名" の付いたチャンネルのみ（final チャンネルなどの通常の応答は保留しない）
+	trigger("<|start|>assistant<|channel|>commentary", `^<\|start\|>assistant<\|channel\|>commentary\s+to=`, "gpt-oss"),
+	trigger("<|channel|>", `^<\|channel\|>(commentary|analysis)\s+to=`, "gpt-oss"),
+	// Seed-OSS
+	trigger("<seed:tool_call>", "", "seed-oss"),
+	// Nemotron v2
+	trigger("<TOOLCALL>", "", "nemotron-v2"),
+	// Apertus
+	trigger("<|tools_prefix|>", "", "apertus"),
+	// LFM2
+	trigger("<|tool_call_start|>", "", "lfm2"),
+	// MiniMax-M2
+	trigger("<minimax:tool_call>", "", "minimax-m2"),
+	// Kimi K2
+	trigger("<|tool_calls_section_begin|>", "", "kimi-k2"),
+	// Apriel 1.5
+	trigger("<tool_calls>", `^<tool_calls>\s*[\[{]`, "apriel-1.5"),
+	// Functionary v3.2（">>>関数名" の後に JSON。">>>all" は文章）
+	trigger(">>>", `^>>>\s*[A-Za-z_][\w.\-]*\s*\{`, "functionary-v3.2"),
+	// Firefunction v2
+	trigger(" functools", `^ functools\s*\[`, "firefunction-v2"),
+	// Functionary v3.1 Llama 3.1 / Qwen3-Coder
+	trigger("<function=", `^<function=[^>\s]+>\s*(\{|<parameter=)`, "functionary-v3.1-llama-3.1", "qwen3-coder"),
+	// Llama 3.x（Llama 3.1 は "type" なしの {"name": ..., "parameters": ...}）
+	trigger(`{"type": "function"`, `^\{"type":\s*"function",\s*"(name|function)"`, "llama-3.x", "generic"),
+	trigger(`{"type":"function"`, `^\{"type":\s*"function",\s*"(name|function)"`, "llama-3.x", "generic"),
+	trigger(`{"name": "`, `^\{"name":\s*"[^"]+",\s*"(parameters|arguments)"`, "llama-3.x", "hermes-2-pro", "generic"),
+	trigger(`{"name":"`, `^\{"name":\s*"[^"]+",\s*"(parameters|arguments)"`, "llama-3.x", "hermes-2-pro", "generic"),
+	// Magistral / Mistral Nemo
+	trigger("[TOOLCALLS]", "", "magistral"),
+	trigger("[TOOL_CALLS]", "", "mistral-nemo"),
+	// JSON / Markdown JSON / ジェネリック（tool_calls, toolcalls, tool_call, toolcall）
+	trigger(`{"tool`, `^\{"tool_?calls?"\s*:`, "json", "generic"),
+	trigger("```json\n{\"tool", "^```json\\n\\{\"tool_?calls?\"\\s*:", "markdown-json"),
+	trigger("```\n{\"tool", "^```\\n\\{\"tool_?calls?\"\\s*:", "markdown-json"),
+}
+
+// streamTriggersFor はモデルで選ばれたパーサーが抽出する形式のトリガーのみを返す
+// 独自パーサー（parser.StreamTriggerer）が提供するトリガーは、そのパーサーが選ばれている場合に加える（検出した時点で確定）
+func streamTriggersFor(parsers []parser.ToolCallParser) []streamTrigger {
+	selected := map[string]bool{}
+	for _, p := range parsers {
+		selected[p.Name()] = true
+	}
+	var triggers []streamTrigger
+	for _, t := range streamToolCallTriggers {
+		for _, name := range t.parsers {
+			if selected[name] {
+				triggers = append(triggers, t)
+				break
+			}
+		}
+	}
+	for _, p := range parsers {
+		if custom, ok := p.(parser.StreamTriggerer); ok {
+			for _, text := range custom.StreamTriggers() {
+				triggers = append(triggers, streamTrigger{text: text, parsers: []string{p.Name()}})
+			}
+		}
+	}
+	return triggers
+}
+
+// toolMarkupHoldBuffer はストリーミング時にツール呼び出しの可能性があるテキストを保留するバッファ
+// 通常の文章はすぐに送出し、トリガー文字列を検出した時点からのテキストは保留して、
+// ストリーム終了時（または保留上限超過時）にツール呼び出しかどうかを判定する
+// トリガーの後に続くべき内容を streamTriggerWindow バイト以内に確かめられない場合は、その時点で文章として解放する
+type toolMarkupHoldBuffer struct {
+	triggers     []streamTrigger
+	markers      []string                // triggers の文字列（末尾の部分一致の判定に使う）
+	parsers      []parser.ToolCallParser // 保留上限超過時の判定に使うパーサー
+	limit        int                     // 保留テキストの上限バイト数（0は無制限）
+	pending      string                  // トリガーの接頭辞と一致する可能性がある末尾（次の断片を見るまで送出を保留）
+	held         string                  // トリガー検出以降の保留テキスト
+	holding      bool                    // トリガーを検出して保留中かどうか
+	trigger      streamTrigger           // 保留を始めたトリガー
+	confirmed    bool                    // トリガーの後にツール呼び出しとして続く内容を確かめたか
+	limitChecked bool                    // 保留上限を超えた時点で完結したツール呼び出しを含んでいたか（以降は判定し直さない）
+}
+
+func newToolMarkupHoldBuffer(triggers []streamTrigger, parsers []parser.ToolCallParser, limit int) *toolMarkupHoldBuffer {
+	markers := make([]string, 0, len(triggers))
+	for _, t := range triggers {
+		markers = append(markers, t.text)
+	}
+	return &toolMarkupHoldBuffer{triggers: triggers, markers: markers, parsers: parsers, limit: limit}
+}
+
+// feed はテキスト片を受け取り、すぐにクライアントへ送出してよいテキストを返す
+func (b *toolMarkupHoldBuffer) feed(text string) string {
+	if b.holding {
+		b.held += text
+		return b.releaseIfNotToolCall()
+	}
+
+	b.pending += text
+
+	// 完全なトリガーが現れたら、その手前までを送出し、以降を保留する
+	if idx, trigger := b.findTrigger(b.pending); idx != -1 {
+		out := b.pending[:idx]
+		b.held = b.pending[idx:]
+		b.pending = ""
+		b.holding = true
+		b.trigger = trigger
+		b.confirmed = trigger.confirm == nil
+		logDebug("Stream Hold Started", map[string]any{"Trigger": trigger.text})
+		return out + b.releaseIfNotToolCall()
+	}
+
+	// 末尾がトリガーの途中までと一致する場合（例: 末尾の "<"）はその部分だけを保留する
+	keep := partialSuffixLen(b.pending, b.markers)
+	out := b.pending[:len(b.pending)-keep]
+	b.pending = b.pending[len(b.pending)-keep:]
+	return out
+}
+
+// holdEverything はトリガーの有無にかかわらず、以降のテキストをすべて保留する（上限による解放もしない）
+func (b *toolMarkupHoldBuffer) holdEverything() {
+	b.held = b.pending + b.held
+	b.pending = ""
+	b.holding = true
+	b.confirmed = true
+	b.limit = 0
+}
+
+// flush はストリーム終了時に保留中のテキストをすべて返す
+// 戻り値はツール呼び出しかどうかを判定する対象のテキスト
+func (b *toolMarkupHoldBuffer) flush() string {
+	rest := b.pending + b.held
+	b.pending = ""
+	b.held = ""
+	b.holding = false
+	return rest
+}
+
+// releaseIfNotToolCall は保留中のテキストがツール呼び出しではないと判断できた時点で解放する
+//   - トリガーの後に続くべき内容が streamTriggerWindow バイト以内に現れない場合は、トリガー自体を文章として送出し、
+//     その後ろのテキストは改めてトリガーを探す
+//   - 保留テキストが上限を超え、かつツール呼び出しとして解釈できない場合も誤検出とみなして解放する
+//     （上限を超えた時点で一度だけ判定し、完結した呼び出しを含んでいた場合はストリーム終了まで保留を続ける）
+func (b *toolMarkupHoldBuffer) releaseIfNotToolCall() string {
+	if !b.confirmed {
+		if b.trigger.confirm.MatchString(b.held) {
+			b.confirmed = true
+		} else if len(b.held) > streamTriggerWindow {
+			logDebug("Stream Hold Released", map[string]any{
+				"Reason":  "trigger not followed by a tool call",
+				"Trigger": b.trigger.text,
+			})
+			out := b.held[:len(b.trigger.text)]
+			rest := b.held[len(b.trigger.text):]
+			b.held = ""
+			b.holding = false
+			return out + b.feed(rest)
+		} else {
+			return ""
+		}
+	}
+	return b.releaseIfOverLimit()
+}
+
+// 保留テキストが上限を超え、かつツール呼び出しとして解釈できない場合は誤検出とみなして解放する
+// 解放したテキストは送出し、以降は再び通常の検出状態に戻る
+func (b *toolMarkupHoldBuffer) releaseIfOverLimit() string {
+	if b.limit <= 0 || b.limitChecked || len(b.held) <= b.limit {
+		return ""
+	}
+	if toolCalls, _ := extractToolCalls(b.held, b.parsers); len(toolCalls) > 0 {
+		b.limitChecked = true
+		return "" // 完結したツール呼び出しを含むので保留を継続（後続の呼び出しが続く可能性がある）
+	}
+	logDebug("Stream Hold Released", map[string]any{
+		"Reason":   "hold limit exceeded without a tool call",
+		"Held Len": len(b.held),
+	})
+	out := b.held
+	b.held = ""
+	b.holding = false
+	return out
+}
+
+// テキスト中で最も手前にある完全なトリガーの位置を返す（見つからない場合は -1）
+func (b *toolMarkupHoldBuffer) findTrigger(text string) (int, streamTrigger) {
+	best := -1
+	var bestTrigger streamTrigger
+	for _, trigger := range b.triggers {
+		if idx := strings.Index(text, trigger.text); idx != -1 && (best == -1 || idx < best) {
+			best = idx
+			bestTrigger = trigger
+		}
+	}
+	return best, bestTrigger
+}
+
+// partialSuffixLen はテキスト末尾のうち、いずれかのマーカーの真の接頭辞と一致する最長の長さを返す
+func partialSuffixLen(text string, markers []string) int {
+	longest := 0
+	for _, marker := range markers {
+		max := len(marker) - 1
+		if max > len(text) {
+			max = len(text)
+		}
+		for n := max; n > longest; n-- {
+			if strings.HasSuffix(text, marker[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+// --- Ginハンドラー ---
+
+// エミュレートモード（ストリーミング）: Bifrostからのストリームを受けてツール呼び出しをエミュレート
+// req はツール定義の埋め込み済みであること
+// ツール呼び出し時に content を null にする設定のモデル（デフォルト）では、ツール定義があるリクエストの文章も逐次送出せずに最後まで保留する
+// tool_choice で呼び出しを強制した場合（parallel_tool_calls: false・引数の検証で聞き直す設定の場合も）は、
+// 条件を満たさない出力を聞き直した結果で置き換えられるよう、本文を最後まで保留する
+// クライアントへの書き出しは front（Chat Completions / Messages など、APIごとの形式）に従う
+func handleChatCompletionsEmulateStream(c *gin.Context, e *emulation, front streamFrontEnd) {
+	req, modelConfig, requirement, renderer := e.req, e.modelConfig, e.requirement, e.renderer
+	requirement.validation = &validationRecord{}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(requestTimeout)*time.Millisecond)
+	defer cancel()
+
+	resp, errBody, ferr := openBifrostStream(ctx, req)
+	if ferr != nil {
+		code := 500
+		if s, err := strconv.Atoi(ferr.Error()); err == nil {
+			code = s
+		}
+		if errBody == nil {
+			errBody = map[string]any{"error": map[string]any{"message": ferr.Error(), "type": "server_error"}}
+		}
+		logDebug("Bifrost Response Error", errBody)
+		front.fail(code, errBody)
+		return
+	}
+	defer resp.Body.Close()
+
+	// 聞き直しの回数と結果は本文の後に決まるため、トレーラーとして送る
+	if requirement.repair.enabled() {
+		c.Writer.Header().Set("Trailer", headerRepairAttempts+", "+headerRepairResult)
+	}
+	w := front.newWriter()
+	if err := w.writeRole(); err != nil {
+		return // クライアント切断
+	}
+
+	// 推論テキストを本文から分離したうえで、通常の文章はそのまま送出し、ツール呼び出しの可能性があるテキストのみ保留する
+	splitter := &reasoningStreamSplitter{}
+	parsers := toolCallParsersFor(modelConfig, requirement.choice)
+	triggers := streamTriggersFor(parsers) // tool_choice: "none" ではパーサーがないため保留しない
+	hold := newToolMarkupHoldBuffer(triggers, parsers, streamHoldLimit)
+	// ツール定義がないリクエスト（tool_choice: "none" を含む）は content を null にする呼び出しがないため、文章をそのまま送る
+	if (!modelConfig.KeepContentWithToolCalls && len(requirement.tools) > 0 && len(parsers) > 0) || requirement.needsHold() {
+		hold.holdEverything()
+	}
+	var usage *Usage
+	backendFinish := "" // バックエンドが最後に返した null でない finish_reason
+	streamedLen := 0
+	reasoningLen := 0
+	// 推論と本文を順に送出する（書き込み失敗はクライアント切断）
+	emit := func(content, reasoning string) error {
+		reasoningLen += len(reasoning)
+		if err := w.writeReasoning(reasoning); err != nil {
+			return err
+		}
+		out := hold.feed(content)
+		streamedLen += len(out)
+		return w.writeContent(out)
+	}
+	readErr := readSSEEvents(resp.Body, func(data []byte) error {
+		var event map[string]any
+		if err := json.Unmarshal(data, &event); err != nil {
+			logDebug("Stream Chunk Parse Failed", map[string]any{"Error": err.Error()})
+			return nil
+		}
+		if _, ok := event["error"]; ok {
+			return &streamBackendError{body: event}
+		}
+		if u := extractUsageFromChunk(event); u != nil {
+			usage = u
+		}
+		if reason := extractFinishReasonFromChunk(event); reason != "" {
+			backendFinish = reason
+		}
+		// バックエンドが返した reasoning_content はそのまま推論として送出
+		if err := w.writeReasoning(extractDeltaFieldFromChunk(event, "reasoning_content")); err != nil {
+			return err
+		}
+		return emit(splitter.feed(extractDeltaFieldFromChunk(event, "content"))) // 書き込み失敗時は受信を中断
+	})
+	if readErr != nil {
+		var backendErr *streamBackendError
+		if errors.As(readErr, &backendErr) {
+			logDebug("Bifrost Stream Error", backendErr.body)
+			_ = w.writeError(502, backendErr.body)
+			_ = w.writeDone()
+			return
+		}
+		logDebug("Bifrost Stream Read Failed", map[string]any{"Error": readErr.Error()})
+		_ = w.writeError(502, map[string]any{"error": map[string]any{"message": fmt.Sprintf("Backend stream error: %v", readErr), "type": "server_error"}})
+		_ = w.writeDone()
+		return
+	}
+
+	// 推論の分離で保留していた末尾を処理
+	if err := emit(splitter.flush()); err != nil {
+		return
+	}
+
+	// 保留していたテキストがツール呼び出しかどうかを判定
+	heldText := hold.flush()
+	toolCalls, spans := extractToolCalls(heldText, parsers)
+
+	// tool_choice・parallel_tool_calls: false の条件を満たしていない・壊れた呼び出しを修復する場合は（ストリーミングなしで）聞き直す
+	var reask string
+	attempts, repaired := 0, false
+	if toolCalls, reask = requirement.check(heldText, toolCalls); reask != "" {
+		var retry *toolCallRetryResult
+		if retry, attempts = retryForToolCalls(e.ctx, req, requirement, renderer, modelConfig, parsers, heldText, reask); retry != nil {
+			repaired = true
+			if err := w.writeReasoning(retry.reasoning); err != nil {
+				return
+			}
+			reasoningLen += len(retry.reasoning)
+			heldText, toolCalls, spans = retry.content, retry.toolCalls, retry.spans
+			backendFinish = backendFinishReason(retry.backendResp)
+			usage = addUsage(usage, extractUsageFromChunk(retry.backendResp)) // 聞き直しで使ったトークンも最終チャンクの usage に含める
+		} else {
+			var strictErr *strictToolCallError
+			if toolCalls, strictErr = requirement.fallback(toolCalls); strictErr != nil {
+				// strict: true のツールの引数がスキーマに合わない場合は、合わない引数を送らずにエラーを送る
+				if requirement.repair.enabled() {
+					setRepairHeaders(c.Writer.Header(), requirement.repair, attempts, false)
+				}
+				_ = w.writeError(502, map[string]any{"error": strictErr.response().Error})
+				_ = w.writeDone() // エラーの後もストリームの終端（[DONE] など）を送り、クライアントが終わりを待ち続けないようにする
+				return
+			}
+		}
+	}
+
+	finish := emulatedFinishReason(backendFinish, toolCalls)
+	var writeErr error
+	if len(toolCalls) > 0 {
+		// マークアップの後ろなどに残った文章は tool_calls の前に送る
+		if modelConfig.KeepContentWithToolCalls {
+			writeErr = w.writeContent(removeMarkupSpans(heldText, spans))
+		}
+		if writeErr == nil {
+			writeErr = w.writeToolCalls(toolCalls)
+		}
+	} else if len(spans) > 0 {
+		// 抽出した呼び出しをすべて取り除いた場合（検証で drop など）は、マークアップを除いた文章のみ送出
+		writeErr = w.writeContent(requirement.finalAnswer(removeMarkupSpans(heldText, spans)))
+	} else {
+		// ツール呼び出しではなかった（誤検出）ので文章として送出
+		writeErr = w.writeContent(requirement.finalAnswer(heldText))
+	}
+	if writeErr != nil {
+		return // クライアント切断
+	}
+	if err := w.writeFinish(finish, usage); err != nil {
+		return
+	}
+	if requirement.repair.enabled() {
+		setRepairHeaders(c.Writer.Header(), requirement.repair, attempts, repaired)
+	}
+	setValidationHeader(c.Writer.Header(), [][]toolCallValidationReport{requirement.validation.reports}, true)
+	_ = w.writeDone()
+
+	logDebug("Stream Completed (Emulate Mode)", map[string]any{
+		"Finish Reason":    finish,
+		"Tool Calls Count": len(toolCalls),
+		"Streamed Len":     streamedLen,
+		"Held Len":         len(heldText),
+		"Reasoning Len":    reasoningLen,
+	})
+}
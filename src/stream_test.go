@@ -0,0 +1,206 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/t-kawata/tcgw/parser"
+)
+
+// holdResult はテキスト片を順に保留バッファへ渡した結果
+type holdResult struct {
+	streamed string // ストリーム中に送出したテキスト
+	held     string // ストリーム終了時に残っていたテキスト（ツール呼び出しかどうかを判定する対象）
+}
+
+// feedHold は chunks を順に渡して送出されたテキストと最後に残ったテキストを返す
+// 送出した各断片が UTF-8 として正しいことも確かめる
+func feedHold(t *testing.T, b *toolMarkupHoldBuffer, chunks []string) holdResult {
+	t.Helper()
+	var streamed strings.Builder
+	for _, chunk := range chunks {
+		out := b.feed(chunk)
+		if !utf8.ValidString(out) {
+			t.Fatalf("feed(%q) emitted invalid UTF-8: %q", chunk, out)
+		}
+		streamed.WriteString(out)
+	}
+	return holdResult{streamed: streamed.String(), held: b.flush()}
+}
+
+// chainFor はテスト用にパーサー名からパーサーの並びを作る
+func chainFor(t *testing.T, names ...string) []parser.ToolCallParser {
+	t.Helper()
+	if len(names) == 0 {
+		return parser.Default.Parsers()
+	}
+	chain, err := parser.Default.Chain(names)
+	if err != nil {
+		t.Fatalf("Chain(%v): %v", names, err)
+	}
+	return chain
+}
+
+// runeChunks は text を n 文字ずつ（バイトではなく文字単位で）分ける
+func runeChunks(text string, n int) []string {
+	runes := []rune(text)
+	var chunks []string
+	for i := 0; i < len(runes); i += n {
+		end := i + n
+		if end > len(runes) {
+			end = len(runes)
+		}
+		chunks = append(chunks, string(runes[i:end]))
+	}
+	return chunks
+}
+
+func TestToolMarkupHoldBuffer(t *testing.T) {
+	const call = `<tool_call>{"name":"get_weather","arguments":{"city":"Tokyo"}}</tool_call>`
+	longProse := strings.Repeat("plain words ", 20) + "end." // streamTriggerWindow を超える長さ（末尾の空白は " functools" の接頭辞として保留されるため句点で終える）
+	jaProse := strings.Repeat("あ", 60)                       // 180 バイト（3 バイト文字がウィンドウの境界をまたぐ）
+
+	tests := []struct {
+		name    string
+		parsers []string // 空の場合は標準の順のすべてのパーサー
+		limit   int
+		chunks  []string
+		want    holdResult
+	}{
+		{
+			name:   "plain text streams immediately",
+			chunks: []string{"Hello, ", "world."},
+			want:   holdResult{streamed: "Hello, world."},
+		},
+		{
+			name:   "trigger split across chunks",
+			chunks: []string{"Checking. <tool", "_call>", call[len("<tool_call>"):]},
+			want:   holdResult{streamed: "Checking. ", held: call},
+		},
+		{
+			name:   "trigger split after a single byte",
+			chunks: []string{"a <", "function_calls>\n<invoke name=\"f\">"},
+			want:   holdResult{streamed: "a ", held: "<function_calls>\n<invoke name=\"f\">"},
+		},
+		{
+			name:   "partial trigger released when the next chunk does not match",
+			chunks: []string{"1 <", "2"},
+			want:   holdResult{streamed: "1 <2"},
+		},
+		{
+			name:   "multibyte trigger split across chunks",
+			chunks: []string{"x<｜tool▁", "calls▁begin｜>"},
+			want:   holdResult{streamed: "x", held: "<｜tool▁calls▁begin｜>"},
+		},
+		{
+			name:   "literal <tool> in prose is released after the window",
+			chunks: append([]string{"Wrap it in a <tool> tag: "}, runeChunks(longProse, 7)...),
+			want:   holdResult{streamed: "Wrap it in a <tool> tag: " + longProse},
+		},
+		{
+			name:   "confirmed <tool> stays held",
+			chunks: []string{"<tool>", `{"name": "f"`, longProse},
+			want:   holdResult{held: `<tool>{"name": "f"` + longProse},
+		},
+		{
+			name:   "unconfirmed trigger still held at stream end is judged at flush",
+			chunks: []string{"see <tool> here"},
+			want:   holdResult{streamed: "see ", held: "<tool> here"},
+		},
+		{
+			name:   "trigger inside released text is detected again",
+			chunks: []string{"<tool> " + longProse + call},
+			want:   holdResult{streamed: "<tool> " + longProse, held: call},
+		},
+		{
+			name:   "multibyte prose at the window edge",
+			chunks: append([]string{"<tool>"}, runeChunks(jaProse, 5)...),
+			want:   holdResult{streamed: "<tool>" + jaProse},
+		},
+		{
+			name:    "triggers of unselected parsers are ignored",
+			parsers: []string{"xml"},
+			chunks:  []string{"Text ", call},
+			want:    holdResult{streamed: "Text " + call},
+		},
+		{
+			name:   "over-limit text without a tool call is released",
+			limit:  64,
+			chunks: []string{"[TOOL_CALLS]", longProse},
+			want:   holdResult{streamed: "[TOOL_CALLS]" + longProse},
+		},
+		{
+			name:   "over-limit text with a complete tool call stays held",
+			limit:  64,
+			chunks: []string{call, longProse},
+			want:   holdResult{held: call + longProse},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsers := chainFor(t, tt.parsers...)
+			b := newToolMarkupHoldBuffer(streamTriggersFor(parsers), parsers, tt.limit)
+			if got := feedHold(t, b, tt.chunks); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToolMarkupHoldBufferHoldEverything(t *testing.T) {
+	parsers := chainFor(t)
+	b := newToolMarkupHoldBuffer(streamTriggersFor(parsers), parsers, 16)
+	b.holdEverything()
+	text := strings.Repeat("no tool call here ", 10)
+	want := holdResult{held: text}
+	if got := feedHold(t, b, runeChunks(text, 9)); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestPartialSuffixLen(t *testing.T) {
+	markers := []string{"<tool_call>", "<function_calls>", "[TOOL_CALLS]"}
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"hello", 0},
+		{"hello <", 1},
+		{"hello <tool_c", 7},
+		{"hello <func", 5},
+		{"[TOOL", 5},
+		{"<tool_call>", 0}, // 完全に一致するものは接頭辞ではない
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := partialSuffixLen(tt.text, markers); got != tt.want {
+			t.Errorf("partialSuffixLen(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestAddUsage(t *testing.T) {
+	a := &Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15, CompletionTokensDetails: &CompletionTokensDetails{ReasoningTokens: 2}}
+	b := &Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15, PromptTokensDetails: &PromptTokensDetails{CachedTokens: 8}}
+
+	if got := addUsage(nil, b); got != b {
+		t.Errorf("addUsage(nil, b) = %+v, want b", got)
+	}
+	if got := addUsage(a, nil); got != a {
+		t.Errorf("addUsage(a, nil) = %+v, want a", got)
+	}
+	got := addUsage(a, b)
+	if got.PromptTokens != 22 || got.CompletionTokens != 8 || got.TotalTokens != 30 {
+		t.Errorf("addUsage tokens = %+v, want 22/8/30", got)
+	}
+	if got.PromptTokensDetails == nil || got.PromptTokensDetails.CachedTokens != 8 {
+		t.Errorf("addUsage prompt details = %+v, want cached 8", got.PromptTokensDetails)
+	}
+	if got.CompletionTokensDetails == nil || got.CompletionTokensDetails.ReasoningTokens != 2 {
+		t.Errorf("addUsage completion details = %+v, want reasoning 2", got.CompletionTokensDetails)
+	}
+	if a.PromptTokens != 10 || b.PromptTokens != 12 {
+		t.Error("addUsage modified its arguments")
+	}
+}
@@ -0,0 +1,383 @@
+/**
+ * toolchoice.go
+ *
+ * tool_choice のエミュレーション
+ * ツール定義はバックエンドに送らないため、tool_choice もそのままでは効果がない。
+ * "none" はツール定義を埋め込まず、出力中のツール呼び出しらしきマークアップも解釈しない。
+ * "required" と関数名の指定は、システムプロンプトに指示を加えたうえで抽出結果を確認し、
+ * 従わない出力だった場合は、指示を加えてモデルに聞き直す（モデル設定で有効にした場合は開始タグを assistant のプレフィルとして渡す）。
+ * parallel_tool_calls: false も同様に、1つだけ呼び出すよう指示し、複数の呼び出しがあった場合は先頭のみ残すか聞き直す（SINGLE_TOOL_CALL_POLICY）。
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/t-kawata/tcgw/config"
+	"github.com/t-kawata/tcgw/parser"
+)
+
+// parallel_tool_calls: false で複数の呼び出しがあった場合の扱い
+const (
+	singleToolCallKeepFirst = "keep_first" // 先頭の呼び出しのみ残す
+	singleToolCallRetry     = "retry"      // モデルに聞き直す
+)
+
+// tool_choice のモード
+const (
+	toolChoiceAuto     = "auto"
+	toolChoiceNone     = "none"
+	toolChoiceRequired = "required"
+	toolChoiceFunction = "function" // 関数名を指定した呼び出しの強制
+)
+
+// toolChoice はリクエストの tool_choice を解釈したもの
+type toolChoice struct {
+	Mode string // "auto", "none", "required", "function"
+	Name string // Mode が "function" の場合の関数名
+}
+
+// parseToolChoice は tool_choice（文字列 または {"type":"function","function":{"name":...}}）を解釈する
+// 未指定の場合とツール定義がない場合は "auto"。指定した関数名がツール定義にない場合はエラーを返す
+func parseToolChoice(value any, tools []Tool) (toolChoice, error) {
+	if len(tools) == 0 {
+		return toolChoice{Mode: toolChoiceAuto}, nil
+	}
+	switch v := value.(type) {
+	case nil:
+		return toolChoice{Mode: toolChoiceAuto}, nil
+	case string:
+		switch v {
+		case toolChoiceAuto, toolChoiceNone, toolChoiceRequired:
+			return toolChoice{Mode: v}, nil
+		}
+		return toolChoice{}, fmt.Errorf("invalid tool_choice %q (expected \"none\", \"auto\", \"required\" or a function object)", v)
+	case map[string]any:
+		if t, _ := v["type"].(string); t != "function" {
+			return toolChoice{}, fmt.Errorf("invalid tool_choice type %q (expected \"function\")", t)
+		}
+		fn, _ := v["function"].(map[string]any)
+		name, _ := fn["name"].(string)
+		if name == "" {
+			return toolChoice{}, fmt.Errorf("tool_choice.function.name is required")
+		}
+		for _, tool := range tools {
+			if tool.Function.Name == name {
+				return toolChoice{Mode: toolChoiceFunction, Name: name}, nil
+			}
+		}
+		return toolChoice{}, fmt.Errorf("tool_choice function %q is not defined in tools", name)
+	}
+	return toolChoice{}, fmt.Errorf("invalid tool_choice (expected a string or a function object)")
+}
+
+// forcesCall はツール呼び出しを強制するモード（"required" / 関数名の指定）かどうか
+func (tc toolChoice) forcesCall() bool {
+	return tc.Mode == toolChoiceRequired || tc.Mode == toolChoiceFunction
+}
+
+// instruction はツール呼び出しを強制する場合にシステムプロンプトの末尾に加える指示
+func (tc toolChoice) instruction() string {
+	switch tc.Mode {
+	case toolChoiceRequired:
+		return "IMPORTANT: In this response you MUST call at least one of the tools above. Do NOT answer in natural language."
+	case toolChoiceFunction:
+		return fmt.Sprintf("IMPORTANT: In this response you MUST call the tool %q. Do NOT call any other tool and do NOT answer in natural language.", tc.Name)
+	}
+	return ""
+}
+
+// reaskMessage は従わない出力だった場合に、聞き直すために送る user メッセージ
+func (tc toolChoice) reaskMessage() string {
+	if tc.Mode == toolChoiceFunction {
+		return fmt.Sprintf("Your previous response did not call the tool %q. You MUST call %q now, using the exact tool call format described in the system prompt. Respond with the tool call only.", tc.Name, tc.Name)
+	}
+	return "Your previous response did not contain a valid tool call. You MUST call a tool now, using the exact tool call format described in the system prompt. Respond with the tool call only."
+}
+
+// apply は抽出したツール呼び出しが tool_choice に従っているかを判定する
+// 関数名の指定では、指定した関数以外の呼び出しを取り除く（1つも残らなければ従っていない）
+func (tc toolChoice) apply(calls []ToolCall) ([]ToolCall, bool) {
+	switch tc.Mode {
+	case toolChoiceRequired:
+		return calls, len(calls) > 0
+	case toolChoiceFunction:
+		var matched []ToolCall
+		for _, call := range calls {
+			if call.Function.Name == tc.Name {
+				matched = append(matched, call)
+			}
+		}
+		if len(matched) < len(calls) {
+			logDebug("Tool Choice: Calls Dropped", map[string]any{"Required": tc.Name, "Dropped": len(calls) - len(matched)})
+		}
+		return matched, len(matched) > 0
+	}
+	return calls, true
+}
+
+// toolCallRequirement はモデルの出力に求めるツール呼び出しの条件（tool_choice・parallel_tool_calls: false・引数の検証）
+type toolCallRequirement struct {
+	choice           toolChoice
+	single           bool   // parallel_tool_calls: false（1回の応答で呼び出せるツールは1つ）
+	retryOnMultiple  bool   // 複数の呼び出しがあった場合に聞き直すか（false の場合は先頭のみ残す）
+	tools            []Tool // 呼び出しの確認（ツール名の補正・引数の検証）に使うツール定義（埋め込み前のリクエストのもの）
+	validationPolicy string // 検証に失敗した呼び出しの扱い（"pass", "drop", "repair"）
+	namePolicy       string // 宣言されていないツール名の呼び出しの扱い（"correct", "strict", "off"）
+	repair           toolCallRepair
+	format           responseFormat    // response_format をエミュレートする場合の最終回答の形式（ツール呼び出しがない応答に求める）
+	validation       *validationRecord // 最後に行った検証の結果の記録先（nil の場合は記録しない。選択肢ごとに作る）
+}
+
+// newToolCallRequirement はリクエストとモデル設定から出力に求める条件を作る
+func newToolCallRequirement(req *ChatCompletionRequest, choice toolChoice, modelConfig config.ModelConfig, renderer toolPromptRenderer) toolCallRequirement {
+	return toolCallRequirement{
+		choice:           choice,
+		single:           req.ParallelToolCalls != nil && !*req.ParallelToolCalls && len(req.Tools) > 0,
+		retryOnMultiple:  resolveSingleToolCallPolicy(modelConfig) == singleToolCallRetry,
+		tools:            req.Tools,
+		validationPolicy: resolveValidationPolicy(modelConfig),
+		namePolicy:       resolveToolNamePolicy(modelConfig),
+		repair:           newToolCallRepair(modelConfig, renderer, toolCallParsersFor(modelConfig, choice)),
+	}
+}
+
+// resolveSingleToolCallPolicy はモデル設定の single_tool_call_policy（未指定の場合は SINGLE_TOOL_CALL_POLICY）を返す
+func resolveSingleToolCallPolicy(modelConfig config.ModelConfig) string {
+	if modelConfig.SingleToolCallPolicy != "" {
+		return modelConfig.SingleToolCallPolicy
+	}
+	return singleToolCallPolicy
+}
+
+// validSingleToolCallPolicy は single_tool_call_policy として有効な値か
+func validSingleToolCallPolicy(policy string) bool {
+	return policy == singleToolCallKeepFirst || policy == singleToolCallRetry
+}
+
+// needsHold は出力を聞き直した結果で置き換える可能性があるか（ストリーミング時に本文を最後まで保留する）
+func (r toolCallRequirement) needsHold() bool {
+	return r.choice.forcesCall() || (r.single && r.retryOnMultiple) || (len(r.tools) > 0 && r.repairsInvalid()) || r.hasStrictTools() || r.format.active()
+}
+
+// hasStrictTools は strict: true のツールがあるか（違反した場合はエラーレスポンスに置き換えるため、ストリーミング時は本文を保留する）
+func (r toolCallRequirement) hasStrictTools() bool {
+	for _, tool := range r.tools {
+		if tool.Function.Strict != nil && *tool.Function.Strict {
+			return true
+		}
+	}
+	return false
+}
+
+// repairsInvalid は検証に失敗した呼び出しを聞き直して直すか（validation_policy が repair、または修復が有効）
+func (r toolCallRequirement) repairsInvalid() bool {
+	return r.validationPolicy == validationRepair || r.repair.enabled()
+}
+
+// maxAttempts は聞き直す回数の上限（修復が有効な場合は repair_attempts、それ以外は TOOL_CHOICE_RETRIES）
+func (r toolCallRequirement) maxAttempts() int {
+	if r.repair.enabled() {
+		return r.repair.attempts
+	}
+	return toolChoiceRetries
+}
+
+// instruction はシステムプロンプトの末尾に加える指示
+func (r toolCallRequirement) instruction() string {
+	var lines []string
+	if s := r.choice.instruction(); s != "" {
+		lines = append(lines, s)
+	}
+	if r.single && r.choice.Mode != toolChoiceNone {
+		lines = append(lines, "IMPORTANT: You may call at most ONE tool in this response. Do NOT call multiple tools at once; wait for the result before calling the next tool.")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// check は抽出したツール呼び出しが条件を満たすかを判定する
+// 満たさない場合は、聞き直すための user メッセージを返す（満たす場合は空）
+// 検証に失敗した呼び出しは validation_policy に従って扱う（drop の場合は取り除いたうえで残りを判定する）
+// parallel_tool_calls: false で聞き直さない設定の場合は、先頭の呼び出しのみ残して満たしたものとする
+// 修復が有効な場合は、呼び出しを抽出できなかった本文（content）に壊れたマークアップがあれば聞き直す
+// response_format をエミュレートする場合は、ツール呼び出しがない本文が形式を満たさなければ聞き直す
+func (r toolCallRequirement) check(content string, calls []ToolCall) ([]ToolCall, string) {
+	if len(calls) == 0 && len(r.tools) > 0 && r.choice.Mode != toolChoiceNone {
+		if reask := r.repair.malformed(content); reask != "" {
+			return calls, reask
+		}
+	}
+	// 関数名を宣言済みのツールに合わせ、引数の型をツール定義のスキーマに合わせてから検証する
+	calls = r.correctNames(calls, false)
+	calls = coerceToolCallArguments(calls, r.tools)
+	calls, reask, _ := r.validate(calls, false)
+	if reask != "" {
+		return calls, reask
+	}
+	calls, ok := r.choice.apply(calls)
+	if !ok {
+		return calls, r.choice.reaskMessage()
+	}
+	if r.single && len(calls) > 1 {
+		if r.retryOnMultiple {
+			return calls, "Your previous response called multiple tools at once. You may call only ONE tool per response. Call the single most appropriate tool now, using the exact tool call format described in the system prompt. Respond with the tool call only."
+		}
+		return r.keepFirst(calls), ""
+	}
+	// ツール呼び出しがない応答は最終回答として response_format の形式を確認する（ツール呼び出しを優先する）
+	if len(calls) == 0 && r.format.active() {
+		return calls, r.format.check(content)
+	}
+	return calls, ""
+}
+
+// fallback は聞き直しても条件を満たさなかった場合に、元の出力から返すツール呼び出しを求める
+// strict: true のツールの呼び出しがスキーマに合わない場合はそのエラーを返す（呼び出し側はエラーレスポンスを返す）
+// 宣言されていないツール名の呼び出し・検証に失敗した呼び出しは（repair の場合も）取り除き、関数名の指定に合わない呼び出しも取り除き、複数の場合は先頭のみ残す
+func (r toolCallRequirement) fallback(calls []ToolCall) ([]ToolCall, *strictToolCallError) {
+	calls = r.correctNames(calls, true)
+	calls, _, err := r.validate(calls, true)
+	if err != nil {
+		return nil, err
+	}
+	calls, _ = r.choice.apply(calls)
+	if r.single && len(calls) > 1 {
+		return r.keepFirst(calls), nil
+	}
+	return calls, nil
+}
+
+// validate は引数を検証し、validation_policy に従って検証に失敗した呼び出しを扱う
+// repair で final でない場合は、呼び出しはそのままで聞き直すための user メッセージを返す
+// final（聞き直し後の最終処理）では、repair も drop と同じく検証に失敗した呼び出しを取り除く
+// 修復が有効な場合は validation_policy によらず聞き直し、final では validation_policy に従う
+// strict: true のツールの呼び出しは常に聞き直し、final でも直っていなければ strictToolCallError を返す
+func (r toolCallRequirement) validate(calls []ToolCall, final bool) ([]ToolCall, string, *strictToolCallError) {
+	if len(r.tools) == 0 || len(calls) == 0 {
+		r.validation.record(nil)
+		return calls, "", nil
+	}
+	reports := validateToolCalls(calls, r.tools)
+	logValidationReports(reports, r.validationPolicy)
+	r.validation.record(reports)
+	invalid := invalidReports(reports)
+	if len(invalid) == 0 {
+		return calls, "", nil
+	}
+	strict := strictReports(invalid)
+	if !final {
+		if r.repairsInvalid() {
+			return calls, validationReaskMessage(invalid), nil
+		}
+		if len(strict) > 0 {
+			return calls, validationReaskMessage(strict), nil
+		}
+	}
+	if len(strict) > 0 {
+		logDebug("Strict Tool Call Invalid", map[string]any{"Invalid": len(strict)})
+		return nil, "", &strictToolCallError{invalid: strict}
+	}
+	if r.validationPolicy == validationPass {
+		return calls, "", nil
+	}
+	valid := make([]ToolCall, 0, len(calls)-len(invalid))
+	for i, call := range calls {
+		if reports[i].Valid {
+			valid = append(valid, call)
+		}
+	}
+	logDebug("Tool Call Validation: Calls Dropped", map[string]any{"Dropped": len(invalid), "Kept": len(valid)})
+	return valid, "", nil
+}
+
+// keepFirst は先頭の呼び出しのみ残し、取り除いた呼び出しをデバッグログに記録する
+func (r toolCallRequirement) keepFirst(calls []ToolCall) []ToolCall {
+	dropped := make([]string, 0, len(calls)-1)
+	for _, call := range calls[1:] {
+		dropped = append(dropped, call.Function.Name)
+	}
+	logDebug("Parallel Tool Calls Disabled: Calls Dropped", map[string]any{
+		"Kept":    calls[0].Function.Name,
+		"Dropped": dropped,
+	})
+	return calls[:1]
+}
+
+// toolCallRetryResult は聞き直した結果（条件を満たす出力が得られた場合）
+type toolCallRetryResult struct {
+	backendResp map[string]any
+	content     string // 推論を除いた本文（プレフィルを補ったもの）
+	reasoning   string
+	toolCalls   []ToolCall
+	spans       []markupSpan
+}
+
+// retryForToolCalls は条件を満たさなかった出力に対し、最大 maxAttempts 回（修復が有効な場合は時間の上限まで）モデルに聞き直す
+// 満たさなかった出力を assistant メッセージとして、指示（reask）を user メッセージとして履歴に加えて送る（ストリーミングなし）
+// モデル設定で assistant_prefill を有効にした場合は、ツール呼び出しの開始部分を assistant のプレフィルとして最後に加える
+// 満たす出力が得られなかった場合やバックエンドエラーの場合は nil を返す（呼び出し側は元の出力に fallback を適用して返す）
+// ctx はクライアントのリクエストのコンテキスト（切断された場合は聞き直しを中断する）
+// 2つ目の戻り値は実際に聞き直した回数
+func retryForToolCalls(ctx context.Context, req *ChatCompletionRequest, requirement toolCallRequirement, renderer toolPromptRenderer, modelConfig config.ModelConfig, parsers []parser.ToolCallParser, previous, reask string) (*toolCallRetryResult, int) {
+	choice := requirement.choice
+	messages := append([]Message(nil), req.Messages...)
+	deadline := requirement.repair.deadline(time.Now())
+	attempt := 1
+	for ; attempt <= requirement.maxAttempts(); attempt++ {
+		timeout := remainingTimeout(deadline)
+		if timeout <= 0 {
+			logDebug("Tool Calls: Repair Time Budget Exhausted", map[string]any{"Attempts": attempt - 1, "Budget": requirement.repair.budget})
+			break
+		}
+		if ctx.Err() != nil {
+			logDebug("Tool Calls: Re-ask Cancelled", map[string]any{"Attempts": attempt - 1, "Error": ctx.Err().Error()})
+			return nil, attempt - 1
+		}
+		if strings.TrimSpace(previous) != "" {
+			messages = append(messages, Message{Role: "assistant", Content: previous})
+		}
+		messages = append(messages, Message{Role: "user", Content: reask})
+
+		retryReq := *req
+		retryReq.Messages = messages
+		retryReq.Stream = false
+		retryReq.StreamOptions = nil
+		retryReq.N = nil // 聞き直しは選択肢ごとに1つずつ
+		prefill := ""
+		// response_format の最終回答を求める場合があるときは、ツール呼び出しを強制するプレフィルを使わない
+		if modelConfig.AssistantPrefill && (choice.forcesCall() || !requirement.format.active()) {
+			prefill = renderer.CallPrefix(choice.Name)
+			retryReq.Messages = append(append([]Message(nil), messages...), Message{Role: "assistant", Content: prefill})
+		}
+
+		logDebug("Tool Calls: Re-asking", map[string]any{
+			"Tool Choice": choice.Mode,
+			"Name":        choice.Name,
+			"Single":      requirement.single,
+			"Attempt":     attempt,
+			"Prefill":     prefill,
+		})
+		backendResp, err := forwardToBifrostWithContext(ctx, &retryReq, timeout)
+		if err != nil {
+			logDebug("Tool Calls: Re-ask Failed", map[string]any{"Error": err.Error(), "Response": backendResp})
+			return nil, attempt
+		}
+
+		reasoning, content := extractReasoning(extractContentFromBackendResponse(backendResp))
+		// プレフィルの続きだけを返すバックエンドでは、開始部分を補ってから抽出する
+		if prefill != "" && !strings.HasPrefix(strings.TrimSpace(content), strings.TrimSpace(prefill)) {
+			content = prefill + content
+		}
+		toolCalls, spans := extractToolCalls(content, parsers)
+		toolCalls, next := requirement.check(content, toolCalls)
+		if next == "" {
+			return &toolCallRetryResult{backendResp: backendResp, content: content, reasoning: reasoning, toolCalls: toolCalls, spans: spans}, attempt
+		}
+		previous, reask = content, next
+	}
+	logDebug("Tool Calls: Requirement Not Satisfied", map[string]any{"Tool Choice": choice.Mode, "Name": choice.Name, "Single": requirement.single, "Attempts": attempt - 1})
+	return nil, attempt - 1
+}
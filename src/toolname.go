@@ -0,0 +1,182 @@
+/**
+ * toolname.go
+ *
+ * ツール呼び出しの関数名の確認と補正
+ * モデルはリクエストで宣言されていないツール名（大文字小文字の違い、"functions." などの接頭辞、GPT-OSS のドット区切りの名前、
+ * ハイフンとアンダースコアの取り違え、綴りの誤り）を出力することがあり、そのまま返すとクライアント側の呼び出し処理が失敗する。
+ * 抽出した呼び出しの関数名を、埋め込み前のリクエストの tools と照合し、TOOL_NAME_POLICY（モデルごとには tool_name_policy）に従って扱う。
+ *   - correct: 宣言されたツールに一意に対応づけられる場合は補正し、対応づけられない呼び出しは取り除く
+ *   - strict: 宣言どおりの名前の呼び出しのみ残す
+ *   - off: 照合しない
+ */
+package main
+
+import (
+	"strings"
+
+	"github.com/t-kawata/tcgw/config"
+)
+
+// 宣言されていないツール名の呼び出しの扱い
+const (
+	toolNameCorrect = "correct"
+	toolNameStrict  = "strict"
+	toolNameOff     = "off"
+)
+
+// toolNamePrefixes はモデルが関数名の前に付けがちな名前空間
+var toolNamePrefixes = []string{"functions.", "function.", "tools.", "tool."}
+
+// validToolNamePolicy は tool_name_policy として有効な値か
+func validToolNamePolicy(policy string) bool {
+	return policy == toolNameCorrect || policy == toolNameStrict || policy == toolNameOff
+}
+
+// resolveToolNamePolicy はモデル設定の tool_name_policy（未指定の場合は TOOL_NAME_POLICY）を返す
+func resolveToolNamePolicy(modelConfig config.ModelConfig) string {
+	if modelConfig.ToolNamePolicy != "" {
+		return modelConfig.ToolNamePolicy
+	}
+	return toolNamePolicy
+}
+
+// matchToolName は関数名に対応する宣言済みのツール名を求める（対応づけられない場合は ok=false）
+// 完全一致 → 接頭辞・名前空間を除いた名前 → 大文字小文字・ハイフン・空白の違いを無視した一致 → 小さな編集距離 の順に試し、
+// 候補が複数ある段階では対応づけない
+func matchToolName(name string, tools []Tool, policy string) (string, bool) {
+	for _, tool := range tools {
+		if tool.Function.Name == name {
+			return name, true
+		}
+	}
+	if policy != toolNameCorrect {
+		return "", false
+	}
+
+	candidates := []string{strings.TrimSpace(name)}
+	for _, prefix := range toolNamePrefixes {
+		if len(name) > len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+			candidates = append(candidates, name[len(prefix):])
+		}
+	}
+	// GPT-OSS などのドット区切りの名前（"functions.get_weather", "browser.search" など）は最後の要素
+	if idx := strings.LastIndex(name, "."); idx != -1 && idx < len(name)-1 {
+		candidates = append(candidates, name[idx+1:])
+	}
+
+	for _, candidate := range candidates {
+		for _, tool := range tools {
+			if tool.Function.Name == candidate {
+				return candidate, true
+			}
+		}
+	}
+	for _, candidate := range candidates {
+		if match, ok := uniqueToolName(tools, func(declared string) bool {
+			return normalizeToolName(declared) == normalizeToolName(candidate)
+		}); ok {
+			return match, true
+		}
+	}
+	for _, candidate := range candidates {
+		key := normalizeToolName(candidate)
+		best, bestDistance, unique := "", -1, false
+		for _, tool := range tools {
+			declared := normalizeToolName(tool.Function.Name)
+			d := editDistance(key, declared)
+			if d > maxToolNameDistance(declared) {
+				continue
+			}
+			switch {
+			case bestDistance == -1 || d < bestDistance:
+				best, bestDistance, unique = tool.Function.Name, d, true
+			case d == bestDistance:
+				unique = false
+			}
+		}
+		if bestDistance != -1 && unique {
+			return best, true
+		}
+	}
+	return "", false
+}
+
+// uniqueToolName は条件に当てはまる宣言済みのツール名がちょうど1つの場合にそれを返す
+func uniqueToolName(tools []Tool, match func(declared string) bool) (string, bool) {
+	found := ""
+	for _, tool := range tools {
+		if match(tool.Function.Name) {
+			if found != "" && found != tool.Function.Name {
+				return "", false
+			}
+			found = tool.Function.Name
+		}
+	}
+	return found, found != ""
+}
+
+// normalizeToolName は大文字小文字・ハイフン・空白の違いを無視して比べるための名前
+func normalizeToolName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.NewReplacer("-", "_", " ", "_").Replace(name)
+}
+
+// maxToolNameDistance は綴りの誤りとみなす編集距離の上限（短い名前ほど厳しくする）
+func maxToolNameDistance(name string) int {
+	switch n := len([]rune(name)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance は2つの文字列のレーベンシュタイン距離
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// correctNames は各呼び出しの関数名を宣言済みのツールと照合し、補正または取り除く
+// 聞き直しで直せる場合（修復が有効・validation_policy が repair）で final でなければ、対応づけられない呼び出しは残し、
+// 引数の検証で未定義のツールとして聞き直す
+func (r toolCallRequirement) correctNames(calls []ToolCall, final bool) []ToolCall {
+	if r.namePolicy == toolNameOff || len(r.tools) == 0 || len(calls) == 0 {
+		return calls
+	}
+	kept := make([]ToolCall, 0, len(calls))
+	for _, call := range calls {
+		name, ok := matchToolName(call.Function.Name, r.tools, r.namePolicy)
+		switch {
+		case ok && name != call.Function.Name:
+			logDebug("Tool Name Corrected", map[string]any{"From": call.Function.Name, "To": name})
+			call.Function.Name = name
+			kept = append(kept, call)
+		case ok:
+			kept = append(kept, call)
+		case !final && r.repairsInvalid():
+			kept = append(kept, call)
+		default:
+			logDebug("Undeclared Tool Call Dropped", map[string]any{"Name": call.Function.Name, "Policy": r.namePolicy})
+		}
+	}
+	return kept
+}
@@ -0,0 +1,114 @@
+package main
+
+import "testing"
+
+// toolsNamed はテスト用に名前だけのツール定義を作る
+func toolsNamed(names ...string) []Tool {
+	tools := make([]Tool, len(names))
+	for i, name := range names {
+		tools[i] = Tool{Type: "function", Function: FunctionDef{Name: name}}
+	}
+	return tools
+}
+
+func TestMatchToolName(t *testing.T) {
+	declared := toolsNamed("get_weather", "get_time", "search", "list-files", "ls")
+
+	tests := []struct {
+		name   string
+		tools  []Tool // nil の場合は declared
+		call   string
+		policy string
+		want   string
+		wantOK bool
+	}{
+		// 完全一致
+		{name: "exact", call: "get_weather", policy: toolNameCorrect, want: "get_weather", wantOK: true},
+		{name: "exact under strict", call: "get_time", policy: toolNameStrict, want: "get_time", wantOK: true},
+		{name: "strict does not correct", call: "Get_Weather", policy: toolNameStrict},
+		{name: "off does not correct", call: "functions.get_weather", policy: toolNameOff},
+
+		// 接頭辞・ドット区切り
+		{name: "functions prefix", call: "functions.get_weather", policy: toolNameCorrect, want: "get_weather", wantOK: true},
+		{name: "prefix is case insensitive", call: "Tools.search", policy: toolNameCorrect, want: "search", wantOK: true},
+		{name: "dotted namespace uses the last element", call: "browser.search", policy: toolNameCorrect, want: "search", wantOK: true},
+		{name: "prefix alone is not a name", call: "functions.", policy: toolNameCorrect},
+
+		// 大文字小文字・ハイフン・空白の違い
+		{name: "case", call: "GET_WEATHER", policy: toolNameCorrect, want: "get_weather", wantOK: true},
+		{name: "underscore for hyphen", call: "list_files", policy: toolNameCorrect, want: "list-files", wantOK: true},
+		{name: "hyphen for underscore", call: "get-time", policy: toolNameCorrect, want: "get_time", wantOK: true},
+		{name: "spaces", call: " get time ", policy: toolNameCorrect, want: "get_time", wantOK: true},
+		{name: "prefix and normalization", call: "functions.Get-Weather", policy: toolNameCorrect, want: "get_weather", wantOK: true},
+
+		// 編集距離（8文字以上は2、4〜7文字は1、4文字未満は0まで）
+		{name: "long name within 2 edits", call: "get_wether", policy: toolNameCorrect, want: "get_weather", wantOK: true},
+		{name: "long name at 2 edits", call: "gt_wether", policy: toolNameCorrect, want: "get_weather", wantOK: true},
+		{name: "long name over 2 edits", call: "gt_wthr", policy: toolNameCorrect},
+		{name: "medium name within 1 edit", call: "serch", policy: toolNameCorrect, want: "search", wantOK: true},
+		{name: "medium name over 1 edit", call: "srch", policy: toolNameCorrect},
+		{name: "short name must match exactly", call: "la", policy: toolNameCorrect},
+		{name: "unrelated name", call: "delete_everything", policy: toolNameCorrect},
+
+		// 候補が複数ある場合は対応づけない
+		{
+			name:   "ambiguous near match",
+			tools:  toolsNamed("get_time", "get_tide"),
+			call:   "get_tiXe",
+			policy: toolNameCorrect,
+		},
+		{
+			name:   "ambiguous normalization",
+			tools:  toolsNamed("get-weather", "get_weather"),
+			call:   "GET_WEATHER",
+			policy: toolNameCorrect,
+		},
+		{
+			name:   "closer candidate wins",
+			tools:  toolsNamed("get_time", "get_times"),
+			call:   "get_tim",
+			policy: toolNameCorrect,
+			want:   "get_time",
+			wantOK: true,
+		},
+		{
+			name:   "exact match beats a near match",
+			tools:  toolsNamed("get_tide", "get_time"),
+			call:   "get_tide",
+			policy: toolNameCorrect,
+			want:   "get_tide",
+			wantOK: true,
+		},
+		{name: "no tools", tools: []Tool{}, call: "get_weather", policy: toolNameCorrect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tools := tt.tools
+			if tools == nil {
+				tools = declared
+			}
+			got, ok := matchToolName(tt.call, tools, tt.policy)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("matchToolName(%q, %s) = %q, %v; want %q, %v", tt.call, tt.policy, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"search", "serch", 1},
+		{"天気", "天気予報", 2}, // バイトではなく文字単位
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
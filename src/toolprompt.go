@@ -0,0 +1,438 @@
+/**
+ * toolprompt.go
+ *
+ * モデルファミリー別のツール定義プロンプト
+ * モデルは学習時のツール定義・ツール呼び出しの形式に合わせたプロンプトのほうが、ツール呼び出しの精度が大きく上がる。
+ * そのため、ツール定義（システムプロンプト）・過去のツール呼び出し・ツールの実行結果の書き方を toolPromptRenderer としてまとめ、
+ * モデル別設定の tool_prompt で選べるようにする。各レンダラーは、その形式の出力を抽出するパーサー名も持つ。
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// toolResult はツールの実行結果（role: "tool" のメッセージ）
+type toolResult struct {
+	Name    string // 対応する呼び出しのツール名
+	CallID  string // tool_call_id
+	Content string
+}
+
+// toolPromptRenderer はツール定義・ツール呼び出し・実行結果をモデルの学習形式のテキストにする
+type toolPromptRenderer interface {
+	// SystemPrompt はツール定義（ctx.Tools）を埋め込んだシステムプロンプトを返す
+	SystemPrompt(ctx promptContext) (string, error)
+	// ToolCalls は過去の assistant のツール呼び出しを、モデルが出力する形式のテキストにする
+	ToolCalls(calls []ToolCall) string
+	// ToolResults は連続するツールの実行結果を、1つの user メッセージのテキストにする
+	ToolResults(results []toolResult) string
+	// Parsers はこの形式の出力を抽出するパーサー名（モデル設定で parsers を指定しない場合に先に試す）
+	Parsers() []string
+	// CallPrefix はツール呼び出しの開始部分（tool_choice で呼び出しを強制する際の assistant のプレフィル。name が空の場合は関数名の手前まで）
+	CallPrefix(name string) string
+}
+
+// defaultToolPromptName はモデル設定で tool_prompt を指定しない場合のレンダラー
+const defaultToolPromptName = "tcgw"
+
+// toolPromptRenderers は名前で選べるレンダラーの一覧
+var toolPromptRenderers = map[string]toolPromptRenderer{}
+
+// registerToolPromptRenderer はレンダラーを登録する（同じ名前は上書き）
+func registerToolPromptRenderer(name string, r toolPromptRenderer) {
+	toolPromptRenderers[name] = r
+}
+
+// toolPromptRendererNames は登録済みのレンダラー名を返す（エラーメッセージ用）
+func toolPromptRendererNames() []string {
+	names := make([]string, 0, len(toolPromptRenderers))
+	for name := range toolPromptRenderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// toolPromptRendererFor はモデル設定の tool_prompt からレンダラーを求める（未指定・未登録の場合は tcgw）
+func toolPromptRendererFor(name string) toolPromptRenderer {
+	if r, ok := toolPromptRenderers[name]; ok {
+		return r
+	}
+	return toolPromptRenderers[defaultToolPromptName]
+}
+
+func init() {
+	registerToolPromptRenderer("tcgw", tcgwToolPrompt{})
+	registerToolPromptRenderer("hermes", hermesToolPrompt{})
+	registerToolPromptRenderer("llama3.1", llama31ToolPrompt{})
+	registerToolPromptRenderer("mistral", mistralToolPrompt{})
+	registerToolPromptRenderer("harmony", harmonyToolPrompt{})
+}
+
+// --- TCGW標準（<function_calls> XML形式） ---
+
+// tcgwToolPrompt は従来の TOOL_SYSTEM_PROMPT と <function_calls> / <function_results> 形式
+type tcgwToolPrompt struct{}
+
+// システムプロンプトは default プロファイル（組み込みは TOOL_SYSTEM_PROMPT、PROMPT_PROFILE_DIR の default.tmpl で上書き可能）
+func (tcgwToolPrompt) SystemPrompt(ctx promptContext) (string, error) {
+	return renderPromptProfile(defaultPromptProfileName, ctx)
+}
+
+func (tcgwToolPrompt) ToolCalls(calls []ToolCall) string {
+	return renderFunctionCalls(calls)
+}
+
+func (tcgwToolPrompt) ToolResults(results []toolResult) string {
+	blocks := make([]string, len(results))
+	for i, r := range results {
+		blocks[i] = renderFunctionResult(r.Name, r.Content)
+	}
+	return renderFunctionResults(blocks)
+}
+
+func (tcgwToolPrompt) Parsers() []string { return nil } // 標準の抽出順をそのまま使う
+
+func (tcgwToolPrompt) CallPrefix(name string) string {
+	if name == "" {
+		return "<function_calls>\n"
+	}
+	return fmt.Sprintf("<function_calls>\n  <invoke name=\"%s\">\n", escapeXML(name))
+}
+
+// --- Hermes 2 Pro / Qwen 2.5（<tools> JSON + <tool_call>） ---
+
+type hermesToolPrompt struct{}
+
+func (hermesToolPrompt) SystemPrompt(ctx promptContext) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("# Tools\n\nYou may call one or more functions to assist with the user query.\n\n")
+	sb.WriteString("You are provided with function signatures within <tools></tools> XML tags:\n<tools>\n")
+	for _, tool := range ctx.Tools {
+		sb.WriteString(toolDefinitionJSON(tool))
+		sb.WriteString("\n")
+	}
+	sb.WriteString("</tools>\n\n")
+	sb.WriteString("For each function call, return a json object with function name and arguments within <tool_call></tool_call> XML tags:\n")
+	sb.WriteString("<tool_call>\n{\"name\": <function-name>, \"arguments\": <args-json-object>}\n</tool_call>")
+	return sb.String(), nil
+}
+
+func (hermesToolPrompt) ToolCalls(calls []ToolCall) string {
+	blocks := make([]string, len(calls))
+	for i, call := range calls {
+		blocks[i] = fmt.Sprintf("<tool_call>\n{\"name\": %s, \"arguments\": %s}\n</tool_call>", jsonString(call.Function.Name), argumentsJSON(call.Function.Arguments))
+	}
+	return strings.Join(blocks, "\n")
+}
+
+func (hermesToolPrompt) ToolResults(results []toolResult) string {
+	blocks := make([]string, len(results))
+	for i, r := range results {
+		blocks[i] = "<tool_response>\n" + strings.TrimSpace(r.Content) + "\n</tool_response>"
+	}
+	return strings.Join(blocks, "\n")
+}
+
+func (hermesToolPrompt) Parsers() []string { return []string{"hermes-2-pro"} }
+
+func (hermesToolPrompt) CallPrefix(name string) string {
+	if name == "" {
+		return "<tool_call>\n"
+	}
+	return fmt.Sprintf("<tool_call>\n{\"name\": %s, \"arguments\": ", jsonString(name))
+}
+
+// --- Llama 3.1（Environment: ipython + JSON関数リスト） ---
+
+type llama31ToolPrompt struct{}
+
+func (llama31ToolPrompt) SystemPrompt(ctx promptContext) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("Environment: ipython\n\n")
+	sb.WriteString("Given the following functions, please respond with a JSON for a function call with its proper arguments that best answers the given prompt.\n\n")
+	sb.WriteString("Respond in the format {\"name\": function name, \"parameters\": dictionary of argument name and its value}. Do not use variables.\n\n")
+	for _, tool := range ctx.Tools {
+		sb.WriteString(toolDefinitionJSON(tool))
+		sb.WriteString("\n\n")
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+func (llama31ToolPrompt) ToolCalls(calls []ToolCall) string {
+	lines := make([]string, len(calls))
+	for i, call := range calls {
+		lines[i] = fmt.Sprintf("{\"name\": %s, \"parameters\": %s}", jsonString(call.Function.Name), argumentsJSON(call.Function.Arguments))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (llama31ToolPrompt) ToolResults(results []toolResult) string {
+	// Llama 3.1 の ipython ロールの代わりに、user メッセージとして関数名と出力を渡す
+	blocks := make([]string, len(results))
+	for i, r := range results {
+		blocks[i] = fmt.Sprintf("[ipython] Output of %s:\n%s", r.Name, strings.TrimSpace(r.Content))
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+func (llama31ToolPrompt) Parsers() []string { return []string{"llama-3.x"} }
+
+func (llama31ToolPrompt) CallPrefix(name string) string {
+	if name == "" {
+		return "{\"name\": "
+	}
+	return fmt.Sprintf("{\"name\": %s, \"parameters\": ", jsonString(name))
+}
+
+// --- Mistral（[AVAILABLE_TOOLS] + [TOOL_CALLS]） ---
+
+type mistralToolPrompt struct{}
+
+func (mistralToolPrompt) SystemPrompt(ctx promptContext) (string, error) {
+	defs := make([]string, len(ctx.Tools))
+	for i, tool := range ctx.Tools {
+		defs[i] = toolDefinitionJSON(tool)
+	}
+	return "[AVAILABLE_TOOLS][" + strings.Join(defs, ", ") + "][/AVAILABLE_TOOLS]\n\n" +
+		"To call tools, respond with [TOOL_CALLS] followed by a JSON array of objects of the form {\"name\": function name, \"arguments\": arguments object}, and nothing else.", nil
+}
+
+func (mistralToolPrompt) ToolCalls(calls []ToolCall) string {
+	items := make([]string, len(calls))
+	for i, call := range calls {
+		items[i] = fmt.Sprintf("{\"name\": %s, \"arguments\": %s, \"id\": %s}", jsonString(call.Function.Name), argumentsJSON(call.Function.Arguments), jsonString(call.ID))
+	}
+	return "[TOOL_CALLS][" + strings.Join(items, ", ") + "]"
+}
+
+func (mistralToolPrompt) ToolResults(results []toolResult) string {
+	blocks := make([]string, len(results))
+	for i, r := range results {
+		blocks[i] = fmt.Sprintf("[TOOL_RESULTS]{\"call_id\": %s, \"content\": %s}[/TOOL_RESULTS]", jsonString(r.CallID), toolResultJSON(r.Content))
+	}
+	return strings.Join(blocks, "\n")
+}
+
+func (mistralToolPrompt) Parsers() []string { return []string{"mistral-nemo"} }
+
+func (mistralToolPrompt) CallPrefix(name string) string {
+	if name == "" {
+		return "[TOOL_CALLS]["
+	}
+	return fmt.Sprintf("[TOOL_CALLS][{\"name\": %s, \"arguments\": ", jsonString(name))
+}
+
+// --- GPT-OSS Harmony（namespace functions） ---
+
+type harmonyToolPrompt struct{}
+
+func (harmonyToolPrompt) SystemPrompt(ctx promptContext) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("# Tools\n\n## functions\n\nnamespace functions {\n\n")
+	for _, tool := range ctx.Tools {
+		if desc := strings.TrimSpace(tool.Function.Description); desc != "" {
+			for _, line := range strings.Split(desc, "\n") {
+				sb.WriteString("// " + line + "\n")
+			}
+		}
+		params := tool.Function.Parameters
+		if props, _ := params["properties"].(map[string]any); len(props) == 0 {
+			sb.WriteString(fmt.Sprintf("type %s = () => any;\n\n", tool.Function.Name))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("type %s = (_: %s) => any;\n\n", tool.Function.Name, harmonyObjectType(params, "", "")))
+	}
+	sb.WriteString("} // namespace functions\n\n")
+	sb.WriteString("# Valid channels: analysis, commentary, final. Channel must be included for every message.\n")
+	sb.WriteString("Calls to these tools must go to the commentary channel: 'functions'.\n")
+	sb.WriteString("To call a tool, output: <|start|>assistant<|channel|>commentary to=functions.TOOL_NAME <|constrain|>json<|message|>{JSON arguments}<|call|>")
+	return sb.String(), nil
+}
+
+func (harmonyToolPrompt) ToolCalls(calls []ToolCall) string {
+	var sb strings.Builder
+	for _, call := range calls {
+		sb.WriteString(fmt.Sprintf("<|start|>assistant<|channel|>commentary to=functions.%s <|constrain|>json<|message|>%s<|call|>", call.Function.Name, argumentsJSON(call.Function.Arguments)))
+	}
+	return sb.String()
+}
+
+func (harmonyToolPrompt) ToolResults(results []toolResult) string {
+	var sb strings.Builder
+	for _, r := range results {
+		sb.WriteString(fmt.Sprintf("<|start|>functions.%s to=assistant<|channel|>commentary<|message|>%s<|end|>", r.Name, strings.TrimSpace(r.Content)))
+	}
+	return sb.String()
+}
+
+func (harmonyToolPrompt) Parsers() []string { return []string{"gpt-oss"} }
+
+func (harmonyToolPrompt) CallPrefix(name string) string {
+	if name == "" {
+		return "<|start|>assistant<|channel|>commentary to=functions."
+	}
+	return fmt.Sprintf("<|start|>assistant<|channel|>commentary to=functions.%s <|constrain|>json<|message|>", name)
+}
+
+// harmonyObjectType は object の JSON Schema を Harmony（TypeScript風）の型定義にする
+// プロパティはキーの昇順で indent の深さに並べ、閉じ括弧は closeIndent の深さに書く
+// 説明はコメント、必須でないものは "?"、デフォルト値は行末コメントで書く
+func harmonyObjectType(schema map[string]any, indent, closeIndent string) string {
+	props, _ := schema["properties"].(map[string]any)
+	if len(props) == 0 {
+		return "object"
+	}
+	required := make(map[string]bool)
+	if req, ok := schema["required"].([]any); ok {
+		for _, r := range req {
+			if name, ok := r.(string); ok {
+				required[name] = true
+			}
+		}
+	}
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString("{\n")
+	for _, key := range keys {
+		prop, _ := props[key].(map[string]any)
+		if desc, _ := prop["description"].(string); desc != "" {
+			for _, line := range strings.Split(strings.TrimSpace(desc), "\n") {
+				sb.WriteString(indent + "// " + line + "\n")
+			}
+		}
+		optional := ""
+		if !required[key] {
+			optional = "?"
+		}
+		sb.WriteString(fmt.Sprintf("%s%s%s: %s,", indent, key, optional, harmonyType(prop, indent)))
+		if def, ok := prop["default"]; ok {
+			defJSON, _ := json.Marshal(def)
+			sb.WriteString(" // default: " + string(defJSON))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString(closeIndent + "}")
+	return sb.String()
+}
+
+// harmonyType は JSON Schema の型を TypeScript風の型表記にする
+func harmonyType(schema map[string]any, indent string) string {
+	if schema == nil {
+		return "any"
+	}
+	if enum, ok := schema["enum"].([]any); ok && len(enum) > 0 {
+		values := make([]string, len(enum))
+		for i, v := range enum {
+			b, _ := json.Marshal(v)
+			values[i] = string(b)
+		}
+		return strings.Join(values, " | ")
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		if variants, ok := schema[key].([]any); ok && len(variants) > 0 {
+			types := make([]string, len(variants))
+			for i, v := range variants {
+				sub, _ := v.(map[string]any)
+				types[i] = harmonyType(sub, indent)
+			}
+			return strings.Join(types, " | ")
+		}
+	}
+	// "type": ["string", "null"] のような複数型
+	if list, ok := schema["type"].([]any); ok {
+		types := make([]string, 0, len(list))
+		for _, t := range list {
+			if name, ok := t.(string); ok {
+				sub := make(map[string]any, len(schema))
+				for k, v := range schema {
+					sub[k] = v
+				}
+				sub["type"] = name
+				types = append(types, harmonyType(sub, indent))
+			}
+		}
+		return strings.Join(types, " | ")
+	}
+	typeName, _ := schema["type"].(string)
+	switch typeName {
+	case "string":
+		return "string"
+	case "number", "integer":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "null":
+		return "null"
+	case "array":
+		items, _ := schema["items"].(map[string]any)
+		itemType := harmonyType(items, indent)
+		if strings.Contains(itemType, "|") {
+			itemType = "(" + itemType + ")"
+		}
+		return itemType + "[]"
+	case "object":
+		return harmonyObjectType(schema, indent+"  ", indent)
+	}
+	return "any"
+}
+
+// --- 共通ヘルパー ---
+
+// toolDefinitionJSON はツール定義をOpenAI形式の1行のJSONにする
+func toolDefinitionJSON(tool Tool) string {
+	def := map[string]any{
+		"type": "function",
+		"function": map[string]any{
+			"name":        tool.Function.Name,
+			"description": tool.Function.Description,
+			"parameters":  tool.Function.Parameters,
+		},
+	}
+	b, err := json.Marshal(def)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// argumentsJSON は arguments（JSON文字列）を埋め込み用の1行のJSONにする（JSONでない場合は文字列として埋め込む）
+func argumentsJSON(arguments string) string {
+	trimmed := strings.TrimSpace(arguments)
+	if trimmed == "" {
+		return "{}"
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(trimmed)); err == nil {
+		return compact.String()
+	}
+	return jsonString(arguments)
+}
+
+// toolResultJSON はツールの実行結果を、JSONであればそのまま、そうでなければJSON文字列として埋め込む
+func toolResultJSON(content string) string {
+	trimmed := strings.TrimSpace(content)
+	if trimmed != "" && json.Valid([]byte(trimmed)) {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, []byte(trimmed)); err == nil {
+			return compact.String()
+		}
+	}
+	return jsonString(content)
+}
+
+// jsonString は文字列をJSONの文字列リテラルにする
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
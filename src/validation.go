@@ -0,0 +1,199 @@
+/**
+ * validation.go
+ *
+ * 抽出したツール呼び出しの引数の検証
+ * パーサーは解釈できた引数をそのまま返すため、必須パラメータの欠落・型の誤り・未定義のパラメータがそのまま実行側に届いてしまう。
+ * 抽出したすべての呼び出しを、ツール定義の parameters（JSON Schema）で検証し（schema パッケージ）、検証結果をレポートにまとめる。
+ * 検証に失敗した呼び出しの扱いは TOOL_VALIDATION_POLICY（モデルごとには validation_policy）で選ぶ。
+ *   - pass: そのまま返す
+ *   - drop: 検証に失敗した呼び出しを取り除く
+ *   - repair: エラーを示してモデルに聞き直す（tool_choice と同じ聞き直しの仕組みを使う）
+ * strict: true のツールは、未定義のプロパティを許可しないスキーマ（schema.Strict）で検証し、validation_policy によらず聞き直す。
+ * 聞き直しても直らなかった場合は、スキーマに合わない引数を返さずにエラーレスポンスを返す。
+ * 返した応答の呼び出しの検証結果（選択肢ごとの最後の検証）は、policy によらず X-TCGW-Validation ヘッダーに JSON で返す
+ * （ストリーミングではヘッダーを送った後に検証するため、HTTP トレーラーで返す）。
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/t-kawata/tcgw/config"
+	"github.com/t-kawata/tcgw/schema"
+)
+
+// 検証に失敗した呼び出しの扱い
+const (
+	validationPass   = "pass"
+	validationDrop   = "drop"
+	validationRepair = "repair"
+)
+
+// validValidationPolicy は validation_policy として有効な値か
+func validValidationPolicy(policy string) bool {
+	return policy == validationPass || policy == validationDrop || policy == validationRepair
+}
+
+// resolveValidationPolicy はモデル設定の validation_policy（未指定の場合は TOOL_VALIDATION_POLICY）を返す
+func resolveValidationPolicy(modelConfig config.ModelConfig) string {
+	if modelConfig.ValidationPolicy != "" {
+		return modelConfig.ValidationPolicy
+	}
+	return toolValidationPolicy
+}
+
+// headerValidation は返した呼び出しの検証結果（toolCallValidationReport の JSON 配列）を返すヘッダー
+const headerValidation = "X-TCGW-Validation"
+
+// toolCallValidationReport は1件のツール呼び出しの検証結果
+type toolCallValidationReport struct {
+	Choice     int                      `json:"choice"` // 選択肢の index（ヘッダーで返す場合のみ設定する）
+	ToolCallID string                   `json:"tool_call_id"`
+	Name       string                   `json:"name"`
+	Valid      bool                     `json:"valid"`
+	Strict     bool                     `json:"strict,omitempty"` // strict: true のツールの呼び出し
+	Errors     []schema.ValidationError `json:"errors,omitempty"`
+}
+
+// validateToolCalls は各呼び出しの引数をツール定義の parameters で検証する
+// ツール定義にない名前の呼び出しは keyword "name" のエラーとする
+func validateToolCalls(calls []ToolCall, tools []Tool) []toolCallValidationReport {
+	defs := make(map[string]FunctionDef, len(tools))
+	for _, tool := range tools {
+		defs[tool.Function.Name] = tool.Function
+	}
+	reports := make([]toolCallValidationReport, len(calls))
+	for i, call := range calls {
+		report := toolCallValidationReport{ToolCallID: call.ID, Name: call.Function.Name}
+		if def, ok := defs[call.Function.Name]; !ok {
+			report.Errors = []schema.ValidationError{{Keyword: "name", Message: fmt.Sprintf("unknown tool %q", call.Function.Name)}}
+		} else {
+			params := def.Parameters
+			if def.Strict != nil && *def.Strict {
+				report.Strict = true
+				params = schema.Strict(params)
+			}
+			report.Errors = schema.ValidateJSON(params, call.Function.Arguments)
+		}
+		report.Valid = len(report.Errors) == 0
+		reports[i] = report
+	}
+	return reports
+}
+
+// invalidReports は検証に失敗したものだけを返す
+func invalidReports(reports []toolCallValidationReport) []toolCallValidationReport {
+	var invalid []toolCallValidationReport
+	for _, r := range reports {
+		if !r.Valid {
+			invalid = append(invalid, r)
+		}
+	}
+	return invalid
+}
+
+// strictReports は strict: true のツールの呼び出しのものだけを返す
+func strictReports(reports []toolCallValidationReport) []toolCallValidationReport {
+	var strict []toolCallValidationReport
+	for _, r := range reports {
+		if r.Strict {
+			strict = append(strict, r)
+		}
+	}
+	return strict
+}
+
+// strictToolCallError は strict: true のツールの呼び出しが、聞き直してもスキーマに合わなかったことを表す
+type strictToolCallError struct {
+	invalid []toolCallValidationReport
+}
+
+func (e *strictToolCallError) Error() string {
+	var parts []string
+	for _, r := range e.invalid {
+		for _, ve := range r.Errors {
+			parts = append(parts, r.Name+": "+ve.Error())
+		}
+	}
+	return "The model produced arguments that do not conform to the strict schema of the tool: " + strings.Join(parts, "; ")
+}
+
+// response は strict の違反を返すエラーレスポンス
+func (e *strictToolCallError) response() ErrorResponse {
+	return ErrorResponse{Error: ErrorDetail{
+		Message: e.Error(),
+		Type:    "server_error",
+		Code:    stringPtr("strict_tool_call_invalid"),
+	}}
+}
+
+// logValidationReports は検証に失敗した呼び出しのレポートをデバッグログに出力する
+func logValidationReports(reports []toolCallValidationReport, policy string) {
+	invalid := invalidReports(reports)
+	if len(invalid) == 0 {
+		return
+	}
+	reportJSON, _ := json.Marshal(invalid)
+	logDebug("Tool Call Validation Failed", map[string]any{
+		"Policy":  policy,
+		"Invalid": len(invalid),
+		"Total":   len(reports),
+		"Report":  string(reportJSON),
+	})
+}
+
+// validationRecord は1つの選択肢で最後に行った検証の結果（聞き直した場合は最後の出力のもの）
+type validationRecord struct {
+	reports []toolCallValidationReport
+}
+
+// record は検証の結果を記録する（r が nil の場合は何もしない）
+func (r *validationRecord) record(reports []toolCallValidationReport) {
+	if r != nil {
+		r.reports = reports
+	}
+}
+
+// setValidationHeader は選択肢ごとの検証結果を index を付けてヘッダーに設定する（検証した呼び出しがない場合は何もしない）
+// trailer の場合はレスポンスのトレーラーとして設定する（ストリーミングでヘッダーを送った後）
+func setValidationHeader(header http.Header, perChoice [][]toolCallValidationReport, trailer bool) {
+	var all []toolCallValidationReport
+	for i, reports := range perChoice {
+		for _, r := range reports {
+			r.Choice = i
+			all = append(all, r)
+		}
+	}
+	if len(all) == 0 {
+		return
+	}
+	reportJSON, err := json.Marshal(all)
+	if err != nil {
+		return
+	}
+	key := headerValidation
+	if trailer {
+		key = http.TrailerPrefix + headerValidation
+	}
+	header.Set(key, string(reportJSON))
+}
+
+// validationReaskMessage は検証エラーを示して、正しい引数で呼び出し直すよう求める user メッセージ
+func validationReaskMessage(invalid []toolCallValidationReport) string {
+	var sb strings.Builder
+	sb.WriteString("Your previous tool call(s) had invalid arguments and were rejected:\n")
+	for _, r := range invalid {
+		for _, e := range r.Errors {
+			path := e.Path
+			if path == "" {
+				path = "(arguments)"
+			}
+			sb.WriteString(fmt.Sprintf("- %s %s: %s\n", r.Name, path, e.Message))
+		}
+	}
+	sb.WriteString("Call the tool(s) again with corrected arguments that follow the parameter schema, using the exact tool call format described in the system prompt. Respond with the tool call only.")
+	return sb.String()
+}